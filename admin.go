@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedConfig is the representation of Config exposed by the admin API
+type redactedConfig struct {
+	Domain    string   `json:"domain"`
+	ApiKey    string   `json:"api_key"`
+	FromName  string   `json:"from_name"`
+	FromEmail string   `json:"from_email"`
+	LogLevel  string   `json:"log_level"`
+	LogFormat string   `json:"log_format"`
+	Port      string   `json:"port"`
+	Region    string   `json:"region"`
+	APIBase   string   `json:"api_base"`
+	APIKeys   []string `json:"api_keys"`
+
+	MaxEmailsPerRecipientPerDay int            `json:"max_emails_per_recipient_per_day"`
+	MaxEmailsPerDay             int            `json:"max_emails_per_day"`
+	TagDailyLimits              map[string]int `json:"tag_daily_limits"`
+	JSONMaxBodyBytes            int            `json:"json_max_body_bytes"`
+	JSONMaxDepth                int            `json:"json_max_depth"`
+	JSONMaxArrayElements        int            `json:"json_max_array_elements"`
+	SendingEnabled              bool           `json:"sending_enabled"`
+	InlineCSS                   bool           `json:"inline_css"`
+	AutoPlainText               bool           `json:"auto_plain_text"`
+	TextWrapWidth               int            `json:"text_wrap_width"`
+
+	WebhookSigningKey       string                       `json:"webhook_signing_key"`
+	PublicBaseURL           string                       `json:"public_base_url"`
+	ReviewServiceURL        string                       `json:"review_service_url"`
+	ReviewTTL               string                       `json:"review_ttl"`
+	UnsubscribeSigningKey   string                       `json:"unsubscribe_signing_key"`
+	EventForwardURL         string                       `json:"event_forward_url"`
+	EventForwardMaxAttempts int                          `json:"event_forward_max_attempts"`
+	ArchiveBCC              []string                     `json:"archive_bcc"`
+	ArchiveBCCByCategory    map[string][]string          `json:"archive_bcc_by_category"`
+	ReplyTo                 string                       `json:"reply_to"`
+	ReplyToByCategory       map[string]string            `json:"reply_to_by_category"`
+	RecipientNormalization  map[string]NormalizationRule `json:"recipient_normalization"`
+	SpamCheck               string                       `json:"spam_check"`
+	SpamRules               SpamRules                    `json:"spam_rules"`
+	DefaultLocale           string                       `json:"default_locale"`
+	SupportedLocales        []string                     `json:"supported_locales"`
+	MaintenanceMode         bool                         `json:"maintenance_mode"`
+	MaintenanceRetryAfter   string                       `json:"maintenance_retry_after"`
+	SubjectTemplate         string                       `json:"subject_template"`
+	EnvSubjectPrefix        string                       `json:"env_subject_prefix"`
+
+	FromIdentities             map[string]FromIdentity   `json:"from_identities"`
+	DevMode                    bool                      `json:"dev_mode"`
+	RouteTimeouts              map[string]string         `json:"route_timeouts"`
+	DefaultTimeout             string                    `json:"default_route_timeout"`
+	DKIMSelectors              []string                  `json:"dkim_selectors"`
+	DKIMVerifyDNS              bool                      `json:"dkim_verify_dns"`
+	MaxRetries                 int                       `json:"max_retries"`
+	RetryBackoff               string                    `json:"retry_backoff"`
+	Prewarm                    bool                      `json:"prewarm"`
+	PrewarmTimeout             string                    `json:"prewarm_timeout"`
+	StorageURLLookup           bool                      `json:"storage_url_lookup"`
+	StorageURLTimeout          string                    `json:"storage_url_timeout"`
+	AdminEmail                 string                    `json:"admin_email"`
+	TestMode                   bool                      `json:"test_mode"`
+	TestFromEmail              string                    `json:"test_from_email"`
+	AuditLogPath               string                    `json:"audit_log_path"`
+	AuditLogMaxBytes           int64                     `json:"audit_log_max_bytes"`
+	AuditLogMaxBackups         int                       `json:"audit_log_max_backups"`
+	AuditLogMaxAge             string                    `json:"audit_log_max_age"`
+	StatsCacheTTL              string                    `json:"stats_cache_ttl"`
+	RouteCacheTTLs             map[string]string         `json:"route_cache_ttls"`
+	SendRateLimit              int                       `json:"send_rate_limit"`
+	RateLimitCooldown          string                    `json:"rate_limit_cooldown"`
+	InlineImageMaxBytes        int                       `json:"inline_image_max_bytes"`
+	InlineImagesMaxTotalBytes  int                       `json:"inline_images_max_total_bytes"`
+	CampaignTTL                string                    `json:"campaign_ttl"`
+	DedupWindow                string                    `json:"dedup_window"`
+	DedupFields                []string                  `json:"dedup_fields"`
+	ThumbnailRenderURL         string                    `json:"thumbnail_render_url"`
+	DefaultInlineLogoPath      string                    `json:"default_inline_logo_path"`
+	TemplateStoreDir           string                    `json:"template_store_dir"`
+	MailgunDomains             map[string]string         `json:"mailgun_domains"`
+	CallbackMaxAttempts        int                       `json:"callback_max_attempts"`
+	MaxMessageBytes            int                       `json:"max_message_bytes"`
+	MailgunProxy               string                    `json:"mailgun_https_proxy"`
+	MailgunMinTLSVersion       string                    `json:"mailgun_min_tls_version"`
+	RedirectAllTo              string                    `json:"redirect_all_to"`
+	MaxRecipients              int                       `json:"max_recipients"`
+	StreamMaxRecipients        int                       `json:"stream_max_recipients"`
+	CSVMaxBytes                int                       `json:"csv_max_bytes"`
+	CSVMaxRows                 int                       `json:"csv_max_rows"`
+	BulkValidationMailgun      bool                      `json:"bulk_validation_mailgun"`
+	BulkValidationRate         int                       `json:"bulk_validation_rate"`
+	BulkValidationConcurrency  int                       `json:"bulk_validation_concurrency"`
+	BulkValidationMaxAddresses int                       `json:"bulk_validation_max_addresses"`
+	DigestWindow               string                    `json:"digest_window"`
+	DigestMaxItems             int                       `json:"digest_max_items"`
+	DigestStatePath            string                    `json:"digest_state_path"`
+	HTMLURLTimeout             string                    `json:"html_url_timeout"`
+	HTMLURLMaxBytes            int                       `json:"html_url_max_bytes"`
+	SendingProfiles            map[string]SendingProfile `json:"sending_profiles"`
+	ShutdownDrainTimeout       string                    `json:"shutdown_drain_timeout"`
+	ReadinessDisabledChecks    []string                  `json:"readiness_disabled_checks"`
+	ReadinessTimeout           string                    `json:"readiness_timeout"`
+	Sources                    map[string]string         `json:"sources"`
+}
+
+// redactSecret masks a secret, keeping only its last 4 characters
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return strings.Repeat("*", 4) + secret[len(secret)-4:]
+}
+
+// maskSecret hides a secret entirely, only revealing whether it is set
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+// redactURL hides the credentials a URL may carry in its userinfo or query
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return maskSecret(raw)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query.Set(key, "xxxxx")
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// redact returns a copy of the config that is safe to expose
+func (c Config) redact() redactedConfig {
+	apiBase, _ := c.APIBase()
+	apiKeys := make([]string, len(c.APIKeys))
+	for i, key := range c.APIKeys {
+		apiKeys[i] = maskSecret(key)
+	}
+
+	routeTimeouts := make(map[string]string, len(c.RouteTimeouts))
+	for path, timeout := range c.RouteTimeouts {
+		routeTimeouts[path] = timeout.String()
+	}
+	routeCacheTTLs := make(map[string]string, len(c.RouteCacheTTLs))
+	for path, ttl := range c.RouteCacheTTLs {
+		routeCacheTTLs[path] = ttl.String()
+	}
+
+	// The proxy URL may carry credentials
+	var mailgunProxy, mailgunMinTLSVersion string
+	if c.MailgunProxy != nil {
+		mailgunProxy = c.MailgunProxy.Redacted()
+	}
+	if c.MailgunMinTLSVersion != 0 {
+		mailgunMinTLSVersion = tls.VersionName(c.MailgunMinTLSVersion)
+	}
+
+	return redactedConfig{
+		Domain:    c.Domain,
+		ApiKey:    redactSecret(c.ApiKey),
+		FromName:  c.FromName,
+		FromEmail: c.FromEmail,
+		LogLevel:  c.LogLevel,
+		LogFormat: c.LogFormat,
+		Port:      c.Port,
+		Region:    c.Region,
+		APIBase:   apiBase,
+		APIKeys:   apiKeys,
+
+		MaxEmailsPerRecipientPerDay: c.MaxEmailsPerRecipientPerDay,
+		MaxEmailsPerDay:             c.MaxEmailsPerDay,
+		TagDailyLimits:              c.TagDailyLimits,
+		JSONMaxBodyBytes:            c.JSONLimits.MaxBodyBytes,
+		JSONMaxDepth:                c.JSONLimits.MaxDepth,
+		JSONMaxArrayElements:        c.JSONLimits.MaxArrayElements,
+		SendingEnabled:              c.SendingEnabled,
+		InlineCSS:                   c.InlineCSS,
+		AutoPlainText:               c.AutoPlainText,
+		TextWrapWidth:               c.TextWrapWidth,
+
+		WebhookSigningKey:       maskSecret(c.WebhookSigningKey),
+		PublicBaseURL:           redactURL(c.PublicBaseURL),
+		ReviewServiceURL:        redactURL(c.ReviewServiceURL),
+		ReviewTTL:               c.ReviewTTL.String(),
+		UnsubscribeSigningKey:   maskSecret(c.UnsubscribeSigningKey),
+		EventForwardURL:         redactURL(c.EventForwardURL),
+		EventForwardMaxAttempts: c.EventForwardMaxAttempts,
+		ArchiveBCC:              c.ArchiveBCC,
+		ArchiveBCCByCategory:    c.ArchiveBCCByCategory,
+		ReplyTo:                 c.ReplyTo,
+		ReplyToByCategory:       c.ReplyToByCategory,
+		RecipientNormalization:  c.RecipientNormalization,
+		SpamCheck:               c.SpamCheck,
+		SpamRules:               c.SpamRules,
+		DefaultLocale:           c.DefaultLocale,
+		SupportedLocales:        c.SupportedLocales,
+		MaintenanceMode:         c.MaintenanceMode,
+		MaintenanceRetryAfter:   c.MaintenanceRetryAfter.String(),
+		SubjectTemplate:         c.SubjectTemplate,
+		EnvSubjectPrefix:        c.EnvSubjectPrefix,
+
+		FromIdentities:             c.FromIdentities,
+		DevMode:                    c.DevMode,
+		RouteTimeouts:              routeTimeouts,
+		DefaultTimeout:             c.DefaultRouteTimeout.String(),
+		DKIMSelectors:              c.DKIMSelectors,
+		DKIMVerifyDNS:              c.DKIMVerifyDNS,
+		MaxRetries:                 c.MaxRetries,
+		RetryBackoff:               c.RetryBackoff.String(),
+		Prewarm:                    c.Prewarm,
+		PrewarmTimeout:             c.PrewarmTimeout.String(),
+		StorageURLLookup:           c.StorageURLLookup,
+		StorageURLTimeout:          c.StorageURLTimeout.String(),
+		AdminEmail:                 c.AdminEmail,
+		TestMode:                   c.TestMode,
+		TestFromEmail:              c.TestFromEmail,
+		AuditLogPath:               c.AuditLogPath,
+		AuditLogMaxBytes:           c.AuditLogRotation.MaxBytes,
+		AuditLogMaxBackups:         c.AuditLogRotation.MaxBackups,
+		AuditLogMaxAge:             c.AuditLogRotation.MaxAge.String(),
+		StatsCacheTTL:              c.StatsCacheTTL.String(),
+		RouteCacheTTLs:             routeCacheTTLs,
+		SendRateLimit:              c.SendRateLimit,
+		RateLimitCooldown:          c.RateLimitCooldown.String(),
+		InlineImageMaxBytes:        c.InlineImageMaxBytes,
+		InlineImagesMaxTotalBytes:  c.InlineImagesMaxTotalBytes,
+		CampaignTTL:                c.CampaignTTL.String(),
+		DedupWindow:                c.DedupWindow.String(),
+		DedupFields:                c.DedupFields,
+		ThumbnailRenderURL:         redactURL(c.ThumbnailRenderURL),
+		DefaultInlineLogoPath:      c.DefaultInlineLogoPath,
+		TemplateStoreDir:           c.TemplateStoreDir,
+		MailgunDomains:             c.MailgunDomains,
+		CallbackMaxAttempts:        c.CallbackMaxAttempts,
+		MaxMessageBytes:            c.MaxMessageBytes,
+		MailgunProxy:               mailgunProxy,
+		MailgunMinTLSVersion:       mailgunMinTLSVersion,
+		RedirectAllTo:              c.RedirectAllTo,
+		MaxRecipients:              c.MaxRecipients,
+		StreamMaxRecipients:        c.StreamMaxRecipients,
+		CSVMaxBytes:                c.CSVMaxBytes,
+		CSVMaxRows:                 c.CSVMaxRows,
+		BulkValidationMailgun:      c.BulkValidationMailgun,
+		BulkValidationRate:         c.BulkValidationRate,
+		BulkValidationConcurrency:  c.BulkValidationConcurrency,
+		BulkValidationMaxAddresses: c.BulkValidationMaxAddresses,
+		DigestWindow:               c.DigestWindow.String(),
+		DigestMaxItems:             c.DigestMaxItems,
+		DigestStatePath:            c.DigestStatePath,
+		HTMLURLTimeout:             c.HTMLURLTimeout.String(),
+		HTMLURLMaxBytes:            c.HTMLURLMaxBytes,
+		SendingProfiles:            c.SendingProfiles,
+		ShutdownDrainTimeout:       c.ShutdownDrainTimeout.String(),
+		ReadinessDisabledChecks:    c.ReadinessDisabledChecks,
+		ReadinessTimeout:           c.ReadinessTimeout.String(),
+		Sources:                    c.Sources,
+	}
+}
+
+// AdminConfigHandler returns the effective configuration with secrets redacted
+func (h *Handler) AdminConfigHandler(c *gin.Context) {
+	c.JSON(200, h.emailService.cfg().redact())
+}
+
+// SetSendingHandler toggles the global send kill-switch
+func (h *Handler) SetSendingHandler(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	h.emailService.SetSendingEnabled(*req.Enabled)
+	slog.Warn("sending kill-switch toggled", "enabled", *req.Enabled)
+
+	c.JSON(200, gin.H{
+		"sending_enabled": h.emailService.SendingEnabled(),
+	})
+}
+
+// RenderTemplateHandler renders a configured template against caller
+// supplied data so template authors can iterate without sending
+func (h *Handler) RenderTemplateHandler(c *gin.Context) {
+	var req struct {
+		Template string         `json:"template"`
+		Data     map[string]any `json:"data"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if req.Template == "" {
+		c.JSON(400, gin.H{
+			"error":     "Missing required fields",
+			"templates": h.emailService.templateNames(),
+		})
+		return
+	}
+
+	output, err := h.emailService.renderTemplate(req.Template, req.Data)
+	if errors.Is(err, ErrUnknownTemplate) {
+		c.JSON(400, gin.H{
+			"error":     err.Error(),
+			"templates": h.emailService.templateNames(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error":   "Template render failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"template": req.Template,
+		"output":   output,
+	})
+}
+
+// sampleProductEmail is the fixed email sent by the test-send endpoint
+func sampleProductEmail(recipient string) ProductEmail {
+	return ProductEmail{
+		ProductName:    "Sample Product",
+		Price:          19.99,
+		Description:    "This is a test email confirming that this environment can deliver product emails.",
+		RecipientEmail: recipient,
+	}
+}
+
+// TestSendHandler sends a sample product email to the configured admin address
+func (h *Handler) TestSendHandler(c *gin.Context) {
+	adminEmail := h.emailService.cfg().AdminEmail
+	if adminEmail == "" {
+		c.JSON(400, gin.H{
+			"error": "ADMIN_EMAIL is not configured",
+		})
+		return
+	}
+
+	ctx := withActor(c.Request.Context(), requestActor(c, h.emailService.cfg().APIKeys))
+	result, err := h.emailService.SendProductEmail(ctx, sampleProductEmail(adminEmail))
+	// Failures and skips are reported like those of any other send
+	if err != nil || result.Skipped != "" {
+		h.writeSendResult(c, result, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":   "Test email sent",
+		"id":        result.ID,
+		"recipient": adminEmail,
+		"test_mode": result.TestMode,
+	})
+}
+
+// ValidateTemplateHandler checks that a template parses and renders with
+// sample data. Nothing is stored or sent.
+func (h *Handler) ValidateTemplateHandler(c *gin.Context) {
+	var req struct {
+		Kind   string `json:"kind"`
+		Source string `json:"source"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if req.Source == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = "text"
+	}
+
+	warnings, err := h.emailService.validateTemplate(req.Kind, req.Source)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error":   "Invalid template",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"valid":    true,
+		"warnings": warnings,
+	})
+}
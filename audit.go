@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one line of the audit trail
+type AuditRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Recipient  string    `json:"recipient"`
+	Product    string    `json:"product"`
+	Result     string    `json:"result"`
+	MessageID  string    `json:"message_id,omitempty"`
+	StorageURL string    `json:"storage_url,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Actor      string    `json:"actor"`
+}
+
+// auditBackupLayout timestamps rotated audit logs; it sorts chronologically
+const auditBackupLayout = "20060102T150405.000000000"
+
+// AuditRotation bounds the disk space of the audit log. Zero values
+// disable the corresponding limit.
+type AuditRotation struct {
+	// MaxBytes rotates the log before a write would take it past this size
+	MaxBytes int64
+	// MaxBackups and MaxAge prune rotated logs by count and age
+	MaxBackups int
+	MaxAge     time.Duration
+}
+
+// AuditLogger appends one JSON line per send attempt to a dedicated file.
+// It is independent of the application logger and its LOG_LEVEL, and every
+// write is flushed to disk before returning.
+type AuditLogger struct {
+	path     string
+	rotation AuditRotation
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewAuditLogger opens (or creates) the audit log at path in append-only
+// mode, rotating it according to rotation
+func NewAuditLogger(path string, rotation AuditRotation) (*AuditLogger, error) {
+	a := &AuditLogger{path: path, rotation: rotation}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open opens the log file and records its current size. Callers hold a.mu
+// or own a.
+func (a *AuditLogger) open() error {
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file, a.size = file, info.Size()
+	return nil
+}
+
+// Write appends a record and syncs it to disk
+func (a *AuditLogger) Write(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if max := a.rotation.MaxBytes; max > 0 && a.size > 0 && a.size+int64(len(line)) > max {
+		if err := a.rotate(); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// rotate moves the current log aside under a timestamped name, starts a new
+// one and prunes old backups. Callers hold a.mu.
+func (a *AuditLogger) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	backup := a.path + "." + time.Now().UTC().Format(auditBackupLayout)
+	if err := os.Rename(a.path, backup); err != nil {
+		// Keep appending to the current file rather than losing records
+		if openErr := a.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	a.prune()
+	return nil
+}
+
+// prune deletes the backups beyond MaxBackups and those older than MaxAge.
+// Failures are logged; they must not block the audit trail.
+func (a *AuditLogger) prune() {
+	backups, err := filepath.Glob(a.path + ".*")
+	if err != nil {
+		return
+	}
+	// Newest first; the timestamp layout sorts chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i, backup := range backups {
+		rotatedAt, err := time.Parse(auditBackupLayout, strings.TrimPrefix(backup, a.path+"."))
+		if err != nil {
+			continue
+		}
+		tooMany := a.rotation.MaxBackups > 0 && i >= a.rotation.MaxBackups
+		tooOld := a.rotation.MaxAge > 0 && time.Since(rotatedAt) > a.rotation.MaxAge
+		if tooMany || tooOld {
+			if err := os.Remove(backup); err != nil {
+				slog.Warn("audit log backup not removed", "path", backup, "error", err)
+			}
+		}
+	}
+}
+
+// Close closes the underlying file
+func (a *AuditLogger) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// SetAuditLogger enables the audit trail for every send attempt
+func (s *EmailService) SetAuditLogger(audit *AuditLogger) {
+	s.audit = audit
+}
+
+// actorKey is the context key carrying the identity of the caller
+type actorKey struct{}
+
+// withActor records who triggered sends made with ctx
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the caller identity carried by ctx
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// maskEmail hides most of the local part of an address, e.g. "j***@example.com"
+func maskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// auditSend writes the audit record for a completed send attempt
+func (s *EmailService) auditSend(ctx context.Context, data ProductEmail, result SendResult, err error) {
+	if s.audit == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Timestamp:  s.clock.Now().UTC(),
+		Recipient:  maskEmail(data.RecipientEmail),
+		Product:    data.ProductName,
+		Result:     "sent",
+		MessageID:  result.ID,
+		StorageURL: result.StorageURL,
+		Actor:      actorFrom(ctx),
+	}
+	switch {
+	case err != nil:
+		record.Result = "failed"
+		record.Error = err.Error()
+	case result.Skipped != "":
+		record.Result = "skipped:" + result.Skipped
+	}
+
+	if writeErr := s.audit.Write(record); writeErr != nil {
+		slog.Error("audit log write failed", "error", writeErr)
+	}
+}
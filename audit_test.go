@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readAudit returns the records of the audit log at path
+func readAudit(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLogRecordsEverySend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := NewAuditLogger(path, AuditRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	service, sender := newTestService(t, map[string]string{"API_KEYS": "secret", "MAX_RETRIES": "0"})
+	service.SetAuditLogger(audit)
+	service.SetPreferences(fakePreferences{optedOut: map[string]string{"sam@example.com": defaultCategory}})
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	send := func(recipient string, headers map[string]string) {
+		do(router, "POST", "/send-product", `{"recipient_email": "`+recipient+`", "product_name": "Desk Lamp"}`, headers)
+	}
+	send("alex@example.com", map[string]string{"X-API-Key": "secret"})
+	send("sam@example.com", nil)
+	sender.mu.Lock()
+	sender.err = errors.New("connection reset")
+	sender.mu.Unlock()
+	send("kim@example.com", nil)
+
+	records := readAudit(t, path)
+	if len(records) != 3 {
+		t.Fatalf("%d audit records, want 3: %+v", len(records), records)
+	}
+	want := []AuditRecord{
+		{Recipient: "a***@example.com", Product: "Desk Lamp", Result: "sent", Actor: apiKeyID("secret")},
+		{Recipient: "s***@example.com", Product: "Desk Lamp", Result: "skipped:" + skipOptedOut, Actor: "anonymous"},
+		{Recipient: "k***@example.com", Product: "Desk Lamp", Result: "failed", Error: "connection reset", Actor: "anonymous"},
+	}
+	for i, record := range records {
+		if record.Timestamp.IsZero() {
+			t.Errorf("record %d has no timestamp", i)
+		}
+		record.Timestamp = want[i].Timestamp
+		if i == 0 {
+			if record.MessageID == "" {
+				t.Error("the sent record has no message id")
+			}
+			record.MessageID = ""
+		}
+		if record != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, record, want[i])
+		}
+	}
+
+	// The log is appended to across restarts
+	audit.Close()
+	reopened, err := NewAuditLogger(path, AuditRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if err := reopened.Write(AuditRecord{Recipient: "x***@example.com", Result: "sent"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(readAudit(t, path)); got != 4 {
+		t.Errorf("%d records after reopening, want 4", got)
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	for email, want := range map[string]string{
+		"alex@example.com": "a***@example.com",
+		"a@example.com":    "a***@example.com",
+		"@example.com":     "***",
+		"not an email":     "***",
+	} {
+		if got := maskEmail(email); got != want {
+			t.Errorf("maskEmail(%q) = %q, want %q", email, got, want)
+		}
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	record := AuditRecord{Recipient: "a***@example.com", Product: "Desk Lamp", Result: "sent", Actor: "anonymous"}
+	line, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two records fit in a file, a third rotates it
+	maxBytes := int64(2*len(line) + 2)
+	write := func(t *testing.T, path string, rotation AuditRotation, n int) []string {
+		t.Helper()
+		audit, err := NewAuditLogger(path, rotation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer audit.Close()
+		for range n {
+			if err := audit.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backups, err := filepath.Glob(path + ".2*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backups
+	}
+
+	t.Run("by count", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		backups := write(t, path, AuditRotation{MaxBytes: maxBytes, MaxBackups: 2}, 7)
+		if records := readAudit(t, path); len(records) != 1 {
+			t.Errorf("current log has %d records, want 1", len(records))
+		}
+		if len(backups) != 2 {
+			t.Fatalf("backups = %v, want the newest 2", backups)
+		}
+		for _, backup := range backups {
+			if records := readAudit(t, backup); len(records) != 2 {
+				t.Errorf("%s has %d records, want 2", backup, len(records))
+			}
+		}
+	})
+
+	t.Run("by age", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		// A backup past MaxAge and a file that isn't a backup at all
+		stale := path + "." + time.Now().Add(-48*time.Hour).UTC().Format(auditBackupLayout)
+		for _, name := range []string{stale, path + ".bak"} {
+			if err := os.WriteFile(name, []byte("{}\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backups := write(t, path, AuditRotation{MaxBytes: maxBytes, MaxAge: 24 * time.Hour}, 3)
+		if len(backups) != 1 || backups[0] == stale {
+			t.Errorf("backups = %v, want only the new one", backups)
+		}
+		if _, err := os.Stat(path + ".bak"); err != nil {
+			t.Errorf("a file that isn't a backup was removed: %v", err)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		if backups := write(t, path, AuditRotation{}, 7); len(backups) != 0 {
+			t.Errorf("backups = %v without a size limit", backups)
+		}
+		if records := readAudit(t, path); len(records) != 7 {
+			t.Errorf("log has %d records, want 7", len(records))
+		}
+	})
+}
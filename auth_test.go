@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	router := newTestRouter()
+	router.GET("/private", RequireAPIKey([]string{"first-key", "second-key"}), func(c *gin.Context) {
+		c.String(200, requestActor(c, []string{"first-key", "second-key"}))
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		actor   string
+	}{
+		{name: "X-API-Key", headers: map[string]string{"X-API-Key": "first-key"}, status: 200, actor: apiKeyID("first-key")},
+		{name: "bearer", headers: map[string]string{"Authorization": "Bearer second-key"}, status: 200, actor: apiKeyID("second-key")},
+		{name: "bearer scheme is case-insensitive", headers: map[string]string{"Authorization": "bearer first-key"}, status: 200, actor: apiKeyID("first-key")},
+		{name: "authorization wins", headers: map[string]string{"Authorization": "Bearer second-key", "X-API-Key": "first-key"}, status: 200, actor: apiKeyID("second-key")},
+		{name: "wrong bearer despite a valid X-API-Key", headers: map[string]string{"Authorization": "Bearer wrong-key", "X-API-Key": "first-key"}, status: 401},
+		{name: "wrong key", headers: map[string]string{"X-API-Key": "wrong-key"}, status: 401},
+		{name: "key prefix", headers: map[string]string{"X-API-Key": "first"}, status: 401},
+		{name: "basic scheme", headers: map[string]string{"Authorization": "Basic Zmlyc3Qta2V5"}, status: 401},
+		{name: "bearer without a token", headers: map[string]string{"Authorization": "Bearer"}, status: 401},
+		{name: "missing", status: 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(router, "GET", "/private", "", tt.headers)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == 401 {
+				if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="vue-go"` {
+					t.Errorf("WWW-Authenticate = %q", got)
+				}
+				return
+			}
+			if got := w.Body.String(); got != tt.actor {
+				t.Errorf("actor = %q, want %q", got, tt.actor)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKeyWithoutKeysRejectsEverything(t *testing.T) {
+	router := newTestRouter()
+	router.GET("/private", RequireAPIKey(nil), func(c *gin.Context) { c.Status(200) })
+	for _, headers := range []map[string]string{nil, {"X-API-Key": ""}, {"Authorization": "Bearer "}} {
+		if w := do(router, "GET", "/private", "", headers); w.Code != 401 {
+			t.Errorf("headers %v: status = %d, want 401", headers, w.Code)
+		}
+	}
+}
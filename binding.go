@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSONLimits bounds the shape of JSON request bodies so that pathological
+// payloads are rejected before they are decoded into structs
+type JSONLimits struct {
+	// MaxBodyBytes caps the size of the request body itself
+	MaxBodyBytes     int
+	MaxDepth         int
+	MaxArrayElements int
+}
+
+// JSONLimitError reports which decoding limit a payload exceeded
+type JSONLimitError struct {
+	Limit string
+	Max   int
+}
+
+func (e *JSONLimitError) Error() string {
+	return fmt.Sprintf("JSON %s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// checkJSONLimits walks the JSON tokens of body and fails as soon as a limit
+// is exceeded. A zero limit disables that check.
+func checkJSONLimits(body []byte, limits JSONLimits) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+
+	// counts holds the number of elements seen in each open array; -1 marks
+	// an open object
+	var counts []int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		delim, isDelim := tok.(json.Delim)
+		if isDelim && (delim == ']' || delim == '}') {
+			counts = counts[:len(counts)-1]
+			continue
+		}
+
+		// Every value (including nested containers) counts towards the
+		// enclosing array; members of objects are not counted
+		if n := len(counts); n > 0 && counts[n-1] >= 0 {
+			counts[n-1]++
+			if limits.MaxArrayElements > 0 && counts[n-1] > limits.MaxArrayElements {
+				return &JSONLimitError{Limit: "max_array_elements", Max: limits.MaxArrayElements}
+			}
+		}
+
+		if isDelim {
+			if limits.MaxDepth > 0 && len(counts)+1 > limits.MaxDepth {
+				return &JSONLimitError{Limit: "max_depth", Max: limits.MaxDepth}
+			}
+			if delim == '[' {
+				counts = append(counts, 0)
+			} else {
+				counts = append(counts, -1)
+			}
+		}
+	}
+}
+
+// requestBodyKey is the gin context key holding the buffered request body
+const requestBodyKey = "request_body"
+
+// requestBody returns the request body, reading it from the client only
+// once. The body is kept on the context and c.Request.Body is rewound on
+// every call, so middleware can inspect it and handlers can still bind it.
+// Reading fails with an *http.MaxBytesError past maxBytes, unless zero.
+func requestBody(c *gin.Context, maxBytes int) ([]byte, error) {
+	var body []byte
+	if buffered, ok := c.Get(requestBodyKey); ok {
+		body = buffered.([]byte)
+	} else {
+		reader := c.Request.Body
+		if maxBytes > 0 {
+			reader = http.MaxBytesReader(c.Writer, reader, int64(maxBytes))
+		}
+		var err error
+		if body, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+		c.Set(requestBodyKey, body)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// bindJSON decodes the request body into v after enforcing the configured
+// JSON limits. It writes a 400 response and returns false on failure.
+func (h *Handler) bindJSON(c *gin.Context, v any) bool {
+	return bindJSONWithLimits(c, h.emailService.cfg().JSONLimits, v)
+}
+
+// bindJSONWithLimits decodes the request body into v after enforcing
+// limits, writing a 413 or 400 response and returning false on failure
+func bindJSONWithLimits(c *gin.Context, limits JSONLimits, v any) bool {
+	body, err := requestBody(c, limits.MaxBodyBytes)
+	if err == nil {
+		err = checkJSONLimits(body, limits)
+	}
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err == nil {
+		return true
+	}
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		c.JSON(413, gin.H{
+			"error":   "Request body too large",
+			"details": fmt.Sprintf("request bodies are limited to %d bytes", maxErr.Limit),
+		})
+		return false
+	}
+
+	var limitErr *JSONLimitError
+	if errors.As(err, &limitErr) {
+		c.JSON(400, gin.H{
+			"error":   "Request body exceeds JSON limits",
+			"details": limitErr.Error(),
+			"limit":   limitErr.Limit,
+		})
+		return false
+	}
+
+	c.JSON(400, gin.H{
+		"error":   "Invalid request body",
+		"details": err.Error(),
+	})
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckJSONLimits(t *testing.T) {
+	limits := JSONLimits{MaxDepth: 3, MaxArrayElements: 2}
+	tests := []struct {
+		name  string
+		body  string
+		limit string
+	}{
+		{name: "within limits", body: `{"a": {"b": [1, 2]}}`},
+		{name: "nested arrays in budget", body: `[[1, 2], [3]]`},
+		{name: "at max depth", body: `{"a": {"b": {"c": 1}}}`},
+		{name: "too deep", body: `{"a": {"b": {"c": [1]}}}`, limit: "max_depth"},
+		{name: "too many elements", body: `{"recipients": [1, 2, 3]}`, limit: "max_array_elements"},
+		{name: "object members aren't elements", body: `{"a": 1, "b": 2, "c": 3}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkJSONLimits([]byte(tt.body), limits)
+			var limitErr *JSONLimitError
+			switch {
+			case tt.limit == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.limit != "" && !errors.As(err, &limitErr):
+				t.Errorf("err = %v, want the %s limit", err, tt.limit)
+			case tt.limit != "" && limitErr.Limit != tt.limit:
+				t.Errorf("limit = %s, want %s", limitErr.Limit, tt.limit)
+			}
+		})
+	}
+}
+
+func TestBindJSONEnforcesLimits(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{
+		"JSON_MAX_BODY_BYTES":     "512",
+		"JSON_MAX_DEPTH":          "3",
+		"JSON_MAX_ARRAY_ELEMENTS": "2",
+	})
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		limit  string
+	}{
+		{
+			name:   "too deep",
+			body:   `{"recipient_email": "alex@example.com", "product_name": "Lamp", "custom_variables": {"a": {"b": {"c": "d"}}}}`,
+			status: 400,
+			limit:  "max_depth",
+		},
+		{
+			name:   "too many elements",
+			body:   `{"recipient_email": "alex@example.com", "product_name": "Lamp", "recipients": ["a@example.com", "b@example.com", "c@example.com"]}`,
+			status: 400,
+			limit:  "max_array_elements",
+		},
+		{
+			name:   "too large",
+			body:   `{"recipient_email": "alex@example.com", "product_name": "Lamp", "description": "` + strings.Repeat("x", 600) + `"}`,
+			status: 413,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(router, "POST", "/send-product", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			var response struct {
+				Limit string `json:"limit"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if response.Limit != tt.limit {
+				t.Errorf("limit = %q, want %q", response.Limit, tt.limit)
+			}
+		})
+	}
+	if sent := sender.sent(); len(sent) != 0 {
+		t.Errorf("%d messages sent for rejected requests", len(sent))
+	}
+}
+
+func TestRequestBodyIsReadOnce(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	var seen string
+	inspect := func(c *gin.Context) {
+		body, err := requestBody(c, 0)
+		if err != nil {
+			c.AbortWithStatus(500)
+			return
+		}
+		seen = string(body)
+		// A second read comes from the buffer, not the drained client body
+		if again, _ := requestBody(c, 0); string(again) != seen {
+			t.Errorf("second read = %q, want %q", again, seen)
+		}
+	}
+	router.POST("/send-product", inspect, NewHandler(service).SendProductHandler)
+
+	const body = `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`
+	if w := do(router, "POST", "/send-product", body, nil); w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if seen != body {
+		t.Errorf("middleware read %q, want %q", seen, body)
+	}
+	if got := sender.last(t).To(); len(got) != 1 || got[0] != "alex@example.com" {
+		t.Errorf("to = %v, the handler didn't bind the body", got)
+	}
+}
+
+func TestRequestBodyRewindsForHandlers(t *testing.T) {
+	router := newTestRouter()
+	router.POST("/echo", func(c *gin.Context) {
+		if _, err := requestBody(c, 0); err != nil {
+			c.AbortWithStatus(500)
+		}
+	}, func(c *gin.Context) {
+		// Handlers that read c.Request.Body directly still see the body
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(200, string(body))
+	})
+	if w := do(router, "POST", "/echo", `{"a": 1}`, nil); w.Body.String() != `{"a": 1}` {
+		t.Errorf("body = %q", w.Body)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	router := newTestRouter()
+	router.POST("/echo", func(c *gin.Context) {
+		var maxErr *http.MaxBytesError
+		if _, err := requestBody(c, 8); !errors.As(err, &maxErr) {
+			t.Errorf("err = %v, want an *http.MaxBytesError", err)
+		}
+		if _, ok := c.Get(requestBodyKey); ok {
+			t.Error("a truncated body was buffered")
+		}
+	})
+	do(router, "POST", "/echo", `{"description": "too long"}`, nil)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Body formats selectable with ProductEmail.BodyFormat
+const (
+	bodyFormatText = "text"
+	bodyFormatHTML = "html"
+	bodyFormatBoth = "both"
+)
+
+// ErrInvalidBodyFormat is returned for unknown body_format values
+var ErrInvalidBodyFormat = errors.New("invalid body_format")
+
+// errNoHTMLBody is returned when an HTML-only email has no HTML body to send
+var errNoHTMLBody = errors.New("no html body to send")
+
+// bodyFormat returns which bodies the email carries, both by default
+func (data ProductEmail) bodyFormat() (string, error) {
+	switch data.BodyFormat {
+	case "":
+		return bodyFormatBoth, nil
+	case bodyFormatText, bodyFormatHTML, bodyFormatBoth:
+		return data.BodyFormat, nil
+	default:
+		return "", fmt.Errorf("%w %q: must be text, html or both", ErrInvalidBodyFormat, data.BodyFormat)
+	}
+}
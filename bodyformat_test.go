@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBodyFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		status int
+		text   bool
+		html   bool
+	}{
+		{format: "", status: 200, text: true, html: true},
+		{format: "both", status: 200, text: true, html: true},
+		{format: "text", status: 200, text: true},
+		{format: "html", status: 200, html: true},
+		{format: "amp", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "body_format": "`+tt.format+`"}`, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				if len(sender.sent()) != 0 {
+					t.Error("a message was sent")
+				}
+				return
+			}
+			message := plain(t, sender.last(t))
+			if text := strings.Contains(message.Text(), "Desk Lamp"); text != tt.text {
+				t.Errorf("text body present = %v, want %v:\n%s", text, tt.text, message.Text())
+			}
+			if html := strings.Contains(message.HTML(), "Desk Lamp"); html != tt.html {
+				t.Errorf("html body present = %v, want %v:\n%s", html, tt.html, message.HTML())
+			}
+		})
+	}
+}
+
+func TestTextOnlyBodyFromHTMLURL(t *testing.T) {
+	// The text body is derived from the fetched HTML even with
+	// AUTO_PLAIN_TEXT off, since it is the only body sent
+	service, sender := newTestService(t, map[string]string{"AUTO_PLAIN_TEXT": "false"})
+	service.htmlFetcher.client.Transport = serveDocument(200, "text/html", `<html><body><h1>Spring sale</h1></body></html>`)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "html_url": "https://cms.example.com/spring.html", "body_format": "text"}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	message := plain(t, sender.last(t))
+	if message.HTML() != "" {
+		t.Errorf("text-only email has an HTML body:\n%s", message.HTML())
+	}
+	if !strings.Contains(message.Text(), "Spring sale") {
+		t.Errorf("text body = %q, want it derived from the HTML", message.Text())
+	}
+}
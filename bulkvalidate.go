@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// AddressCheck is the validation result of one address of a bulk request
+type AddressCheck struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+	Valid   bool   `json:"valid"`
+	// Reason explains why an address is invalid or risky
+	Reason     string `json:"reason,omitempty"`
+	DidYouMean string `json:"did_you_mean,omitempty"`
+	Risk       string `json:"risk,omitempty"`
+	// Error is set when Mailgun couldn't validate the address
+	Error string `json:"error,omitempty"`
+}
+
+// BulkValidator checks lists of addresses, syntactically and with Mailgun's
+// validation API when one is configured
+type BulkValidator struct {
+	mailgun mailgun.EmailValidator
+	limiter *AdaptiveLimiter
+	workers int
+}
+
+// NewBulkValidator creates a validator running workers checks at a time.
+// A nil validator limits the checks to syntax; otherwise calls to Mailgun
+// are paced at rate per second, backing off for cooldown after a 429.
+func NewBulkValidator(clock Clock, validator mailgun.EmailValidator, workers, rate int, cooldown time.Duration) *BulkValidator {
+	v := &BulkValidator{
+		mailgun: validator,
+		workers: max(workers, 1),
+	}
+	if validator != nil && rate > 0 {
+		v.limiter = NewAdaptiveLimiter(clock, rate, cooldown)
+	}
+	return v
+}
+
+// newMailgunValidator creates a client for Mailgun's v4 validation API in
+// the configured region
+func newMailgunValidator(config Config) *mailgun.EmailValidatorImpl {
+	validator := mailgun.NewEmailValidator(config.ApiKey)
+	if apiBase, err := config.APIBase(); err == nil {
+		validator.SetAPIBase(strings.TrimSuffix(apiBase, "/v3") + "/v4")
+	}
+	if transport := mailgunTransport(config); transport != nil {
+		validator.SetClient(&http.Client{Transport: transport})
+	}
+	return validator
+}
+
+// Validate checks every address with a bounded pool of workers. Results
+// keep the order of addresses.
+func (v *BulkValidator) Validate(ctx context.Context, addresses []string) []AddressCheck {
+	results := make([]AddressCheck, len(addresses))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(v.workers, len(addresses)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = v.check(ctx, addresses[i])
+				results[i].Index = i
+			}
+		}()
+	}
+	for i := range addresses {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// bareAddress returns address without surrounding space, reporting false
+// unless it is a plain address, without display name, on a dotted domain
+func bareAddress(address string) (string, bool) {
+	address = strings.TrimSpace(address)
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address {
+		return "", false
+	}
+	domain := address[strings.LastIndexByte(address, '@')+1:]
+	return address, strings.Contains(domain, ".")
+}
+
+// check validates a single address
+func (v *BulkValidator) check(ctx context.Context, address string) AddressCheck {
+	result := AddressCheck{Address: address}
+	address, ok := bareAddress(address)
+	if !ok {
+		result.Reason = "invalid_syntax"
+		return result
+	}
+	if v.mailgun == nil {
+		result.Valid = true
+		return result
+	}
+
+	if v.limiter != nil {
+		if err := v.limiter.Wait(ctx); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	verification, err := v.mailgun.ValidateEmail(ctx, address, false)
+	if err != nil {
+		if v.limiter != nil && isRateLimited(err) {
+			v.limiter.Throttle()
+		}
+		result.Error = err.Error()
+		return result
+	}
+	// Only addresses Mailgun is confident about are rejected; catch-all and
+	// unknown results may still be deliverable
+	result.Valid = verification.Result != "undeliverable" && verification.Result != "do_not_send"
+	result.Reason = strings.Join(verification.Reasons, ", ")
+	result.DidYouMean = verification.DidYouMean
+	result.Risk = verification.Risk
+	return result
+}
+
+// ValidateBulkHandler validates a list of addresses before a campaign
+func (h *Handler) ValidateBulkHandler(c *gin.Context) {
+	var req struct {
+		Addresses []string `json:"addresses"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if len(req.Addresses) == 0 {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+	if limit := h.emailService.cfg().BulkValidationMaxAddresses; limit > 0 && len(req.Addresses) > limit {
+		c.JSON(413, gin.H{
+			"error":         "Too many addresses",
+			"max_addresses": limit,
+		})
+		return
+	}
+
+	results := h.emailService.bulkValidator.Validate(c.Request.Context(), req.Addresses)
+	valid := 0
+	for _, result := range results {
+		if result.Valid {
+			valid++
+		}
+	}
+	c.JSON(200, gin.H{
+		"results": results,
+		"valid":   valid,
+		"invalid": len(results) - valid,
+	})
+}
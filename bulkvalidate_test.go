@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// fakeValidator answers address validations from a table, counting how many
+// run at once
+type fakeValidator struct {
+	results map[string]mailgun.EmailVerification
+	err     error
+
+	mu      sync.Mutex
+	active  int
+	peak    int
+	checked []string
+}
+
+// ValidateEmail implements mailgun.EmailValidator
+func (f *fakeValidator) ValidateEmail(_ context.Context, email string, _ bool) (mailgun.EmailVerification, error) {
+	f.mu.Lock()
+	f.active++
+	f.peak = max(f.peak, f.active)
+	f.checked = append(f.checked, email)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+
+	if f.err != nil {
+		return mailgun.EmailVerification{}, f.err
+	}
+	return f.results[email], nil
+}
+
+// ParseAddresses implements mailgun.EmailValidator
+func (f *fakeValidator) ParseAddresses(context.Context, ...string) ([]string, []string, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func TestBulkValidatorWithMailgun(t *testing.T) {
+	validator := &fakeValidator{results: map[string]mailgun.EmailVerification{
+		"alex@example.com": {Result: "deliverable", Risk: "low"},
+		"sam@gmial.com":    {Result: "undeliverable", Reasons: []string{"no_mx", "typo"}, DidYouMean: "sam@gmail.com", Risk: "high"},
+		"info@example.com": {Result: "catch_all", Risk: "medium"},
+		"kim@example.com":  {Result: "do_not_send", Reasons: []string{"high_risk"}},
+	}}
+	addresses := []string{" alex@example.com ", "sam@gmial.com", "not an address", "info@example.com", "kim@example.com", "jo@localhost"}
+	results := NewBulkValidator(realClock{}, validator, 2, 0, 0).Validate(context.Background(), addresses)
+
+	want := []AddressCheck{
+		{Index: 0, Address: " alex@example.com ", Valid: true, Risk: "low"},
+		{Index: 1, Address: "sam@gmial.com", Reason: "no_mx, typo", DidYouMean: "sam@gmail.com", Risk: "high"},
+		{Index: 2, Address: "not an address", Reason: "invalid_syntax"},
+		{Index: 3, Address: "info@example.com", Valid: true, Risk: "medium"},
+		{Index: 4, Address: "kim@example.com", Reason: "high_risk"},
+		{Index: 5, Address: "jo@localhost", Reason: "invalid_syntax"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("%d results, want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+	// Only well-formed addresses reach Mailgun, two at a time at most
+	if len(validator.checked) != 4 {
+		t.Errorf("Mailgun checked %v", validator.checked)
+	}
+	if validator.peak > 2 {
+		t.Errorf("%d checks ran at once, want at most 2", validator.peak)
+	}
+}
+
+func TestBulkValidatorReportsMailgunErrors(t *testing.T) {
+	validator := &fakeValidator{err: errors.New("validation unavailable")}
+	results := NewBulkValidator(realClock{}, validator, 1, 0, 0).Validate(context.Background(), []string{"alex@example.com"})
+	if got := results[0]; got.Valid || got.Error != "validation unavailable" {
+		t.Errorf("result = %+v, want the error reported", got)
+	}
+}
+
+func TestValidateBulkHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		body    string
+		status  int
+		valid   int
+		invalid int
+	}{
+		{name: "syntax only", body: `{"addresses": ["alex@example.com", "Alex <alex@example.com>", "sam@"]}`, status: 200, valid: 1, invalid: 2},
+		{name: "no addresses", body: `{"addresses": []}`, status: 400},
+		{name: "too many", env: map[string]string{"BULK_VALIDATION_MAX_ADDRESSES": "2"}, body: `{"addresses": ["a@example.com", "b@example.com", "c@example.com"]}`, status: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/validate-bulk", NewHandler(service).ValidateBulkHandler)
+
+			w := do(router, "POST", "/validate-bulk", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				return
+			}
+			var response struct {
+				Results []AddressCheck `json:"results"`
+				Valid   int            `json:"valid"`
+				Invalid int            `json:"invalid"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Valid != tt.valid || response.Invalid != tt.invalid || len(response.Results) != tt.valid+tt.invalid {
+				t.Errorf("response = %+v", response)
+			}
+			if !strings.Contains(w.Body.String(), `"reason":"invalid_syntax"`) {
+				t.Errorf("invalid addresses have no reason: %s", w.Body)
+			}
+		})
+	}
+}
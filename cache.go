@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheBypassParam skips the response cache when present in the query
+const cacheBypassParam = "nocache"
+
+// defaultCacheTTLs are the per-route response cache TTLs used unless
+// overridden by ROUTE_CACHE_TTLS
+var defaultCacheTTLs = map[string]time.Duration{
+	"/version":      time.Minute,
+	"/stats":        5 * time.Second,
+	"/quota":        5 * time.Second,
+	"/admin/stats":  time.Minute,
+	"/capabilities": time.Minute,
+}
+
+// parseRouteCacheTTLs parses "path=duration" pairs separated by commas, e.g.
+// "/quota=10s,/stats=0s", on top of the defaults. A zero TTL disables
+// caching for the route.
+func parseRouteCacheTTLs(raw string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration, len(defaultCacheTTLs))
+	for path, ttl := range defaultCacheTTLs {
+		ttls[path] = ttl
+	}
+
+	for _, pair := range splitList(raw) {
+		path, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid ROUTE_CACHE_TTLS entry %q (expected path=duration)", pair)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid ROUTE_CACHE_TTLS duration for %s: %q", path, value)
+		}
+		if ttl == 0 {
+			delete(ttls, strings.TrimSpace(path))
+			continue
+		}
+		ttls[strings.TrimSpace(path)] = ttl
+	}
+	return ttls, nil
+}
+
+// cachedResponse is a stored 200 response
+type cachedResponse struct {
+	contentType string
+	body        []byte
+	expiresAt   time.Time
+}
+
+// ResponseCache briefly caches the responses of read-mostly GET routes
+type ResponseCache struct {
+	clock Clock
+	ttls  map[string]time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+// NewResponseCache creates a cache with TTLs keyed by route path
+func NewResponseCache(clock Clock, ttls map[string]time.Duration) *ResponseCache {
+	return &ResponseCache{
+		clock:   clock,
+		ttls:    ttls,
+		entries: make(map[string]cachedResponse),
+	}
+}
+
+// cacheKey identifies a response by route and query, ignoring the bypass
+// parameter. Encode sorts the parameters, so their order doesn't matter.
+func cacheKey(c *gin.Context) string {
+	query := url.Values{}
+	for name, values := range c.Request.URL.Query() {
+		if name != cacheBypassParam {
+			query[name] = values
+		}
+	}
+	return c.FullPath() + "?" + query.Encode()
+}
+
+// cachingWriter records the body of a response while writing it and sets
+// the cache headers once the status is known
+type cachingWriter struct {
+	gin.ResponseWriter
+	ttl  time.Duration
+	body bytes.Buffer
+}
+
+// WriteHeader marks only successful responses as cacheable
+func (w *cachingWriter) WriteHeader(code int) {
+	if code == 200 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(w.ttl.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cachingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cachingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Middleware serves GET requests for routes with a TTL from the cache,
+// storing successful responses. Register it after any authentication so
+// cached responses aren't served to unauthenticated callers. Adding
+// ?nocache to the query fetches a fresh response.
+func (rc *ResponseCache) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ttl, ok := rc.ttls[c.FullPath()]
+		if !ok || c.Request.Method != "GET" {
+			c.Next()
+			return
+		}
+
+		key := cacheKey(c)
+		now := rc.clock.Now()
+		_, bypass := c.Request.URL.Query()[cacheBypassParam]
+		if !bypass {
+			rc.mu.Lock()
+			entry, found := rc.entries[key]
+			rc.mu.Unlock()
+			if found && now.Before(entry.expiresAt) {
+				remaining := entry.expiresAt.Sub(now).Round(time.Second)
+				c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(remaining.Seconds())))
+				c.Header("X-Cache", "HIT")
+				c.Data(200, entry.contentType, entry.body)
+				c.Abort()
+				return
+			}
+		}
+
+		if bypass {
+			c.Header("X-Cache", "BYPASS")
+		} else {
+			c.Header("X-Cache", "MISS")
+		}
+		writer := &cachingWriter{ResponseWriter: c.Writer, ttl: ttl}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.Status() != 200 {
+			return
+		}
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		for k, entry := range rc.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(rc.entries, k)
+			}
+		}
+		rc.entries[key] = cachedResponse{
+			contentType: writer.Header().Get("Content-Type"),
+			body:        writer.body.Bytes(),
+			expiresAt:   now.Add(ttl),
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseCache(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewResponseCache(clock, map[string]time.Duration{"/quota": 5 * time.Second})
+	calls, status := 0, 200
+	handler := func(c *gin.Context) {
+		calls++
+		c.JSON(status, gin.H{"calls": calls})
+	}
+	router := newTestRouter()
+	router.GET("/quota", cache.Middleware(), handler)
+	router.POST("/quota", cache.Middleware(), handler)
+	router.GET("/uncached", cache.Middleware(), handler)
+
+	tests := []struct {
+		name         string
+		advance      time.Duration
+		method       string
+		target       string
+		status       int
+		xCache       string
+		cacheControl string
+		calls        int
+	}{
+		{name: "first request", target: "/quota", xCache: "MISS", cacheControl: "private, max-age=5", calls: 1},
+		{name: "within the TTL", advance: 2 * time.Second, target: "/quota", xCache: "HIT", cacheControl: "private, max-age=3", calls: 1},
+		{name: "query order ignored", target: "/quota?b=2&a=1", xCache: "MISS", cacheControl: "private, max-age=5", calls: 2},
+		{name: "same query reordered", target: "/quota?a=1&b=2", xCache: "HIT", cacheControl: "private, max-age=5", calls: 2},
+		{name: "bypass", target: "/quota?nocache", xCache: "BYPASS", cacheControl: "private, max-age=5", calls: 3},
+		{name: "bypass refreshed the entry", advance: 3 * time.Second, target: "/quota", xCache: "HIT", cacheControl: "private, max-age=2", calls: 3},
+		{name: "after the TTL", advance: 2 * time.Second, target: "/quota", xCache: "MISS", cacheControl: "private, max-age=5", calls: 4},
+		{name: "post", method: "POST", target: "/quota", calls: 5},
+		{name: "route without a TTL", target: "/uncached", calls: 6},
+		{name: "failures aren't cached", advance: time.Minute, target: "/quota", status: 500, xCache: "MISS", cacheControl: "no-store", calls: 7},
+		{name: "after a failure", target: "/quota", xCache: "MISS", cacheControl: "private, max-age=5", calls: 8},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		status = 200
+		if tt.status != 0 {
+			status = tt.status
+		}
+		method := tt.method
+		if method == "" {
+			method = "GET"
+		}
+		w := do(router, method, tt.target, "", nil)
+		var body struct {
+			Calls int `json:"calls"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := w.Header().Get("X-Cache"); got != tt.xCache {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, tt.xCache)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.cacheControl)
+		}
+		if calls != tt.calls || body.Calls != tt.calls {
+			t.Errorf("%s: handler ran %d times and served call %d, want %d", tt.name, calls, body.Calls, tt.calls)
+		}
+	}
+}
+
+func TestParseRouteCacheTTLs(t *testing.T) {
+	ttls, err := parseRouteCacheTTLs("/quota=10s, /stats=0s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttls["/quota"] != 10*time.Second {
+		t.Errorf("/quota TTL = %v, want 10s", ttls["/quota"])
+	}
+	if _, ok := ttls["/stats"]; ok {
+		t.Error("a zero TTL didn't disable caching")
+	}
+	if ttls["/version"] != defaultCacheTTLs["/version"] {
+		t.Errorf("/version TTL = %v, want the default", ttls["/version"])
+	}
+	for _, raw := range []string{"/quota", "/quota=soon", "/quota=-1s"} {
+		if _, err := parseRouteCacheTTLs(raw); err == nil {
+			t.Errorf("parseRouteCacheTTLs(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	service, _ := newTestService(t, nil)
+	router := newTestRouter()
+	router.GET("/version", NewHandler(service).VersionHandler)
+
+	w := do(router, "GET", "/version", "", nil)
+	var response struct {
+		Version   string `json:"version"`
+		GoVersion string `json:"go_version"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 200 || response.Version == "" || response.GoVersion == "" {
+		t.Errorf("status %d, response %s", w.Code, w.Body)
+	}
+}
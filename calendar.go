@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// calendarTimeFormat is the iCalendar UTC date-time format
+const calendarTimeFormat = "20060102T150405Z"
+
+// ErrInvalidEvent is returned when the calendar event fields are invalid
+var ErrInvalidEvent = errors.New("invalid event")
+
+// CalendarEvent describes an optional calendar invite attached to the email
+type CalendarEvent struct {
+	Title    string `json:"title"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Location string `json:"location"`
+}
+
+// parseTimes validates the event and returns its start and end times
+func (e CalendarEvent) parseTimes() (time.Time, time.Time, error) {
+	if strings.TrimSpace(e.Title) == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: title is required", ErrInvalidEvent)
+	}
+	start, err := time.Parse(time.RFC3339, e.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: start must be an RFC 3339 timestamp", ErrInvalidEvent)
+	}
+	end, err := time.Parse(time.RFC3339, e.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: end must be an RFC 3339 timestamp", ErrInvalidEvent)
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: start must be before end", ErrInvalidEvent)
+	}
+	return start, end, nil
+}
+
+// buildICS renders the event as an iCalendar REQUEST with a single VEVENT
+func buildICS(event CalendarEvent, uid, organizer, attendee string, now time.Time) ([]byte, error) {
+	start, end, err := event.parseTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//vue-go//Product Email//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:REQUEST",
+		"BEGIN:VEVENT",
+		"UID:" + uid,
+		"DTSTAMP:" + now.UTC().Format(calendarTimeFormat),
+		"DTSTART:" + start.UTC().Format(calendarTimeFormat),
+		"DTEND:" + end.UTC().Format(calendarTimeFormat),
+		"SUMMARY:" + escapeICSText(event.Title),
+	}
+	if event.Location != "" {
+		lines = append(lines, "LOCATION:"+escapeICSText(event.Location))
+	}
+	lines = append(lines,
+		"ORGANIZER:mailto:"+organizer,
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;RSVP=TRUE:mailto:"+attendee,
+		"STATUS:CONFIRMED",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+
+	var ics strings.Builder
+	for _, line := range lines {
+		ics.WriteString(foldICSLine(line))
+		ics.WriteString("\r\n")
+	}
+	return []byte(ics.String()), nil
+}
+
+// escapeICSText escapes a TEXT value per RFC 5545 section 3.3.11
+func escapeICSText(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(text)
+}
+
+// foldICSLine folds content lines longer than 75 octets without splitting
+// multi-byte characters
+func foldICSLine(line string) string {
+	const limit = 75
+	if len(line) <= limit {
+		return line
+	}
+
+	var folded strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > limit {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	return folded.String()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildICS(t *testing.T) {
+	event := CalendarEvent{
+		Title:    "Launch; demo, Q&A",
+		Start:    "2026-03-01T10:00:00+01:00",
+		End:      "2026-03-01T11:30:00+01:00",
+		Location: "Main hall\nFloor 2",
+	}
+	now := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	raw, err := buildICS(event, "abc@mg.example.com", "shop@mg.example.com", "alex@example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ics := string(raw)
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"METHOD:REQUEST\r\n",
+		"UID:abc@mg.example.com\r\n",
+		"DTSTAMP:20260201T120000Z\r\n",
+		// Times are converted to UTC
+		"DTSTART:20260301T090000Z\r\n",
+		"DTEND:20260301T103000Z\r\n",
+		`SUMMARY:Launch\; demo\, Q&A` + "\r\n",
+		`LOCATION:Main hall\nFloor 2` + "\r\n",
+		"ORGANIZER:mailto:shop@mg.example.com\r\n",
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;RSVP=TRUE:mailto:alex@example.com\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("invite is missing %q:\n%s", want, ics)
+		}
+	}
+}
+
+func TestCalendarEventValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		event CalendarEvent
+	}{
+		{name: "no title", event: CalendarEvent{Start: "2026-03-01T10:00:00Z", End: "2026-03-01T11:00:00Z"}},
+		{name: "bad start", event: CalendarEvent{Title: "Launch", Start: "tomorrow", End: "2026-03-01T11:00:00Z"}},
+		{name: "bad end", event: CalendarEvent{Title: "Launch", Start: "2026-03-01T10:00:00Z", End: "2026-03-01"}},
+		{name: "ends before it starts", event: CalendarEvent{Title: "Launch", Start: "2026-03-01T11:00:00Z", End: "2026-03-01T10:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.event.parseTimes(); !errors.Is(err, ErrInvalidEvent) {
+				t.Errorf("err = %v, want ErrInvalidEvent", err)
+			}
+		})
+	}
+}
+
+func TestFoldICSLine(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 60)
+	folded := foldICSLine(line)
+	for _, part := range strings.Split(folded, "\r\n") {
+		if len(part) > 75 {
+			t.Errorf("folded line has %d octets: %q", len(part), part)
+		}
+	}
+	if unfolded := strings.ReplaceAll(folded, "\r\n ", ""); unfolded != line {
+		t.Errorf("unfolding gives %q, want %q", unfolded, line)
+	}
+	if short := "SUMMARY:Launch"; foldICSLine(short) != short {
+		t.Error("a short line was folded")
+	}
+}
+
+func TestSendAttachesCalendarInvite(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "event": {"title": "Launch", "start": "2026-03-01T10:00:00Z", "end": "2026-03-01T11:00:00Z"}}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	attachments := sender.last(t).BufferAttachments()
+	if len(attachments) != 1 || attachments[0].Filename != "invite.ics" {
+		t.Fatalf("attachments = %+v, want invite.ics", attachments)
+	}
+	if ics := string(attachments[0].Buffer); !strings.Contains(ics, "ORGANIZER:mailto:shop@mg.example.com") || !strings.Contains(ics, "mailto:alex@example.com") {
+		t.Errorf("invite:\n%s", ics)
+	}
+
+	w = do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "event": {"title": "Launch", "start": "soon", "end": "later"}}`, nil)
+	if w.Code != 400 {
+		t.Errorf("invalid event status = %d, want 400", w.Code)
+	}
+}
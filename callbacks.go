@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrInvalidCallbackURL is returned for callback URLs that aren't plain
+// http(s) URLs or that point at a private address
+var ErrInvalidCallbackURL = errors.New("invalid callback_url")
+
+// publicAddr reports whether addr may be reached by callbacks. Loopback,
+// private, link-local and other non-public ranges are refused.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() &&
+		!netip.MustParsePrefix("100.64.0.0/10").Contains(addr)
+}
+
+// errPrivateAddress is returned when dialing an address refused by publicAddr
+var errPrivateAddress = errors.New("not a public address")
+
+// checkPublicURL checks that raw is an absolute http(s) URL that doesn't
+// point at a private address. Host names are checked again when dialing,
+// so DNS can't be used to smuggle in a private address.
+func checkPublicURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return errors.New("must be an absolute http(s) URL")
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !publicAddr(addr) {
+		return fmt.Errorf("%s is not a public address", addr)
+	}
+	if u.Hostname() == "localhost" {
+		return errors.New("localhost is not allowed")
+	}
+	return nil
+}
+
+// validateCallbackURL checks a callback URL before the send is attempted
+func validateCallbackURL(raw string) error {
+	if err := checkPublicURL(raw); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallbackURL, err)
+	}
+	return nil
+}
+
+// refusePrivateDial is a net.Dialer Control hook rejecting connections to
+// non-public addresses after DNS resolution
+func refusePrivateDial(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%s: %w", addrPort.Addr(), errPrivateAddress)
+	}
+	return nil
+}
+
+// publicHTTPClient returns a client that only connects to public addresses
+// and doesn't follow redirects, which could lead to a private address the
+// URL check never saw
+func publicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: refusePrivateDial}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Callback is the payload posted to a ProductEmail's callback_url
+type Callback struct {
+	URL       string    `json:"-"`
+	Status    string    `json:"status"`
+	Recipient string    `json:"recipient"`
+	ID        string    `json:"id,omitempty"`
+	Skipped   string    `json:"skipped,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CallbackNotifier posts send results to caller supplied URLs in the
+// background, retrying failures. Callback failures never affect the send.
+type CallbackNotifier struct {
+	clock       Clock
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	queue       chan Callback
+}
+
+// NewCallbackNotifier creates a notifier making up to maxAttempts attempts
+// per callback
+func NewCallbackNotifier(clock Clock, maxAttempts int) *CallbackNotifier {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &CallbackNotifier{
+		clock:       clock,
+		client:      publicHTTPClient(5 * time.Second),
+		maxAttempts: maxAttempts,
+		backoff:     time.Second,
+		queue:       make(chan Callback, 1000),
+	}
+}
+
+// Run delivers queued callbacks until ctx is cancelled
+func (n *CallbackNotifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case callback := <-n.queue:
+			if err := n.deliverWithRetry(ctx, callback); err != nil {
+				slog.Error("send callback dropped", "url", callback.URL, "error", err)
+			}
+		}
+	}
+}
+
+// Enqueue schedules a callback without blocking; it is dropped when the
+// queue is full
+func (n *CallbackNotifier) Enqueue(callback Callback) {
+	select {
+	case n.queue <- callback:
+	default:
+		slog.Error("send callback dropped", "url", callback.URL, "error", "callback queue is full")
+	}
+}
+
+// Backlog returns the number of queued callbacks and the queue's capacity
+func (n *CallbackNotifier) Backlog() (int, int) {
+	return len(n.queue), cap(n.queue)
+}
+
+// deliverWithRetry attempts delivery with exponential backoff between attempts
+func (n *CallbackNotifier) deliverWithRetry(ctx context.Context, callback Callback) error {
+	delay := n.backoff
+	var err error
+	for attempt := 1; attempt <= n.maxAttempts; attempt++ {
+		if err = n.deliver(ctx, callback); err == nil || errors.Is(err, errPrivateAddress) {
+			return err
+		}
+		slog.Warn("send callback failed", "attempt", attempt, "url", callback.URL, "error", err)
+
+		if attempt == n.maxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.clock.After(delay):
+		}
+		delay *= 2
+	}
+	return err
+}
+
+// deliver posts a single callback
+func (n *CallbackNotifier) deliver(ctx context.Context, callback Callback) error {
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SetCallbackNotifier sets the notifier used for callback_url requests
+func (s *EmailService) SetCallbackNotifier(callbacks *CallbackNotifier) {
+	s.callbacks = callbacks
+}
+
+// notifyCallback queues the outcome of a send for the email's callback URL
+func (s *EmailService) notifyCallback(data ProductEmail, result SendResult, err error) {
+	if data.CallbackURL == "" || s.callbacks == nil || errors.Is(err, ErrInvalidCallbackURL) {
+		return
+	}
+
+	callback := Callback{
+		URL:       data.CallbackURL,
+		Status:    "sent",
+		Recipient: data.RecipientEmail,
+		ID:        result.ID,
+		Skipped:   result.Skipped,
+		Timestamp: s.clock.Now().UTC(),
+	}
+	switch {
+	case err != nil:
+		callback.Status = "failed"
+		callback.Error = err.Error()
+	case result.Skipped != "":
+		callback.Status = "skipped"
+	}
+	s.callbacks.Enqueue(callback)
+}
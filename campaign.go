@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// skipAlreadySentInCampaign is reported when the recipient already received
+// an email with the same subject in the campaign
+const skipAlreadySentInCampaign = "already_sent_in_campaign"
+
+// campaignPruneInterval spaces out the sweeps for expired campaign sends,
+// so a bulk send doesn't scan the whole store for every recipient
+const campaignPruneInterval = time.Minute
+
+// CampaignStore remembers which subjects were sent to which recipients of a
+// campaign. It is an interface so the in-memory implementation can be
+// swapped for a shared backend such as Redis.
+type CampaignStore interface {
+	// Reserve records a send of subject to recipient in campaign. It
+	// returns false when the same send was already recorded.
+	Reserve(campaign, recipient, subject string) (bool, error)
+	// Release forgets a send previously recorded by Reserve, e.g. when
+	// delivery to Mailgun failed.
+	Release(campaign, recipient, subject string) error
+}
+
+// MemoryCampaignStore is a process-local CampaignStore. A send is
+// remembered for ttl, after which the same subject may go out again.
+type MemoryCampaignStore struct {
+	clock Clock
+	ttl   time.Duration
+
+	mu sync.Mutex
+	// sends maps each recorded send to when it is forgotten
+	sends map[string]time.Time
+	// prunedAt is when expired sends were last dropped
+	prunedAt time.Time
+}
+
+// NewMemoryCampaignStore creates an empty in-memory campaign store keeping
+// sends for ttl
+func NewMemoryCampaignStore(clock Clock, ttl time.Duration) *MemoryCampaignStore {
+	return &MemoryCampaignStore{
+		clock: clock,
+		ttl:   ttl,
+		sends: make(map[string]time.Time),
+	}
+}
+
+// campaignSendKey identifies a subject sent to a recipient of a campaign.
+// The NUL separators can't appear in any of the parts.
+func campaignSendKey(campaign, recipient, subject string) string {
+	return strings.Join([]string{campaign, recipientKey(recipient), subject}, "\x00")
+}
+
+// Reserve implements CampaignStore
+func (s *MemoryCampaignStore) Reserve(campaign, recipient, subject string) (bool, error) {
+	key := campaignSendKey(campaign, recipient, subject)
+
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.prunedAt) >= campaignPruneInterval {
+		for sendKey, expiresAt := range s.sends {
+			if !now.Before(expiresAt) {
+				delete(s.sends, sendKey)
+			}
+		}
+		s.prunedAt = now
+	}
+	if expiresAt, sent := s.sends[key]; sent && now.Before(expiresAt) {
+		return false, nil
+	}
+	s.sends[key] = now.Add(s.ttl)
+	return true, nil
+}
+
+// Release implements CampaignStore
+func (s *MemoryCampaignStore) Release(campaign, recipient, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sends, campaignSendKey(campaign, recipient, subject))
+	return nil
+}
+
+// SetCampaignStore replaces the store used to suppress repeated campaign
+// sends
+func (s *EmailService) SetCampaignStore(campaigns CampaignStore) {
+	s.campaigns = campaigns
+}
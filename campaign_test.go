@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCampaignSuppressesRepeatedSubjects(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	send := func(campaign, recipient, subject string) SendResult {
+		t.Helper()
+		result, err := service.SendProductEmail(context.Background(), ProductEmail{
+			RecipientEmail: recipient,
+			ProductName:    "Desk Lamp",
+			Subject:        subject,
+			CampaignID:     campaign,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		campaign  string
+		recipient string
+		subject   string
+		skipped   string
+	}{
+		{name: "first send", campaign: "spring", recipient: "alex@example.com", subject: "Spring sale"},
+		{name: "repeat", campaign: "spring", recipient: "Alex@Example.com", subject: "Spring sale", skipped: skipAlreadySentInCampaign},
+		{name: "other subject", campaign: "spring", recipient: "alex@example.com", subject: "Last chance"},
+		{name: "other recipient", campaign: "spring", recipient: "sam@example.com", subject: "Spring sale"},
+		{name: "independent campaign", campaign: "summer", recipient: "alex@example.com", subject: "Spring sale"},
+		{name: "no campaign", recipient: "alex@example.com", subject: "Spring sale"},
+		{name: "no campaign again", recipient: "alex@example.com", subject: "Spring sale"},
+	}
+	sent := 0
+	for _, tt := range tests {
+		result := send(tt.campaign, tt.recipient, tt.subject)
+		if result.Skipped != tt.skipped {
+			t.Errorf("%s: skipped = %q, want %q", tt.name, result.Skipped, tt.skipped)
+		}
+		if tt.skipped == "" {
+			sent++
+		}
+	}
+	if got := len(sender.sent()); got != sent {
+		t.Errorf("%d messages sent, want %d", got, sent)
+	}
+}
+
+func TestCampaignReleasesFailedSends(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		fail func(service *EmailService, sender *fakeSender)
+	}{
+		{
+			name: "mailgun error",
+			fail: func(_ *EmailService, sender *fakeSender) { sender.err = errors.New("mailgun is down") },
+		},
+		{
+			name: "recipient quota",
+			env:  map[string]string{"MAX_EMAILS_PER_RECIPIENT_PER_DAY": "1"},
+			fail: func(service *EmailService, _ *fakeSender) {
+				service.quotas.Reserve("alex@example.com", 1, quotaWindow, service.clock.Now())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			tt.fail(service, sender)
+			data := ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", CampaignID: "spring"}
+			if _, err := service.SendProductEmail(context.Background(), data); err == nil {
+				t.Fatal("send didn't fail")
+			}
+
+			subject, err := service.resolveSubject(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reserved, _ := service.campaigns.Reserve("spring", "alex@example.com", subject); !reserved {
+				t.Error("the failed send still holds its campaign slot")
+			}
+		})
+	}
+}
+
+func TestMemoryCampaignStoreExpires(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryCampaignStore(clock, time.Hour)
+	reserve := func() bool {
+		reserved, err := store.Reserve("spring", "alex@example.com", "Spring sale")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reserved
+	}
+
+	if !reserve() {
+		t.Fatal("first send not reserved")
+	}
+	clock.Advance(time.Hour - time.Second)
+	if reserve() {
+		t.Error("repeat allowed within the TTL")
+	}
+	clock.Advance(time.Second)
+	if !reserve() {
+		t.Error("repeat still suppressed after the TTL")
+	}
+}
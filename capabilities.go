@@ -0,0 +1,24 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// supportedCurrencies lists the currencies prices can be shown in; prices
+// are always formatted in US dollars
+var supportedCurrencies = []string{"USD"}
+
+// CapabilitiesHandler describes the options a send form can offer: the
+// supported locales, the template names, the currencies and the largest
+// message that will be accepted
+func (h *Handler) CapabilitiesHandler(c *gin.Context) {
+	maxMessageBytes := h.emailService.cfg().MaxMessageBytes
+	if maxMessageBytes <= 0 {
+		maxMessageBytes = mailgunMaxMessageBytes
+	}
+
+	c.JSON(200, gin.H{
+		"locales":           h.emailService.supportedLocales(),
+		"templates":         h.emailService.templateNames(),
+		"currencies":        supportedCurrencies,
+		"max_message_bytes": maxMessageBytes,
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	tests := []struct {
+		name            string
+		env             map[string]string
+		locales         []string
+		maxMessageBytes int
+	}{
+		{name: "defaults", locales: []string{"en-US"}, maxMessageBytes: mailgunMaxMessageBytes},
+		{name: "configured", env: map[string]string{"SUPPORTED_LOCALES": "en-US,de-DE", "MAX_MESSAGE_BYTES": "1048576"}, locales: []string{"en-US", "de-DE"}, maxMessageBytes: 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.GET("/capabilities", NewHandler(service).CapabilitiesHandler)
+
+			w := do(router, "GET", "/capabilities", "", nil)
+			if w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			var response struct {
+				Locales         []string `json:"locales"`
+				Templates       []string `json:"templates"`
+				Currencies      []string `json:"currencies"`
+				MaxMessageBytes int      `json:"max_message_bytes"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(response.Locales, tt.locales) {
+				t.Errorf("locales = %v, want %v", response.Locales, tt.locales)
+			}
+			if !slices.Contains(response.Templates, "product_text") || !slices.Contains(response.Templates, "product_html") || !slices.IsSorted(response.Templates) {
+				t.Errorf("templates = %v, want the sorted template names", response.Templates)
+			}
+			if !slices.Equal(response.Currencies, []string{"USD"}) {
+				t.Errorf("currencies = %v", response.Currencies)
+			}
+			if response.MaxMessageBytes != tt.maxMessageBytes {
+				t.Errorf("max_message_bytes = %d, want %d", response.MaxMessageBytes, tt.maxMessageBytes)
+			}
+		})
+	}
+}
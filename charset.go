@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mailgun/mailgun-go/v4"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// ErrUnsupportedCharset is returned for a charset we can't encode bodies in
+var ErrUnsupportedCharset = errors.New("unsupported charset")
+
+// ErrUnrepresentableCharacter is returned when a body contains a character
+// the requested charset has no encoding for
+var ErrUnrepresentableCharacter = errors.New("character can't be represented in the charset")
+
+// bodyCharset is a charset other than UTF-8 the body parts are encoded in
+type bodyCharset struct {
+	name     string
+	encoding encoding.Encoding
+}
+
+// resolveCharset looks up a charset by its IANA name or alias, e.g.
+// "ISO-8859-1" or "latin1". It returns nil for UTF-8, which needs no
+// transcoding.
+func resolveCharset(name string) (*bodyCharset, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := ianaindex.MIME.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedCharset, name)
+	}
+	canonical, err := ianaindex.MIME.Name(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedCharset, name)
+	}
+	if strings.EqualFold(canonical, "UTF-8") {
+		return nil, nil
+	}
+	return &bodyCharset{name: canonical, encoding: enc}, nil
+}
+
+// encode transcodes a UTF-8 body, naming the first character that can't be
+// represented
+func (c *bodyCharset) encode(body string) ([]byte, error) {
+	encoded, err := c.encoding.NewEncoder().Bytes([]byte(body))
+	if err == nil {
+		return encoded, nil
+	}
+	encoder := c.encoding.NewEncoder()
+	line := 1
+	for _, r := range body {
+		if r == '\n' {
+			line++
+		}
+		if _, err := encoder.String(string(r)); err != nil || r == utf8.RuneError {
+			return nil, fmt.Errorf("%w %s: %q (U+%04X) on line %d", ErrUnrepresentableCharacter, c.name, r, r, line)
+		}
+	}
+	return nil, fmt.Errorf("encode body as %s: %w", c.name, err)
+}
+
+// checkRepresentable fails when a body can't be encoded in the charset
+func (c *bodyCharset) checkRepresentable(bodies ...string) error {
+	for _, body := range bodies {
+		if _, err := c.encode(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metaCharsetPattern matches the <meta charset="utf-8"> of our templates
+var metaCharsetPattern = regexp.MustCompile(`(?i)<meta\s+charset=["']?utf-8["']?\s*/?>`)
+
+// declareCharset makes the HTML's own charset declaration agree with the
+// charset of its MIME part
+func (c *bodyCharset) declareCharset(htmlBody string) string {
+	return metaCharsetPattern.ReplaceAllString(htmlBody, `<meta charset="`+c.name+`">`)
+}
+
+// toMIMEMessage re-renders a plain message with its bodies in the charset.
+// Mailgun's API always sends the text and html fields as UTF-8, so the
+// message goes out as raw MIME; its sending options travel as the
+// X-Mailgun-* headers.
+func (c *bodyCharset) toMIMEMessage(message *mailgun.Message, date time.Time) (*mailgun.Message, error) {
+	raw, err := renderMIME(message, date, c, false)
+	if err != nil {
+		return nil, err
+	}
+	// Bcc is left out of the headers, so every recipient is passed as to
+	var recipients []string
+	for _, list := range recipientLists(message) {
+		recipients = append(recipients, list...)
+	}
+	mimeMessage := mailgun.NewMIMEMessage(newReplayableBody(raw), recipients...)
+	if message.TestMode() {
+		mimeMessage.EnableTestMode()
+	}
+	return mimeMessage, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeMIMEAPI records the raw MIME messages posted to Mailgun
+type fakeMIMEAPI struct {
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+func (f *fakeMIMEAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("message")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer file.Close()
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	f.mu.Lock()
+	f.messages = append(f.messages, raw)
+	f.mu.Unlock()
+	io.WriteString(w, `{"id": "<1@mg.example.com>", "message": "Queued. Thank you."}`)
+}
+
+// textParts returns the decoded bodies of the text parts of a raw MIME
+// message by media type, along with their charsets
+func textParts(t *testing.T, raw []byte) (bodies, charsets map[string]string) {
+	t.Helper()
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies, charsets = map[string]string{}, map[string]string{}
+	var walk func(header map[string][]string, body io.Reader)
+	walk = func(header map[string][]string, body io.Reader) {
+		mediaType, params, err := mime.ParseMediaType(first(header["Content-Type"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			reader := multipart.NewReader(body, params["boundary"])
+			for {
+				part, err := reader.NextRawPart()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				walk(part.Header, part)
+			}
+		}
+		if strings.HasPrefix(mediaType, "text/") {
+			decoded, err := io.ReadAll(quotedprintable.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies[mediaType], charsets[mediaType] = string(decoded), params["charset"]
+		}
+	}
+	walk(message.Header, message.Body)
+	return bodies, charsets
+}
+
+// first returns the first of values, or ""
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func TestCharsetTranscodesBodies(t *testing.T) {
+	api := &fakeMIMEAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	service := NewEmailService(testConfig(t, nil))
+	service.mg.SetAPIBase(server.URL + "/v3")
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Café Lamp", "charset": "latin1"}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if len(api.messages) != 1 {
+		t.Fatalf("%d MIME messages posted, want 1", len(api.messages))
+	}
+	bodies, charsets := textParts(t, api.messages[0])
+	for _, mediaType := range []string{"text/plain", "text/html"} {
+		if charsets[mediaType] != "ISO-8859-1" {
+			t.Errorf("%s charset = %q, want ISO-8859-1", mediaType, charsets[mediaType])
+		}
+		if !strings.Contains(bodies[mediaType], "Caf\xe9 Lamp") {
+			t.Errorf("%s body isn't ISO-8859-1 encoded:\n%q", mediaType, bodies[mediaType])
+		}
+	}
+	if strings.Contains(strings.ToLower(bodies["text/html"]), `charset="utf-8"`) {
+		t.Error("the html still declares utf-8")
+	}
+}
+
+func TestCharsetRejections(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		details string
+	}{
+		{
+			name:    "unrepresentable character",
+			body:    `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "description": "Only 40€", "charset": "ISO-8859-1"}`,
+			details: "U+20AC",
+		},
+		{
+			name:    "unsupported charset",
+			body:    `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "charset": "klingon"}`,
+			details: "unsupported charset",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", tt.body, nil)
+			if w.Code != 400 || !strings.Contains(w.Body.String(), tt.details) {
+				t.Errorf("response = %d %s, want 400 mentioning %q", w.Code, w.Body, tt.details)
+			}
+			if len(sender.sent()) != 0 {
+				t.Error("sent despite the charset error")
+			}
+		})
+	}
+}
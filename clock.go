@@ -0,0 +1,61 @@
+package main
+
+import "time"
+
+// Clock abstracts the current time and timers so time-dependent logic can
+// be driven deterministically
+type Clock interface {
+	Now() time.Time
+	// After sends the current time on the returned channel once d elapsed
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d elapsed, unless the
+	// returned timer is stopped first
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a call scheduled by Clock.AfterFunc
+type Timer interface {
+	// Stop cancels the call, reporting false if it already happened or
+	// was stopped
+	Stop() bool
+}
+
+// realClock is the Clock backed by the system time
+type realClock struct{}
+
+// Now returns the current system time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After implements Clock with time.After
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// AfterFunc implements Clock with time.AfterFunc
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SetClock replaces the clock of the service and of the stores and buffers
+// it created. It must be called before the service is used.
+func (s *EmailService) SetClock(clock Clock) {
+	s.clock = clock
+	if campaigns, ok := s.campaigns.(*MemoryCampaignStore); ok {
+		campaigns.clock = clock
+	}
+	if s.dedup != nil {
+		s.dedup.clock = clock
+	}
+	if s.digests != nil {
+		s.digests.clock = clock
+	}
+	if s.limiter != nil {
+		s.limiter.clock = clock
+	}
+	if s.bulkValidator.limiter != nil {
+		s.bulkValidator.limiter.clock = clock
+	}
+	s.metrics.startedAt = clock.Now()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when the test advances it. Timers
+// fire from Advance once their time is reached.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a call scheduled on a fakeClock
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	fire  func(now time.Time)
+}
+
+// newFakeClock creates a clock stopped at a fixed time
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// Now implements Clock
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements Clock
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.schedule(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+// AfterFunc implements Clock
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.schedule(d, func(time.Time) { go f() })
+}
+
+// schedule registers fire to run once the clock reached d from now
+func (c *fakeClock) schedule(d time.Duration, fire func(now time.Time)) *fakeTimer {
+	c.mu.Lock()
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), fire: fire}
+	if d > 0 {
+		c.timers = append(c.timers, timer)
+		c.mu.Unlock()
+		return timer
+	}
+	now := c.now
+	c.mu.Unlock()
+	fire(now)
+	return timer
+}
+
+// Stop implements Timer
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d and fires the timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(now) {
+			pending = append(pending, timer)
+		} else {
+			due = append(due, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, timer := range due {
+		timer.fire(now)
+	}
+}
+
+// waiters returns how many timers have not fired yet
+func (c *fakeClock) waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func TestSendDeduperExpiresAfterWindow(t *testing.T) {
+	clock := newFakeClock()
+	dedup := newSendDeduper(clock, time.Minute, nil)
+	sends := 0
+	send := func() (SendResult, error) {
+		sends++
+		return SendResult{ID: "<id@example.com>"}, nil
+	}
+
+	tests := []struct {
+		advance      time.Duration
+		deduplicated bool
+	}{
+		{advance: 0, deduplicated: false},
+		{advance: 59 * time.Second, deduplicated: true},
+		{advance: time.Second, deduplicated: false},
+	}
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		result, err := dedup.do(context.Background(), "key", send)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Deduplicated != tt.deduplicated {
+			t.Errorf("send %d after %v: deduplicated = %v, want %v", i, tt.advance, result.Deduplicated, tt.deduplicated)
+		}
+	}
+	if sends != 2 {
+		t.Errorf("sent %d times, want 2", sends)
+	}
+}
+
+func TestPendingReviewExpiry(t *testing.T) {
+	clock := newFakeClock()
+	review := PendingReview{SubmittedAt: clock.Now(), ExpiresAt: clock.Now().Add(time.Hour)}
+	never := PendingReview{SubmittedAt: clock.Now()}
+
+	clock.Advance(time.Hour - time.Second)
+	if review.expired(clock.Now()) {
+		t.Error("review expired before its TTL")
+	}
+	clock.Advance(time.Second)
+	if !review.expired(clock.Now()) {
+		t.Error("review still pending after its TTL")
+	}
+	clock.Advance(365 * 24 * time.Hour)
+	if never.expired(clock.Now()) {
+		t.Error("review without a TTL expired")
+	}
+}
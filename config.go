@@ -0,0 +1,637 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/mailgun/mailgun-go/v4"
+	"golang.org/x/text/language"
+)
+
+// Config holds the application configuration
+type Config struct {
+	Domain    string
+	ApiKey    string
+	FromName  string
+	FromEmail string
+	LogLevel  string
+	LogFormat string
+	Port      string
+	Region    string
+	APIKeys   []string
+	// MaxEmailsPerRecipientPerDay caps sends to one recipient in a rolling
+	// 24h window. Zero disables the cap.
+	MaxEmailsPerRecipientPerDay int
+	// MaxEmailsPerDay caps all sends in a rolling 24h window. Zero disables it.
+	MaxEmailsPerDay int
+	// TagDailyLimits caps the sends of a tag in a rolling 24h window, on
+	// top of MaxEmailsPerDay. Tags without an entry are only bound by it.
+	TagDailyLimits map[string]int
+	JSONLimits     JSONLimits
+	// SendingEnabled is the initial state of the global send kill-switch
+	SendingEnabled bool
+	// InlineCSS moves <style> rules into inline style attributes in HTML bodies
+	InlineCSS bool
+	// AutoPlainText derives the text body from the HTML body when it would
+	// otherwise be empty
+	AutoPlainText bool
+	// TextWrapWidth wraps the lines of the templated text body at this many
+	// characters; zero disables wrapping
+	TextWrapWidth int
+	// WebhookSigningKey verifies the signature of Mailgun webhook requests
+	WebhookSigningKey string
+	// PublicBaseURL is where this service is reachable by recipients; with
+	// UnsubscribeSigningKey it points unsubscribe footers at GET
+	// /unsubscribe instead of Mailgun's unsubscribe page
+	PublicBaseURL string
+	// ReviewServiceURL receives emails sent with require_approval, which
+	// are held until approved through /approve/:id
+	ReviewServiceURL string
+	// ReviewTTL discards emails not decided within this long; zero keeps
+	// them until decided
+	ReviewTTL             time.Duration
+	UnsubscribeSigningKey string
+	// EventForwardURL receives every verified webhook event when set
+	EventForwardURL         string
+	EventForwardMaxAttempts int
+	// ArchiveBCC receives a blind copy of every email whose category has no
+	// entry in ArchiveBCCByCategory. The archive mailboxes store every email
+	// in full, so they must be encrypted at rest by their provider.
+	ArchiveBCC           []string
+	ArchiveBCCByCategory map[string][]string
+	// ReplyTo receives replies to emails whose category has no entry in
+	// ReplyToByCategory; replies go to the sender when it is empty
+	ReplyTo           string
+	ReplyToByCategory map[string]string
+	// DefaultLocale formats emails whose locale can't be determined otherwise
+	DefaultLocale string
+	// SupportedLocales are the locales rendered by /preview-all-locales
+	SupportedLocales []string
+	// MaintenanceMode is the initial state of the maintenance switch
+	MaintenanceMode       bool
+	MaintenanceRetryAfter time.Duration
+	// SubjectTemplate renders the default subject from the ProductEmail
+	SubjectTemplate string
+	// EnvSubjectPrefix such as "[STAGING]" is prepended to every subject
+	// outside production
+	EnvSubjectPrefix string
+	// FromIdentities are alternative senders selected by ProductEmail.FromKey
+	FromIdentities map[string]FromIdentity
+	// DevMode enables development-only endpoints such as /render-template
+	// and /debug/build-mime
+	DevMode bool
+	// RouteTimeouts are per-route request deadlines keyed by route path
+	RouteTimeouts       map[string]time.Duration
+	DefaultRouteTimeout time.Duration
+	// DKIMSelectors restricts per-request dkim_selector values when set
+	DKIMSelectors []string
+	// DKIMVerifyDNS checks that a requested selector's key is published
+	DKIMVerifyDNS bool
+	// MaxRetries is how often a transient send failure is retried
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Prewarm opens the Mailgun connection at startup, bounded by PrewarmTimeout
+	Prewarm        bool
+	PrewarmTimeout time.Duration
+	// StorageURLLookup finds the Mailgun storage URL of messages sent
+	// through /send-product via the events API, waiting up to
+	// StorageURLTimeout for it
+	StorageURLLookup  bool
+	StorageURLTimeout time.Duration
+	// AdminEmail receives the sample email sent by /admin/test-send
+	AdminEmail string
+	// TestMode submits every message in Mailgun test mode so it is discarded.
+	// Senders are shown as "[TEST] <name>", from TestFromEmail when set.
+	TestMode      bool
+	TestFromEmail string
+	// AuditLogPath is the append-only audit trail of send attempts
+	AuditLogPath string
+	// AuditLogRotation rotates the audit log by size and prunes the
+	// rotated files
+	AuditLogRotation AuditRotation
+	// StatsCacheTTL is how long /admin/stats serves a cached Mailgun response
+	StatsCacheTTL time.Duration
+	// RouteCacheTTLs are per-route response cache TTLs keyed by route path
+	RouteCacheTTLs map[string]time.Duration
+	// SendRateLimit caps sends to Mailgun per second; it is halved for
+	// RateLimitCooldown after a 429. Zero disables the limiter.
+	SendRateLimit     int
+	RateLimitCooldown time.Duration
+	// InlineImageMaxBytes and InlineImagesMaxTotalBytes cap the data-URI
+	// images moved into inline attachments
+	InlineImageMaxBytes       int
+	InlineImagesMaxTotalBytes int
+	// CampaignTTL is how long a campaign send is remembered, so repeats of
+	// it are suppressed
+	CampaignTTL time.Duration
+	// DedupWindow reuses the outcome of an identical send made within the
+	// window, hashing DedupFields. Zero disables de-duplication.
+	DedupWindow time.Duration
+	DedupFields []string
+	// ThumbnailRenderURL converts HTML emails to PNG thumbnails when set
+	ThumbnailRenderURL string
+	// TemplateStoreDir holds edited body templates, saved through PUT
+	// /admin/templates/:name, that replace the built-in ones
+	TemplateStoreDir string
+	// DefaultInlineLogoPath is an image inlined into every HTML email,
+	// loaded once at startup
+	DefaultInlineLogoPath string
+	// MailgunDomains are additional sending domains selected by
+	// ProductEmail.DomainKey
+	MailgunDomains map[string]string
+	// CallbackMaxAttempts bounds deliveries of a ProductEmail callback_url
+	CallbackMaxAttempts int
+	// MaxMessageBytes rejects messages whose estimated size exceeds it; it
+	// can't be raised above Mailgun's 25MB limit, which zero selects
+	MaxMessageBytes int
+	// MailgunProxy and MailgunMinTLSVersion configure the transport of
+	// Mailgun API calls; unset values keep the Go defaults
+	MailgunProxy         *url.URL
+	MailgunMinTLSVersion uint16
+	// RedirectAllTo sends every email to this inbox instead of its
+	// recipients; meant for non-production environments
+	RedirectAllTo string
+	// RecipientNormalization maps domains to the rules that fold several
+	// spellings of an address into one quota and dedup key; nil unless
+	// NORMALIZE_RECIPIENTS is on
+	RecipientNormalization map[string]NormalizationRule
+	// SpamCheck runs SpamRules on every email body: "off" (default),
+	// "warn" to flag it in the response or "block" to reject it with a 422
+	SpamCheck string
+	SpamRules SpamRules
+	// MaxRecipients caps the To, CC and BCC recipients of a single email,
+	// archive copies included
+	MaxRecipients int
+	// StreamMaxRecipients caps the recipients of one /admin/send-stream batch
+	StreamMaxRecipients int
+	// CSVMaxBytes and CSVMaxRows cap uploads to /send-csv
+	CSVMaxBytes int
+	CSVMaxRows  int
+	// BulkValidationMailgun runs /validate-bulk addresses through Mailgun's
+	// paid validation API, BulkValidationRate times per second, instead of
+	// only checking their syntax
+	BulkValidationMailgun      bool
+	BulkValidationRate         int
+	BulkValidationConcurrency  int
+	BulkValidationMaxAddresses int
+	// DigestWindow batches products queued with "digest" per recipient for
+	// this long; zero disables digests. DigestMaxItems sends a batch early.
+	DigestWindow   time.Duration
+	DigestMaxItems int
+	// DigestStatePath saves the pending digests so a restart resumes them
+	// instead of losing them
+	DigestStatePath string
+	// HTMLURLTimeout and HTMLURLMaxBytes bound the download of an html_url
+	HTMLURLTimeout  time.Duration
+	HTMLURLMaxBytes int
+	// SendingProfiles are the delivery defaults selectable per request
+	SendingProfiles map[string]SendingProfile
+	// ShutdownDrainTimeout bounds how long shutdown waits for in-flight
+	// requests and sends
+	ShutdownDrainTimeout time.Duration
+	// ReadinessDisabledChecks names readiness checks to skip, see
+	// readinessCheckNames
+	ReadinessDisabledChecks []string
+	// ReadinessTimeout bounds each readiness check
+	ReadinessTimeout time.Duration
+	// Sources records where each environment variable read at startup came
+	// from: "env", "file" (.env) or "default"
+	Sources map[string]string
+}
+
+// LoadConfig reads the configuration from environment variables, which
+// include the values godotenv loaded from .env
+func LoadConfig() (Config, error) {
+	// Reading the file again tells its values apart from the environment
+	file, _ := godotenv.Read()
+	config, sources, err := ResolveConfig(file)
+	config.Sources = sources
+	return config, err
+}
+
+// Config value sources reported by ResolveConfig
+const (
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// configResolver reads configuration values from the environment and
+// records where each one came from
+type configResolver struct {
+	file    map[string]string
+	sources map[string]string
+}
+
+// getenv returns the value of key, recording whether it was set in the
+// environment, came from the .env file or was left to its default
+func (r *configResolver) getenv(key string) string {
+	value := os.Getenv(key)
+	switch fileValue, inFile := r.file[key]; {
+	case value == "":
+		r.sources[key] = sourceDefault
+	case inFile && fileValue == value:
+		r.sources[key] = sourceFile
+	default:
+		r.sources[key] = sourceEnv
+	}
+	return value
+}
+
+// ResolveConfig reads the configuration from environment variables and
+// returns it with the source of every variable it read, keyed by variable
+// name. file holds the values of the .env file.
+func ResolveConfig(file map[string]string) (Config, map[string]string, error) {
+	r := &configResolver{file: file, sources: make(map[string]string)}
+	config := Config{
+		Domain:    r.getenv("MAILGUN_DOMAIN"),
+		ApiKey:    r.getenv("MAILGUN_API_KEY"),
+		FromName:  r.getenv("MAILGUN_FROM_NAME"),
+		FromEmail: r.getenv("MAILGUN_FROM_EMAIL"),
+		LogLevel:  r.getenv("LOG_LEVEL"),
+		LogFormat: r.getenv("LOG_FORMAT"),
+		Port:      r.getEnvDefault("PORT", "8080"),
+		Region:    strings.ToLower(r.getEnvDefault("MAILGUN_REGION", "us")),
+		APIKeys:   splitList(r.getenv("API_KEYS")),
+
+		DKIMSelectors: splitList(r.getenv("DKIM_SELECTORS")),
+		AdminEmail:    r.getenv("ADMIN_EMAIL"),
+		AuditLogPath:  r.getenv("AUDIT_LOG_PATH"),
+		DedupFields:   splitList(r.getenv("DEDUP_FIELDS")),
+
+		ThumbnailRenderURL:    r.getenv("THUMBNAIL_RENDER_URL"),
+		DefaultInlineLogoPath: r.getenv("DEFAULT_INLINE_LOGO_PATH"),
+		TemplateStoreDir:      r.getenv("TEMPLATE_STORE_DIR"),
+		RedirectAllTo:         r.getenv("REDIRECT_ALL_TO"),
+
+		WebhookSigningKey:     r.getenv("MAILGUN_WEBHOOK_SIGNING_KEY"),
+		PublicBaseURL:         r.getenv("PUBLIC_BASE_URL"),
+		ReviewServiceURL:      r.getenv("REVIEW_SERVICE_URL"),
+		UnsubscribeSigningKey: r.getenv("UNSUBSCRIBE_SIGNING_KEY"),
+		EventForwardURL:       r.getenv("EVENT_FORWARD_URL"),
+		ArchiveBCC:            splitList(r.getenv("ARCHIVE_BCC")),
+		ReplyTo:               r.getenv("REPLY_TO"),
+		DefaultLocale:         r.getEnvDefault("DEFAULT_LOCALE", defaultLocale),
+		SubjectTemplate:       r.getenv("SUBJECT_TEMPLATE"),
+		EnvSubjectPrefix:      r.getenv("ENV_SUBJECT_PREFIX"),
+		SupportedLocales:      splitList(r.getenv("SUPPORTED_LOCALES")),
+	}
+
+	var err error
+	if config.MaxEmailsPerRecipientPerDay, err = r.getEnvInt("MAX_EMAILS_PER_RECIPIENT_PER_DAY", 0); err != nil {
+		return config, r.sources, err
+	}
+	if config.MaxEmailsPerDay, err = r.getEnvInt("MAX_EMAILS_PER_DAY", 0); err != nil {
+		return config, r.sources, err
+	}
+	if config.TagDailyLimits, err = parseTagDailyLimits(r.getenv("TAG_DAILY_LIMITS")); err != nil {
+		return config, r.sources, err
+	}
+	// Room for INLINE_IMAGES_MAX_TOTAL_BYTES of base64 data-URI images
+	if config.JSONLimits.MaxBodyBytes, err = r.getEnvInt("JSON_MAX_BODY_BYTES", 8<<20); err != nil {
+		return config, r.sources, err
+	}
+	if config.JSONLimits.MaxDepth, err = r.getEnvInt("JSON_MAX_DEPTH", 10); err != nil {
+		return config, r.sources, err
+	}
+	if config.JSONLimits.MaxArrayElements, err = r.getEnvInt("JSON_MAX_ARRAY_ELEMENTS", 100); err != nil {
+		return config, r.sources, err
+	}
+	if config.SendingEnabled, err = r.getEnvBool("SENDING_ENABLED", true); err != nil {
+		return config, r.sources, err
+	}
+	if config.InlineCSS, err = r.getEnvBool("INLINE_CSS", true); err != nil {
+		return config, r.sources, err
+	}
+	if config.AutoPlainText, err = r.getEnvBool("AUTO_PLAIN_TEXT", true); err != nil {
+		return config, r.sources, err
+	}
+	if config.TextWrapWidth, err = r.getEnvInt("TEXT_WRAP_WIDTH", 78); err != nil {
+		return config, r.sources, err
+	}
+	if config.EventForwardMaxAttempts, err = r.getEnvInt("EVENT_FORWARD_MAX_ATTEMPTS", 5); err != nil {
+		return config, r.sources, err
+	}
+	if config.MaintenanceMode, err = r.getEnvBool("MAINTENANCE_MODE", false); err != nil {
+		return config, r.sources, err
+	}
+	retryAfter, err := r.getEnvInt("MAINTENANCE_RETRY_AFTER_SECONDS", 120)
+	if err != nil {
+		return config, r.sources, err
+	}
+	config.MaintenanceRetryAfter = time.Duration(retryAfter) * time.Second
+	if config.DevMode, err = r.getEnvBool("DEV_MODE", false); err != nil {
+		return config, r.sources, err
+	}
+	if config.RouteTimeouts, err = parseRouteTimeouts(r.getenv("ROUTE_TIMEOUTS")); err != nil {
+		return config, r.sources, err
+	}
+	if config.DefaultRouteTimeout, err = r.getEnvDuration("DEFAULT_ROUTE_TIMEOUT", 30*time.Second); err != nil {
+		return config, r.sources, err
+	}
+	if config.RouteCacheTTLs, err = parseRouteCacheTTLs(r.getenv("ROUTE_CACHE_TTLS")); err != nil {
+		return config, r.sources, err
+	}
+	if config.DKIMVerifyDNS, err = r.getEnvBool("DKIM_VERIFY_DNS", false); err != nil {
+		return config, r.sources, err
+	}
+	if config.MaxRetries, err = r.getEnvInt("MAX_RETRIES", 2); err != nil {
+		return config, r.sources, err
+	}
+	if config.RetryBackoff, err = r.getEnvDuration("RETRY_BACKOFF", 500*time.Millisecond); err != nil {
+		return config, r.sources, err
+	}
+	if config.Prewarm, err = r.getEnvBool("PREWARM", false); err != nil {
+		return config, r.sources, err
+	}
+	if config.PrewarmTimeout, err = r.getEnvDuration("PREWARM_TIMEOUT", 3*time.Second); err != nil {
+		return config, r.sources, err
+	}
+	if config.StorageURLLookup, err = r.getEnvBool("STORAGE_URL_LOOKUP", false); err != nil {
+		return config, r.sources, err
+	}
+	if config.StorageURLTimeout, err = r.getEnvDuration("STORAGE_URL_TIMEOUT", 3*time.Second); err != nil {
+		return config, r.sources, err
+	}
+	maxBytes, err := r.getEnvInt("AUDIT_LOG_MAX_BYTES", 100<<20)
+	if err != nil {
+		return config, r.sources, err
+	}
+	config.AuditLogRotation.MaxBytes = int64(maxBytes)
+	if config.AuditLogRotation.MaxBackups, err = r.getEnvInt("AUDIT_LOG_MAX_BACKUPS", 10); err != nil {
+		return config, r.sources, err
+	}
+	if config.AuditLogRotation.MaxAge, err = r.getEnvDuration("AUDIT_LOG_MAX_AGE", 0); err != nil {
+		return config, r.sources, err
+	}
+	if config.TestMode, err = r.getEnvBool("MAILGUN_TEST_MODE", false); err != nil {
+		return config, r.sources, err
+	}
+	if raw := r.getenv("TEST_FROM_EMAIL"); raw != "" {
+		address, parseErr := mail.ParseAddress(raw)
+		if parseErr != nil {
+			return config, r.sources, fmt.Errorf("invalid TEST_FROM_EMAIL %q", raw)
+		}
+		config.TestFromEmail = address.Address
+	}
+	if config.StatsCacheTTL, err = r.getEnvDuration("STATS_CACHE_TTL", 5*time.Minute); err != nil {
+		return config, r.sources, err
+	}
+	if config.SendRateLimit, err = r.getEnvInt("SEND_RATE_LIMIT", 50); err != nil {
+		return config, r.sources, err
+	}
+	if config.RateLimitCooldown, err = r.getEnvDuration("RATE_LIMIT_COOLDOWN", 30*time.Second); err != nil {
+		return config, r.sources, err
+	}
+	if config.InlineImageMaxBytes, err = r.getEnvInt("INLINE_IMAGE_MAX_BYTES", 1<<20); err != nil {
+		return config, r.sources, err
+	}
+	if config.InlineImagesMaxTotalBytes, err = r.getEnvInt("INLINE_IMAGES_MAX_TOTAL_BYTES", 5<<20); err != nil {
+		return config, r.sources, err
+	}
+	if config.DedupWindow, err = r.getEnvDuration("DEDUP_WINDOW", 0); err != nil {
+		return config, r.sources, err
+	}
+	if config.CampaignTTL, err = r.getEnvDuration("CAMPAIGN_TTL", 30*24*time.Hour); err != nil {
+		return config, r.sources, err
+	}
+	if config.CallbackMaxAttempts, err = r.getEnvInt("CALLBACK_MAX_ATTEMPTS", 3); err != nil {
+		return config, r.sources, err
+	}
+	if config.MaxMessageBytes, err = r.getEnvInt("MAX_MESSAGE_BYTES", mailgunMaxMessageBytes); err != nil {
+		return config, r.sources, err
+	}
+	if config.DigestWindow, err = r.getEnvDuration("DIGEST_WINDOW", 0); err != nil {
+		return config, r.sources, err
+	}
+	if config.DigestMaxItems, err = r.getEnvInt("DIGEST_MAX_ITEMS", 10); err != nil {
+		return config, r.sources, err
+	}
+	config.DigestStatePath = r.getenv("DIGEST_STATE_PATH")
+	if config.DigestStatePath != "" {
+		if dir, statErr := os.Stat(filepath.Dir(config.DigestStatePath)); statErr != nil || !dir.IsDir() {
+			return config, r.sources, fmt.Errorf("invalid DIGEST_STATE_PATH %q (its directory must exist)", config.DigestStatePath)
+		}
+		if file, statErr := os.Stat(config.DigestStatePath); statErr == nil && file.IsDir() {
+			return config, r.sources, fmt.Errorf("invalid DIGEST_STATE_PATH %q (expected a file, got a directory)", config.DigestStatePath)
+		}
+	}
+	if config.HTMLURLTimeout, err = r.getEnvDuration("HTML_URL_TIMEOUT", 5*time.Second); err != nil {
+		return config, r.sources, err
+	}
+	if config.HTMLURLMaxBytes, err = r.getEnvInt("HTML_URL_MAX_BYTES", 2<<20); err != nil {
+		return config, r.sources, err
+	}
+	if config.ShutdownDrainTimeout, err = r.getEnvDuration("SHUTDOWN_DRAIN_TIMEOUT", 30*time.Second); err != nil {
+		return config, r.sources, err
+	}
+	config.ReadinessDisabledChecks = splitList(r.getenv("READINESS_DISABLED_CHECKS"))
+	for _, name := range config.ReadinessDisabledChecks {
+		if !slices.Contains(readinessCheckNames, name) {
+			return config, r.sources, fmt.Errorf("unknown READINESS_DISABLED_CHECKS entry %q (expected one of %s)", name, strings.Join(readinessCheckNames, ", "))
+		}
+	}
+	if config.ReadinessTimeout, err = r.getEnvDuration("READINESS_TIMEOUT", 2*time.Second); err != nil {
+		return config, r.sources, err
+	}
+	if config.MaxRecipients, err = r.getEnvInt("MAX_RECIPIENTS", 50); err != nil {
+		return config, r.sources, err
+	}
+	if config.StreamMaxRecipients, err = r.getEnvInt("STREAM_MAX_RECIPIENTS", 10000); err != nil {
+		return config, r.sources, err
+	}
+	if config.CSVMaxBytes, err = r.getEnvInt("CSV_MAX_BYTES", 1<<20); err != nil {
+		return config, r.sources, err
+	}
+	if config.CSVMaxRows, err = r.getEnvInt("CSV_MAX_ROWS", 1000); err != nil {
+		return config, r.sources, err
+	}
+	if config.BulkValidationMailgun, err = r.getEnvBool("BULK_VALIDATION_MAILGUN", false); err != nil {
+		return config, r.sources, err
+	}
+	if config.BulkValidationRate, err = r.getEnvInt("BULK_VALIDATION_RATE", 10); err != nil {
+		return config, r.sources, err
+	}
+	if config.BulkValidationConcurrency, err = r.getEnvInt("BULK_VALIDATION_CONCURRENCY", 4); err != nil {
+		return config, r.sources, err
+	}
+	if config.BulkValidationMaxAddresses, err = r.getEnvInt("BULK_VALIDATION_MAX_ADDRESSES", 1000); err != nil {
+		return config, r.sources, err
+	}
+	if config.MaxMessageBytes > mailgunMaxMessageBytes {
+		return config, r.sources, fmt.Errorf("MAX_MESSAGE_BYTES must not exceed Mailgun's limit of %d", mailgunMaxMessageBytes)
+	}
+
+	// Validate required environment variables
+	if config.Domain == "" || config.ApiKey == "" {
+		return config, r.sources, fmt.Errorf("required environment variables MAILGUN_DOMAIN and MAILGUN_API_KEY must be set")
+	}
+	if _, err := config.APIBase(); err != nil {
+		return config, r.sources, err
+	}
+	if _, err := language.Parse(config.DefaultLocale); err != nil {
+		return config, r.sources, fmt.Errorf("invalid DEFAULT_LOCALE %q: %w", config.DefaultLocale, err)
+	}
+	if _, err := parseSubjectTemplate(config.SubjectTemplate); err != nil {
+		return config, r.sources, fmt.Errorf("invalid SUBJECT_TEMPLATE: %w", err)
+	}
+	if len(config.SupportedLocales) == 0 {
+		config.SupportedLocales = []string{config.DefaultLocale}
+	}
+	for _, locale := range config.SupportedLocales {
+		if _, err := language.Parse(locale); err != nil {
+			return config, r.sources, fmt.Errorf("invalid SUPPORTED_LOCALES entry %q: %w", locale, err)
+		}
+	}
+	if err := validateDedupFields(config.DedupFields); err != nil {
+		return config, r.sources, err
+	}
+	if config.MailgunDomains, err = parseMailgunDomains(r.getenv("MAILGUN_DOMAINS")); err != nil {
+		return config, r.sources, err
+	}
+	if config.SendingProfiles, err = parseSendingProfiles(r.getenv("SENDING_PROFILES")); err != nil {
+		return config, r.sources, err
+	}
+	if config.ArchiveBCCByCategory, err = parseArchiveBCCByCategory(r.getenv("ARCHIVE_BCC_BY_CATEGORY")); err != nil {
+		return config, r.sources, err
+	}
+	if config.ReplyTo != "" && !validAddress(config.ReplyTo) {
+		return config, r.sources, fmt.Errorf("invalid REPLY_TO %q", config.ReplyTo)
+	}
+	if config.RedirectAllTo != "" && !validAddress(config.RedirectAllTo) {
+		return config, r.sources, fmt.Errorf("invalid REDIRECT_ALL_TO %q", config.RedirectAllTo)
+	}
+	if config.PublicBaseURL != "" {
+		if u, parseErr := url.Parse(config.PublicBaseURL); parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return config, r.sources, fmt.Errorf("invalid PUBLIC_BASE_URL %q (expected an absolute http(s) URL)", config.PublicBaseURL)
+		}
+	}
+	if config.ReviewTTL, err = r.getEnvOptionalDuration("REVIEW_TTL", 72*time.Hour); err != nil {
+		return config, r.sources, err
+	}
+	if config.ReviewServiceURL != "" {
+		if u, parseErr := url.Parse(config.ReviewServiceURL); parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return config, r.sources, fmt.Errorf("invalid REVIEW_SERVICE_URL %q (expected an absolute http(s) URL)", config.ReviewServiceURL)
+		}
+	}
+	normalize, err := r.getEnvBool("NORMALIZE_RECIPIENTS", false)
+	if err != nil {
+		return config, r.sources, err
+	}
+	if config.RecipientNormalization, err = parseNormalizationRules(normalize, r.getenv("RECIPIENT_NORMALIZATION")); err != nil {
+		return config, r.sources, err
+	}
+	if config.SpamCheck, err = parseSpamCheckMode(r.getenv("SPAM_CHECK")); err != nil {
+		return config, r.sources, err
+	}
+	if config.SpamRules, err = parseSpamRules(r.getenv("SPAM_RULES")); err != nil {
+		return config, r.sources, err
+	}
+	if config.ReplyToByCategory, err = parseReplyToByCategory(r.getenv("REPLY_TO_BY_CATEGORY")); err != nil {
+		return config, r.sources, err
+	}
+	if config.MailgunProxy, err = parseProxyURL(r.getenv("MAILGUN_HTTPS_PROXY")); err != nil {
+		return config, r.sources, err
+	}
+	if config.MailgunMinTLSVersion, err = parseTLSVersion(r.getenv("MAILGUN_MIN_TLS_VERSION")); err != nil {
+		return config, r.sources, err
+	}
+	if config.FromIdentities, err = parseFromIdentities(r.getenv("FROM_IDENTITIES"), config.sendingDomains()); err != nil {
+		return config, r.sources, err
+	}
+
+	return config, r.sources, nil
+}
+
+// APIBase resolves the Mailgun API base URL for the configured region
+func (c Config) APIBase() (string, error) {
+	switch c.Region {
+	case "", "us":
+		return mailgun.APIBaseUS, nil
+	case "eu":
+		return mailgun.APIBaseEU, nil
+	default:
+		return "", fmt.Errorf("unknown MAILGUN_REGION %q (expected us or eu)", c.Region)
+	}
+}
+
+// getEnvDefault returns the environment variable value or a fallback when unset
+func (r *configResolver) getEnvDefault(key, fallback string) string {
+	if value := r.getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt parses an integer environment variable, returning the fallback when unset
+func (r *configResolver) getEnvInt(key string, fallback int) (int, error) {
+	value := r.getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", key, value)
+	}
+	return n, nil
+}
+
+// getEnvBool parses a boolean environment variable, returning the fallback when unset
+func (r *configResolver) getEnvBool(key string, fallback bool) (bool, error) {
+	value := r.getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got %q", key, value)
+	}
+	return b, nil
+}
+
+// getEnvDuration parses a duration environment variable such as "30s",
+// returning the fallback when unset
+func (r *configResolver) getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := r.getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration, got %q", key, value)
+	}
+	return d, nil
+}
+
+// getEnvOptionalDuration is getEnvDuration for settings where zero turns
+// the limit off
+func (r *configResolver) getEnvOptionalDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := r.getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a duration of zero or more, got %q", key, value)
+	}
+	return d, nil
+}
+
+// splitList splits a comma separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
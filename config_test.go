@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResolveConfigSources(t *testing.T) {
+	t.Setenv("MAILGUN_DOMAIN", "mg.example.com")
+	t.Setenv("MAILGUN_API_KEY", "key-test")
+	// Loaded from .env, overridden in the environment and left unset
+	t.Setenv("MAILGUN_FROM_NAME", "Shop")
+	t.Setenv("DEFAULT_LOCALE", "de-DE")
+	t.Setenv("ADMIN_EMAIL", "")
+	file := map[string]string{
+		"MAILGUN_FROM_NAME": "Shop",
+		"DEFAULT_LOCALE":    "en-GB",
+	}
+
+	config, sources, err := ResolveConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"MAILGUN_DOMAIN":    sourceEnv,
+		"MAILGUN_FROM_NAME": sourceFile,
+		"DEFAULT_LOCALE":    sourceEnv,
+		"ADMIN_EMAIL":       sourceDefault,
+	} {
+		if got := sources[key]; got != want {
+			t.Errorf("sources[%s] = %q, want %q", key, got, want)
+		}
+	}
+	if config.DefaultLocale != "de-DE" {
+		t.Errorf("default locale = %q, want the environment value", config.DefaultLocale)
+	}
+
+	// /admin/config reports the sources with the values
+	config.Sources = sources
+	router := newTestRouter()
+	router.GET("/admin/config", NewHandler(NewEmailServiceWithSender(config, &fakeSender{})).AdminConfigHandler)
+	w := do(router, "GET", "/admin/config", "", nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var response struct {
+		Sources map[string]string `json:"sources"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Sources["MAILGUN_FROM_NAME"] != sourceFile || response.Sources["ADMIN_EMAIL"] != sourceDefault {
+		t.Errorf("sources = %v", response.Sources)
+	}
+}
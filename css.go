@@ -0,0 +1,257 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// cssRule is a single inlinable CSS rule
+type cssRule struct {
+	selector     simpleSelector
+	declarations string
+	specificity  int
+	order        int
+}
+
+// simpleSelector matches elements by tag, id and classes, e.g. "td.price"
+type simpleSelector struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+var (
+	cssCommentPattern  = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	simpleSelectorPart = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*)?((?:[.#][\w-]+)*)$`)
+	selectorQualifier  = regexp.MustCompile(`[.#][\w-]+`)
+)
+
+// parseSimpleSelector parses a selector, reporting false for anything more
+// complex than tag/id/class combinations
+func parseSimpleSelector(selector string) (simpleSelector, bool) {
+	m := simpleSelectorPart.FindStringSubmatch(strings.TrimSpace(selector))
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return simpleSelector{}, false
+	}
+
+	sel := simpleSelector{tag: strings.ToLower(m[1])}
+	for _, part := range selectorQualifier.FindAllString(m[2], -1) {
+		if part[0] == '#' {
+			sel.id = part[1:]
+		} else {
+			sel.classes = append(sel.classes, part[1:])
+		}
+	}
+	return sel, true
+}
+
+// specificity approximates CSS specificity for a simple selector
+func (s simpleSelector) specificity() int {
+	n := len(s.classes) * 10
+	if s.id != "" {
+		n += 100
+	}
+	if s.tag != "" {
+		n++
+	}
+	return n
+}
+
+// matches reports whether the selector applies to the element node
+func (s simpleSelector) matches(n *html.Node) bool {
+	if s.tag != "" && n.Data != s.tag {
+		return false
+	}
+	if s.id != "" && attr(n, "id") != s.id {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	for _, want := range s.classes {
+		found := false
+		for _, class := range classes {
+			if class == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCSS splits a stylesheet into inlinable rules and the leftover CSS
+// (at-rules and complex selectors) that has to stay in a <style> block
+func parseCSS(css string) ([]cssRule, string) {
+	css = cssCommentPattern.ReplaceAllString(css, "")
+
+	var rules []cssRule
+	var leftover strings.Builder
+	for len(strings.TrimSpace(css)) > 0 {
+		open := strings.IndexByte(css, '{')
+		if open < 0 {
+			break
+		}
+
+		// Find the matching closing brace so nested at-rule blocks are kept whole
+		depth, end := 0, -1
+		for i := open; i < len(css); i++ {
+			if css[i] == '{' {
+				depth++
+			} else if css[i] == '}' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+
+		prelude := strings.TrimSpace(css[:open])
+		block := strings.TrimSpace(css[open+1 : end])
+		raw := css[:end+1]
+		css = css[end+1:]
+
+		if strings.HasPrefix(prelude, "@") {
+			leftover.WriteString(strings.TrimSpace(raw) + "\n")
+			continue
+		}
+
+		for _, part := range strings.Split(prelude, ",") {
+			sel, ok := parseSimpleSelector(part)
+			if !ok {
+				leftover.WriteString(strings.TrimSpace(part) + " { " + block + " }\n")
+				continue
+			}
+			rules = append(rules, cssRule{
+				selector:     sel,
+				declarations: block,
+				specificity:  sel.specificity(),
+				order:        len(rules),
+			})
+		}
+	}
+	return rules, leftover.String()
+}
+
+// attr returns the value of an element attribute
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// setAttr sets or replaces an element attribute
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+// joinDeclarations concatenates CSS declaration blocks into one style value
+func joinDeclarations(blocks []string) string {
+	var parts []string
+	for _, block := range blocks {
+		for _, decl := range strings.Split(block, ";") {
+			if decl = strings.TrimSpace(decl); decl != "" {
+				parts = append(parts, decl)
+			}
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// inlineCSS moves the rules of <style> blocks into style attributes on the
+// matching elements, since many email clients strip <style> blocks. Rules
+// that cannot be inlined are kept in a <style> block in the head.
+func inlineCSS(document string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	// Collect and detach all style blocks
+	var css strings.Builder
+	var styles []*html.Node
+	var head *html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Style:
+				styles = append(styles, n)
+			case atom.Head:
+				head = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	for _, style := range styles {
+		for c := style.FirstChild; c != nil; c = c.NextSibling {
+			css.WriteString(c.Data)
+			css.WriteString("\n")
+		}
+		style.Parent.RemoveChild(style)
+	}
+
+	rules, leftover := parseCSS(css.String())
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return rules[i].specificity < rules[j].specificity
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	var apply func(*html.Node)
+	apply = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			var blocks []string
+			for _, rule := range rules {
+				if rule.selector.matches(n) {
+					blocks = append(blocks, rule.declarations)
+				}
+			}
+			if len(blocks) > 0 {
+				// Existing inline styles win over stylesheet rules
+				if existing := attr(n, "style"); existing != "" {
+					blocks = append(blocks, existing)
+				}
+				setAttr(n, "style", joinDeclarations(blocks))
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			apply(c)
+		}
+	}
+	apply(doc)
+
+	if strings.TrimSpace(leftover) != "" && head != nil {
+		style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: leftover})
+		head.AppendChild(style)
+	}
+
+	var out strings.Builder
+	if err := html.Render(&out, doc); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	const document = `<html><head><style>
+/* brand colours */
+p { color: #333; }
+.price { font-weight: bold; }
+td.price { color: green; }
+#total { color: red; }
+a:hover { color: blue; }
+@media (max-width: 600px) { p { font-size: 12px; } }
+</style></head><body>
+<p>Hello</p>
+<table><tr><td class="price" style="padding: 4px">$40.00</td><td id="total" class="price">$45.00</td></tr></table>
+<a href="https://shop.example.com">Shop</a>
+</body></html>`
+	got, err := inlineCSS(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<p style="color: #333">Hello</p>`,
+		// Rules apply by specificity and the element's own style wins
+		`<td class="price" style="font-weight: bold; color: green; padding: 4px">`,
+		`<td id="total" class="price" style="font-weight: bold; color: green; color: red">`,
+		// What can't be inlined stays in the head
+		"a:hover { color: blue; }",
+		"@media (max-width: 600px) { p { font-size: 12px; } }",
+		`<a href="https://shop.example.com">Shop</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("inlined document is missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "brand colours") || strings.Contains(got, "font-weight: bold; }") {
+		t.Errorf("inlined rules were kept in the style block:\n%s", got)
+	}
+}
+
+func TestSendInlinesCSS(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		inlined bool
+	}{
+		{name: "default", inlined: true},
+		{name: "disabled", env: map[string]string{"INLINE_CSS": "false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+			if w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil); w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			html := plain(t, sender.last(t)).HTML()
+			if inlined := strings.Contains(html, `<h2 style="color: #1a1a1a">`); inlined != tt.inlined {
+				t.Errorf("inlined = %v, want %v:\n%s", inlined, tt.inlined, html)
+			}
+		})
+	}
+}
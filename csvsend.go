@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSVRowError describes a CSV row that could not be sent. Row numbers count
+// the header as row 1, matching what a spreadsheet shows.
+type CSVRowError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+// CSVSendSummary is the outcome of a CSV upload
+type CSVSendSummary struct {
+	Processed int           `json:"processed"`
+	Sent      int           `json:"sent"`
+	Skipped   int           `json:"skipped"`
+	Failed    []CSVRowError `json:"failed"`
+}
+
+// csvRecipient is a parsed CSV row
+type csvRecipient struct {
+	row       int
+	email     string
+	name      string
+	variables map[string]string
+}
+
+// parseRecipientCSV reads recipients from a CSV with an email column, an
+// optional name column and any number of variable columns. Rows that can't
+// be used are returned as errors while the remaining rows are still parsed.
+func parseRecipientCSV(r io.Reader, maxRows int) ([]csvRecipient, []CSVRowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+	emailColumn, nameColumn := -1, -1
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		switch header[i] {
+		case "email":
+			emailColumn = i
+		case "name":
+			nameColumn = i
+		}
+	}
+	if emailColumn < 0 {
+		return nil, nil, errors.New("missing email column")
+	}
+
+	var recipients []csvRecipient
+	var rowErrors []CSVRowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, nil, err
+		}
+		if len(recipients)+len(rowErrors) >= maxRows {
+			return nil, nil, fmt.Errorf("more than %d rows", maxRows)
+		}
+
+		// Quoted fields may span lines, so rows are numbered by their line
+		row, _ := reader.FieldPos(0)
+		if err != nil {
+			rowErrors = append(rowErrors, CSVRowError{Row: row, Error: fmt.Sprintf("expected %d columns, got %d", len(header), len(record))})
+			continue
+		}
+
+		email := strings.TrimSpace(record[emailColumn])
+		if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+			rowErrors = append(rowErrors, CSVRowError{Row: row, Email: email, Error: "invalid email address"})
+			continue
+		}
+
+		recipient := csvRecipient{row: row, email: email, variables: make(map[string]string)}
+		for i, value := range record {
+			switch i {
+			case emailColumn:
+			case nameColumn:
+				recipient.name = strings.TrimSpace(value)
+			default:
+				if header[i] != "" {
+					recipient.variables[header[i]] = value
+				}
+			}
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, rowErrors, nil
+}
+
+// SendCSVHandler sends the product email to every recipient of an uploaded
+// CSV. The multipart form carries the CSV as "file" and the product as
+// product_name, price, description, subject and category fields.
+func (h *Handler) SendCSVHandler(c *gin.Context) {
+	cfg := h.emailService.cfg()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(cfg.CSVMaxBytes))
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(413, gin.H{
+				"error":   "CSV too large",
+				"details": fmt.Sprintf("uploads are limited to %d bytes", cfg.CSVMaxBytes),
+			})
+			return
+		}
+		c.JSON(400, gin.H{
+			"error":   "Missing CSV file",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	product := ProductEmail{
+		ProductName: c.PostForm("product_name"),
+		Description: c.PostForm("description"),
+		Subject:     c.PostForm("subject"),
+		Category:    c.PostForm("category"),
+	}
+	if product.ProductName == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+	if price := c.PostForm("price"); price != "" {
+		if product.Price, err = strconv.ParseFloat(price, 64); err != nil {
+			c.JSON(400, gin.H{
+				"error":   "Invalid price",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	upload, err := file.Open()
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error":   "Invalid CSV",
+			"details": err.Error(),
+		})
+		return
+	}
+	defer upload.Close()
+
+	recipients, rowErrors, err := parseRecipientCSV(upload, cfg.CSVMaxRows)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error":   "Invalid CSV",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	ctx := withActor(c.Request.Context(), requestActor(c, cfg.APIKeys))
+	summary := CSVSendSummary{
+		Processed: len(recipients) + len(rowErrors),
+		Failed:    rowErrors,
+	}
+	for _, recipient := range recipients {
+		data := product
+		data.RecipientEmail = recipient.email
+		data.RecipientName = recipient.name
+		data.Variables = recipient.variables
+
+		result, err := h.emailService.SendProductEmail(ctx, data)
+		switch {
+		case err != nil:
+			summary.Failed = append(summary.Failed, CSVRowError{Row: recipient.row, Email: recipient.email, Error: err.Error()})
+		case result.Skipped != "":
+			summary.Skipped++
+		default:
+			summary.Sent++
+		}
+	}
+	if summary.Failed == nil {
+		summary.Failed = []CSVRowError{}
+	}
+	sort.Slice(summary.Failed, func(i, j int) bool {
+		return summary.Failed[i].Row < summary.Failed[j].Row
+	})
+
+	c.JSON(200, summary)
+}
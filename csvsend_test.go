@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadCSV posts a CSV and form fields to /send-csv as multipart form data
+func uploadCSV(t *testing.T, router *gin.Engine, csv string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, value := range fields {
+		form.WriteField(key, value)
+	}
+	part, err := form.CreateFormFile("file", "recipients.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(csv))
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/send-csv", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSendCSVHandler(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-csv", NewHandler(service).SendCSVHandler)
+
+	csv := "\ufeffEmail,Name,Coupon\n" +
+		"alex@example.com,Alex,SPRING10\n" +
+		"not-an-address,Sam,SPRING10\n" +
+		"\"kim@example.com\",\"Kim\nLee\",SPRING20\n" +
+		"jo@example.com,Jo\n"
+	w := uploadCSV(t, router, csv, map[string]string{"product_name": "Desk Lamp", "price": "40"})
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var summary CSVSendSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Processed != 4 || summary.Sent != 2 || summary.Skipped != 0 {
+		t.Errorf("summary = %+v", summary)
+	}
+	// Rows count the header as row 1, and the quoted name spans two lines
+	want := []CSVRowError{
+		{Row: 3, Email: "not-an-address", Error: "invalid email address"},
+		{Row: 6, Error: "expected 3 columns, got 2"},
+	}
+	if !slices.Equal(summary.Failed, want) {
+		t.Errorf("failed = %+v, want %+v", summary.Failed, want)
+	}
+
+	var recipients []string
+	for _, message := range sender.sent() {
+		recipients = append(recipients, message.To()...)
+		if text := plain(t, message).Text(); !strings.Contains(text, "Name: Desk Lamp") || !strings.Contains(text, "$40.00") {
+			t.Errorf("body is missing the product:\n%s", text)
+		}
+	}
+	if !slices.Equal(recipients, []string{"alex@example.com", "kim@example.com"}) {
+		t.Errorf("sent to %v", recipients)
+	}
+}
+
+func TestParseRecipientCSV(t *testing.T) {
+	recipients, rowErrors, err := parseRecipientCSV(strings.NewReader("email, name ,coupon\nalex@example.com, Alex ,SPRING10\n"), 10)
+	if err != nil || len(rowErrors) != 0 || len(recipients) != 1 {
+		t.Fatalf("parseRecipientCSV() = %+v, %+v, %v", recipients, rowErrors, err)
+	}
+	if got := recipients[0]; got.email != "alex@example.com" || got.name != "Alex" || got.variables["coupon"] != "SPRING10" {
+		t.Errorf("recipient = %+v", got)
+	}
+}
+
+func TestSendCSVHandlerRejectsUnusableUploads(t *testing.T) {
+	product := map[string]string{"product_name": "Desk Lamp"}
+	tests := []struct {
+		name   string
+		env    map[string]string
+		csv    string
+		fields map[string]string
+		status int
+	}{
+		{name: "no email column", csv: "name\nAlex\n", fields: product, status: 400},
+		{name: "too many rows", env: map[string]string{"CSV_MAX_ROWS": "1"}, csv: "email\nalex@example.com\nkim@example.com\n", fields: product, status: 400},
+		{name: "too large", env: map[string]string{"CSV_MAX_BYTES": "128"}, csv: "email\n" + strings.Repeat("alex@example.com\n", 20), fields: product, status: 413},
+		{name: "missing product", csv: "email\nalex@example.com\n", status: 400},
+		{name: "invalid price", csv: "email\nalex@example.com\n", fields: map[string]string{"product_name": "Desk Lamp", "price": "forty"}, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/send-csv", NewHandler(service).SendCSVHandler)
+
+			if w := uploadCSV(t, router, tt.csv, tt.fields); w.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if len(sender.sent()) != 0 {
+				t.Error("a message was sent")
+			}
+		})
+	}
+}
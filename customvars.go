@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+const (
+	// maxCustomVariables bounds how many v: variables a message may carry
+	maxCustomVariables = 20
+	// maxCustomVariablesSize bounds the combined size of keys and values
+	maxCustomVariablesSize = 4096
+)
+
+// ErrInvalidCustomVariables is returned when custom_variables exceed the limits
+var ErrInvalidCustomVariables = errors.New("invalid custom_variables")
+
+// addCustomVariables stamps the variables onto the message as Mailgun v:
+// variables, which are echoed back in webhook events but never shown to
+// the recipient
+func addCustomVariables(message *mailgun.Message, variables map[string]string) error {
+	if len(variables) > maxCustomVariables {
+		return fmt.Errorf("%w: at most %d variables are allowed", ErrInvalidCustomVariables, maxCustomVariables)
+	}
+
+	size := 0
+	for key, value := range variables {
+		if key == "" {
+			return fmt.Errorf("%w: variable names must not be empty", ErrInvalidCustomVariables)
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxCustomVariablesSize {
+		return fmt.Errorf("%w: variables exceed %d bytes", ErrInvalidCustomVariables, maxCustomVariablesSize)
+	}
+
+	for key, value := range variables {
+		if err := message.AddVariable(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
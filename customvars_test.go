@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSendWithCustomVariables(t *testing.T) {
+	many := map[string]string{}
+	for i := range maxCustomVariables + 1 {
+		many[fmt.Sprintf("key%d", i)] = "x"
+	}
+	tests := []struct {
+		name      string
+		variables map[string]string
+		status    int
+	}{
+		{name: "none", status: 200},
+		{name: "some", variables: map[string]string{"order_id": "1234", "campaign": "spring"}, status: 200},
+		{name: "too many", variables: many, status: 400},
+		{name: "too large", variables: map[string]string{"blob": strings.Repeat("x", maxCustomVariablesSize)}, status: 400},
+		{name: "empty name", variables: map[string]string{"": "x"}, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+			body, _ := json.Marshal(map[string]any{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "custom_variables": tt.variables})
+
+			w := do(router, "POST", "/send-product", string(body), nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				return
+			}
+			got := sender.last(t).Variables()
+			for key, value := range tt.variables {
+				if got[key] != value {
+					t.Errorf("v:%s = %q, want %q", key, got[key], value)
+				}
+			}
+			if len(got) != len(tt.variables) {
+				t.Errorf("variables = %v, want %v", got, tt.variables)
+			}
+			// v: variables never reach the recipient
+			if text := plain(t, sender.last(t)).Text(); strings.Contains(text, "1234") {
+				t.Errorf("a variable ended up in the body:\n%s", text)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDedupFields identify a duplicate send when DEDUP_FIELDS is unset
+var defaultDedupFields = []string{"recipient", "product", "subject"}
+
+// dedupFields extract the values a content hash can be built from
+var dedupFields = map[string]func(s *EmailService, data ProductEmail) string{
+	"recipient": func(s *EmailService, data ProductEmail) string {
+		return normalizeRecipient(data.RecipientEmail, s.cfg().RecipientNormalization)
+	},
+	"product": func(_ *EmailService, data ProductEmail) string { return data.ProductName },
+	"subject": func(s *EmailService, data ProductEmail) string {
+		if subject, err := s.resolveSubject(data); err == nil {
+			return subject
+		}
+		return data.Subject
+	},
+	"price":       func(_ *EmailService, data ProductEmail) string { return strconv.FormatFloat(data.Price, 'f', -1, 64) },
+	"description": func(_ *EmailService, data ProductEmail) string { return data.Description },
+	"category":    func(_ *EmailService, data ProductEmail) string { return data.category() },
+	"from_key":    func(_ *EmailService, data ProductEmail) string { return data.FromKey },
+	"template":    func(_ *EmailService, data ProductEmail) string { return data.Template },
+}
+
+// validateDedupFields checks that every DEDUP_FIELDS entry is known
+func validateDedupFields(fields []string) error {
+	for _, field := range fields {
+		if _, ok := dedupFields[field]; !ok {
+			return fmt.Errorf("unknown DEDUP_FIELDS entry %q", field)
+		}
+	}
+	return nil
+}
+
+// dedupEntry is a send that identical requests within the window reuse
+type dedupEntry struct {
+	done      chan struct{}
+	result    SendResult
+	err       error
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+// sendDeduper remembers recent sends by content hash so double-clicks
+// don't send the same email twice
+type sendDeduper struct {
+	clock  Clock
+	window time.Duration
+	fields []string
+
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+// newSendDeduper creates a deduper hashing fields over window
+func newSendDeduper(clock Clock, window time.Duration, fields []string) *sendDeduper {
+	if len(fields) == 0 {
+		fields = defaultDedupFields
+	}
+	return &sendDeduper{
+		clock:   clock,
+		window:  window,
+		fields:  fields,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// key hashes the configured fields of an email and its effective send
+// mode, so a live send is never answered with the result of a test one
+func (d *sendDeduper) key(ctx context.Context, s *EmailService, data ProductEmail) string {
+	hash := sha256.New()
+	for _, field := range d.fields {
+		hash.Write([]byte(dedupFields[field](s, data)))
+		hash.Write([]byte{0})
+	}
+	hash.Write([]byte(sendModeName(testModeFrom(ctx, s.cfg().TestMode))))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// do runs send unless an identical send is in flight or finished within
+// the window, in which case its outcome is returned with Deduplicated set.
+// Failed sends are forgotten so they can be retried.
+func (d *sendDeduper) do(ctx context.Context, key string, send func() (SendResult, error)) (SendResult, error) {
+	now := d.clock.Now()
+	d.mu.Lock()
+	for k, entry := range d.entries {
+		if !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+			delete(d.entries, k)
+		}
+	}
+	if entry, ok := d.entries[key]; ok {
+		d.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return SendResult{}, ctx.Err()
+		case <-entry.done:
+		}
+		result := entry.result
+		result.Deduplicated = true
+		return result, entry.err
+	}
+	entry := &dedupEntry{done: make(chan struct{}), createdAt: now}
+	d.entries[key] = entry
+	d.mu.Unlock()
+
+	entry.result, entry.err = send()
+
+	d.mu.Lock()
+	if entry.err != nil {
+		delete(d.entries, key)
+	} else {
+		entry.expiresAt = d.clock.Now().Add(d.window)
+	}
+	d.mu.Unlock()
+	close(entry.done)
+	return entry.result, entry.err
+}
+
+// DedupCacheStats describes the de-duplication cache
+type DedupCacheStats struct {
+	Enabled  bool `json:"enabled"`
+	Size     int  `json:"size"`
+	InFlight int  `json:"in_flight"`
+	// OldestAgeSeconds is the age of the oldest cached send
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+}
+
+// stats reports the live entries of the cache
+func (d *sendDeduper) stats() DedupCacheStats {
+	now := d.clock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := DedupCacheStats{Enabled: true}
+	var oldest time.Time
+	for _, entry := range d.entries {
+		if entry.expiresAt.IsZero() {
+			stats.InFlight++
+			continue
+		}
+		if !now.Before(entry.expiresAt) {
+			continue
+		}
+		stats.Size++
+		if oldest.IsZero() || entry.createdAt.Before(oldest) {
+			oldest = entry.createdAt
+		}
+	}
+	if !oldest.IsZero() {
+		stats.OldestAgeSeconds = now.Sub(oldest).Seconds()
+	}
+	return stats
+}
+
+// purge forgets every finished send so identical requests go out again and
+// returns how many were dropped. Sends in flight are kept so concurrent
+// duplicates still wait for them.
+func (d *sendDeduper) purge() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	purged := 0
+	for key, entry := range d.entries {
+		if !entry.expiresAt.IsZero() {
+			delete(d.entries, key)
+			purged++
+		}
+	}
+	return purged
+}
+
+// DedupCacheHandler reports the size of the de-duplication cache
+func (h *Handler) DedupCacheHandler(c *gin.Context) {
+	if h.emailService.dedup == nil {
+		c.JSON(200, DedupCacheStats{})
+		return
+	}
+	c.JSON(200, h.emailService.dedup.stats())
+}
+
+// PurgeDedupCacheHandler clears the de-duplication cache, e.g. so sends
+// swallowed after a bad deploy can be repeated
+func (h *Handler) PurgeDedupCacheHandler(c *gin.Context) {
+	purged := 0
+	if h.emailService.dedup != nil {
+		purged = h.emailService.dedup.purge()
+	}
+	slog.Warn("dedup cache purged", "entries", purged, "actor", requestActor(c, h.emailService.cfg().APIKeys))
+	c.JSON(200, gin.H{
+		"message": "Dedup cache purged",
+		"purged":  purged,
+	})
+}
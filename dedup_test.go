@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+func TestSendDeduplication(t *testing.T) {
+	first := ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}
+	tests := []struct {
+		name         string
+		env          map[string]string
+		ctx          context.Context
+		second       ProductEmail
+		deduplicated bool
+	}{
+		{name: "identical", second: first, deduplicated: true},
+		{name: "recipient case", second: ProductEmail{RecipientEmail: "Alex@Example.com", ProductName: "Desk Lamp"}, deduplicated: true},
+		{name: "other product", second: ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Bookshelf"}},
+		{name: "other subject", second: ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Subject: "Last chance"}},
+		{name: "test mode", ctx: withTestMode(context.Background(), true), second: first},
+		{name: "configured fields", env: map[string]string{"DEDUP_FIELDS": "recipient"}, second: ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Bookshelf"}, deduplicated: true},
+		{name: "unlisted field differs", env: map[string]string{"DEDUP_FIELDS": "recipient,product"}, second: ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Price: 30}, deduplicated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"DEDUP_WINDOW": "1m"}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+			service, sender := newTestService(t, env)
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			original, err := service.SendProductEmail(context.Background(), first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := service.SendProductEmail(ctx, tt.second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Deduplicated != tt.deduplicated {
+				t.Errorf("deduplicated = %v, want %v", result.Deduplicated, tt.deduplicated)
+			}
+			if tt.deduplicated && result.ID != original.ID {
+				t.Errorf("id = %q, want the original %q", result.ID, original.ID)
+			}
+			want := 2
+			if tt.deduplicated {
+				want = 1
+			}
+			if got := len(sender.sent()); got != want {
+				t.Errorf("%d messages sent, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestSendDeduplicationForgetsFailedSends(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"DEDUP_WINDOW": "1m", "MAX_RETRIES": "0"})
+	data := ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}
+	sender.err = errors.New("connection reset")
+	if _, err := service.SendProductEmail(context.Background(), data); err == nil {
+		t.Fatal("the send didn't fail")
+	}
+	sender.mu.Lock()
+	sender.err = nil
+	sender.mu.Unlock()
+	result, err := service.SendProductEmail(context.Background(), data)
+	if err != nil || result.Deduplicated {
+		t.Errorf("retry = %+v, %v, want a new send", result, err)
+	}
+	if got := len(sender.sent()); got != 2 {
+		t.Errorf("%d send attempts, want 2", got)
+	}
+}
+
+// gatedSender is a fakeSender whose sends wait until release is closed
+type gatedSender struct {
+	fakeSender
+	started chan struct{}
+	release chan struct{}
+}
+
+// Send implements Sender
+func (g *gatedSender) Send(ctx context.Context, message *mailgun.Message) (string, string, error) {
+	g.started <- struct{}{}
+	<-g.release
+	return g.fakeSender.Send(ctx, message)
+}
+
+func TestSendDeduplicationJoinsSendsInFlight(t *testing.T) {
+	sender := &gatedSender{started: make(chan struct{}, 2), release: make(chan struct{})}
+	service := NewEmailServiceWithSender(testConfig(t, map[string]string{"DEDUP_WINDOW": "1m"}), sender)
+	data := ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}
+
+	results := make(chan SendResult, 2)
+	send := func() {
+		result, err := service.SendProductEmail(context.Background(), data)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}
+	go send()
+	<-sender.started
+	go send()
+	// The duplicate waits for the first send rather than starting its own
+	time.Sleep(20 * time.Millisecond)
+	if len(sender.started) != 0 {
+		t.Error("the duplicate started a second send")
+	}
+	close(sender.release)
+
+	a, b := <-results, <-results
+	if a.ID != b.ID || a.Deduplicated == b.Deduplicated {
+		t.Errorf("results = %+v and %+v, want one send shared by both", a, b)
+	}
+	if got := len(sender.sent()); got != 1 {
+		t.Errorf("%d messages sent, want 1", got)
+	}
+}
+
+func TestDedupFieldsAreValidated(t *testing.T) {
+	t.Setenv("MAILGUN_DOMAIN", "mg.example.com")
+	t.Setenv("MAILGUN_API_KEY", "key-test")
+	t.Setenv("DEDUP_FIELDS", "recipient,colour")
+	if _, _, err := ResolveConfig(nil); err == nil {
+		t.Error("an unknown DEDUP_FIELDS entry was accepted")
+	}
+}
+
+func TestDedupCacheAdmin(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"DEDUP_WINDOW": "1m"})
+	clock := newFakeClock()
+	service.SetClock(clock)
+	handler := NewHandler(service)
+	router := newTestRouter()
+	router.POST("/send-product", handler.SendProductHandler)
+	router.GET("/admin/idempotency-cache", handler.DedupCacheHandler)
+	router.DELETE("/admin/idempotency-cache", handler.PurgeDedupCacheHandler)
+	send := func() string {
+		w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil)
+		if w.Code != 200 {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+	stats := func() DedupCacheStats {
+		var stats DedupCacheStats
+		if err := json.Unmarshal(do(router, "GET", "/admin/idempotency-cache", "", nil).Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	send()
+	if body := send(); !strings.Contains(body, `"deduplicated":true`) {
+		t.Fatalf("repeated send wasn't deduplicated: %s", body)
+	}
+	clock.Advance(30 * time.Second)
+	if got, want := stats(), (DedupCacheStats{Enabled: true, Size: 1, OldestAgeSeconds: 30}); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+
+	w := do(router, "DELETE", "/admin/idempotency-cache", "", nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"purged":1`) {
+		t.Fatalf("purge status = %d, body %s", w.Code, w.Body)
+	}
+	if got := stats(); got.Size != 0 {
+		t.Errorf("size = %d after the purge, want 0", got.Size)
+	}
+	if body := send(); strings.Contains(body, `"deduplicated"`) {
+		t.Errorf("send after the purge was deduplicated: %s", body)
+	}
+	if got := len(sender.sent()); got != 2 {
+		t.Errorf("%d messages sent, want 2", got)
+	}
+}
+
+func TestDedupCacheAdminWithoutWindow(t *testing.T) {
+	service, _ := newTestService(t, nil)
+	handler := NewHandler(service)
+	router := newTestRouter()
+	router.GET("/admin/idempotency-cache", handler.DedupCacheHandler)
+	router.DELETE("/admin/idempotency-cache", handler.PurgeDedupCacheHandler)
+
+	if w := do(router, "GET", "/admin/idempotency-cache", "", nil); !strings.Contains(w.Body.String(), `"enabled":false`) {
+		t.Errorf("stats = %s, want the cache disabled", w.Body)
+	}
+	if w := do(router, "DELETE", "/admin/idempotency-cache", "", nil); w.Code != 200 || !strings.Contains(w.Body.String(), `"purged":0`) {
+		t.Errorf("purge status = %d, body %s", w.Code, w.Body)
+	}
+}
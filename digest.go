@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingDigest collects the products queued for one recipient
+type pendingDigest struct {
+	id    string
+	actor string
+	// overrides are the X-Send-Mode and X-Max-Retries of the requests
+	overrides sendOverrides
+	items     []ProductEmail
+	dueAt     time.Time
+	// startedAt is set once the digest is being sent
+	startedAt time.Time
+	timer     Timer
+}
+
+// saved returns the digest as written to the state file
+func (d *pendingDigest) saved() savedDigest {
+	digest := savedDigest{ID: d.id, Actor: d.actor, Overrides: d.overrides, Items: d.items, DueAt: d.dueAt}
+	if !d.startedAt.IsZero() {
+		startedAt := d.startedAt
+		digest.StartedAt = &startedAt
+	}
+	return digest
+}
+
+// digestBuffer batches products queued for the same recipient within a
+// window so they go out as a single email. Products queued with different
+// template sets or send overrides are batched separately.
+type digestBuffer struct {
+	clock    Clock
+	window   time.Duration
+	maxItems int
+	flush    func(ctx context.Context, digest *pendingDigest)
+	// state persists the pending digests when DIGEST_STATE_PATH is set
+	state *digestState
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[string]*pendingDigest
+	// sending holds the digests being sent by ID; they stay in the saved
+	// state until their send has finished
+	sending map[string]*pendingDigest
+	// flushing tracks flushes started by timers so Close can wait for them
+	flushing sync.WaitGroup
+	// stop is canceled by Close to end the delivery checks of restored
+	// digests
+	stop       context.Context
+	cancelStop context.CancelFunc
+}
+
+// newDigestBuffer creates a buffer handing each batch to flush once window
+// has passed since its first product or it holds maxItems products. The
+// digests are saved to statePath unless it is empty.
+func newDigestBuffer(clock Clock, window time.Duration, maxItems int, statePath string, flush func(ctx context.Context, digest *pendingDigest)) *digestBuffer {
+	b := &digestBuffer{
+		clock:    clock,
+		window:   window,
+		maxItems: maxItems,
+		flush:    flush,
+		pending:  make(map[string]*pendingDigest),
+		sending:  make(map[string]*pendingDigest),
+	}
+	b.stop, b.cancelStop = context.WithCancel(context.Background())
+	if statePath != "" {
+		b.state = &digestState{path: statePath}
+	}
+	return b
+}
+
+// digestKey identifies the digest data is batched into: products for the
+// same recipient, template set and send overrides go out together
+func digestKey(data ProductEmail, overrides sendOverrides) string {
+	return recipientKey(data.RecipientEmail) + "|template=" + data.Template + overrides.key()
+}
+
+// add queues data and returns how many products are pending for its
+// recipient. It reports false once the buffer is closed.
+func (b *digestBuffer) add(actor string, overrides sendOverrides, data ProductEmail) (int, bool) {
+	key := digestKey(data, overrides)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, false
+	}
+
+	digest, ok := b.pending[key]
+	if !ok {
+		digest = &pendingDigest{id: randomID(), actor: actor, overrides: overrides, dueAt: b.clock.Now().Add(b.window)}
+		digest.timer = b.clock.AfterFunc(b.window, func() { b.flushKey(key, digest) })
+		b.pending[key] = digest
+	}
+	digest.items = append(digest.items, data)
+	queued := len(digest.items)
+
+	if b.maxItems > 0 && queued >= b.maxItems && digest.timer.Stop() {
+		b.takeLocked(key, digest)
+		b.flushing.Add(1)
+		go func() {
+			defer b.flushing.Done()
+			b.send(context.Background(), digest)
+		}()
+		return queued, true
+	}
+	b.saveLocked()
+	return queued, true
+}
+
+// flushKey sends the digest of key when its window elapses, unless it was
+// already taken by a full batch or Close
+func (b *digestBuffer) flushKey(key string, digest *pendingDigest) {
+	b.mu.Lock()
+	if b.pending[key] != digest {
+		b.mu.Unlock()
+		return
+	}
+	b.takeLocked(key, digest)
+	b.flushing.Add(1)
+	b.mu.Unlock()
+
+	defer b.flushing.Done()
+	b.send(context.Background(), digest)
+}
+
+// takeLocked moves the digest of key from pending to sending
+func (b *digestBuffer) takeLocked(key string, digest *pendingDigest) {
+	delete(b.pending, key)
+	digest.startedAt = b.clock.Now()
+	b.sending[digest.id] = digest
+	b.saveLocked()
+}
+
+// send hands a digest taken by takeLocked to flush and forgets it
+// afterwards. A digest whose send was cut short by ctx stays saved as in
+// flight, so the next run checks whether it went out.
+func (b *digestBuffer) send(ctx context.Context, digest *pendingDigest) {
+	b.flush(ctx, digest)
+	if ctx.Err() != nil && b.state != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sending, digest.id)
+	b.saveLocked()
+}
+
+// saveLocked writes the pending and sending digests to the state file. A
+// failed write is only logged; the digests are still sent from memory.
+func (b *digestBuffer) saveLocked() {
+	if b.state == nil {
+		return
+	}
+	digests := make([]savedDigest, 0, len(b.pending)+len(b.sending))
+	for _, digest := range b.pending {
+		digests = append(digests, digest.saved())
+	}
+	for _, digest := range b.sending {
+		digests = append(digests, digest.saved())
+	}
+	if err := b.state.save(digests); err != nil {
+		slog.Error("saving digest state failed", "path", b.state.path, "error", err)
+	}
+}
+
+// restore schedules digests saved by a previous run: pending ones for
+// their original time, or straight away if that has passed. A digest that
+// was being sent stays saved while resend decides on it, and goes out
+// again only when resend returns true; when Close stops resend the digest
+// is kept for the next run.
+func (b *digestBuffer) restore(pending, inFlight []savedDigest, resend func(ctx context.Context, digest savedDigest) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, saved := range pending {
+		key := digestKey(saved.Items[0], saved.Overrides)
+		digest := &pendingDigest{id: saved.ID, actor: saved.Actor, overrides: saved.Overrides, items: saved.Items, dueAt: saved.DueAt}
+		digest.timer = b.clock.AfterFunc(max(digest.dueAt.Sub(b.clock.Now()), 0), func() { b.flushKey(key, digest) })
+		b.pending[key] = digest
+	}
+	for _, saved := range inFlight {
+		digest := &pendingDigest{id: saved.ID, actor: saved.Actor, overrides: saved.Overrides, items: saved.Items, dueAt: saved.DueAt, startedAt: *saved.StartedAt}
+		b.sending[digest.id] = digest
+		b.flushing.Add(1)
+		go func() {
+			defer b.flushing.Done()
+			if resend(b.stop, saved) {
+				b.mu.Lock()
+				digest.startedAt = b.clock.Now()
+				b.saveLocked()
+				b.mu.Unlock()
+				b.send(context.Background(), digest)
+				return
+			}
+			if b.stop.Err() != nil {
+				return
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.sending, digest.id)
+			b.saveLocked()
+		}()
+	}
+	b.saveLocked()
+}
+
+// Close stops accepting products, sends every pending digest under ctx and
+// waits for them and the flushes in progress, or until ctx is done
+func (b *digestBuffer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.cancelStop()
+	for key, digest := range b.pending {
+		digest.timer.Stop()
+		b.takeLocked(key, digest)
+		b.flushing.Add(1)
+		go func() {
+			defer b.flushing.Done()
+			b.send(ctx, digest)
+		}()
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.flushing.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// digestEmail combines the products queued for a recipient into one email.
+// Recipient, sender and routing settings come from the first product.
+func digestEmail(items []ProductEmail) ProductEmail {
+	data := items[0]
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.ProductName
+	}
+
+	data.DigestItems = items
+	data.ProductName = strings.Join(names, ", ")
+	data.Subject = fmt.Sprintf("%d new products", len(items))
+	data.Price = 0
+	data.Description = ""
+	data.DescriptionMarkdown = ""
+	data.Variables = nil
+	data.Event = nil
+	data.CallbackURL = ""
+	data.HTMLURL = ""
+	return data
+}
+
+// QueueDigest buffers data for the recipient's next digest and returns how
+// many products are pending for them. It reports false when digests are
+// disabled or shutting down, in which case the caller should send directly.
+func (s *EmailService) QueueDigest(ctx context.Context, data ProductEmail) (int, bool, error) {
+	if s.digests == nil {
+		return 0, false, nil
+	}
+	// The request context is gone by the time the digest is sent
+	if data.Locale == "" {
+		data.Locale = localeFrom(ctx)
+	}
+	if data.CallbackURL != "" {
+		if err := validateCallbackURL(data.CallbackURL); err != nil {
+			return 0, false, err
+		}
+	}
+	queued, ok := s.digests.add(actorFrom(ctx), sendOverridesFrom(ctx), data)
+	return queued, ok, nil
+}
+
+// FlushDigests sends every pending digest; used on shutdown
+func (s *EmailService) FlushDigests(ctx context.Context) error {
+	if s.digests == nil {
+		return nil
+	}
+	return s.digests.Close(ctx)
+}
+
+// digestSend returns the email sending the products queued for a
+// recipient. A single product is sent as a regular product email.
+func digestSend(items []ProductEmail) ProductEmail {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return digestEmail(items)
+}
+
+// digestSendTimeout bounds each digest send like a /send-product request
+func (s *EmailService) digestSendTimeout() time.Duration {
+	cfg := s.cfg()
+	if timeout, ok := cfg.RouteTimeouts["/send-product"]; ok {
+		return timeout
+	}
+	return cfg.DefaultRouteTimeout
+}
+
+// sendDigest sends the products queued for a recipient with the overrides
+// of the requests that queued them, within digestSendTimeout of ctx
+func (s *EmailService) sendDigest(ctx context.Context, digest *pendingDigest) {
+	ctx, cancel := context.WithTimeout(ctx, s.digestSendTimeout())
+	defer cancel()
+	items := digest.items
+	data := digestSend(items)
+	ctx = withDigestID(withActor(ctx, digest.actor), digest.id)
+	ctx = digest.overrides.apply(ctx)
+	result, err := s.sendProductEmail(ctx, data)
+	s.auditSend(ctx, data, result, err)
+	for _, item := range items {
+		s.notifyCallback(item, result, err)
+	}
+	if err != nil {
+		slog.Error("digest send failed", "recipient", maskEmail(data.RecipientEmail), "products", len(items), "error", err)
+	}
+}
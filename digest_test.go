@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// waitForSent waits until sender has recorded n messages
+func waitForSent(t *testing.T, sender *fakeSender, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sender.sent()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d messages sent, want %d", len(sender.sent()), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// queueDigest queues a product, failing the test when it isn't buffered
+func queueDigest(t *testing.T, service *EmailService, ctx context.Context, data ProductEmail) int {
+	t.Helper()
+	queued, ok, err := service.QueueDigest(ctx, data)
+	if err != nil || !ok {
+		t.Fatalf("queue digest: ok %v, err %v", ok, err)
+	}
+	return queued
+}
+
+func TestDigestBuffersPerRecipientUntilShutdown(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"DIGEST_WINDOW": "1h"})
+	ctx := context.Background()
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Price: 40})
+	if queued := queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "Alex@Example.com", ProductName: "Bookshelf", Price: 90}); queued != 2 {
+		t.Errorf("queued = %d, want 2 for the same recipient", queued)
+	}
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "sam@example.com", ProductName: "Armchair", Price: 150})
+	if sent := sender.sent(); len(sent) != 0 {
+		t.Fatalf("%d messages sent before the window elapsed", len(sent))
+	}
+
+	if err := service.FlushDigests(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sent := sender.sent()
+	if len(sent) != 2 {
+		t.Fatalf("%d messages sent on shutdown, want one per recipient", len(sent))
+	}
+	texts := map[string]string{}
+	for _, message := range sent {
+		texts[message.To()[0]] = plain(t, message).Text()
+	}
+	for _, want := range []string{"Here are 2 new products", "Name: Desk Lamp", "Name: Bookshelf"} {
+		if !strings.Contains(texts["alex@example.com"], want) {
+			t.Errorf("digest is missing %q:\n%s", want, texts["alex@example.com"])
+		}
+	}
+	if text := texts["sam@example.com"]; !strings.Contains(text, "Product Details:") || !strings.Contains(text, "Name: Armchair") {
+		t.Errorf("a single product isn't sent as a product email:\n%s", text)
+	}
+
+	if _, ok, _ := service.QueueDigest(ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Rug"}); ok {
+		t.Error("products are still buffered after shutdown")
+	}
+}
+
+func TestDigestFlushes(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{name: "after the window", env: map[string]string{"DIGEST_WINDOW": "20ms"}},
+		{name: "when full", env: map[string]string{"DIGEST_WINDOW": "1h", "DIGEST_MAX_ITEMS": "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			ctx := context.Background()
+			queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+			queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Bookshelf"})
+
+			waitForSent(t, sender, 1)
+			if text := plain(t, sender.last(t)).Text(); !strings.Contains(text, "Here are 2 new products") {
+				t.Errorf("not sent as a digest:\n%s", text)
+			}
+			if err := service.FlushDigests(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if sent := sender.sent(); len(sent) != 1 {
+				t.Errorf("%d messages sent, want the digest once", len(sent))
+			}
+		})
+	}
+}
+
+func TestDigestPersonalizesEachItem(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"DIGEST_WINDOW": "1h"})
+	ctx := context.Background()
+	queueDigest(t, service, ctx, ProductEmail{
+		RecipientEmail: "alex@example.com",
+		ProductName:    "Desk Lamp",
+		Description:    "Use code {{code}}",
+		Variables:      map[string]string{"code": "LAMP10"},
+	})
+	queueDigest(t, service, ctx, ProductEmail{
+		RecipientEmail:      "alex@example.com",
+		ProductName:         "Bookshelf",
+		DescriptionMarkdown: "Solid **oak**, code {{code}}",
+		Variables:           map[string]string{"code": "OAK5"},
+	})
+	if err := service.FlushDigests(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	email := plain(t, sender.last(t))
+	for _, want := range []string{"Use code LAMP10", "Solid oak, code OAK5"} {
+		if !strings.Contains(email.Text(), want) {
+			t.Errorf("text body is missing %q:\n%s", want, email.Text())
+		}
+	}
+	for _, want := range []string{"Use code LAMP10", "<strong>oak</strong>, code OAK5"} {
+		if !strings.Contains(email.HTML(), want) {
+			t.Errorf("html body is missing %q:\n%s", want, email.HTML())
+		}
+	}
+}
+
+func TestDigestFlushesWhenWindowElapses(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"DIGEST_WINDOW": "1h"})
+	clock := newFakeClock()
+	service.SetClock(clock)
+	ctx := context.Background()
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+	clock.Advance(30 * time.Minute)
+	// Products join the open digest without extending its window
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Bookshelf"})
+
+	clock.Advance(30*time.Minute - time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if sent := sender.sent(); len(sent) != 0 {
+		t.Fatalf("%d messages sent before the window elapsed", len(sent))
+	}
+	clock.Advance(time.Second)
+	waitForSent(t, sender, 1)
+	if text := plain(t, sender.last(t)).Text(); !strings.Contains(text, "Name: Desk Lamp") || !strings.Contains(text, "Name: Bookshelf") {
+		t.Errorf("digest is missing products:\n%s", text)
+	}
+
+	// A product queued after the flush opens a new window
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Armchair"})
+	clock.Advance(time.Hour)
+	waitForSent(t, sender, 2)
+}
+
+// hangingSender is a Sender whose sends only end with their context,
+// recording the deadline each was given
+type hangingSender struct {
+	deadlines chan time.Time
+}
+
+// Send implements Sender
+func (h *hangingSender) Send(ctx context.Context, _ *mailgun.Message) (string, string, error) {
+	deadline, _ := ctx.Deadline()
+	h.deadlines <- deadline
+	<-ctx.Done()
+	return "", "", ctx.Err()
+}
+
+func TestDigestSendsAreBounded(t *testing.T) {
+	sender := &hangingSender{deadlines: make(chan time.Time, 1)}
+	service := NewEmailServiceWithSender(testConfig(t, map[string]string{
+		"DIGEST_WINDOW":  "1h",
+		"ROUTE_TIMEOUTS": "/send-product=50ms",
+	}), sender)
+	queueDigest(t, service, context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+
+	start := time.Now()
+	if err := service.FlushDigests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := <-sender.deadlines
+	if after := deadline.Sub(start); deadline.IsZero() || after < 50*time.Millisecond || after > 500*time.Millisecond {
+		t.Errorf("send deadline = %v after the flush started, want about 50ms", after)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("flush took %v", elapsed)
+	}
+}
+
+func TestDigestCloseHonoursContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digests.json")
+	sender := &hangingSender{deadlines: make(chan time.Time, 2)}
+	service := NewEmailServiceWithSender(testConfig(t, map[string]string{
+		"DIGEST_WINDOW":     "1h",
+		"DIGEST_STATE_PATH": path,
+	}), sender)
+	ctx := context.Background()
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "sam@example.com", ProductName: "Bookshelf"})
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := service.FlushDigests(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context's", err)
+	}
+	// Both digests were sent together, not one after the other
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("flush took %v", elapsed)
+	}
+	if got := len(sender.deadlines); got != 2 {
+		t.Errorf("%d sends started, want 2", got)
+	}
+
+	// The sends cut short stay saved for the next run to check
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		saved, err := (&digestState{path: path}).load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inFlight := 0
+		for _, digest := range saved {
+			if digest.StartedAt != nil {
+				inFlight++
+			}
+		}
+		if inFlight == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("saved digests = %+v, want both in flight", saved)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/mailgun/mailgun-go/v4/events"
+)
+
+// digestIDVariable is the Mailgun v: variable carrying the ID of a digest,
+// so a restart can tell whether a digest in flight went out
+const digestIDVariable = "digest_id"
+
+// digestRecoveryTimeout bounds each delivery check of a digest that was in
+// flight when the service stopped
+const digestRecoveryTimeout = 30 * time.Second
+
+// digestDeliveryGrace is how long after a send started a missing accepted
+// event still may be Mailgun's indexing delay rather than a lost send
+const digestDeliveryGrace = 30 * time.Minute
+
+// digestRecoveryPollInterval is how often the events of a digest in flight
+// are checked again within digestDeliveryGrace
+const digestRecoveryPollInterval = time.Minute
+
+type digestIDKey struct{}
+
+// withDigestID marks the sends made with ctx as the digest with the given ID
+func withDigestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, digestIDKey{}, id)
+}
+
+// digestIDFrom returns the digest a send belongs to, if any
+func digestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(digestIDKey{}).(string)
+	return id
+}
+
+// savedDigest is a pending digest as written to DIGEST_STATE_PATH
+type savedDigest struct {
+	ID        string         `json:"id"`
+	Actor     string         `json:"actor,omitempty"`
+	Overrides sendOverrides  `json:"overrides"`
+	Items     []ProductEmail `json:"items"`
+	DueAt     time.Time      `json:"due_at"`
+	// StartedAt is set while the digest is being sent
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// digestState persists the pending digests to a JSON file so they survive
+// a restart
+type digestState struct {
+	path string
+}
+
+// load reads the digests saved by a previous run; a missing file has none
+func (s *digestState) load() ([]savedDigest, error) {
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state struct {
+		Digests []savedDigest `json:"digests"`
+	}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, err
+	}
+	return state.Digests, nil
+}
+
+// save replaces the file atomically so a crash never leaves a partial
+// state behind
+func (s *digestState) save(digests []savedDigest) error {
+	raw, err := json.Marshal(struct {
+		Digests []savedDigest `json:"digests"`
+	}{digests})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// RestoreDigests reschedules the digests saved by a previous run. A digest
+// that was being sent is checked in the background and only sent again
+// once Mailgun's events show it wasn't accepted, see resendDigest.
+func (s *EmailService) RestoreDigests() error {
+	if s.digests == nil || s.digests.state == nil {
+		return nil
+	}
+	saved, err := s.digests.state.load()
+	if err != nil {
+		return err
+	}
+
+	var pending, inFlight []savedDigest
+	for _, digest := range saved {
+		if len(digest.Items) == 0 {
+			continue
+		}
+		if digest.StartedAt == nil {
+			pending = append(pending, digest)
+		} else {
+			inFlight = append(inFlight, digest)
+		}
+	}
+	s.digests.restore(pending, inFlight, s.resendDigest)
+	if len(saved) > 0 {
+		slog.Info("digests restored", "pending", len(pending), "in_flight", len(inFlight))
+	}
+	return nil
+}
+
+// resendDigest reports whether a digest in flight at shutdown has to be
+// sent again. Mailgun indexes events with a delay, so the lookup is
+// repeated until digestDeliveryGrace has passed since the send started
+// before a missing accepted event counts. A digest whose delivery can't be
+// checked is never resent, since a missed digest is better than a
+// duplicate one; it is retried until ctx is done.
+func (s *EmailService) resendDigest(ctx context.Context, digest savedDigest) bool {
+	recipient := maskEmail(digest.Items[0].RecipientEmail)
+	for {
+		lookupCtx, cancel := context.WithTimeout(ctx, digestRecoveryTimeout)
+		delivered, err := s.digestDelivered(lookupCtx, digest)
+		cancel()
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return false
+			}
+			slog.Warn("checking delivery of digest in flight at shutdown failed", "digest_id", digest.ID, "recipient", recipient, "error", err)
+		case delivered:
+			slog.Info("digest in flight at shutdown was delivered", "digest_id", digest.ID, "recipient", recipient)
+			return false
+		case s.clock.Now().Sub(*digest.StartedAt) >= digestDeliveryGrace:
+			slog.Info("resending digest in flight at shutdown", "digest_id", digest.ID, "recipient", recipient, "products", len(digest.Items))
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.clock.After(digestRecoveryPollInterval):
+		}
+	}
+}
+
+// digestDelivered reports whether Mailgun accepted a digest, looking for
+// its digest_id among the recipient's accepted events since it was sent
+func (s *EmailService) digestDelivered(ctx context.Context, digest savedDigest) (bool, error) {
+	data := digestSend(digest.Items)
+	domain, err := s.sendingDomain(data)
+	if err != nil {
+		return false, err
+	}
+	recipient := data.RecipientEmail
+	if to := s.cfg().RedirectAllTo; to != "" {
+		recipient = to
+	}
+	opts := &mailgun.ListEventOptions{
+		// Leaves room for clock skew between us and Mailgun
+		Begin: digest.StartedAt.Add(-time.Minute),
+		Filter: map[string]string{
+			"event":     "accepted",
+			"recipient": recipient,
+		},
+		Limit: 300,
+	}
+	it := s.mailgunFor(domain).ListEventsWithDomain(opts, domain)
+	var page []mailgun.Event
+	for it.Next(ctx, &page) {
+		for _, event := range page {
+			accepted, ok := event.(*events.Accepted)
+			if !ok {
+				continue
+			}
+			if variables, ok := accepted.UserVariables.(map[string]any); ok && variables[digestIDVariable] == digest.ID {
+				return true, nil
+			}
+		}
+	}
+	return false, it.Err()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDigestsSurviveRestart(t *testing.T) {
+	env := map[string]string{
+		"DIGEST_WINDOW":     "1h",
+		"DIGEST_STATE_PATH": filepath.Join(t.TempDir(), "digests.json"),
+	}
+	ctx := context.Background()
+	before, beforeSender := newTestService(t, env)
+	queueDigest(t, before, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+	queueDigest(t, before, withTestMode(ctx, true), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Bookshelf"})
+	queueDigest(t, before, withTestMode(ctx, true), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Armchair"})
+	// The first service stops without flushing, as in a crash
+
+	after, sender := newTestService(t, env)
+	if err := after.RestoreDigests(); err != nil {
+		t.Fatal(err)
+	}
+	if err := after.FlushDigests(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(beforeSender.sent()) != 0 {
+		t.Error("the stopped service sent")
+	}
+	sent := sender.sent()
+	if len(sent) != 2 {
+		t.Fatalf("%d messages sent after the restart, want 2", len(sent))
+	}
+	for _, message := range sent {
+		text := plain(t, message).Text()
+		switch {
+		case message.TestMode():
+			if !strings.Contains(text, "Name: Bookshelf") || !strings.Contains(text, "Name: Armchair") {
+				t.Errorf("test-mode digest lost products:\n%s", text)
+			}
+		case !strings.Contains(text, "Name: Desk Lamp"):
+			t.Errorf("live digest lost its product:\n%s", text)
+		}
+	}
+
+	saved, err := (&digestState{path: env["DIGEST_STATE_PATH"]}).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("%d digests still saved after they were sent", len(saved))
+	}
+}
+
+func TestDigestsInFlightAtShutdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		events func(w http.ResponseWriter, id string)
+		resent bool
+		kept   bool
+	}{
+		{
+			name: "accepted before the restart",
+			events: func(w http.ResponseWriter, id string) {
+				fmt.Fprintf(w, `{"items": [{"event": "accepted", "id": "ev1", "timestamp": 1709294400, "user-variables": {"digest_id": %q}}], "paging": {}}`, id)
+			},
+		},
+		{
+			name:   "never accepted",
+			events: func(w http.ResponseWriter, _ string) { fmt.Fprint(w, `{"items": [], "paging": {}}`) },
+			resent: true,
+		},
+		{
+			name:   "delivery unknown",
+			events: func(w http.ResponseWriter, _ string) { http.Error(w, "unavailable", 503) },
+			kept:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const id = "digest-1"
+			var sends, lookups atomic.Int32
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /v3/mg.example.com/messages", func(w http.ResponseWriter, r *http.Request) {
+				sends.Add(1)
+				fmt.Fprint(w, `{"id": "<1@mg.example.com>", "message": "Queued. Thank you."}`)
+			})
+			mux.HandleFunc("GET /v3/mg.example.com/events", func(w http.ResponseWriter, r *http.Request) {
+				lookups.Add(1)
+				tt.events(w, id)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			path := filepath.Join(t.TempDir(), "digests.json")
+			state := &digestState{path: path}
+			// Well past digestDeliveryGrace, so a missing event counts
+			startedAt := time.Now().Add(-time.Hour)
+			if err := state.save([]savedDigest{{
+				ID:        id,
+				Items:     []ProductEmail{{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}, {RecipientEmail: "alex@example.com", ProductName: "Bookshelf"}},
+				DueAt:     startedAt,
+				StartedAt: &startedAt,
+			}}); err != nil {
+				t.Fatal(err)
+			}
+
+			service := NewEmailService(testConfig(t, map[string]string{"DIGEST_WINDOW": "1h", "DIGEST_STATE_PATH": path}))
+			service.mg.SetAPIBase(server.URL + "/v3")
+			if err := service.RestoreDigests(); err != nil {
+				t.Fatal(err)
+			}
+			// Wait for the recovery to decide; a kept digest is only checked
+			settled := func() bool {
+				if tt.kept {
+					return lookups.Load() > 0
+				}
+				saved, err := state.load()
+				return err == nil && len(saved) == 0
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for !settled() {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d lookups and %d sends, the recovery didn't finish", lookups.Load(), sends.Load())
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err := service.FlushDigests(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if resent := sends.Load() > 0; resent != tt.resent {
+				t.Errorf("resent = %v, want %v", resent, tt.resent)
+			}
+			saved, err := state.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := len(saved) == 1 && saved[0].ID == id; kept != tt.kept {
+				t.Errorf("saved digests = %+v, want kept %v", saved, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// ErrInvalidDKIMSelector is returned for malformed or unknown DKIM selectors
+var ErrInvalidDKIMSelector = errors.New("invalid dkim_selector")
+
+// dkimSelectorPattern matches one or more DNS labels
+var dkimSelectorPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// validateDKIMSelector checks the selector's syntax, that it is one of the
+// configured selectors (when any are configured) and, optionally, that its
+// key is published in the sending domain's DNS
+func (s *EmailService) validateDKIMSelector(ctx context.Context, selector, domain string) error {
+	if !dkimSelectorPattern.MatchString(selector) {
+		return fmt.Errorf("%w %q", ErrInvalidDKIMSelector, selector)
+	}
+
+	if len(s.cfg().DKIMSelectors) > 0 {
+		known := false
+		for _, configured := range s.cfg().DKIMSelectors {
+			if strings.EqualFold(configured, selector) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: %q is not configured for %s", ErrInvalidDKIMSelector, selector, domain)
+		}
+	}
+
+	if s.cfg().DKIMVerifyDNS {
+		name := selector + "._domainkey." + domain
+		if _, err := net.DefaultResolver.LookupTXT(ctx, name); err != nil {
+			return fmt.Errorf("%w: no DKIM key published at %s", ErrInvalidDKIMSelector, name)
+		}
+	}
+	return nil
+}
+
+// applyDKIMSelector signs the message with the given selector's key instead
+// of the domain's default selector
+func applyDKIMSelector(message *mailgun.Message, selector, domain string) {
+	message.SetDKIM(true)
+	message.AddHeader("X-Mailgun-Secondary-DKIM", domain+"/"+selector)
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestSendWithDKIMSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		selector string
+		status   int
+	}{
+		{name: "any selector", selector: "mg2024", status: 200},
+		{name: "subdomain labels", selector: "k1.marketing", status: 200},
+		{name: "configured selector", env: map[string]string{"DKIM_SELECTORS": "mg2024,MG2025"}, selector: "mg2025", status: 200},
+		{name: "unknown selector", env: map[string]string{"DKIM_SELECTORS": "mg2024"}, selector: "other", status: 400},
+		{name: "malformed", selector: "bad_selector!", status: 400},
+		{name: "leading hyphen", selector: "-mg", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "dkim_selector": "`+tt.selector+`"}`, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				if len(sender.sent()) != 0 {
+					t.Error("sent with an invalid selector")
+				}
+				return
+			}
+			message := sender.last(t)
+			if got, want := message.Headers()["X-Mailgun-Secondary-DKIM"], "mg.example.com/"+tt.selector; got != want {
+				t.Errorf("X-Mailgun-Secondary-DKIM = %q, want %q", got, want)
+			}
+			if dkim := message.DKIM(); dkim == nil || !*dkim {
+				t.Error("DKIM signing isn't enabled")
+			}
+		})
+	}
+}
+
+func TestSendWithoutDKIMSelector(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+	if w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil); w.Code != 200 {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if _, ok := sender.last(t).Headers()["X-Mailgun-Secondary-DKIM"]; ok {
+		t.Error("a selector header was set without a dkim_selector")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// ErrUnknownDomainKey is returned when a request selects an unconfigured
+// sending domain
+var ErrUnknownDomainKey = errors.New("unknown domain_key")
+
+// parseMailgunDomains parses the MAILGUN_DOMAINS JSON object mapping keys
+// to additional sending domains
+func parseMailgunDomains(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var domains map[string]string
+	if err := json.Unmarshal([]byte(raw), &domains); err != nil {
+		return nil, fmt.Errorf("invalid MAILGUN_DOMAINS: %w", err)
+	}
+	for key, domain := range domains {
+		if domain == "" || strings.ContainsAny(domain, "@/ ") {
+			return nil, fmt.Errorf("MAILGUN_DOMAINS %q: invalid domain %q", key, domain)
+		}
+	}
+	return domains, nil
+}
+
+// sendingDomains returns the primary domain followed by the additional ones
+func (c Config) sendingDomains() []string {
+	domains := []string{c.Domain}
+	for _, domain := range c.MailgunDomains {
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// sendingDomain returns the domain selected by domain_key, or the primary
+// domain when none is requested
+func (s *EmailService) sendingDomain(data ProductEmail) (string, error) {
+	cfg := s.cfg()
+	if data.DomainKey == "" {
+		return cfg.Domain, nil
+	}
+	domain, ok := cfg.MailgunDomains[data.DomainKey]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownDomainKey, data.DomainKey)
+	}
+	return domain, nil
+}
+
+// newMailgunClient creates a Mailgun client for domain with the configured
+// credentials, region and transport
+func newMailgunClient(config Config, domain string) *mailgun.MailgunImpl {
+	mg := mailgun.NewMailgun(domain, config.ApiKey)
+	mg.SetWebhookSigningKey(config.WebhookSigningKey)
+	if apiBase, err := config.APIBase(); err == nil {
+		mg.SetAPIBase(apiBase)
+	}
+	if transport := mailgunTransport(config); transport != nil {
+		mg.SetClient(&http.Client{Transport: transport})
+	}
+	return mg
+}
+
+// senderFor returns the sender delivering through domain. A sender
+// injected through NewEmailServiceWithSender handles every domain.
+func (s *EmailService) senderFor(domain string) Sender {
+	if s.sender != Sender(s.mg) {
+		return s.sender
+	}
+	return s.mailgunFor(domain)
+}
+
+// mailgunFor returns the Mailgun client of domain. Clients for additional
+// domains are created on first use and reused afterwards.
+func (s *EmailService) mailgunFor(domain string) *mailgun.MailgunImpl {
+	if domain == s.mg.Domain() {
+		return s.mg
+	}
+
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	client, ok := s.clients[domain]
+	if !ok {
+		client = newMailgunClient(s.cfg(), domain)
+		s.clients[domain] = client
+	}
+	return client
+}
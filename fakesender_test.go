@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// fakeSender is a Sender that records every message it is given instead of
+// sending it, answering with a canned response and message ID
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []*mailgun.Message
+	// err fails every send when set
+	err error
+}
+
+// Send implements Sender
+func (f *fakeSender) Send(_ context.Context, message *mailgun.Message) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, message)
+	if f.err != nil {
+		return "", "", f.err
+	}
+	return "Queued. Thank you.", fmt.Sprintf("<%d@mg.example.com>", len(f.messages)), nil
+}
+
+// sent returns the messages recorded so far
+func (f *fakeSender) sent() []*mailgun.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*mailgun.Message(nil), f.messages...)
+}
+
+// last returns the most recent message, failing the test when none was sent
+func (f *fakeSender) last(t *testing.T) *mailgun.Message {
+	t.Helper()
+	messages := f.sent()
+	if len(messages) == 0 {
+		t.Fatal("no message was sent")
+	}
+	return messages[len(messages)-1]
+}
+
+// plain returns the plain message of a message built by the service
+func plain(t *testing.T, message *mailgun.Message) *mailgun.PlainMessage {
+	t.Helper()
+	specific, ok := message.Specific.(*mailgun.PlainMessage)
+	if !ok {
+		t.Fatalf("message is a %T, not a plain message", message.Specific)
+	}
+	return specific
+}
+
+// testConfig resolves the configuration from the minimal environment plus
+// env, the way the service reads it at startup
+func testConfig(t *testing.T, env map[string]string) Config {
+	t.Helper()
+	vars := map[string]string{
+		"MAILGUN_DOMAIN":     "mg.example.com",
+		"MAILGUN_API_KEY":    "key-test",
+		"MAILGUN_FROM_NAME":  "Shop",
+		"MAILGUN_FROM_EMAIL": "shop",
+	}
+	for key, value := range env {
+		vars[key] = value
+	}
+	for key, value := range vars {
+		t.Setenv(key, value)
+	}
+	config, _, err := ResolveConfig(nil)
+	if err != nil {
+		t.Fatalf("resolve config: %v", err)
+	}
+	return config
+}
+
+// newTestService creates a service sending through a fakeSender
+func newTestService(t *testing.T, env map[string]string) (*EmailService, *fakeSender) {
+	t.Helper()
+	sender := &fakeSender{}
+	return NewEmailServiceWithSender(testConfig(t, env), sender), sender
+}
+
+// newTestRouter creates a router without the production middleware, for
+// mounting the handlers under test
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	return gin.New()
+}
+
+// do runs a single request against router. A body is sent as JSON.
+func do(router http.Handler, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessCheckNames are the readiness checks READINESS_DISABLED_CHECKS
+// can turn off
+var readinessCheckNames = []string{"mailgun", "callback_queue", "event_forward_queue", "database"}
+
+// ReadinessCheck reports why a dependency can't serve traffic, or nil
+type ReadinessCheck func(ctx context.Context) error
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	maintenance *Maintenance
+	timeout     time.Duration
+	checks      map[string]ReadinessCheck
+}
+
+// NewHealthHandler creates a new health handler whose readiness checks each
+// get timeout to complete
+func NewHealthHandler(maintenance *Maintenance, timeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		maintenance: maintenance,
+		timeout:     timeout,
+		checks:      make(map[string]ReadinessCheck),
+	}
+}
+
+// AddCheck registers a dependency checked by the readiness probe
+func (h *HealthHandler) AddCheck(name string, check ReadinessCheck) {
+	h.checks[name] = check
+}
+
+// queueCheck fails once a queue is full, when new items would be dropped
+func queueCheck(backlog func() (int, int)) ReadinessCheck {
+	return func(context.Context) error {
+		if length, capacity := backlog(); length >= capacity {
+			return fmt.Errorf("queue is full (%d items)", length)
+		}
+		return nil
+	}
+}
+
+// Healthz reports that the process is alive
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"status":      "ok",
+		"maintenance": h.maintenance.Enabled(),
+	})
+}
+
+// Readyz reports whether the service should receive traffic. Every
+// registered check runs concurrently and the response breaks down their
+// status; any failing check makes it a 503.
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	if h.maintenance.Enabled() {
+		c.JSON(503, gin.H{
+			"status":      "maintenance",
+			"maintenance": true,
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.timeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checks := make(gin.H, len(h.checks))
+	ready := true
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				ready = false
+				checks[name] = gin.H{"status": "failing", "error": err.Error()}
+				return
+			}
+			checks[name] = gin.H{"status": "ok"}
+		}()
+	}
+	wg.Wait()
+
+	if !ready {
+		c.JSON(503, gin.H{
+			"status":      "not_ready",
+			"maintenance": false,
+			"checks":      checks,
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"status":      "ready",
+		"maintenance": false,
+		"checks":      checks,
+	})
+}
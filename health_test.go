@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadyzBreakdown(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+	slow := func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }
+
+	tests := []struct {
+		name   string
+		checks map[string]ReadinessCheck
+		status int
+		want   map[string]string
+	}{
+		{
+			name:   "all up",
+			checks: map[string]ReadinessCheck{"mailgun": ok, "database": ok},
+			status: 200,
+			want:   map[string]string{"mailgun": "ok", "database": "ok"},
+		},
+		{
+			name:   "dependency down",
+			checks: map[string]ReadinessCheck{"mailgun": ok, "database": down},
+			status: 503,
+			want:   map[string]string{"mailgun": "ok", "database": "failing"},
+		},
+		{
+			name:   "dependency times out",
+			checks: map[string]ReadinessCheck{"mailgun": slow, "database": ok},
+			status: 503,
+			want:   map[string]string{"mailgun": "failing", "database": "ok"},
+		},
+		{
+			name:   "full queue",
+			checks: map[string]ReadinessCheck{"callback_queue": queueCheck(func() (int, int) { return 100, 100 })},
+			status: 503,
+			want:   map[string]string{"callback_queue": "failing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := NewHealthHandler(NewMaintenance(false, time.Minute), 20*time.Millisecond)
+			for name, check := range tt.checks {
+				health.AddCheck(name, check)
+			}
+			router := newTestRouter()
+			router.GET("/readyz", health.Readyz)
+
+			w := do(router, "GET", "/readyz", "", nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			var response struct {
+				Checks map[string]struct {
+					Status string `json:"status"`
+					Error  string `json:"error"`
+				} `json:"checks"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Checks) != len(tt.want) {
+				t.Errorf("checks = %+v, want %v", response.Checks, tt.want)
+			}
+			for name, status := range tt.want {
+				check := response.Checks[name]
+				if check.Status != status {
+					t.Errorf("%s status = %q, want %q", name, check.Status, status)
+				}
+				if (status == "failing") != (check.Error != "") {
+					t.Errorf("%s error = %q", name, check.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// ErrInvalidHTMLURL is returned for html_url values that aren't plain
+// http(s) URLs or that point at a private address
+var ErrInvalidHTMLURL = errors.New("invalid html_url")
+
+// warnHTMLURLFallback is reported when the html_url couldn't be fetched and
+// the template-rendered body was sent instead
+const warnHTMLURLFallback = "html_url_fetch_failed"
+
+// validateHTMLURL checks an html_url before the send is attempted
+func validateHTMLURL(raw string) error {
+	if err := checkPublicURL(raw); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHTMLURL, err)
+	}
+	return nil
+}
+
+// HTMLFetcher downloads email HTML hosted elsewhere, e.g. on a CMS
+type HTMLFetcher struct {
+	client   *http.Client
+	maxBytes int
+}
+
+// NewHTMLFetcher creates a fetcher reading at most maxBytes per document
+func NewHTMLFetcher(timeout time.Duration, maxBytes int) *HTMLFetcher {
+	return &HTMLFetcher{
+		client:   publicHTTPClient(timeout),
+		maxBytes: maxBytes,
+	}
+}
+
+// Fetch downloads the HTML document at url
+func (f *HTMLFetcher) Fetch(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != "text/html" {
+		return "", fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(f.maxBytes)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > f.maxBytes {
+		return "", fmt.Errorf("document exceeds %d bytes", f.maxBytes)
+	}
+	return string(body), nil
+}
+
+// fetchHTMLBody downloads the html_url of an email, inlining its CSS when
+// enabled
+func (s *EmailService) fetchHTMLBody(ctx context.Context, url string) (string, error) {
+	document, err := s.htmlFetcher.Fetch(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if s.cfg().InlineCSS {
+		return inlineCSS(document)
+	}
+	return document, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc serves the requests of an http.Client from a function
+type roundTripFunc func(*http.Request) *http.Response
+
+// RoundTrip implements http.RoundTripper
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+// serveDocument answers every request with a document of the given type
+func serveDocument(status int, contentType, body string) roundTripFunc {
+	return func(*http.Request) *http.Response {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.WriteString(body)
+		return w.Result()
+	}
+}
+
+func TestValidateHTMLURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{url: "https://cms.example.com/campaigns/spring.html", valid: true},
+		{url: "file:///etc/passwd"},
+		{url: "https://localhost/campaign.html"},
+		{url: "http://127.0.0.1:9000/campaign.html"},
+		{url: "http://10.0.0.5/campaign.html"},
+		{url: "http://192.168.0.20/campaign.html"},
+		{url: "http://169.254.169.254/latest/meta-data"},
+		{url: "http://[fe80::1]/campaign.html"},
+	}
+	for _, tt := range tests {
+		err := validateHTMLURL(tt.url)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateHTMLURL(%q) = %v, want valid %v", tt.url, err, tt.valid)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidHTMLURL) {
+			t.Errorf("validateHTMLURL(%q) = %v, want ErrInvalidHTMLURL", tt.url, err)
+		}
+	}
+}
+
+func TestHTMLFetcher(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport roundTripFunc
+		valid     bool
+	}{
+		{name: "html", transport: serveDocument(200, "text/html; charset=utf-8", "<p>Spring sale</p>"), valid: true},
+		{name: "not html", transport: serveDocument(200, "application/json", `{"html": "<p>Spring sale</p>"}`)},
+		{name: "too large", transport: serveDocument(200, "text/html", "<p>"+strings.Repeat("x", 64)+"</p>")},
+		{name: "not found", transport: serveDocument(404, "text/html", "<p>Not found</p>")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := NewHTMLFetcher(time.Second, 32)
+			fetcher.client.Transport = tt.transport
+			document, err := fetcher.Fetch(context.Background(), "https://cms.example.com/spring.html")
+			if (err == nil) != tt.valid {
+				t.Fatalf("Fetch() = %q, %v, want valid %v", document, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestHTMLFetcherRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the private address was reached")
+	}))
+	defer server.Close()
+
+	if _, err := NewHTMLFetcher(time.Second, 1024).Fetch(context.Background(), server.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("err = %v, want errPrivateAddress", err)
+	}
+}
+
+func TestSendWithHTMLURL(t *testing.T) {
+	const document = `<html><head><style>.cta { color: #c00; }</style></head><body><h1>Spring sale</h1><a class="cta" href="https://shop.example.com/sale">Shop now</a></body></html>`
+	tests := []struct {
+		name      string
+		transport roundTripFunc
+		warnings  []string
+	}{
+		{name: "fetched", transport: serveDocument(200, "text/html", document)},
+		{name: "fetch failed", transport: serveDocument(500, "text/html", ""), warnings: []string{warnHTMLURLFallback}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			service.htmlFetcher.client.Transport = tt.transport
+			result, err := service.SendProductEmail(context.Background(), ProductEmail{
+				RecipientEmail: "alex@example.com",
+				ProductName:    "Desk Lamp",
+				HTMLURL:        "https://cms.example.com/spring.html",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(result.Warnings, tt.warnings) {
+				t.Errorf("warnings = %q, want %q", result.Warnings, tt.warnings)
+			}
+
+			email := plain(t, sender.last(t))
+			if tt.warnings != nil {
+				// The templated bodies are sent instead
+				if !strings.Contains(email.Text(), "Name: Desk Lamp") || !strings.Contains(email.HTML(), "Desk Lamp") {
+					t.Errorf("template bodies were not used:\n%s\n%s", email.Text(), email.HTML())
+				}
+				return
+			}
+			if !strings.Contains(email.HTML(), "Spring sale") || !strings.Contains(email.HTML(), `style="color: #c00`) {
+				t.Errorf("html body is not the fetched document with inlined CSS:\n%s", email.HTML())
+			}
+			if !strings.Contains(email.Text(), "Shop now (https://shop.example.com/sale)") || strings.Contains(email.Text(), "Name: Desk Lamp") {
+				t.Errorf("text body is not derived from the document:\n%s", email.Text())
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+)
+
+// FromIdentity is a named sender identity selectable per request
+type FromIdentity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// ErrUnknownFromKey is returned when a request selects an unconfigured identity
+var ErrUnknownFromKey = errors.New("unknown from_key")
+
+// ErrFromDomainMismatch is returned when the selected identity's address
+// is not on the selected sending domain
+var ErrFromDomainMismatch = errors.New("from_key is not on the sending domain")
+
+// parseFromIdentities parses the FROM_IDENTITIES JSON object and checks that
+// every address belongs to one of the Mailgun sending domains
+func parseFromIdentities(raw string, domains []string) (map[string]FromIdentity, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var identities map[string]FromIdentity
+	if err := json.Unmarshal([]byte(raw), &identities); err != nil {
+		return nil, fmt.Errorf("invalid FROM_IDENTITIES: %w", err)
+	}
+	for key, identity := range identities {
+		at := strings.LastIndexByte(identity.Email, '@')
+		if at <= 0 {
+			return nil, fmt.Errorf("FROM_IDENTITIES %q: invalid email %q", key, identity.Email)
+		}
+		if !slices.ContainsFunc(domains, func(domain string) bool { return strings.EqualFold(identity.Email[at+1:], domain) }) {
+			return nil, fmt.Errorf("FROM_IDENTITIES %q: email %q is not on a Mailgun sending domain", key, identity.Email)
+		}
+	}
+	return identities, nil
+}
+
+// testSenderPrefix marks the display name of emails sent in test mode
+const testSenderPrefix = "[TEST]"
+
+// resolveSender builds the From header for an email, using the identity
+// selected by from_key or the default configured identity on domain. In
+// test mode the display name is prefixed and TEST_FROM_EMAIL replaces the
+// address when set.
+func (s *EmailService) resolveSender(data ProductEmail, domain string, testMode bool) (string, error) {
+	cfg := s.cfg()
+	identity := FromIdentity{Name: cfg.FromName, Email: cfg.FromEmail + "@" + domain}
+	if data.FromKey != "" {
+		var ok bool
+		if identity, ok = cfg.FromIdentities[data.FromKey]; !ok {
+			return "", fmt.Errorf("%w %q", ErrUnknownFromKey, data.FromKey)
+		}
+		// Mailgun only signs mail from the domain it is sent through
+		if at := strings.LastIndexByte(identity.Email, '@'); !strings.EqualFold(identity.Email[at+1:], domain) {
+			return "", fmt.Errorf("%w: %q sends from %s, not %s", ErrFromDomainMismatch, data.FromKey, identity.Email[at+1:], domain)
+		}
+	}
+
+	if testMode {
+		identity.Name = strings.TrimSpace(testSenderPrefix + " " + identity.Name)
+		if cfg.TestFromEmail != "" {
+			identity.Email = cfg.TestFromEmail
+		}
+	}
+	return formatAddress(identity.Name, identity.Email), nil
+}
+
+// formatAddress builds an address header value. Display names with
+// non-ASCII characters are RFC 2047 encoded and specials are quoted.
+func formatAddress(name, email string) string {
+	return (&mail.Address{Name: name, Address: email}).String()
+}
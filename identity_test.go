@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/mail"
+	"testing"
+)
+
+func TestParseFromIdentities(t *testing.T) {
+	domains := []string{"mg.example.com", "mg.brand.example"}
+	tests := []struct {
+		raw   string
+		valid bool
+	}{
+		{raw: "", valid: true},
+		{raw: `{"sales": {"name": "Sales", "email": "sales@mg.example.com"}}`, valid: true},
+		{raw: `{"brand": {"name": "Brand", "email": "hello@MG.Brand.Example"}}`, valid: true},
+		{raw: `{"other": {"name": "Other", "email": "hello@other.example"}}`},
+		{raw: `{"broken": {"name": "Broken", "email": "no-at-sign"}}`},
+		{raw: `not json`},
+	}
+	for _, tt := range tests {
+		if _, err := parseFromIdentities(tt.raw, domains); (err == nil) != tt.valid {
+			t.Errorf("parseFromIdentities(%q) err = %v, want valid %v", tt.raw, err, tt.valid)
+		}
+	}
+}
+
+func TestFromIdentityMatchesSendingDomain(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{
+		"MAILGUN_DOMAINS": `{"brand": "mg.brand.example"}`,
+		"FROM_IDENTITIES": `{"sales": {"name": "Sales", "email": "sales@mg.example.com"}, "brand": {"name": "Brand Team", "email": "hello@mg.brand.example"}}`,
+	})
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	tests := []struct {
+		name      string
+		fromKey   string
+		domainKey string
+		status    int
+		from      string
+	}{
+		{name: "default identity", status: 200, from: `"Shop" <shop@mg.example.com>`},
+		{name: "default identity on another domain", domainKey: "brand", status: 200, from: `"Shop" <shop@mg.brand.example>`},
+		{name: "identity on the primary domain", fromKey: "sales", status: 200, from: `"Sales" <sales@mg.example.com>`},
+		{name: "identity on the selected domain", fromKey: "brand", domainKey: "brand", status: 200, from: `"Brand Team" <hello@mg.brand.example>`},
+		{name: "identity on another domain", fromKey: "brand", status: 400},
+		{name: "primary identity on the selected domain", fromKey: "sales", domainKey: "brand", status: 400},
+		{name: "unknown identity", fromKey: "support", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(sender.sent())
+			body, err := json.Marshal(map[string]string{
+				"recipient_email": "alex@example.com",
+				"product_name":    "Desk Lamp",
+				"from_key":        tt.fromKey,
+				"domain_key":      tt.domainKey,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := do(router, "POST", "/send-product", string(body), nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				if len(sender.sent()) != before {
+					t.Error("a message was sent")
+				}
+				return
+			}
+			if got := plain(t, sender.last(t)).From(); got != tt.from {
+				t.Errorf("from = %q, want %q", got, tt.from)
+			}
+		})
+	}
+}
+
+func TestFormatAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Shop", want: `"Shop" <shop@mg.example.com>`},
+		{name: "Café Müller", want: `=?utf-8?q?Caf=C3=A9_M=C3=BCller?= <shop@mg.example.com>`},
+		{name: `Shop, "Outlet"`, want: `"Shop, \"Outlet\"" <shop@mg.example.com>`},
+		{name: "", want: `<shop@mg.example.com>`},
+	}
+	for _, tt := range tests {
+		if got := formatAddress(tt.name, "shop@mg.example.com"); got != tt.want {
+			t.Errorf("formatAddress(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendEncodesNonASCIIFromName(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"MAILGUN_FROM_NAME": "Café Müller"})
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	from := plain(t, sender.last(t)).From()
+	if want := `=?utf-8?q?Caf=C3=A9_M=C3=BCller?= <shop@mg.example.com>`; from != want {
+		t.Errorf("from = %s, want %s", from, want)
+	}
+	// The header decodes back to the configured name
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.Name != "Café Müller" {
+		t.Errorf("decoded name = %q", address.Name)
+	}
+}
+
+func TestTestModeSender(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		from string
+	}{
+		{name: "live", env: map[string]string{}, from: `"Shop" <shop@mg.example.com>`},
+		{name: "test mode", env: map[string]string{"MAILGUN_TEST_MODE": "true"}, from: `"[TEST] Shop" <shop@mg.example.com>`},
+		{name: "test address", env: map[string]string{"MAILGUN_TEST_MODE": "true", "TEST_FROM_EMAIL": "qa@mg.example.com"}, from: `"[TEST] Shop" <qa@mg.example.com>`},
+		{name: "test address ignored live", env: map[string]string{"TEST_FROM_EMAIL": "qa@mg.example.com"}, from: `"Shop" <shop@mg.example.com>`},
+		{name: "no display name", env: map[string]string{"MAILGUN_TEST_MODE": "true", "MAILGUN_FROM_NAME": ""}, from: `"[TEST]" <shop@mg.example.com>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil)
+			if w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if got := plain(t, sender.last(t)).From(); got != tt.from {
+				t.Errorf("from = %s, want %s", got, tt.from)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mailgun/mailgun-go/v4"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ErrInlineImageTooLarge is returned when embedded data-URI images exceed
+// the configured size caps
+var ErrInlineImageTooLarge = errors.New("inline image too large")
+
+// inlineImage is a data-URI image extracted from the HTML body
+type inlineImage struct {
+	filename string
+	data     []byte
+}
+
+// decodeDataURI decodes a base64 data: URI, reporting false for anything
+// that isn't a base64 encoded image. Both the standard and URL-safe
+// alphabets are accepted, with or without padding.
+func decodeDataURI(uri string) (mediaType string, data []byte, ok bool) {
+	rest, found := strings.CutPrefix(uri, "data:")
+	if !found {
+		return "", nil, false
+	}
+	meta, payload, found := strings.Cut(rest, ",")
+	if !found {
+		return "", nil, false
+	}
+	mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !isBase64 || !strings.HasPrefix(mediaType, "image/") {
+		return "", nil, false
+	}
+
+	payload = strings.TrimRight(strings.Join(strings.Fields(payload), ""), "=")
+	if strings.ContainsAny(payload, "-_") {
+		data, err := base64.RawURLEncoding.DecodeString(payload)
+		return mediaType, data, err == nil
+	}
+	data, err := base64.RawStdEncoding.DecodeString(payload)
+	return mediaType, data, err == nil
+}
+
+// imageExtension returns the file extension for an image media type
+func imageExtension(mediaType string) string {
+	switch subtype := strings.TrimPrefix(mediaType, "image/"); subtype {
+	case "jpeg":
+		return "jpg"
+	case "svg+xml":
+		return "svg"
+	default:
+		return subtype
+	}
+}
+
+// extractDataURIImages replaces data-URI <img> sources with cid: references
+// and returns the decoded images, since many clients block data URIs.
+// maxImage and maxTotal cap the decoded sizes in bytes.
+func extractDataURIImages(document string, maxImage, maxTotal int) (string, []inlineImage, error) {
+	if !strings.Contains(document, "data:") {
+		return document, nil, nil
+	}
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var images []inlineImage
+	total := 0
+	var walk func(*html.Node) error
+	walk = func(n *html.Node) error {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Img {
+			if mediaType, data, ok := decodeDataURI(strings.TrimSpace(attr(n, "src"))); ok {
+				if len(data) > maxImage {
+					return fmt.Errorf("%w: %d bytes exceeds the %d byte limit per image", ErrInlineImageTooLarge, len(data), maxImage)
+				}
+				if total += len(data); total > maxTotal {
+					return fmt.Errorf("%w: images exceed the %d byte total limit", ErrInlineImageTooLarge, maxTotal)
+				}
+
+				// Mailgun uses the inline filename as the Content-ID
+				filename := fmt.Sprintf("inline-%d.%s", len(images)+1, imageExtension(mediaType))
+				images = append(images, inlineImage{filename: filename, data: data})
+				setAttr(n, "src", "cid:"+filename)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(doc); err != nil {
+		return "", nil, err
+	}
+	if len(images) == 0 {
+		return document, nil, nil
+	}
+
+	var out strings.Builder
+	if err := html.Render(&out, doc); err != nil {
+		return "", nil, err
+	}
+	return out.String(), images, nil
+}
+
+// addInlineImages attaches the extracted images as inline parts that are
+// uploaded again when the send is retried
+func addInlineImages(message *mailgun.Message, images []inlineImage) {
+	for _, image := range images {
+		message.AddReaderInline(image.filename, newReplayableBody(image.data))
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeDataURI(t *testing.T) {
+	// 0xfb 0xff encodes to characters that differ between the alphabets
+	data := []byte{0xfb, 0xff, 'P', 'N', 'G'}
+	tests := []struct {
+		name      string
+		uri       string
+		mediaType string
+		ok        bool
+	}{
+		{name: "standard", uri: "data:image/png;base64," + base64.StdEncoding.EncodeToString(data), mediaType: "image/png", ok: true},
+		{name: "unpadded url-safe", uri: "data:image/png;base64," + base64.RawURLEncoding.EncodeToString(data), mediaType: "image/png", ok: true},
+		{name: "wrapped lines", uri: "data:image/gif;base64,+/9Q\n Tkc=", mediaType: "image/gif", ok: true},
+		{name: "not base64", uri: "data:image/svg+xml,<svg/>"},
+		{name: "not an image", uri: "data:text/html;base64,PGI+"},
+		{name: "corrupt", uri: "data:image/png;base64,!!!!"},
+		{name: "remote", uri: "https://cdn.example.com/lamp.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediaType, got, ok := decodeDataURI(tt.uri)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && (mediaType != tt.mediaType || string(got) != string(data)) {
+				t.Errorf("decodeDataURI() = %q, %v", mediaType, got)
+			}
+		})
+	}
+}
+
+func TestExtractDataURIImages(t *testing.T) {
+	png := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte(pngSignature))
+	jpeg := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("\xff\xd8\xff"))
+	document := `<p><img src="` + png + `"><img src="https://cdn.example.com/lamp.png"><img src="` + jpeg + `"></p>`
+
+	out, images, err := extractDataURIImages(document, 1<<10, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].filename != "inline-1.png" || images[1].filename != "inline-2.jpg" {
+		t.Fatalf("images = %+v", images)
+	}
+	if string(images[0].data) != pngSignature {
+		t.Errorf("image data = %q", images[0].data)
+	}
+	for _, want := range []string{`src="cid:inline-1.png"`, `src="cid:inline-2.jpg"`, `src="https://cdn.example.com/lamp.png"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("HTML is missing %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "data:") {
+		t.Errorf("HTML still has a data URI:\n%s", out)
+	}
+
+	if _, _, err := extractDataURIImages(document, len(pngSignature)-1, 1<<10); !errors.Is(err, ErrInlineImageTooLarge) {
+		t.Errorf("per-image limit err = %v, want ErrInlineImageTooLarge", err)
+	}
+	if _, _, err := extractDataURIImages(document, 1<<10, len(pngSignature)+1); !errors.Is(err, ErrInlineImageTooLarge) {
+		t.Errorf("total limit err = %v, want ErrInlineImageTooLarge", err)
+	}
+}
+
+func TestSendExtractsDataURIImages(t *testing.T) {
+	document := `<html><body><img src="data:image/png;base64,` + base64.StdEncoding.EncodeToString([]byte(pngSignature)) + `"></body></html>`
+	service, sender := newTestService(t, nil)
+	service.htmlFetcher.client.Transport = serveDocument(200, "text/html", document)
+
+	if _, err := service.SendProductEmail(context.Background(), ProductEmail{
+		RecipientEmail: "alex@example.com",
+		ProductName:    "Desk Lamp",
+		HTMLURL:        "https://cms.example.com/spring.html",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	message := sender.last(t)
+	if names := inlineNames(message); !slices.Contains(names, "inline-1.png") {
+		t.Errorf("inline attachments = %v, want inline-1.png", names)
+	}
+	if html := plain(t, message).HTML(); !strings.Contains(html, `src="cid:inline-1.png"`) {
+		t.Errorf("HTML doesn't reference the inline image:\n%s", html)
+	}
+
+	service, sender = newTestService(t, map[string]string{"INLINE_IMAGE_MAX_BYTES": "4"})
+	service.htmlFetcher.client.Transport = serveDocument(200, "text/html", document)
+	if _, err := service.SendProductEmail(context.Background(), ProductEmail{
+		RecipientEmail: "alex@example.com",
+		ProductName:    "Desk Lamp",
+		HTMLURL:        "https://cms.example.com/spring.html",
+	}); !errors.Is(err, ErrInlineImageTooLarge) {
+		t.Errorf("err = %v, want ErrInlineImageTooLarge", err)
+	}
+	if len(sender.sent()) != 0 {
+		t.Error("an oversized image was sent")
+	}
+}
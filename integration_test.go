@@ -0,0 +1,51 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMailgunSandboxSend sends through a real Mailgun sandbox domain. Run it
+// with
+//
+//	MAILGUN_SANDBOX_DOMAIN=sandbox123.mailgun.org MAILGUN_SANDBOX_API_KEY=... \
+//	MAILGUN_SANDBOX_RECIPIENT=you@example.com go test -tags integration -run Sandbox
+//
+// The recipient must be authorized for the sandbox domain. The send is made
+// in test mode, so Mailgun accepts it without delivering it.
+func TestMailgunSandboxSend(t *testing.T) {
+	domain := os.Getenv("MAILGUN_SANDBOX_DOMAIN")
+	apiKey := os.Getenv("MAILGUN_SANDBOX_API_KEY")
+	recipient := os.Getenv("MAILGUN_SANDBOX_RECIPIENT")
+	if domain == "" || apiKey == "" || recipient == "" {
+		t.Skip("MAILGUN_SANDBOX_DOMAIN, MAILGUN_SANDBOX_API_KEY and MAILGUN_SANDBOX_RECIPIENT are not set")
+	}
+
+	service := NewEmailService(testConfig(t, map[string]string{
+		"MAILGUN_DOMAIN":    domain,
+		"MAILGUN_API_KEY":   apiKey,
+		"MAILGUN_TEST_MODE": "true",
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := service.SendProductEmail(ctx, ProductEmail{
+		RecipientEmail: recipient,
+		ProductName:    "Integration Test",
+		Price:          1,
+		Description:    "Sent by the integration test suite",
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if result.ID == "" {
+		t.Fatalf("Mailgun returned no message ID (response %q)", result.Response)
+	}
+	if !result.TestMode {
+		t.Error("the send wasn't made in test mode")
+	}
+}
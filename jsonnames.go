@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// productEmailAliases maps the lowercased alternative field names accepted
+// for a ProductEmail to their canonical names: the camelCase spelling of
+// every snake_case field, e.g. productName or callbackURL, plus
+// recipientEmail and recipient_email for email
+var productEmailAliases = func() map[string]string {
+	aliases := map[string]string{
+		"recipientemail":  "email",
+		"recipient_email": "email",
+	}
+	fields := reflect.TypeOf(ProductEmail{})
+	for i := 0; i < fields.NumField(); i++ {
+		name, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if camel := camelCase(name); camel != name {
+			aliases[strings.ToLower(camel)] = name
+		}
+	}
+	return aliases
+}()
+
+// camelCase converts a snake_case name to camelCase
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// UnmarshalJSON decodes a ProductEmail accepting both the canonical
+// snake_case field names and their camelCase aliases. The canonical name
+// wins when a payload sets both.
+func (p *ProductEmail) UnmarshalJSON(data []byte) error {
+	// productEmail has the same fields without this method, avoiding recursion
+	type productEmail ProductEmail
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		// Let the regular decoder report non-object payloads
+		return json.Unmarshal(data, (*productEmail)(p))
+	}
+	renamed := false
+	for key, value := range fields {
+		name, ok := productEmailAliases[strings.ToLower(key)]
+		if !ok {
+			continue
+		}
+		delete(fields, key)
+		if _, set := fields[name]; !set {
+			fields[name] = value
+		}
+		renamed = true
+	}
+	if renamed {
+		var err error
+		if data, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, (*productEmail)(p))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestProductEmailFieldAliases(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want ProductEmail
+	}{
+		{
+			name: "snake case",
+			body: `{"email": "alex@example.com", "product_name": "Desk Lamp", "original_price": 50}`,
+			want: ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", OriginalPrice: 50},
+		},
+		{
+			name: "camel case",
+			body: `{"recipientEmail": "alex@example.com", "productName": "Desk Lamp", "originalPrice": 50, "recipientName": "Alex", "callbackURL": "https://hooks.example.com/sent"}`,
+			want: ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", OriginalPrice: 50, RecipientName: "Alex", CallbackURL: "https://hooks.example.com/sent"},
+		},
+		{
+			name: "recipient_email",
+			body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`,
+			want: ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"},
+		},
+		{
+			name: "canonical name wins",
+			body: `{"productName": "Lamp", "product_name": "Desk Lamp"}`,
+			want: ProductEmail{ProductName: "Desk Lamp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ProductEmail
+			if err := json.Unmarshal([]byte(tt.body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.RecipientEmail != tt.want.RecipientEmail || got.ProductName != tt.want.ProductName ||
+				got.OriginalPrice != tt.want.OriginalPrice || got.RecipientName != tt.want.RecipientName || got.CallbackURL != tt.want.CallbackURL {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	var email ProductEmail
+	if err := json.Unmarshal([]byte(`["Desk Lamp"]`), &email); err == nil {
+		t.Error("a JSON array decoded as a ProductEmail")
+	}
+}
+
+func TestSendAcceptsCamelCaseFields(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipientEmail": "alex@example.com", "productName": "Desk Lamp", "price": 40}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	message := sender.last(t)
+	if to := message.To(); len(to) != 1 || to[0] != "alex@example.com" {
+		t.Errorf("to = %v", to)
+	}
+	if text := plain(t, message).Text(); !strings.Contains(text, "Name: Desk Lamp") {
+		t.Errorf("text body is missing the product:\n%s", text)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// minSendRate is the lowest rate, in sends per second, a 429 can throttle to
+const minSendRate = 1
+
+// AdaptiveLimiter paces sends to Mailgun for the whole service. A 429
+// halves the allowed rate for a cool-down period, after which it ramps
+// linearly back to the configured rate over another cool-down period.
+type AdaptiveLimiter struct {
+	clock    Clock
+	ceiling  float64
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	throttled float64
+	recoverAt time.Time
+	next      time.Time
+}
+
+// NewAdaptiveLimiter creates a limiter allowing rate sends per second
+func NewAdaptiveLimiter(clock Clock, rate int, cooldown time.Duration) *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		clock:    clock,
+		ceiling:  float64(rate),
+		cooldown: cooldown,
+	}
+}
+
+// rate returns the sends per second allowed at now. Callers hold l.mu.
+func (l *AdaptiveLimiter) rate(now time.Time) float64 {
+	if l.throttled == 0 {
+		return l.ceiling
+	}
+	if now.Before(l.recoverAt) {
+		return l.throttled
+	}
+
+	recovered := 1.0
+	if l.cooldown > 0 {
+		recovered = float64(now.Sub(l.recoverAt)) / float64(l.cooldown)
+	}
+	if recovered >= 1 {
+		l.throttled = 0
+		return l.ceiling
+	}
+	return l.throttled + (l.ceiling-l.throttled)*recovered
+}
+
+// Rate returns the currently allowed sends per second
+func (l *AdaptiveLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate(l.clock.Now())
+}
+
+// Wait blocks until the next send is allowed or ctx is done
+func (l *AdaptiveLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(time.Duration(float64(time.Second) / l.rate(now)))
+	l.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.clock.After(delay):
+		return nil
+	}
+}
+
+// Throttle halves the allowed rate after Mailgun rejected a send with 429
+func (l *AdaptiveLimiter) Throttle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.throttled = math.Max(minSendRate, l.rate(now)/2)
+	l.recoverAt = now.Add(l.cooldown)
+	slog.Warn("mailgun rate limit hit, slowing down", "rate", l.throttled, "cooldown", l.cooldown)
+}
+
+// isRateLimited reports whether err is a 429 response from Mailgun
+func isRateLimited(err error) bool {
+	var respErr *mailgun.UnexpectedResponseError
+	return errors.As(err, &respErr) && respErr.Actual == 429
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterThrottlesAndRecovers(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewAdaptiveLimiter(clock, 10, time.Minute)
+
+	tests := []struct {
+		advance  time.Duration
+		throttle bool
+		rate     float64
+	}{
+		{rate: 10},
+		{throttle: true, rate: 5},
+		{advance: 59 * time.Second, rate: 5},
+		{advance: time.Second, rate: 5},
+		// Ramps back linearly over another cool-down
+		{advance: 30 * time.Second, rate: 7.5},
+		{advance: 30 * time.Second, rate: 10},
+	}
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		if tt.throttle {
+			limiter.Throttle()
+		}
+		if got := limiter.Rate(); got != tt.rate {
+			t.Errorf("step %d: rate = %v, want %v", i, got, tt.rate)
+		}
+	}
+}
+
+func TestAdaptiveLimiterPacesSends(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewAdaptiveLimiter(clock, 2, time.Minute)
+	ctx := context.Background()
+	if err := limiter.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second send within the same half second has to wait for it
+	done := make(chan error, 1)
+	go func() { done <- limiter.Wait(ctx) }()
+	for clock.waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(499 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("send went through before its slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A canceled wait gives up instead of blocking
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter.Wait(canceled); err != context.Canceled {
+		t.Errorf("Wait() = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// defaultLocale is used when neither the request nor the config set one
+const defaultLocale = "en-US"
+
+// tldLocales maps country-code TLDs to the locale their recipients most
+// likely expect. It is only consulted when no locale was requested.
+var tldLocales = map[string]string{
+	"au": "en-AU",
+	"at": "de-AT",
+	"br": "pt-BR",
+	"ch": "de-CH",
+	"de": "de-DE",
+	"es": "es-ES",
+	"fr": "fr-FR",
+	"ie": "en-IE",
+	"in": "en-IN",
+	"it": "it-IT",
+	"jp": "ja-JP",
+	"mx": "es-MX",
+	"nl": "nl-NL",
+	"pl": "pl-PL",
+	"pt": "pt-PT",
+	"se": "sv-SE",
+	"uk": "en-GB",
+}
+
+// localeFromEmail infers a locale from the recipient domain's TLD
+func localeFromEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return ""
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	dot := strings.LastIndexByte(domain, '.')
+	if dot < 0 {
+		return ""
+	}
+	return tldLocales[domain[dot+1:]]
+}
+
+// resolveLocale picks the locale for an email: the request value first,
+// then the recipient's TLD, then the configured default
+func (s *EmailService) resolveLocale(data ProductEmail) string {
+	for _, candidate := range []string{data.Locale, localeFromEmail(data.RecipientEmail), s.cfg().DefaultLocale} {
+		if candidate == "" {
+			continue
+		}
+		if tag, err := language.Parse(candidate); err == nil {
+			return tag.String()
+		}
+	}
+	return defaultLocale
+}
+
+// formatPrice formats a USD price with the locale's number conventions
+func formatPrice(price float64, locale string) string {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.MustParse(defaultLocale)
+	}
+	return "$" + message.NewPrinter(tag).Sprint(number.Decimal(price,
+		number.MinFractionDigits(2), number.MaxFractionDigits(2)))
+}
+
+// formatPercent formats a percentage, e.g. 25 for 25%, with the locale's
+// conventions, rounded to a whole number
+func formatPercent(percent float64, locale string) string {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.MustParse(defaultLocale)
+	}
+	return message.NewPrinter(tag).Sprint(number.Percent(percent/100, number.MaxFractionDigits(0)))
+}
+
+// localeKey is the context key carrying the locale negotiated from the
+// Accept-Language header
+type localeKey struct{}
+
+// withLocale sets the negotiated locale for emails sent with ctx
+func withLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// localeFrom returns the negotiated locale carried by ctx, if any
+func localeFrom(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
+
+// matchAcceptLanguage matches an Accept-Language header against the
+// supported locales, returning "" when the header is invalid or nothing
+// matches
+func matchAcceptLanguage(header string, supported []string) string {
+	desired, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(desired) == 0 {
+		return ""
+	}
+
+	var tags []language.Tag
+	var locales []string
+	for _, locale := range supported {
+		if tag, err := language.Parse(locale); err == nil {
+			tags = append(tags, tag)
+			locales = append(locales, tag.String())
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+
+	_, index, confidence := language.NewMatcher(tags).Match(desired...)
+	if confidence == language.No {
+		return ""
+	}
+	return locales[index]
+}
+
+// AcceptLanguage negotiates the email locale from the Accept-Language
+// header. An explicit locale in the request still wins; without a match the
+// usual fallbacks apply.
+func AcceptLanguage(supported func() []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := c.GetHeader("Accept-Language"); header != "" {
+			if locale := matchAcceptLanguage(header, supported()); locale != "" {
+				c.Request = c.Request.WithContext(withLocale(c.Request.Context(), locale))
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestResolveLocale(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		locale string
+		email  string
+		want   string
+	}{
+		{name: "default", email: "alex@example.com", want: "en-US"},
+		{name: "configured default", env: map[string]string{"DEFAULT_LOCALE": "en-GB"}, email: "alex@example.com", want: "en-GB"},
+		{name: "recipient tld", email: "alex@example.de", want: "de-DE"},
+		{name: "tld is case insensitive", email: "alex@Example.FR", want: "fr-FR"},
+		{name: "requested wins", locale: "it-IT", email: "alex@example.de", want: "it-IT"},
+		{name: "invalid request ignored", locale: "not a locale", email: "alex@example.de", want: "de-DE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.env)
+			if got := service.resolveLocale(ProductEmail{Locale: tt.locale, RecipientEmail: tt.email}); got != tt.want {
+				t.Errorf("resolveLocale() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatPrice(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "en-US", want: "$1,234.50"},
+		{locale: "de-DE", want: "$1.234,50"},
+		{locale: "en-IN", want: "$1,234.50"},
+		{locale: "invalid", want: "$1,234.50"},
+	}
+	for _, tt := range tests {
+		if got := formatPrice(1234.5, tt.locale); got != tt.want {
+			t.Errorf("formatPrice(1234.5, %q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+	// German separates the sign with a non-breaking space
+	if got := formatPercent(25, "de-DE"); got != "25\u00a0%" {
+		t.Errorf("formatPercent(25, de-DE) = %q, want %q", got, "25\u00a0%")
+	}
+	if got := formatPercent(12.6, "en-US"); got != "13%" {
+		t.Errorf("formatPercent(12.6, en-US) = %q, want %q", got, "13%")
+	}
+}
+
+func TestSendFormatsPricesForTheRecipientLocale(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	ctx := context.Background()
+	for email, want := range map[string]string{
+		"alex@example.com": "Price: $1,234.50",
+		"kim@example.de":   "Price: $1.234,50",
+	} {
+		if _, err := service.SendProductEmail(ctx, ProductEmail{RecipientEmail: email, ProductName: "Desk", Price: 1234.5}); err != nil {
+			t.Fatal(err)
+		}
+		if text := plain(t, sender.last(t)).Text(); !strings.Contains(text, want) {
+			t.Errorf("%s got:\n%s", email, text)
+		}
+	}
+}
+
+func TestMatchAcceptLanguage(t *testing.T) {
+	supported := []string{"en-US", "de-DE", "fr-FR"}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "de-DE", want: "de-DE"},
+		{header: "fr-CA,fr;q=0.9,en;q=0.5", want: "fr-FR"},
+		{header: "en;q=0.2, de;q=0.8", want: "de-DE"},
+		{header: "ja-JP", want: ""},
+		{header: "not a ;; language", want: ""},
+	}
+	for _, tt := range tests {
+		if got := matchAcceptLanguage(tt.header, supported); got != tt.want {
+			t.Errorf("matchAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSendNegotiatesLocaleFromAcceptLanguage(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"SUPPORTED_LOCALES": "en-US,de-DE"})
+	router := newTestRouter()
+	router.Use(AcceptLanguage(service.supportedLocales))
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	tests := []struct {
+		name   string
+		body   string
+		header string
+		want   string
+	}{
+		{name: "negotiated", body: `{"recipient_email": "alex@example.com", "product_name": "Desk", "price": 1234.5}`, header: "de-CH,de;q=0.9", want: "Price: $1.234,50"},
+		{name: "explicit locale wins", body: `{"recipient_email": "alex@example.com", "product_name": "Desk", "price": 1234.5, "locale": "en-US"}`, header: "de-DE", want: "Price: $1,234.50"},
+		{name: "no match falls back", body: `{"recipient_email": "kim@example.de", "product_name": "Desk", "price": 1234.5}`, header: "ja-JP", want: "Price: $1.234,50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(router, "POST", "/send-product", tt.body, map[string]string{"Accept-Language": tt.header})
+			if w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if text := plain(t, sender.last(t)).Text(); !strings.Contains(text, tt.want) {
+				t.Errorf("want %q, got:\n%s", tt.want, text)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// debugLogPaths lists routes whose access logs are only emitted at debug level
+// so that health probes don't flood the logs
+var debugLogPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// parseLogLevel converts a LOG_LEVEL value into a slog level
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// NewLogger creates a logger writing to w with the given level and format
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// accessLogLevel picks the level an access log entry is written at
+func accessLogLevel(path string, status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	case debugLogPaths[path]:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// RequestLogger logs every request through the given logger, replacing gin's
+// default access log so entries respect the configured level and format
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		status := c.Writer.Status()
+		logger.LogAttrs(c.Request.Context(), accessLogLevel(path, status), "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("request_id", requestID(c)),
+		)
+	}
+}
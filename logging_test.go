@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerLevelGating(t *testing.T) {
+	tests := []struct {
+		level string
+		want  []string
+	}{
+		{level: "debug", want: []string{"debug", "info", "warn", "error"}},
+		{level: "", want: []string{"info", "warn", "error"}},
+		{level: "info", want: []string{"info", "warn", "error"}},
+		{level: "warning", want: []string{"warn", "error"}},
+		{level: "error", want: []string{"error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			var out bytes.Buffer
+			logger, err := NewLogger(&out, tt.level, "text")
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger.Debug("debug")
+			logger.Info("info")
+			logger.Warn("warn")
+			logger.Error("error")
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if _, msg, ok := strings.Cut(line, "msg="); ok {
+					got = append(got, msg)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("logged %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLoggerJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello", "key", "value")
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("entry isn't JSON: %v\n%s", err, out.String())
+	}
+	if entry["msg"] != "hello" || entry["key"] != "value" {
+		t.Errorf("entry = %v", entry)
+	}
+}
+
+func TestNewLoggerRejectsUnknownSettings(t *testing.T) {
+	if _, err := NewLogger(&bytes.Buffer{}, "verbose", "text"); err == nil {
+		t.Error("unknown level accepted")
+	}
+	if _, err := NewLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestAccessLogLevel(t *testing.T) {
+	tests := []struct {
+		path   string
+		status int
+		want   slog.Level
+	}{
+		{"/send-product", 200, slog.LevelInfo},
+		{"/healthz", 200, slog.LevelDebug},
+		{"/readyz", 200, slog.LevelDebug},
+		{"/readyz", 503, slog.LevelError},
+		{"/send-product", 400, slog.LevelWarn},
+		{"/send-product", 502, slog.LevelError},
+	}
+	for _, tt := range tests {
+		if got := accessLogLevel(tt.path, tt.status); got != tt.want {
+			t.Errorf("accessLogLevel(%s, %d) = %v, want %v", tt.path, tt.status, got, tt.want)
+		}
+	}
+}
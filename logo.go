@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadInlineLogo reads the image inlined into every HTML email
+func loadInlineLogo(path string) (*inlineImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mediaType := http.DetectContentType(data)
+	if strings.EqualFold(filepath.Ext(path), ".svg") {
+		// Content sniffing reports SVG as text
+		mediaType = "image/svg+xml"
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%s is not an image (detected %s)", path, mediaType)
+	}
+	return &inlineImage{filename: "logo." + imageExtension(mediaType), data: data}, nil
+}
+
+// SetInlineLogo sets the logo inlined into every HTML email; nil disables it
+func (s *EmailService) SetInlineLogo(logo *inlineImage) {
+	s.logo = logo
+}
+
+// logoSrc returns the cid: reference of the inline logo for data, or ""
+// when there is no logo or the request opted out
+func (s *EmailService) logoSrc(data ProductEmail) htmltemplate.URL {
+	if s.logo == nil || data.SkipLogo {
+		return ""
+	}
+	return htmltemplate.URL("cid:" + s.logo.filename)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// pngSignature is enough of a PNG for content sniffing
+const pngSignature = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+// writeTestFile writes data to a file in a temporary directory
+func writeTestFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// inlineNames returns the file names of the inline attachments of message
+func inlineNames(message *mailgun.Message) []string {
+	var names []string
+	for _, inline := range message.ReaderInlines() {
+		names = append(names, inline.Filename)
+	}
+	return names
+}
+
+func TestInlineLogo(t *testing.T) {
+	logo, err := loadInlineLogo(writeTestFile(t, "brand.png", pngSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logo.filename != "logo.png" {
+		t.Fatalf("filename = %q, want logo.png", logo.filename)
+	}
+
+	tests := []struct {
+		name     string
+		data     ProductEmail
+		template string
+		attached bool
+	}{
+		{name: "configured", attached: true},
+		{name: "skipped by request", data: ProductEmail{SkipLogo: true}},
+		{name: "template without the logo", template: `<p>{{.ProductName}}</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			service.SetInlineLogo(logo)
+			if tt.template != "" {
+				service.SetTemplateStore(NewFileTemplateStore(t.TempDir()))
+				if _, err := service.SaveTemplate(context.Background(), "product_html", tt.template); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tt.data.RecipientEmail = "alex@example.com"
+			tt.data.ProductName = "Desk Lamp"
+			if _, err := service.SendProductEmail(context.Background(), tt.data); err != nil {
+				t.Fatal(err)
+			}
+
+			message := sender.last(t)
+			inlines := inlineNames(message)
+			referenced := strings.Contains(plain(t, message).HTML(), `src="cid:logo.png"`)
+			if tt.attached {
+				if len(inlines) != 1 || inlines[0] != "logo.png" || !referenced {
+					t.Errorf("inlines = %v, referenced %v; want logo.png attached and referenced", inlines, referenced)
+				}
+			} else if len(inlines) != 0 || referenced {
+				t.Errorf("inlines = %v, referenced %v; want no logo", inlines, referenced)
+			}
+		})
+	}
+}
+
+func TestLoadInlineLogoErrors(t *testing.T) {
+	if _, err := loadInlineLogo(filepath.Join(t.TempDir(), "missing.png")); err == nil {
+		t.Error("missing file accepted")
+	}
+	if _, err := loadInlineLogo(writeTestFile(t, "logo.png", "just some text")); err == nil || !strings.Contains(err.Error(), "not an image") {
+		t.Errorf("err = %v, want a not-an-image error", err)
+	}
+}
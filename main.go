@@ -2,9 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	htmltemplate "html/template"
 	"log"
+	"log/slog"
+	"math"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,64 +25,677 @@ import (
 	"github.com/mailgun/mailgun-go/v4"
 )
 
-// Config holds the application configuration
-type Config struct {
-	Domain    string
-	ApiKey    string
-	FromName  string
-	FromEmail string
+// Sender delivers a fully built message. *mailgun.MailgunImpl satisfies it;
+// alternative implementations can be injected with NewEmailServiceWithSender.
+type Sender interface {
+	Send(ctx context.Context, message *mailgun.Message) (string, string, error)
 }
 
 // EmailService handles all email related operations
 type EmailService struct {
-	mg     *mailgun.MailgunImpl
-	config Config
+	mg            *mailgun.MailgunImpl
+	sender        Sender
+	quotas        QuotaStore
+	campaigns     CampaignStore
+	unsubscribes  UnsubscribeStore
+	clock         Clock
+	preferences   Preferences
+	isRetryable   RetryClassifier
+	audit         *AuditLogger
+	limiter       *AdaptiveLimiter
+	dedup         *sendDeduper
+	thumbnails    ThumbnailRenderer
+	callbacks     *CallbackNotifier
+	digests       *digestBuffer
+	htmlFetcher   *HTMLFetcher
+	bulkValidator *BulkValidator
+	templateStore TemplateStore
+	logo          *inlineImage
+	reviews       ReviewStore
+	reviewClient  *http.Client
+
+	// clients are the Mailgun clients of the additional sending domains
+	clientsMu sync.Mutex
+	clients   map[string]*mailgun.MailgunImpl
+
+	// mu guards the settings and templates that can be hot-reloaded
+	mu              sync.RWMutex
+	config          Config
+	subjectTemplate *texttemplate.Template
+	textTemplate    *texttemplate.Template
+	htmlTemplate    *htmltemplate.Template
+	// templateSets are the named body templates of the template store
+	templateSets map[string]bodyTemplateSet
+
+	// sendingEnabled is the global kill-switch; when false no email is sent
+	sendingEnabled atomic.Bool
+	metrics        sendMetrics
 }
 
-// ProductEmail represents the product email request
+// ErrSendingDisabled is returned when the global kill-switch is off
+var ErrSendingDisabled = errors.New("sending is currently disabled")
+
+// ProductEmail represents the product email request. The JSON tags are the
+// canonical snake_case field names; camelCase spellings such as productName
+// and recipientEmail are accepted too (see UnmarshalJSON).
 type ProductEmail struct {
 	ProductName    string  `json:"product_name"`
 	Price          float64 `json:"price"`
 	Description    string  `json:"description"`
 	RecipientEmail string  `json:"email"`
+	// OriginalPrice is shown struck through when it is above Price
+	OriginalPrice float64 `json:"original_price"`
+	// Discount is the percentage off; it is derived from OriginalPrice
+	// when unset
+	Discount float64 `json:"discount"`
+	// DescriptionMarkdown replaces Description when set. It is rendered to
+	// sanitized HTML for the HTML body and to plain text for the text body.
+	DescriptionMarkdown string `json:"description_markdown"`
+	// DescriptionHTML is the rendered DescriptionMarkdown
+	DescriptionHTML htmltemplate.HTML `json:"-"`
+	// SkipLogo leaves out the DEFAULT_INLINE_LOGO_PATH logo
+	SkipLogo bool `json:"skip_logo"`
+	// LogoSrc is the cid: reference of the inline logo, if any
+	LogoSrc htmltemplate.URL `json:"-"`
+	// RecipientName personalizes the greeting when set
+	RecipientName string `json:"recipient_name"`
+	// Variables are substituted into {{key}} placeholders in the description
+	Variables map[string]string `json:"variables"`
+	// OptimizeDeliveryTime enables Mailgun send time optimization so the
+	// email is delivered when the recipient is most likely to engage. Only
+	// tracked marketing emails may use it.
+	OptimizeDeliveryTime bool `json:"optimize_delivery_time"`
+	// Locale controls number formatting, e.g. "de-DE". When empty it is
+	// inferred from the recipient's domain or the configured default.
+	Locale string `json:"locale"`
+	// Subject overrides the configured subject template
+	Subject string `json:"subject"`
+	// Template selects a template set of the template store, e.g.
+	// "wholesale", for the bodies; the default templates when empty
+	Template string `json:"template"`
+	// FromKey selects one of the configured FROM_IDENTITIES
+	FromKey string `json:"from_key"`
+	// Category is checked against recipient preferences; defaults to "product"
+	Category string `json:"category"`
+	// Tag is sent as the Mailgun tag and counted against its
+	// TAG_DAILY_LIMITS cap, if any
+	Tag string `json:"tag"`
+	// Charset encodes the body parts in e.g. "ISO-8859-1" instead of UTF-8
+	Charset string `json:"charset"`
+	// ReplyTo overrides the Reply-To address configured for the category
+	ReplyTo string `json:"reply_to"`
+	// CampaignID opts into suppressing repeated sends of the same subject
+	// to a recipient within the campaign
+	CampaignID string `json:"campaign_id"`
+	// Event attaches an iCalendar invite when set
+	Event *CalendarEvent `json:"event"`
+	// DKIMSelector signs the email with a specific DKIM key of the domain
+	DKIMSelector string `json:"dkim_selector"`
+	// CustomVariables are attached as Mailgun v: variables for event correlation
+	CustomVariables map[string]string `json:"custom_variables"`
+	// DomainKey selects one of the configured MAILGUN_DOMAINS
+	DomainKey string `json:"domain_key"`
+	// CallbackURL receives the send result asynchronously when set
+	CallbackURL string `json:"callback_url"`
+	// Profile selects the SENDING_PROFILES defaults, "transactional" unless
+	// set; Tracking, UnsubscribeFooter and Priority override them
+	Profile           string `json:"profile"`
+	Tracking          *bool  `json:"tracking"`
+	UnsubscribeFooter *bool  `json:"unsubscribe_footer"`
+	Priority          string `json:"priority"`
+	// HTMLURL replaces the rendered bodies with the HTML document at this
+	// URL and text extracted from it; the templates are used if it fails
+	HTMLURL string `json:"html_url"`
+	// BodyFormat selects the bodies sent: "text", "html" or "both" (default)
+	BodyFormat string `json:"body_format"`
+	// RequireApproval holds the email until the REVIEW_SERVICE_URL review
+	// service approves it through /approve/:id
+	RequireApproval bool `json:"require_approval"`
+	// Digest batches the email with other products queued for the same
+	// recipient within DIGEST_WINDOW
+	Digest bool `json:"digest"`
+	// DigestItems are the products of a digest email
+	DigestItems []ProductEmail `json:"-"`
+}
+
+// DiscountPercent returns the discount shown in the templates: Discount
+// when set, else how far Price is below OriginalPrice. Values outside
+// (0, 100] are treated as no discount.
+func (data ProductEmail) DiscountPercent() float64 {
+	discount := data.Discount
+	if discount == 0 && data.OriginalPrice > data.Price {
+		discount = (data.OriginalPrice - data.Price) / data.OriginalPrice * 100
+	}
+	if discount <= 0 || discount > 100 {
+		return 0
+	}
+	return discount
 }
 
+// stoPeriod is the window Mailgun may delay an optimized send by
+const stoPeriod = "24h"
+
 // NewEmailService creates a new email service instance
 func NewEmailService(config Config) *EmailService {
-	return &EmailService{
-		mg:     mailgun.NewMailgun(config.Domain, config.ApiKey),
-		config: config,
+	return NewEmailServiceWithSender(config, nil)
+}
+
+// NewEmailServiceWithSender creates an email service that delivers through
+// the given sender. A nil sender delivers through Mailgun.
+func NewEmailServiceWithSender(config Config, sender Sender) *EmailService {
+	mg := newMailgunClient(config, config.Domain)
+	if sender == nil {
+		sender = mg
+	}
+
+	s := &EmailService{
+		mg:           mg,
+		sender:       sender,
+		clients:      make(map[string]*mailgun.MailgunImpl),
+		config:       config,
+		quotas:       NewMemoryQuotaStore(),
+		unsubscribes: NewMemoryUnsubscribeStore(),
+		reviews:      NewMemoryReviewStore(),
+		reviewClient: &http.Client{Timeout: 10 * time.Second},
+		clock:        realClock{},
+		preferences:  allowAllPreferences{},
+		isRetryable:  DefaultIsRetryable,
+		htmlFetcher:  NewHTMLFetcher(config.HTMLURLTimeout, config.HTMLURLMaxBytes),
+
+		textTemplate: productTextTemplate,
+		htmlTemplate: productHTMLTemplate,
+	}
+	s.campaigns = NewMemoryCampaignStore(s.clock, config.CampaignTTL)
+	// The template was validated by LoadConfig; fall back to the static subject otherwise
+	s.subjectTemplate, _ = parseSubjectTemplate(config.SubjectTemplate)
+	var validator mailgun.EmailValidator
+	if config.BulkValidationMailgun {
+		validator = newMailgunValidator(config)
+	}
+	s.bulkValidator = NewBulkValidator(s.clock, validator, config.BulkValidationConcurrency, config.BulkValidationRate, config.RateLimitCooldown)
+	if config.DedupWindow > 0 {
+		s.dedup = newSendDeduper(s.clock, config.DedupWindow, config.DedupFields)
 	}
+	if config.DigestWindow > 0 {
+		s.digests = newDigestBuffer(s.clock, config.DigestWindow, config.DigestMaxItems, config.DigestStatePath, s.sendDigest)
+	}
+	if config.SendRateLimit > 0 {
+		s.limiter = NewAdaptiveLimiter(s.clock, config.SendRateLimit, config.RateLimitCooldown)
+	}
+	s.sendingEnabled.Store(config.SendingEnabled)
+	s.metrics.startedAt = s.clock.Now()
+	return s
+}
+
+// SendingEnabled reports whether the global kill-switch allows sending
+func (s *EmailService) SendingEnabled() bool {
+	return s.sendingEnabled.Load()
+}
+
+// SetSendingEnabled flips the global kill-switch
+func (s *EmailService) SetSendingEnabled(enabled bool) {
+	s.sendingEnabled.Store(enabled)
+}
+
+// SendResult describes the outcome of a send that didn't fail
+type SendResult struct {
+	Response string
+	ID       string
+	// Domain is the Mailgun domain the email was sent through
+	Domain string
+	// Warnings lists non-fatal problems, e.g. a text-only fallback
+	Warnings []string
+	// Skipped is set when the email was intentionally not sent
+	Skipped string
+	// Deduplicated is set when an identical recent send was reused
+	Deduplicated bool
+	// OriginalRecipients lists the intended recipients when REDIRECT_ALL_TO
+	// rewrote them
+	OriginalRecipients []string
+	// DuplicateRecipients lists the archive copies dropped because the
+	// address already receives the email
+	DuplicateRecipients []string
+	// Timing breaks down where the send spent its time
+	Timing SendTiming
+	// TestMode is set when the message was submitted in Mailgun test mode
+	TestMode bool
+	// StorageURL retrieves the stored message; see STORAGE_URL_LOOKUP
+	StorageURL string
 }
 
+// warnHTMLFallback is reported when the HTML body could not be rendered
+const warnHTMLFallback = "html_render_failed"
+
 // SendProductEmail sends product details via email
-func (s *EmailService) SendProductEmail(ctx context.Context, data ProductEmail) (string, string, error) {
-	emailBody := s.formatProductEmail(data)
-	sender := fmt.Sprintf("%s <%s@%s>", s.config.FromName, s.config.FromEmail, s.config.Domain)
+func (s *EmailService) SendProductEmail(ctx context.Context, data ProductEmail) (SendResult, error) {
+	send := func() (SendResult, error) {
+		result, err := s.sendProductEmail(ctx, data)
+		s.auditSend(ctx, data, result, err)
+		s.notifyCallback(data, result, err)
+		return result, err
+	}
+	if s.dedup == nil {
+		return send()
+	}
+	return s.dedup.do(ctx, s.dedup.key(ctx, s, data), send)
+}
 
+// sendProductEmail builds and sends the email
+func (s *EmailService) sendProductEmail(ctx context.Context, data ProductEmail) (SendResult, error) {
+	s.metrics.inFlight.Add(1)
+	defer s.metrics.inFlight.Add(-1)
+
+	var result SendResult
+	cfg := s.cfg()
+	validationStart := s.clock.Now()
+	// An explicit locale wins over the negotiated Accept-Language one
+	if data.Locale == "" {
+		data.Locale = localeFrom(ctx)
+	}
+	if data.CallbackURL != "" {
+		if err := validateCallbackURL(data.CallbackURL); err != nil {
+			return result, err
+		}
+	}
+	if data.HTMLURL != "" {
+		if err := validateHTMLURL(data.HTMLURL); err != nil {
+			return result, err
+		}
+	}
+	if data.Tag != "" {
+		if err := validateTag(data.Tag); err != nil {
+			return result, err
+		}
+	}
+	charset, err := resolveCharset(data.Charset)
+	if err != nil {
+		return result, err
+	}
+	profile, err := resolveProfile(data, cfg.SendingProfiles)
+	if err != nil {
+		return result, err
+	}
+	format, err := data.bodyFormat()
+	if err != nil {
+		return result, err
+	}
+	// Previews and MIME builds send nothing, so the kill-switch doesn't apply
+	if _, capturing := capturedEmailFrom(ctx); !capturing && !s.SendingEnabled() {
+		return result, ErrSendingDisabled
+	}
+
+	allowed, err := s.preferences.Allows(ctx, data.RecipientEmail, data.category())
+	if err != nil {
+		return result, fmt.Errorf("check recipient preferences: %w", err)
+	}
+	if !allowed {
+		result.Skipped = skipOptedOut
+		return result, nil
+	}
+	if profile.HonorUnsubscribes {
+		unsubscribed, err := s.unsubscribes.Unsubscribed(ctx, data.RecipientEmail)
+		if err != nil {
+			return result, fmt.Errorf("check unsubscribes: %w", err)
+		}
+		if unsubscribed {
+			result.Skipped = skipUnsubscribed
+			return result, nil
+		}
+	}
+
+	renderStart := s.clock.Now()
+	result.Timing.Validation = renderStart.Sub(validationStart)
+	data.LogoSrc = s.logoSrc(data)
+	// An approved email goes out exactly as it was reviewed
+	rendered, ok := renderedEmailFrom(ctx)
+	if !ok {
+		if rendered, err = s.renderEmail(ctx, cfg, data, format, profile, &result); err != nil {
+			return result, err
+		}
+	}
+	subject, emailBody, htmlBody := rendered.Subject, rendered.Text, rendered.HTML
+	if charset != nil {
+		if err := charset.checkRepresentable(emailBody, htmlBody); err != nil {
+			return result, err
+		}
+	}
+	domain, err := s.sendingDomain(data)
+	if err != nil {
+		return result, err
+	}
+	result.Domain = domain
+	testMode := testModeFrom(ctx, cfg.TestMode)
+	result.TestMode = testMode
+	sender, err := s.resolveSender(data, domain, testMode)
+	if err != nil {
+		return result, err
+	}
+
+	// Outside production every email can be diverted to a single test inbox
+	to := data.RecipientEmail
+	if cfg.RedirectAllTo != "" {
+		to = cfg.RedirectAllTo
+	}
+	// Non-ASCII subjects such as emoji would otherwise turn into mojibake
+	encodedSubject := encodeSubject(subject)
 	message := mailgun.NewMessage(
 		sender,
-		"Product Information",
+		encodedSubject,
 		emailBody,
-		data.RecipientEmail,
+		to,
 	)
+	size := newMessageSize(encodedSubject)
+	size.addBody(emailBody)
 
-	return s.mg.Send(ctx, message)
-}
-
-// formatProductEmail formats the email body
-func (s *EmailService) formatProductEmail(data ProductEmail) string {
-	return fmt.Sprintf(`
-Product Details:
----------------
-Name: %s
-Price: $%.2f
-Description: %s
-`,
-		data.ProductName,
-		data.Price,
-		data.Description,
-	)
+	if htmlBody != "" {
+		htmlBody, images, err := extractDataURIImages(htmlBody, cfg.InlineImageMaxBytes, cfg.InlineImagesMaxTotalBytes)
+		if err != nil {
+			return result, err
+		}
+		// Custom html_url or template HTML may leave the logo out
+		if data.LogoSrc != "" && strings.Contains(htmlBody, string(data.LogoSrc)) {
+			images = append(images, *s.logo)
+		}
+		message.SetHtml(htmlBody)
+		addInlineImages(message, images)
+		size.addBody(htmlBody)
+		for _, image := range images {
+			size.addAttachment(image.data)
+		}
+	}
+
+	if data.Event != nil {
+		// Mailgun derives the text/calendar content type from the .ics extension
+		organizer := fmt.Sprintf("%s@%s", cfg.FromEmail, domain)
+		uid := randomID() + "@" + domain
+		ics, err := buildICS(*data.Event, uid, organizer, data.RecipientEmail, s.clock.Now())
+		if err != nil {
+			return result, err
+		}
+		message.AddBufferAttachment("invite.ics", ics)
+		size.addAttachment(ics)
+	}
+
+	result.Timing.Rendering = s.clock.Now().Sub(renderStart)
+
+	// Fail early rather than have Mailgun reject an oversized message
+	if err := size.check(cfg.MaxMessageBytes); err != nil {
+		return result, err
+	}
+
+	if data.DKIMSelector != "" {
+		if err := s.validateDKIMSelector(ctx, data.DKIMSelector, domain); err != nil {
+			return result, err
+		}
+		applyDKIMSelector(message, data.DKIMSelector, domain)
+	}
+
+	if err := addCustomVariables(message, data.CustomVariables); err != nil {
+		return result, err
+	}
+	if id := digestIDFrom(ctx); id != "" {
+		if err := message.AddVariable(digestIDVariable, id); err != nil {
+			return result, err
+		}
+	}
+
+	replyTo, err := cfg.replyTo(data)
+	if err != nil {
+		return result, err
+	}
+	if replyTo != "" {
+		message.SetReplyTo(replyTo)
+	}
+
+	applyProfile(message, profile)
+	if profile.UnsubscribeFooter {
+		addListUnsubscribe(message, cfg.unsubscribeURL(data.RecipientEmail))
+	}
+	if data.Tag != "" {
+		if err := message.AddTag(data.Tag); err != nil {
+			return result, err
+		}
+	}
+	if testMode {
+		message.EnableTestMode()
+	}
+
+	// Keep a copy of every email in the archive mailboxes for its category.
+	// A redirected email only reaches the test inbox; the intended
+	// recipients are kept in a header and the response instead.
+	archives := cfg.archiveBCC(data.category())
+	if cfg.RedirectAllTo != "" {
+		result.OriginalRecipients = append([]string{data.RecipientEmail}, archives...)
+		message.AddHeader("X-Original-To", strings.Join(result.OriginalRecipients, ", "))
+	} else {
+		for _, archive := range archives {
+			if !addBCC(message, archive) {
+				result.DuplicateRecipients = append(result.DuplicateRecipients, archive)
+			}
+		}
+	}
+	if err := checkRecipientCount(message, cfg.MaxRecipients); err != nil {
+		return result, err
+	}
+
+	if data.OptimizeDeliveryTime {
+		// Sets the o:deliverytime-optimize-period option
+		if err := message.SetSTOPeriod(stoPeriod); err != nil {
+			return result, err
+		}
+	}
+
+	// A debug build stops here, before anything is reserved or sent
+	if captured, ok := capturedEmailFrom(ctx); ok {
+		captured.message = message
+		captured.rendered = rendered
+		return result, nil
+	}
+	if charset != nil {
+		if message, err = charset.toMIMEMessage(message, s.clock.Now()); err != nil {
+			return result, err
+		}
+	}
+
+	// Send each subject at most once per recipient of a campaign
+	if data.CampaignID != "" {
+		reserved, reserveErr := s.campaigns.Reserve(data.CampaignID, data.RecipientEmail, subject)
+		if reserveErr != nil {
+			return result, fmt.Errorf("check campaign sends: %w", reserveErr)
+		}
+		if !reserved {
+			result.Skipped = skipAlreadySentInCampaign
+			return result, nil
+		}
+		// Let a retry go through if Mailgun doesn't accept the message
+		defer func() {
+			if err != nil {
+				s.campaigns.Release(data.CampaignID, data.RecipientEmail, subject)
+			}
+		}()
+	}
+
+	// Enforce the per-recipient daily cap
+	if limit := cfg.MaxEmailsPerRecipientPerDay; limit > 0 {
+		key := normalizeRecipient(data.RecipientEmail, cfg.RecipientNormalization)
+		now := s.clock.Now()
+		allowed, retryAt, reserveErr := s.quotas.Reserve(key, limit, quotaWindow, now)
+		if reserveErr != nil {
+			// Assigned so the campaign slot reserved above is released
+			err = fmt.Errorf("check recipient quota: %w", reserveErr)
+			return result, err
+		}
+		if !allowed {
+			err = &QuotaExceededError{Recipient: data.RecipientEmail, RetryAt: retryAt}
+			return result, err
+		}
+		// Give the slot back if Mailgun doesn't accept the message
+		defer func() {
+			if err != nil {
+				s.quotas.Release(key, now)
+			}
+		}()
+	}
+
+	// Keep one tag from using up the global daily cap
+	if limit, ok := cfg.TagDailyLimits[data.Tag]; ok && data.Tag != "" {
+		key := tagQuotaKey(data.Tag)
+		now := s.clock.Now()
+		allowed, retryAt, reserveErr := s.quotas.Reserve(key, limit, quotaWindow, now)
+		if reserveErr != nil {
+			err = fmt.Errorf("check tag quota: %w", reserveErr)
+			return result, err
+		}
+		if !allowed {
+			// Assigned so the recipient slot reserved above is released
+			err = &QuotaExceededError{Tag: data.Tag, RetryAt: retryAt}
+			return result, err
+		}
+		defer func() {
+			if err != nil {
+				s.quotas.Release(key, now)
+			}
+		}()
+	}
+
+	// Enforce the global daily cap
+	if limit := cfg.MaxEmailsPerDay; limit > 0 {
+		now := s.clock.Now()
+		allowed, retryAt, reserveErr := s.quotas.Reserve(globalQuotaKey, limit, quotaWindow, now)
+		if reserveErr != nil {
+			err = fmt.Errorf("check daily quota: %w", reserveErr)
+			return result, err
+		}
+		if !allowed {
+			// Assigned so the recipient slot reserved above is released
+			err = &QuotaExceededError{RetryAt: retryAt}
+			return result, err
+		}
+		defer func() {
+			if err != nil {
+				s.quotas.Release(globalQuotaKey, now)
+			}
+		}()
+	}
+
+	start := s.clock.Now()
+	result.Response, result.ID, err = s.sendWithRetry(ctx, s.senderFor(domain), message, maxRetriesFrom(ctx, cfg.MaxRetries))
+	result.Timing.Send = s.clock.Now().Sub(start)
+	s.metrics.record(result.Timing.Send, err)
+	if err == nil && s.wantsStorageURL(ctx, cfg, result) {
+		s.addStorageURL(ctx, cfg, &result)
+	}
+	return result, err
+}
+
+// renderEmail renders the subject and bodies of data as they are sent,
+// including the unsubscribe footer
+func (s *EmailService) renderEmail(ctx context.Context, cfg Config, data ProductEmail, format string, profile SendingProfile, result *SendResult) (renderedEmail, error) {
+	emailBody, err := s.formatProductEmail(data)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	var htmlBody string
+	if data.HTMLURL != "" {
+		fetched, err := s.fetchHTMLBody(ctx, data.HTMLURL)
+		if err != nil {
+			slog.Warn("html_url fetch failed, using the template", "url", data.HTMLURL, "error", err)
+			result.Warnings = append(result.Warnings, warnHTMLURLFallback)
+		} else {
+			// The text template describes the product, not the fetched document
+			htmlBody, emailBody = fetched, ""
+		}
+	}
+
+	// A broken HTML template must not block the email; fall back to text-only
+	if htmlBody == "" && format != bodyFormatText {
+		if htmlBody, err = s.formatProductHTML(data); err != nil {
+			slog.Error("html body rendering failed, sending text-only", "error", err)
+			result.Warnings = append(result.Warnings, warnHTMLFallback)
+			htmlBody = ""
+		}
+	}
+	if strings.TrimSpace(emailBody) == "" && htmlBody != "" && (cfg.AutoPlainText || format == bodyFormatText) {
+		emailBody = htmlToText(htmlBody)
+	}
+	switch format {
+	case bodyFormatText:
+		htmlBody = ""
+	case bodyFormatHTML:
+		if htmlBody == "" {
+			return renderedEmail{}, errNoHTMLBody
+		}
+		emailBody = ""
+	}
+	// Checked before our own footer is added
+	spamReasons, err := checkSpam(cfg.SpamCheck, cfg.SpamRules, emailBody, htmlBody)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	if len(spamReasons) > 0 {
+		slog.Warn("email content looks like spam", "reasons", spamReasons, "recipient", maskEmail(data.RecipientEmail))
+		result.Warnings = append(result.Warnings, warnSpamSuspected)
+	}
+	if profile.UnsubscribeFooter {
+		emailBody, htmlBody = unsubscribeFooter(emailBody, htmlBody, cfg.unsubscribeURL(data.RecipientEmail))
+	}
+
+	subject, err := s.resolveSubject(data)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	return renderedEmail{Subject: subject, Text: emailBody, HTML: htmlBody}, nil
+}
+
+// formatProductEmail formats the plain-text email body
+func (s *EmailService) formatProductEmail(data ProductEmail) (string, error) {
+	var body strings.Builder
+	textTemplate, _, err := s.bodyTemplatesFor(data.Template)
+	if err != nil {
+		return "", err
+	}
+	if err := textTemplate.Execute(&body, s.personalize(data)); err != nil {
+		return "", fmt.Errorf("render text body: %w", err)
+	}
+	return wrapText(body.String(), s.cfg().TextWrapWidth), nil
+}
+
+// formatProductHTML formats the HTML email body
+func (s *EmailService) formatProductHTML(data ProductEmail) (string, error) {
+	var body strings.Builder
+	_, htmlTemplate, err := s.bodyTemplatesFor(data.Template)
+	if err != nil {
+		return "", err
+	}
+	if err := htmlTemplate.Execute(&body, s.personalize(data)); err != nil {
+		return "", fmt.Errorf("render html body: %w", err)
+	}
+	if s.cfg().InlineCSS {
+		return inlineCSS(body.String())
+	}
+	return body.String(), nil
+}
+
+// personalize applies the per-recipient variables and locale to the email data
+func (s *EmailService) personalize(data ProductEmail) ProductEmail {
+	if data.DescriptionMarkdown != "" {
+		data.DescriptionHTML, data.Description = renderMarkdown(substituteVariables(data.DescriptionMarkdown, data.Variables))
+	} else {
+		data.Description = substituteVariables(data.Description, data.Variables)
+	}
+	data.Locale = s.resolveLocale(data)
+
+	if len(data.DigestItems) > 0 {
+		items := make([]ProductEmail, len(data.DigestItems))
+		for i, item := range data.DigestItems {
+			if item.Locale == "" {
+				item.Locale = data.Locale
+			}
+			items[i] = s.personalize(item)
+		}
+		data.DigestItems = items
+	}
+	return data
 }
 
 // Handler represents the HTTP handler dependencies
@@ -87,11 +713,7 @@ func NewHandler(emailService *EmailService) *Handler {
 // SendProductHandler handles the product email endpoint
 func (h *Handler) SendProductHandler(c *gin.Context) {
 	var productData ProductEmail
-	if err := c.BindJSON(&productData); err != nil {
-		c.JSON(400, gin.H{
-			"error":   "Invalid request body",
-			"details": err.Error(),
-		})
+	if !h.bindJSON(c, &productData) {
 		return
 	}
 
@@ -103,23 +725,157 @@ func (h *Handler) SendProductHandler(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second*10)
-	defer cancel()
+	// The deadline comes from the RouteTimeouts middleware
+	ctx := withActor(c.Request.Context(), requestActor(c, h.emailService.cfg().APIKeys))
+	if header := c.GetHeader("X-Max-Retries"); header != "" {
+		retries, err := parseMaxRetries(header)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error":   "Invalid X-Max-Retries header",
+				"details": err.Error(),
+			})
+			return
+		}
+		ctx = withMaxRetries(ctx, retries)
+	}
+	ctx, ok := h.withSendMode(c, ctx, productData.RecipientEmail)
+	if !ok {
+		return
+	}
+
+	// Emails held for approval are sent on their own once approved
+	if productData.RequireApproval {
+		h.submitForReview(c, ctx, productData)
+		return
+	}
+
+	if productData.Digest {
+		queued, ok, err := h.emailService.QueueDigest(ctx, productData)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error":   "Invalid request",
+				"details": err.Error(),
+			})
+			return
+		}
+		if ok {
+			c.JSON(202, gin.H{
+				"message": "Email queued for digest",
+				"queued":  queued,
+			})
+			return
+		}
+	}
 
-	resp, id, err := h.emailService.SendProductEmail(ctx, productData)
+	result, err := h.emailService.SendProductEmail(withStorageURLLookup(ctx), productData)
+	h.writeSendResult(c, result, err)
+}
+
+// withSendMode applies the request's X-Send-Mode header to ctx. It writes
+// the error response and reports false when the header is invalid or the
+// request lacks a valid API key.
+func (h *Handler) withSendMode(c *gin.Context, ctx context.Context, recipient string) (context.Context, bool) {
+	header := c.GetHeader("X-Send-Mode")
+	if header == "" {
+		return ctx, true
+	}
+	cfg := h.emailService.cfg()
+	if !validAPIKey(cfg.APIKeys, requestCredential(c)) {
+		c.JSON(403, gin.H{
+			"error": "X-Send-Mode requires a valid API key",
+		})
+		return ctx, false
+	}
+	testMode, err := parseSendMode(header)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error":   "Failed to send email",
+		c.JSON(400, gin.H{
+			"error":   "Invalid X-Send-Mode header",
 			"details": err.Error(),
 		})
+		return ctx, false
+	}
+	if testMode != cfg.TestMode {
+		slog.Warn("send mode overridden for request", "mode", header, "configured", sendModeName(cfg.TestMode), "actor", requestActor(c, cfg.APIKeys), "recipient", maskEmail(recipient))
+	}
+	return withTestMode(ctx, testMode), true
+}
+
+// writeSendResult writes the response for the outcome of a product email send
+func (h *Handler) writeSendResult(c *gin.Context, result SendResult, err error) {
+	if writeEmailError(c, err) {
+		return
+	}
+	var quotaErr *QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(quotaErr.RetryAt.Sub(h.emailService.clock.Now()).Seconds()))))
+		message := "Recipient email limit reached"
+		switch {
+		case quotaErr.Tag != "":
+			message = "Tag email limit reached"
+		case quotaErr.Recipient == "":
+			message = "Daily email limit reached"
+		}
+		c.JSON(429, gin.H{
+			"error":    message,
+			"details":  quotaErr.Error(),
+			"retry_at": quotaErr.RetryAt.UTC().Format(time.RFC3339),
+		})
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(504, gin.H{
+			"error":   "Timed out sending email",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		response := gin.H{
+			"error":   "Failed to send email",
+			"details": err.Error(),
+		}
+		h.addRawResponse(c, response, result, err)
+		addTiming(c, response, result)
+		c.JSON(500, response)
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"message":  "Email sent successfully",
-		"id":       id,
-		"response": resp,
-	})
+	if result.Skipped != "" {
+		response := gin.H{
+			"message": "Email skipped",
+			"skipped": result.Skipped,
+		}
+		addTiming(c, response, result)
+		c.JSON(200, response)
+		return
+	}
+
+	response := gin.H{
+		"message":   "Email sent successfully",
+		"id":        result.ID,
+		"response":  result.Response,
+		"domain":    result.Domain,
+		"send_mode": sendModeName(result.TestMode),
+	}
+	if len(result.Warnings) > 0 {
+		response["warnings"] = result.Warnings
+	}
+	if result.StorageURL != "" {
+		response["storage_url"] = result.StorageURL
+	}
+	if result.Deduplicated {
+		response["deduplicated"] = true
+	}
+	if len(result.OriginalRecipients) > 0 {
+		response["redirected_to"] = h.emailService.cfg().RedirectAllTo
+		response["original_recipients"] = result.OriginalRecipients
+	}
+	if len(result.DuplicateRecipients) > 0 {
+		response["duplicate_recipients"] = result.DuplicateRecipients
+	}
+	h.addRawResponse(c, response, result, nil)
+	addTiming(c, response, result)
+	c.JSON(200, response)
 }
 
 func main() {
@@ -128,31 +884,102 @@ func main() {
 		log.Println("Error loading .env file")
 	}
 
-	// Get environment variables directly
-	config := Config{
-		Domain:    os.Getenv("MAILGUN_DOMAIN"),
-		ApiKey:    os.Getenv("MAILGUN_API_KEY"),
-		FromName:  os.Getenv("MAILGUN_FROM_NAME"),
-		FromEmail: os.Getenv("MAILGUN_FROM_EMAIL"),
+	config, err := LoadConfig()
+
+	// Configure logging before anything else writes to the log
+	logger, logErr := NewLogger(os.Stderr, config.LogLevel, config.LogFormat)
+	if logErr != nil {
+		log.Fatal(logErr)
 	}
+	slog.SetDefault(logger)
 
-	// Validate required environment variables
-	if config.Domain == "" || config.ApiKey == "" {
-		log.Fatal("Required environment variables MAILGUN_DOMAIN and MAILGUN_API_KEY must be set")
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Initialize services and handlers
 	emailService := NewEmailService(config)
 	handler := NewHandler(emailService)
 
+	if config.ThumbnailRenderURL != "" {
+		emailService.SetThumbnailRenderer(NewHTTPThumbnailRenderer(config.ThumbnailRenderURL))
+	}
+
+	if config.TemplateStoreDir != "" {
+		emailService.SetTemplateStore(NewFileTemplateStore(config.TemplateStoreDir))
+		if err := emailService.LoadTemplates(context.Background()); err != nil {
+			log.Fatalf("load templates from TEMPLATE_STORE_DIR: %v", err)
+		}
+	}
+
+	if config.DefaultInlineLogoPath != "" {
+		logo, err := loadInlineLogo(config.DefaultInlineLogoPath)
+		if err != nil {
+			log.Fatalf("load DEFAULT_INLINE_LOGO_PATH: %v", err)
+		}
+		emailService.SetInlineLogo(logo)
+	}
+
+	if config.AuditLogPath != "" {
+		audit, err := NewAuditLogger(config.AuditLogPath, config.AuditLogRotation)
+		if err != nil {
+			log.Fatalf("open audit log: %v", err)
+		}
+		defer audit.Close()
+		emailService.SetAuditLogger(audit)
+	}
+
+	if config.Prewarm {
+		ctx, cancel := context.WithTimeout(context.Background(), config.PrewarmTimeout)
+		if err := emailService.Prewarm(ctx); err != nil {
+			slog.Warn("mailgun prewarm failed", "error", err)
+		}
+		cancel()
+	}
+
+	var forwarder *EventForwarder
+	if config.EventForwardURL != "" {
+		forwarder = NewEventForwarder(emailService.clock, config.EventForwardURL, config.EventForwardMaxAttempts)
+		go forwarder.Run(context.Background())
+	}
+	callbacks := NewCallbackNotifier(emailService.clock, config.CallbackMaxAttempts)
+	go callbacks.Run(context.Background())
+	emailService.SetCallbackNotifier(callbacks)
+	// Digests saved before a restart are sent through the callbacks above
+	if err := emailService.RestoreDigests(); err != nil {
+		log.Fatalf("restore digests: %v", err)
+	}
+
+	webhooks := NewWebhookHandler(emailService, NewMemoryEventStore(), forwarder)
+	maintenance := NewMaintenance(config.MaintenanceMode, config.MaintenanceRetryAfter)
+	health := NewHealthHandler(maintenance, config.ReadinessTimeout)
+	readinessChecks := map[string]ReadinessCheck{
+		"mailgun":        emailService.Prewarm,
+		"callback_queue": queueCheck(callbacks.Backlog),
+	}
+	if forwarder != nil {
+		readinessChecks["event_forward_queue"] = queueCheck(forwarder.Backlog)
+	}
+	if db, ok := emailService.templateStore.(interface{ Ping(context.Context) error }); ok {
+		readinessChecks["database"] = db.Ping
+	}
+	for name, check := range readinessChecks {
+		if !slices.Contains(config.ReadinessDisabledChecks, name) {
+			health.AddCheck(name, check)
+		}
+	}
+	mailgunStats := NewMailgunStats(emailService.clock, emailService.mg, config.StatsCacheTTL)
+	cache := NewResponseCache(emailService.clock, config.RouteCacheTTLs)
+
 	// Setup router with CORS
-	r := gin.Default()
+	r := gin.New()
+	r.Use(RequestID(), RequestLogger(logger), Recovery(logger))
 
 	// Add CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Max-Retries, X-Return-Raw, X-Timing, X-Send-Mode")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
@@ -162,11 +989,71 @@ func main() {
 
 		c.Next()
 	})
+	r.Use(maintenance.Middleware())
+	r.Use(RouteTimeouts(config.RouteTimeouts, config.DefaultRouteTimeout))
+	r.Use(AcceptLanguage(emailService.supportedLocales))
 
+	r.GET("/healthz", health.Healthz)
+	r.GET("/readyz", health.Readyz)
+	r.GET("/version", cache.Middleware(), handler.VersionHandler)
+	r.GET("/capabilities", cache.Middleware(), handler.CapabilitiesHandler)
+	// Send volumes and remaining capacity are for callers, not the public
+	r.GET("/stats", RequireAPIKey(config.APIKeys), cache.Middleware(), handler.StatsHandler)
+	r.GET("/quota", RequireAPIKey(config.APIKeys), cache.Middleware(), handler.QuotaHandler)
 	r.POST("/send-product", handler.SendProductHandler)
+	r.POST("/send-csv", RequireAPIKey(config.APIKeys), handler.SendCSVHandler)
+	r.POST("/validate-bulk", RequireAPIKey(config.APIKeys), handler.ValidateBulkHandler)
+	r.POST("/preview", RequireAPIKey(config.APIKeys), handler.PreviewHandler)
+	r.POST("/preview-all-locales", RequireAPIKey(config.APIKeys), handler.PreviewLocalesHandler)
+	r.POST("/webhooks/mailgun", webhooks.MailgunWebhookHandler)
+	r.GET("/unsubscribe", handler.UnsubscribeConfirmHandler)
+	r.POST("/unsubscribe", handler.UnsubscribeHandler)
+	r.POST("/approve/:id", RequireAPIKey(config.APIKeys), handler.ApproveHandler)
 
-	// Start server
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal(err)
+	admin := r.Group("/admin", RequireAPIKey(config.APIKeys))
+	admin.GET("/config", handler.AdminConfigHandler)
+	admin.POST("/config/reload", handler.ReloadConfigHandler)
+	admin.POST("/sending", handler.SetSendingHandler)
+	admin.POST("/maintenance", maintenance.ToggleHandler(handler.bindJSON))
+	admin.POST("/test-send", handler.TestSendHandler)
+	admin.POST("/send-stream", handler.SendStreamHandler)
+	admin.GET("/events/dead-letters", webhooks.DeadLettersHandler)
+	admin.POST("/verify-webhook", webhooks.VerifyWebhookHandler)
+	admin.GET("/stats", cache.Middleware(), mailgunStats.StatsHandler)
+	admin.POST("/validate-template", handler.ValidateTemplateHandler)
+	admin.PUT("/templates/:name", handler.PutTemplateHandler)
+	admin.POST("/thumbnail", handler.ThumbnailHandler)
+	admin.GET("/idempotency-cache", handler.DedupCacheHandler)
+	admin.DELETE("/idempotency-cache", handler.PurgeDedupCacheHandler)
+
+	if config.DevMode {
+		r.POST("/render-template", RequireAPIKey(config.APIKeys), handler.RenderTemplateHandler)
+		r.POST("/debug/build-mime", RequireAPIKey(config.APIKeys), handler.BuildMIMEHandler)
+	}
+
+	// Start server and shut down gracefully on SIGINT or SIGTERM
+	server := &http.Server{Addr: ":" + config.Port, Handler: r}
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	<-stop.Done()
+
+	slog.Info("shutting down", "in_flight", emailService.Stats().InFlight)
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), config.ShutdownDrainTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("server shutdown failed", "error", err)
+	}
+	// Pending digests would otherwise be lost
+	if err := emailService.FlushDigests(ctx); err != nil {
+		slog.Error("flushing digests failed", "error", err)
+	}
+	// Sends outlive their requests, e.g. when a client disconnected
+	if inFlight := emailService.Drain(ctx); inFlight > 0 {
+		slog.Warn("shutdown drain timed out, exiting with sends in flight", "in_flight", inFlight)
 	}
 }
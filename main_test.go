@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSendProductHandlerSendsBuiltMessage(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{
+		"recipient_email": "alex@example.com",
+		"recipient_name": "Alex",
+		"product_name": "Desk Lamp",
+		"price": 49.5,
+		"description": "A warm light for late nights",
+		"tag": "launch"
+	}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var response struct {
+		ID       string `json:"id"`
+		SendMode string `json:"send_mode"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != "<1@mg.example.com>" {
+		t.Errorf("id = %q, want the sender's message ID", response.ID)
+	}
+
+	message := sender.last(t)
+	email := plain(t, message)
+	if got := message.To(); !slices.Equal(got, []string{"alex@example.com"}) {
+		t.Errorf("to = %v", got)
+	}
+	if got, want := email.From(), `"Shop" <shop@mg.example.com>`; got != want {
+		t.Errorf("from = %q, want %q", got, want)
+	}
+	if got, want := email.Subject(), "Product Information"; got != want {
+		t.Errorf("subject = %q, want %q", got, want)
+	}
+	for _, want := range []string{"Hi Alex,", "Name: Desk Lamp", "Price: $49.50", "A warm light for late nights"} {
+		if !strings.Contains(email.Text(), want) {
+			t.Errorf("text body is missing %q:\n%s", want, email.Text())
+		}
+	}
+	if !strings.Contains(email.HTML(), ">Desk Lamp</td>") {
+		t.Errorf("html body is missing the product:\n%s", email.HTML())
+	}
+	if got := message.Tags(); !slices.Equal(got, []string{"launch"}) {
+		t.Errorf("tags = %v", got)
+	}
+}
+
+func TestSendProductHandlerRejectsMissingFields(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	for name, body := range map[string]string{
+		"no recipient": `{"product_name": "Desk Lamp"}`,
+		"no product":   `{"recipient_email": "alex@example.com"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if w := do(router, "POST", "/send-product", body, nil); w.Code != 400 {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+	if sent := sender.sent(); len(sent) != 0 {
+		t.Errorf("%d messages sent for invalid requests", len(sent))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceExemptPaths stay reachable while maintenance mode is on
+var maintenanceExemptPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// Maintenance holds the maintenance-mode switch
+type Maintenance struct {
+	enabled    atomic.Bool
+	retryAfter time.Duration
+}
+
+// NewMaintenance creates the maintenance switch in the given initial state
+func NewMaintenance(enabled bool, retryAfter time.Duration) *Maintenance {
+	m := &Maintenance{retryAfter: retryAfter}
+	m.enabled.Store(enabled)
+	return m
+}
+
+// Enabled reports whether maintenance mode is on
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// SetEnabled turns maintenance mode on or off
+func (m *Maintenance) SetEnabled(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+// Middleware answers non-admin requests with a 503 while maintenance mode is on
+func (m *Maintenance) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !m.Enabled() || maintenanceExemptPaths[path] || strings.HasPrefix(path, "/admin/") {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+		c.AbortWithStatusJSON(503, gin.H{
+			"error": "Service is under maintenance",
+		})
+	}
+}
+
+// ToggleHandler switches maintenance mode on or off, reading the request
+// with bind so it gets the same body limits as every other endpoint
+func (m *Maintenance) ToggleHandler(bind func(c *gin.Context, v any) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if !bind(c, &req) {
+			return
+		}
+		if req.Enabled == nil {
+			c.JSON(400, gin.H{
+				"error": "Missing required fields",
+			})
+			return
+		}
+
+		m.SetEnabled(*req.Enabled)
+		slog.Warn("maintenance mode toggled", "enabled", *req.Enabled)
+
+		c.JSON(200, gin.H{
+			"maintenance_mode": m.Enabled(),
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	service, _ := newTestService(t, map[string]string{"JSON_MAX_BODY_BYTES": "64"})
+	maintenance := NewMaintenance(false, 2*time.Minute)
+	health := NewHealthHandler(maintenance, time.Second)
+	router := newTestRouter()
+	router.Use(maintenance.Middleware())
+	router.GET("/healthz", health.Healthz)
+	router.GET("/readyz", health.Readyz)
+	router.GET("/stats", func(c *gin.Context) { c.JSON(200, gin.H{}) })
+	router.POST("/admin/maintenance", maintenance.ToggleHandler(NewHandler(service).bindJSON))
+
+	toggle := func(t *testing.T, body string, status int) {
+		t.Helper()
+		if w := do(router, "POST", "/admin/maintenance", body, nil); w.Code != status {
+			t.Fatalf("toggle %s: status = %d, want %d (body %s)", body, w.Code, status, w.Body)
+		}
+	}
+	expect := func(t *testing.T, stats, readyz int, maintenanceOn bool) {
+		t.Helper()
+		w := do(router, "GET", "/stats", "", nil)
+		if w.Code != stats {
+			t.Errorf("/stats status = %d, want %d", w.Code, stats)
+		}
+		if stats == 503 && w.Header().Get("Retry-After") != "120" {
+			t.Errorf("Retry-After = %q, want 120", w.Header().Get("Retry-After"))
+		}
+		if w := do(router, "GET", "/readyz", "", nil); w.Code != readyz {
+			t.Errorf("/readyz status = %d, want %d", w.Code, readyz)
+		}
+		w = do(router, "GET", "/healthz", "", nil)
+		if w.Code != 200 || !strings.Contains(w.Body.String(), fmt.Sprintf(`"maintenance":%v`, maintenanceOn)) {
+			t.Errorf("/healthz = %d %s, want 200 reporting maintenance %v", w.Code, w.Body, maintenanceOn)
+		}
+	}
+
+	expect(t, 200, 200, false)
+	toggle(t, `{"enabled": true}`, 200)
+	expect(t, 503, 503, true)
+	toggle(t, `{}`, 400)
+	toggle(t, `{"enabled": false, "padding": "`+strings.Repeat("x", 64)+`"}`, 413)
+	expect(t, 503, 503, true)
+	toggle(t, `{"enabled": false}`, 200)
+	expect(t, 200, 200, false)
+}
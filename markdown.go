@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	htmltemplate "html/template"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+)
+
+// markdownPolicy allows the markup Markdown produces and drops scripts,
+// event handlers and unsafe URLs. Data-URI images are kept so they can be
+// moved into inline attachments.
+var markdownPolicy = func() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowDataURIImages()
+	return policy
+}()
+
+// textPolicy strips every tag, leaving only the text content
+var textPolicy = bluemonday.StrictPolicy()
+
+// renderMarkdown converts Markdown to sanitized HTML and to plain text
+func renderMarkdown(source string) (htmltemplate.HTML, string) {
+	var rendered bytes.Buffer
+	// Converting into a bytes.Buffer can't fail
+	_ = goldmark.Convert([]byte(source), &rendered)
+
+	safe := markdownPolicy.Sanitize(rendered.String())
+	text := html.UnescapeString(textPolicy.Sanitize(safe))
+	// Sanitized output is safe to embed in the HTML template unescaped
+	return htmltemplate.HTML(safe), strings.TrimSpace(text)
+}
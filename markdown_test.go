@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownStripsDangerousMarkup(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		forbidden []string
+		kept      []string
+	}{
+		{
+			name:      "script block",
+			source:    "Great lamp\n\n<script>alert(document.cookie)</script>",
+			forbidden: []string{"<script", "alert("},
+			kept:      []string{"<p>Great lamp</p>"},
+		},
+		{
+			name:      "inline script",
+			source:    "Great <script>alert(1)</script> lamp",
+			forbidden: []string{"<script", "</script"},
+		},
+		{
+			name:      "javascript link",
+			source:    "[Buy now](javascript:alert(1))",
+			forbidden: []string{"javascript:"},
+			kept:      []string{"Buy now"},
+		},
+		{
+			name:      "event handler",
+			source:    `<img src="https://shop.example.com/lamp.png" onerror="alert(1)">`,
+			forbidden: []string{"onerror", "alert("},
+		},
+		{
+			name:      "event handler in a block",
+			source:    "<div onmouseover=\"alert(1)\">\nHover me\n</div>",
+			forbidden: []string{"onmouseover", "alert("},
+		},
+		{
+			name:      "vbscript image",
+			source:    "![lamp](vbscript:msgbox(1))",
+			forbidden: []string{"vbscript:"},
+		},
+		{
+			name:   "safe markup",
+			source: "**Bright** lamp, [see it](https://shop.example.com/lamp)\n\n![lamp](https://shop.example.com/lamp.png)",
+			kept: []string{
+				"<strong>Bright</strong>",
+				`<a href="https://shop.example.com/lamp" rel="nofollow">see it</a>`,
+				`<img src="https://shop.example.com/lamp.png" alt="lamp">`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, text := renderMarkdown(tt.source)
+			for _, forbidden := range tt.forbidden {
+				if strings.Contains(strings.ToLower(string(html)), forbidden) {
+					t.Errorf("html contains %q:\n%s", forbidden, html)
+				}
+				if strings.Contains(strings.ToLower(text), "<script") {
+					t.Errorf("text contains markup:\n%s", text)
+				}
+			}
+			for _, kept := range tt.kept {
+				if !strings.Contains(string(html), kept) {
+					t.Errorf("html is missing %q:\n%s", kept, html)
+				}
+			}
+		})
+	}
+}
+
+func TestSendRendersMarkdownDescription(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	if _, err := service.SendProductEmail(context.Background(), ProductEmail{
+		RecipientEmail:      "alex@example.com",
+		ProductName:         "Desk Lamp",
+		Description:         "Plain description",
+		DescriptionMarkdown: "A **bright** lamp <script>alert(1)</script>[docs](javascript:alert(2))",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	email := plain(t, sender.last(t))
+	if !strings.Contains(email.HTML(), "<strong>bright</strong>") {
+		t.Errorf("html body is missing the rendered markdown:\n%s", email.HTML())
+	}
+	// Stripped tags may leave their text behind, which is inert
+	for _, forbidden := range []string{"<script", "javascript:"} {
+		if strings.Contains(email.HTML(), forbidden) || strings.Contains(email.Text(), forbidden) {
+			t.Errorf("email contains %q:\n%s\n%s", forbidden, email.Text(), email.HTML())
+		}
+	}
+	if text := email.Text(); !strings.Contains(text, "A bright lamp") || strings.Contains(text, "Plain description") || strings.Contains(text, "**") {
+		t.Errorf("text body is not the stripped markdown:\n%s", text)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sendMetrics holds lightweight counters for the send path. All fields are
+// updated atomically so they can be read while sends are in flight.
+type sendMetrics struct {
+	startedAt    time.Time
+	sent         atomic.Int64
+	failed       atomic.Int64
+	latencyNanos atomic.Int64
+	// inFlight counts sends that have started but not finished
+	inFlight atomic.Int64
+}
+
+// SendStats is a point-in-time snapshot of the send metrics
+type SendStats struct {
+	TotalSent        int64   `json:"total_sent"`
+	TotalFailed      int64   `json:"total_failed"`
+	AverageLatencyMs float64 `json:"average_latency_ms"`
+	UptimeSeconds    float64 `json:"uptime_seconds"`
+	InFlight         int64   `json:"in_flight"`
+}
+
+// record counts a completed Mailgun send attempt
+func (m *sendMetrics) record(latency time.Duration, err error) {
+	if err != nil {
+		m.failed.Add(1)
+	} else {
+		m.sent.Add(1)
+	}
+	m.latencyNanos.Add(int64(latency))
+}
+
+// snapshot returns the current counter values
+func (m *sendMetrics) snapshot(now time.Time) SendStats {
+	stats := SendStats{
+		TotalSent:     m.sent.Load(),
+		TotalFailed:   m.failed.Load(),
+		UptimeSeconds: now.Sub(m.startedAt).Seconds(),
+		InFlight:      m.inFlight.Load(),
+	}
+	if total := stats.TotalSent + stats.TotalFailed; total > 0 {
+		stats.AverageLatencyMs = float64(m.latencyNanos.Load()) / float64(total) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// Stats returns a snapshot of the service's send counters
+func (s *EmailService) Stats() SendStats {
+	return s.metrics.snapshot(s.clock.Now())
+}
+
+// Drain waits until no sends are in flight or ctx is done, returning the
+// number of sends still running
+func (s *EmailService) Drain(ctx context.Context) int64 {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		inFlight := s.metrics.inFlight.Load()
+		if inFlight == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return inFlight
+		case <-ticker.C:
+		}
+	}
+}
+
+// StatsHandler returns the in-memory send counters
+func (h *Handler) StatsHandler(c *gin.Context) {
+	c.JSON(200, h.emailService.Stats())
+}
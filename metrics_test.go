@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatsCountSends(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"MAX_RETRIES": "0"})
+	ctx := context.Background()
+	data := ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}
+
+	for range 2 {
+		if _, err := service.SendProductEmail(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sender.mu.Lock()
+	sender.err = errors.New("connection reset")
+	sender.mu.Unlock()
+	if _, err := service.SendProductEmail(ctx, data); err == nil {
+		t.Fatal("the failing send succeeded")
+	}
+	// Rejected before reaching Mailgun, so not an attempt
+	if _, err := service.SendProductEmail(ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", ReplyTo: "nope"}); err == nil {
+		t.Fatal("the invalid send succeeded")
+	}
+	stats := service.Stats()
+	if stats.TotalSent != 2 || stats.TotalFailed != 1 || stats.InFlight != 0 {
+		t.Errorf("stats = %+v, want 2 sent, 1 failed, none in flight", stats)
+	}
+	if stats.AverageLatencyMs < 0 {
+		t.Errorf("average latency = %vms", stats.AverageLatencyMs)
+	}
+
+	clock := newFakeClock()
+	service.SetClock(clock)
+	clock.Advance(90 * time.Second)
+	if uptime := service.Stats().UptimeSeconds; uptime != 90 {
+		t.Errorf("uptime = %vs, want 90s", uptime)
+	}
+}
+
+func TestStatsAreSafeForConcurrentSends(t *testing.T) {
+	service, _ := newTestService(t, map[string]string{"MAX_EMAILS_PER_RECIPIENT_PER_DAY": "0"})
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+			service.Stats()
+		}()
+	}
+	wg.Wait()
+	if stats := service.Stats(); stats.TotalSent+stats.TotalFailed != 20 {
+		t.Errorf("stats = %+v, want 20 attempts", stats)
+	}
+}
+
+func TestDrainWaitsForInFlightSends(t *testing.T) {
+	sender := &hangingSender{deadlines: make(chan time.Time, 1)}
+	service := NewEmailServiceWithSender(testConfig(t, map[string]string{"MAX_RETRIES": "0"}), sender)
+	if got := service.Drain(context.Background()); got != 0 {
+		t.Fatalf("idle drain = %d, want 0", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.SendProductEmail(ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+	}()
+	<-sender.deadlines
+	if got := service.Stats().InFlight; got != 1 {
+		t.Errorf("in_flight = %d during the send, want 1", got)
+	}
+
+	timeout, stop := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer stop()
+	if got := service.Drain(timeout); got != 1 {
+		t.Errorf("drain that timed out = %d, want 1", got)
+	}
+
+	cancel()
+	<-done
+	if got := service.Drain(context.Background()); got != 0 {
+		t.Errorf("drain after the send ended = %d, want 0", got)
+	}
+	if got := service.Stats().InFlight; got != 0 {
+		t.Errorf("in_flight = %d after the send, want 0", got)
+	}
+}
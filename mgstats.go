@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// statsEvents are the Mailgun event types summarized by the stats endpoint
+var statsEvents = []string{"accepted", "delivered", "failed", "opened", "clicked"}
+
+// StatsSource reads aggregate sending stats. *mailgun.MailgunImpl satisfies it.
+type StatsSource interface {
+	GetStats(ctx context.Context, events []string, opts *mailgun.GetStatOptions) ([]mailgun.Stats, error)
+}
+
+// StatsCounts are the event counts for one period
+type StatsCounts struct {
+	Time      string `json:"time,omitempty"`
+	Accepted  int    `json:"accepted"`
+	Delivered int    `json:"delivered"`
+	Failed    int    `json:"failed"`
+	Opened    int    `json:"opened"`
+	Clicked   int    `json:"clicked"`
+}
+
+// StatsReport is the response of the Mailgun stats endpoint
+type StatsReport struct {
+	Start      string        `json:"start,omitempty"`
+	End        string        `json:"end,omitempty"`
+	Duration   string        `json:"duration,omitempty"`
+	Resolution string        `json:"resolution"`
+	Totals     StatsCounts   `json:"totals"`
+	Periods    []StatsCounts `json:"periods"`
+}
+
+// cachedReport is a StatsReport and the time it stops being served
+type cachedReport struct {
+	report    StatsReport
+	expiresAt time.Time
+}
+
+// MailgunStats serves Mailgun sending stats, caching each range briefly so
+// dashboards polling it don't hammer the stats API
+type MailgunStats struct {
+	source StatsSource
+	clock  Clock
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedReport
+}
+
+// NewMailgunStats creates a stats reader caching results for ttl
+func NewMailgunStats(clock Clock, source StatsSource, ttl time.Duration) *MailgunStats {
+	return &MailgunStats{
+		source: source,
+		clock:  clock,
+		ttl:    ttl,
+		cache:  make(map[string]cachedReport),
+	}
+}
+
+// Report returns the stats for the range described by opts
+func (m *MailgunStats) Report(ctx context.Context, opts mailgun.GetStatOptions) (StatsReport, error) {
+	key := fmt.Sprintf("%d|%d|%s|%s", opts.Start.Unix(), opts.End.Unix(), opts.Duration, opts.Resolution)
+	now := m.clock.Now()
+
+	m.mu.Lock()
+	cached, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.report, nil
+	}
+
+	stats, err := m.source.GetStats(ctx, statsEvents, &opts)
+	if err != nil {
+		return StatsReport{}, err
+	}
+
+	report := StatsReport{
+		Duration:   opts.Duration,
+		Resolution: string(opts.Resolution),
+		// A range with no traffic reports zero totals and no periods
+		Periods: make([]StatsCounts, 0, len(stats)),
+	}
+	if !opts.Start.IsZero() {
+		report.Start = opts.Start.UTC().Format(time.RFC3339)
+	}
+	if !opts.End.IsZero() {
+		report.End = opts.End.UTC().Format(time.RFC3339)
+	}
+	for _, s := range stats {
+		counts := StatsCounts{
+			Time:      s.Time,
+			Accepted:  s.Accepted.Total,
+			Delivered: s.Delivered.Total,
+			Failed:    s.Failed.Temporary.Total + s.Failed.Permanent.Total,
+			Opened:    s.Opened.Total,
+			Clicked:   s.Clicked.Total,
+		}
+		report.Periods = append(report.Periods, counts)
+		report.Totals.Accepted += counts.Accepted
+		report.Totals.Delivered += counts.Delivered
+		report.Totals.Failed += counts.Failed
+		report.Totals.Opened += counts.Opened
+		report.Totals.Clicked += counts.Clicked
+	}
+
+	m.mu.Lock()
+	m.cache[key] = cachedReport{report: report, expiresAt: now.Add(m.ttl)}
+	for k, entry := range m.cache {
+		if !now.Before(entry.expiresAt) {
+			delete(m.cache, k)
+		}
+	}
+	m.mu.Unlock()
+	return report, nil
+}
+
+// parseStatsDate accepts RFC 3339 timestamps or plain YYYY-MM-DD dates
+func parseStatsDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// statsOptions builds the stats range from the start, end, duration and
+// resolution query parameters. Without start or duration the last 7 days
+// are reported.
+func statsOptions(c *gin.Context) (mailgun.GetStatOptions, error) {
+	opts := mailgun.GetStatOptions{
+		Resolution: mailgun.Resolution(c.DefaultQuery("resolution", string(mailgun.ResolutionDay))),
+		Duration:   c.Query("duration"),
+	}
+	switch opts.Resolution {
+	case mailgun.ResolutionHour, mailgun.ResolutionDay, mailgun.ResolutionMonth:
+	default:
+		return opts, fmt.Errorf("resolution must be hour, day or month, got %q", opts.Resolution)
+	}
+
+	for param, target := range map[string]*time.Time{"start": &opts.Start, "end": &opts.End} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseStatsDate(value)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 timestamp, got %q", param, value)
+		}
+		*target = t
+	}
+	if !opts.Start.IsZero() && !opts.End.IsZero() && opts.End.Before(opts.Start) {
+		return opts, fmt.Errorf("end must not be before start")
+	}
+	if opts.Start.IsZero() && opts.Duration == "" {
+		opts.Duration = "7d"
+	}
+	return opts, nil
+}
+
+// StatsHandler returns delivered, failed, opened and clicked counts from
+// Mailgun for the requested range
+func (m *MailgunStats) StatsHandler(c *gin.Context) {
+	opts, err := statsOptions(c)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error":   "Invalid stats range",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	report, err := m.Report(c.Request.Context(), opts)
+	if err != nil {
+		c.JSON(502, gin.H{
+			"error":   "Failed to fetch Mailgun stats",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, report)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// fakeStatsSource answers GetStats with canned stats, counting the calls
+type fakeStatsSource struct {
+	stats []mailgun.Stats
+	err   error
+	calls int
+	opts  []mailgun.GetStatOptions
+}
+
+// GetStats implements StatsSource
+func (f *fakeStatsSource) GetStats(_ context.Context, _ []string, opts *mailgun.GetStatOptions) ([]mailgun.Stats, error) {
+	f.calls++
+	f.opts = append(f.opts, *opts)
+	return f.stats, f.err
+}
+
+func TestMailgunStatsHandler(t *testing.T) {
+	day := func(date string, accepted, delivered, temporary, permanent int) mailgun.Stats {
+		var s mailgun.Stats
+		s.Time = date
+		s.Accepted.Total = accepted
+		s.Delivered.Total = delivered
+		s.Failed.Temporary.Total = temporary
+		s.Failed.Permanent.Total = permanent
+		return s
+	}
+	source := &fakeStatsSource{stats: []mailgun.Stats{day("Mon, 02 Mar 2026 00:00:00 UTC", 10, 8, 1, 1), day("Tue, 03 Mar 2026 00:00:00 UTC", 5, 5, 0, 0)}}
+	clock := newFakeClock()
+	stats := NewMailgunStats(clock, source, time.Minute)
+	router := newTestRouter()
+	router.GET("/admin/stats", stats.StatsHandler)
+
+	w := do(router, "GET", "/admin/stats?start=2026-03-02&end=2026-03-03", "", nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var report StatsReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if want := (StatsCounts{Accepted: 15, Delivered: 13, Failed: 2}); report.Totals != want {
+		t.Errorf("totals = %+v, want %+v", report.Totals, want)
+	}
+	if len(report.Periods) != 2 || report.Periods[0].Failed != 2 || report.Start != "2026-03-02T00:00:00Z" || report.Resolution != "day" {
+		t.Errorf("report = %+v", report)
+	}
+
+	// The same range is served from the cache until the TTL passes
+	do(router, "GET", "/admin/stats?start=2026-03-02&end=2026-03-03", "", nil)
+	if source.calls != 1 {
+		t.Errorf("%d stats calls within the TTL, want 1", source.calls)
+	}
+	clock.Advance(time.Minute)
+	do(router, "GET", "/admin/stats?start=2026-03-02&end=2026-03-03", "", nil)
+	if source.calls != 2 {
+		t.Errorf("%d stats calls after the TTL, want 2", source.calls)
+	}
+
+	// Without a range the last week is reported
+	do(router, "GET", "/admin/stats", "", nil)
+	if last := source.opts[len(source.opts)-1]; last.Duration != "7d" || !last.Start.IsZero() {
+		t.Errorf("default range = %+v, want the last 7 days", last)
+	}
+}
+
+func TestMailgunStatsHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		err    error
+		status int
+	}{
+		{name: "bad resolution", target: "/admin/stats?resolution=week", status: 400},
+		{name: "bad date", target: "/admin/stats?start=yesterday", status: 400},
+		{name: "end before start", target: "/admin/stats?start=2026-03-03&end=2026-03-02", status: 400},
+		{name: "mailgun failed", target: "/admin/stats", err: errors.New("unavailable"), status: 502},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &fakeStatsSource{err: tt.err}
+			router := newTestRouter()
+			router.GET("/admin/stats", NewMailgunStats(newFakeClock(), source, time.Minute).StatsHandler)
+			if w := do(router, "GET", tt.target, "", nil); w.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
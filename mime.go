@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// renderedEmail is the subject and bodies of an email as rendered for a
+// send, before inline images are moved into parts
+type renderedEmail struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
+}
+
+type renderedEmailKey struct{}
+
+// withRenderedEmail makes sends with ctx use rendered instead of rendering
+// the templates, e.g. to send an approved email exactly as it was reviewed
+func withRenderedEmail(ctx context.Context, rendered renderedEmail) context.Context {
+	return context.WithValue(ctx, renderedEmailKey{}, rendered)
+}
+
+// renderedEmailFrom returns the content a send must use, if any
+func renderedEmailFrom(ctx context.Context) (renderedEmail, bool) {
+	rendered, ok := ctx.Value(renderedEmailKey{}).(renderedEmail)
+	return rendered, ok
+}
+
+// builtEmail is an email built by a build-only send
+type builtEmail struct {
+	message  *mailgun.Message
+	rendered renderedEmail
+}
+
+type capturedEmailKey struct{}
+
+// withEmailCapture makes sends with ctx store the built email in captured
+// and return before anything is reserved or sent
+func withEmailCapture(ctx context.Context, captured *builtEmail) context.Context {
+	return context.WithValue(ctx, capturedEmailKey{}, captured)
+}
+
+// capturedEmailFrom returns where a build-only send stores its email
+func capturedEmailFrom(ctx context.Context) (*builtEmail, bool) {
+	captured, ok := ctx.Value(capturedEmailKey{}).(*builtEmail)
+	return captured, ok
+}
+
+// buildEmail builds the email SendProductEmail would send for data without
+// sending it. Quotas, campaigns and the idempotency cache are left
+// untouched. The email is nil when the send would be skipped.
+func (s *EmailService) buildEmail(ctx context.Context, data ProductEmail) (*builtEmail, SendResult, error) {
+	var built builtEmail
+	result, err := s.sendProductEmail(withEmailCapture(ctx, &built), data)
+	if err != nil || built.message == nil {
+		return nil, result, err
+	}
+	return &built, result, nil
+}
+
+// BuildMIME builds the email SendProductEmail would send for data and
+// returns it as raw MIME without sending it
+func (s *EmailService) BuildMIME(ctx context.Context, data ProductEmail) ([]byte, SendResult, error) {
+	built, result, err := s.buildEmail(ctx, data)
+	if err != nil || built == nil {
+		return nil, result, err
+	}
+	// Validated by the build above
+	charset, _ := resolveCharset(data.Charset)
+	raw, err := renderMIME(built.message, s.clock.Now(), charset, true)
+	return raw, result, err
+}
+
+// mimePart is a node of a MIME tree: a leaf with a body, or a multipart
+// container of parts
+type mimePart struct {
+	header  textproto.MIMEHeader
+	body    []byte
+	subtype string
+	parts   []mimePart
+}
+
+// render serializes p, returning its headers and body
+func (p mimePart) render() (textproto.MIMEHeader, []byte, error) {
+	if p.subtype == "" {
+		return p.header, p.body, nil
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range p.parts {
+		header, content, err := part.render()
+		if err != nil {
+			return nil, nil, err
+		}
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/"+p.subtype, map[string]string{"boundary": writer.Boundary()}))
+	return header, body.Bytes(), nil
+}
+
+// multipartOf wraps parts in a multipart container, or returns the only part
+func multipartOf(subtype string, parts []mimePart) mimePart {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return mimePart{subtype: subtype, parts: parts}
+}
+
+// textPart is a quoted-printable body, in UTF-8 unless charset is set
+func textPart(mediaType, body string, charset *bodyCharset) (mimePart, error) {
+	content, name := []byte(body), "utf-8"
+	if charset != nil {
+		var err error
+		if content, err = charset.encode(body); err != nil {
+			return mimePart{}, err
+		}
+		name = charset.name
+	}
+	var encoded bytes.Buffer
+	w := quotedprintable.NewWriter(&encoded)
+	if _, err := w.Write(content); err != nil {
+		return mimePart{}, err
+	}
+	if err := w.Close(); err != nil {
+		return mimePart{}, err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": name}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return mimePart{header: header, body: encoded.Bytes()}, nil
+}
+
+// filePart is a base64 encoded inline image or attachment
+func filePart(disposition, filename string, data []byte) mimePart {
+	mediaType := mime.TypeByExtension(filepath.Ext(filename))
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mediaType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	if disposition == "inline" {
+		header.Set("Content-Id", "<"+filename+">")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var body strings.Builder
+	for len(encoded) > 76 {
+		body.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	body.WriteString(encoded)
+	return mimePart{header: header, body: []byte(body.String())}
+}
+
+// readAttachment reads and closes a reader attachment or inline
+func readAttachment(attachment mailgun.ReaderAttachment) ([]byte, error) {
+	defer attachment.ReadCloser.Close()
+	return io.ReadAll(attachment.ReadCloser)
+}
+
+// mailgunHeaders returns the X-Mailgun-* headers equivalent to the
+// message's sending options
+func mailgunHeaders(message *mailgun.Message) (map[string]string, error) {
+	headers := map[string]string{}
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	if len(message.Tags()) > 0 {
+		headers["X-Mailgun-Tag"] = strings.Join(message.Tags(), ", ")
+	}
+	if len(message.Variables()) > 0 {
+		variables, err := json.Marshal(message.Variables())
+		if err != nil {
+			return nil, err
+		}
+		headers["X-Mailgun-Variables"] = string(variables)
+	}
+	if message.TestMode() {
+		headers["X-Mailgun-Drop-Message"] = "yes"
+	}
+	if tracking := message.Tracking(); tracking != nil {
+		headers["X-Mailgun-Track"] = yesNo[*tracking]
+	}
+	if clicks := message.TrackingClicks(); clicks != nil {
+		headers["X-Mailgun-Track-Clicks"] = *clicks
+	}
+	if opens := message.TrackingOpens(); opens != nil {
+		headers["X-Mailgun-Track-Opens"] = yesNo[*opens]
+	}
+	if dkim := message.DKIM(); dkim != nil {
+		headers["X-Mailgun-Dkim"] = yesNo[*dkim]
+	}
+	if period := message.STOPeriod(); period != "" {
+		headers["X-Mailgun-Delivery-Time-Optimize-Period"] = period
+	}
+	return headers, nil
+}
+
+// renderMIME serializes a message the way Mailgun would assemble it, with
+// the body parts in charset (nil for UTF-8). keepBcc keeps Bcc as a header
+// so every recipient can be inspected.
+func renderMIME(message *mailgun.Message, date time.Time, charset *bodyCharset, keepBcc bool) ([]byte, error) {
+	plain, ok := message.Specific.(*mailgun.PlainMessage)
+	if !ok {
+		return nil, errors.New("only plain messages can be rendered as MIME")
+	}
+
+	var bodies []mimePart
+	if plain.Text() != "" {
+		part, err := textPart("text/plain", plain.Text(), charset)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, part)
+	}
+	if htmlBody := plain.HTML(); htmlBody != "" {
+		if charset != nil {
+			htmlBody = charset.declareCharset(htmlBody)
+		}
+		part, err := textPart("text/html", htmlBody, charset)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, part)
+	}
+	if len(bodies) == 0 {
+		return nil, errors.New("message has no body")
+	}
+
+	related := []mimePart{multipartOf("alternative", bodies)}
+	for _, inline := range message.ReaderInlines() {
+		data, err := readAttachment(inline)
+		if err != nil {
+			return nil, fmt.Errorf("read inline %s: %w", inline.Filename, err)
+		}
+		related = append(related, filePart("inline", inline.Filename, data))
+	}
+	mixed := []mimePart{multipartOf("related", related)}
+	for _, attachment := range message.BufferAttachments() {
+		mixed = append(mixed, filePart("attachment", attachment.Filename, attachment.Buffer))
+	}
+	for _, attachment := range message.ReaderAttachments() {
+		data, err := readAttachment(attachment)
+		if err != nil {
+			return nil, fmt.Errorf("read attachment %s: %w", attachment.Filename, err)
+		}
+		mixed = append(mixed, filePart("attachment", attachment.Filename, data))
+	}
+	contentHeader, body, err := multipartOf("mixed", mixed).render()
+	if err != nil {
+		return nil, err
+	}
+
+	var raw bytes.Buffer
+	writeHeader := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&raw, "%s: %s\r\n", name, value)
+		}
+	}
+	writeHeader("From", plain.From())
+	writeHeader("To", strings.Join(message.To(), ", "))
+	writeHeader("Cc", strings.Join(plain.CC(), ", "))
+	if keepBcc {
+		writeHeader("Bcc", strings.Join(plain.BCC(), ", "))
+	}
+	writeHeader("Subject", plain.Subject())
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("MIME-Version", "1.0")
+
+	extra, err := mailgunHeaders(message)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range message.Headers() {
+		extra[name] = value
+	}
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeHeader(name, extra[name])
+	}
+	for _, name := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		writeHeader(name, contentHeader.Get(name))
+	}
+	raw.WriteString("\r\n")
+	raw.Write(body)
+	return raw.Bytes(), nil
+}
+
+// writeEmailError responds to the errors building an email can fail with,
+// the same whether it is sent, previewed or held for review. It reports
+// false when err is none of them.
+func writeEmailError(c *gin.Context, err error) bool {
+	if isValidationError(err) {
+		c.JSON(400, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return true
+	}
+	if errors.Is(err, ErrSendingDisabled) {
+		c.JSON(503, gin.H{
+			"error": err.Error(),
+		})
+		return true
+	}
+	var sizeErr *MessageTooLargeError
+	if errors.As(err, &sizeErr) {
+		c.JSON(413, gin.H{
+			"error":   "Message too large",
+			"details": sizeErr.Error(),
+		})
+		return true
+	}
+	var spamErr *SpamContentError
+	if errors.As(err, &spamErr) {
+		c.JSON(422, gin.H{
+			"error":   "Content flagged as spam",
+			"reasons": spamErr.Reasons,
+		})
+		return true
+	}
+	return false
+}
+
+// writeBuildError responds with the reason an email couldn't be built
+func writeBuildError(c *gin.Context, err error) {
+	if writeEmailError(c, err) {
+		return
+	}
+	c.JSON(500, gin.H{
+		"error":   "Failed to build email",
+		"details": err.Error(),
+	})
+}
+
+// BuildMIMEHandler returns the raw MIME of the email a ProductEmail would
+// send, without sending it
+func (h *Handler) BuildMIMEHandler(c *gin.Context) {
+	var productData ProductEmail
+	if !h.bindJSON(c, &productData) {
+		return
+	}
+	if productData.RecipientEmail == "" || productData.ProductName == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	raw, result, err := h.emailService.BuildMIME(c.Request.Context(), productData)
+	if err != nil {
+		writeBuildError(c, err)
+		return
+	}
+	if result.Skipped != "" {
+		c.JSON(200, gin.H{
+			"message": "Email skipped",
+			"skipped": result.Skipped,
+		})
+		return
+	}
+
+	if len(result.Warnings) > 0 {
+		c.Header("X-Build-Warnings", strings.Join(result.Warnings, ", "))
+	}
+	c.Data(200, "message/rfc822", raw)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// mimeLeaves returns the decoded leaf parts of a MIME entity keyed by
+// content type, or by filename for attachments and inline images
+func mimeLeaves(t *testing.T, contentType, encoding string, body io.Reader) map[string]string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := map[string]string{}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return leaves
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range mimeLeaves(t, part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part) {
+				if name := part.FileName(); name != "" {
+					key = name
+				}
+				leaves[key] = value
+			}
+		}
+	}
+	if encoding == "quoted-printable" {
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves[mediaType] = string(data)
+	return leaves
+}
+
+func TestBuildMIMEHandler(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{
+		"ARCHIVE_BCC":                      "archive@example.com",
+		"MAX_EMAILS_PER_RECIPIENT_PER_DAY": "1",
+	})
+	handler := NewHandler(service)
+	router := newTestRouter()
+	router.POST("/debug/build-mime", handler.BuildMIMEHandler)
+	router.POST("/send-product", handler.SendProductHandler)
+	body := `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "subject": "Neu: Lampe für Sie", "tag": "spring"}`
+
+	for range 2 {
+		w := do(router, "POST", "/debug/build-mime", body, nil)
+		if w.Code != 200 {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body)
+		}
+		if got := w.Header().Get("Content-Type"); got != "message/rfc822" {
+			t.Errorf("content type = %q", got)
+		}
+
+		message, err := mail.ReadMessage(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoder mime.WordDecoder
+		subject, err := decoder.DecodeHeader(message.Header.Get("Subject"))
+		if err != nil || subject != "Neu: Lampe für Sie" {
+			t.Errorf("subject = %q, %v", subject, err)
+		}
+		for name, want := range map[string]string{
+			"From":          `"Shop" <shop@mg.example.com>`,
+			"To":            "alex@example.com",
+			"Bcc":           "archive@example.com",
+			"MIME-Version":  "1.0",
+			"X-Mailgun-Tag": "spring",
+		} {
+			if got := message.Header.Get(name); got != want {
+				t.Errorf("%s = %q, want %q", name, got, want)
+			}
+		}
+
+		leaves := mimeLeaves(t, message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+		if !strings.Contains(leaves["text/plain"], "Name: Desk Lamp") {
+			t.Errorf("text part = %q", leaves["text/plain"])
+		}
+		if !strings.Contains(leaves["text/html"], "Desk Lamp") {
+			t.Errorf("html part = %q", leaves["text/html"])
+		}
+	}
+
+	// Building reserves no quota and sends nothing
+	if len(sender.sent()) != 0 {
+		t.Fatal("building the MIME sent a message")
+	}
+	if w := do(router, "POST", "/send-product", body, nil); w.Code != 200 {
+		t.Errorf("send after two builds status = %d, want 200 (body %s)", w.Code, w.Body)
+	}
+}
+
+func TestBuildMIMEHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		body   string
+		status int
+	}{
+		{name: "missing fields", body: `{"product_name": "Desk Lamp"}`, status: 400},
+		{name: "invalid request", body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "body_format": "amp"}`, status: 400},
+		{name: "too large", env: map[string]string{"MAX_MESSAGE_BYTES": "1024"}, body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, status: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/debug/build-mime", NewHandler(service).BuildMIMEHandler)
+			if w := do(router, "POST", "/debug/build-mime", tt.body, nil); w.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NormalizationRule canonicalizes the addresses of a mail provider that
+// delivers several spellings to the same mailbox
+type NormalizationRule struct {
+	// StripDots removes dots from the local part
+	StripDots bool `json:"strip_dots"`
+	// StripPlus removes a +tag from the local part
+	StripPlus bool `json:"strip_plus"`
+	// Domain replaces the domain when set, e.g. gmail.com for googlemail.com
+	Domain string `json:"domain"`
+}
+
+// defaultNormalizationRules are used when NORMALIZE_RECIPIENTS is on;
+// RECIPIENT_NORMALIZATION can replace them or add other domains
+var defaultNormalizationRules = map[string]NormalizationRule{
+	"gmail.com":      {StripDots: true, StripPlus: true},
+	"googlemail.com": {StripDots: true, StripPlus: true, Domain: "gmail.com"},
+}
+
+// parseNormalizationRules parses the RECIPIENT_NORMALIZATION JSON object,
+// keyed by domain, on top of the built-in rules. Disabled normalization
+// yields no rules.
+func parseNormalizationRules(enabled bool, raw string) (map[string]NormalizationRule, error) {
+	if !enabled {
+		return nil, nil
+	}
+	rules := make(map[string]NormalizationRule, len(defaultNormalizationRules))
+	for domain, rule := range defaultNormalizationRules {
+		rules[domain] = rule
+	}
+	if raw == "" {
+		return rules, nil
+	}
+
+	var overrides map[string]NormalizationRule
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid RECIPIENT_NORMALIZATION: %w", err)
+	}
+	for domain, rule := range overrides {
+		rules[strings.ToLower(domain)] = rule
+	}
+	return rules, nil
+}
+
+// normalizeRecipient returns the key identifying the mailbox of email for
+// quotas and de-duplication. Emails are still sent to the address as given.
+func normalizeRecipient(email string, rules map[string]NormalizationRule) string {
+	key := recipientKey(email)
+	at := strings.LastIndexByte(key, '@')
+	if at <= 0 {
+		return key
+	}
+	local, domain := key[:at], key[at+1:]
+	rule, ok := rules[domain]
+	if !ok {
+		return key
+	}
+
+	if rule.StripPlus {
+		local, _, _ = strings.Cut(local, "+")
+	}
+	if rule.StripDots {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	if rule.Domain != "" {
+		domain = strings.ToLower(rule.Domain)
+	}
+	return local + "@" + domain
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeRecipient(t *testing.T) {
+	rules, err := parseNormalizationRules(true, `{"Example.com": {"strip_plus": true}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{email: "Alex.Smith+shop@Gmail.com", want: "alexsmith@gmail.com"},
+		{email: "a.l.e.x@googlemail.com", want: "alex@gmail.com"},
+		{email: "alex.smith+shop@example.com", want: "alex.smith@example.com"},
+		{email: "alex.smith+shop@example.org", want: "alex.smith+shop@example.org"},
+		{email: "not-an-address", want: "not-an-address"},
+	}
+	for _, tt := range tests {
+		if got := normalizeRecipient(tt.email, rules); got != tt.want {
+			t.Errorf("normalizeRecipient(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+
+	if rules, err := parseNormalizationRules(false, `{"example.com": {"strip_plus": true}}`); err != nil || rules != nil {
+		t.Errorf("disabled normalization = %v, %v, want no rules", rules, err)
+	}
+	if _, err := parseNormalizationRules(true, `not json`); err == nil {
+		t.Error("invalid RECIPIENT_NORMALIZATION accepted")
+	}
+}
+
+func TestQuotaCountsNormalizedRecipients(t *testing.T) {
+	tests := []struct {
+		name      string
+		normalize string
+		statuses  []int
+	}{
+		{name: "normalized", normalize: "true", statuses: []int{200, 429, 429}},
+		{name: "as given", normalize: "false", statuses: []int{200, 200, 200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{
+				"MAX_EMAILS_PER_RECIPIENT_PER_DAY": "1",
+				"NORMALIZE_RECIPIENTS":             tt.normalize,
+			})
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			var statuses []int
+			var sentTo []string
+			for _, email := range []string{"alex.smith@gmail.com", "alexsmith+shop@gmail.com", "Alex.Smith@googlemail.com"} {
+				w := do(router, "POST", "/send-product", `{"recipient_email": "`+email+`", "product_name": "Desk Lamp"}`, nil)
+				statuses = append(statuses, w.Code)
+			}
+			for _, message := range sender.sent() {
+				sentTo = append(sentTo, message.To()...)
+			}
+			if !slices.Equal(statuses, tt.statuses) {
+				t.Errorf("statuses = %v, want %v", statuses, tt.statuses)
+			}
+			// Emails still go to the address as given
+			if len(sentTo) == 0 || sentTo[0] != "alex.smith@gmail.com" {
+				t.Errorf("sent to %v", sentTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// paragraphElements are separated from their surroundings by a blank line
+// in the plain-text rendering, lineElements by a line break
+var (
+	paragraphElements = map[atom.Atom]bool{
+		atom.P: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+		atom.H5: true, atom.H6: true, atom.Table: true, atom.Ul: true, atom.Ol: true,
+		atom.Blockquote: true, atom.Pre: true, atom.Hr: true,
+	}
+	lineElements = map[atom.Atom]bool{
+		atom.Div: true, atom.Tr: true, atom.Section: true, atom.Header: true,
+		atom.Footer: true, atom.Article: true,
+	}
+)
+
+// htmlToText renders an HTML document as readable plain text. Block
+// elements start new lines, list items are bulleted and links are kept as
+// "text (url)". Scripts, styles and images are dropped.
+func htmlToText(document string) string {
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return ""
+	}
+
+	var text strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			text.WriteString(collapseSpace(n.Data))
+			return
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Head, atom.Script, atom.Style, atom.Title:
+				return
+			case atom.Br:
+				text.WriteString("\n")
+				return
+			case atom.Li:
+				text.WriteString("\n- ")
+			case atom.Td, atom.Th:
+				text.WriteString(" ")
+			}
+		}
+
+		breaks := 0
+		if paragraphElements[n.DataAtom] {
+			breaks = 2
+		} else if lineElements[n.DataAtom] {
+			breaks = 1
+		}
+		lineBreaks(&text, breaks)
+		start := text.Len()
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.DataAtom == atom.A {
+			label := strings.TrimSpace(text.String()[start:])
+			if href := linkTarget(attr(n, "href")); href != "" && href != label {
+				text.WriteString(" (" + href + ")")
+			}
+		}
+		lineBreaks(&text, breaks)
+	}
+	walk(doc)
+
+	// Trim every line and keep at most one blank line in a row
+	var lines []string
+	blank := true
+	for _, line := range strings.Split(text.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" && blank {
+			continue
+		}
+		blank = line == ""
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// lineBreaks ends text with at least n line breaks, so nested blocks don't
+// pile up blank lines
+func lineBreaks(text *strings.Builder, n int) {
+	current := text.String()
+	trailing := len(current) - len(strings.TrimRight(current, "\n"))
+	for ; trailing < n; trailing++ {
+		text.WriteByte('\n')
+	}
+}
+
+// collapseSpace replaces runs of whitespace with a single space, as a
+// browser would
+func collapseSpace(s string) string {
+	collapsed := strings.Join(strings.Fields(s), " ")
+	if collapsed == "" {
+		if s != "" {
+			return " "
+		}
+		return ""
+	}
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(s, " \t\r\n") != s {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+// linkTarget returns the part of an href worth showing in plain text, or ""
+// for in-page anchors and script links
+func linkTarget(href string) string {
+	href = strings.TrimSpace(href)
+	switch {
+	case href == "", strings.HasPrefix(href, "#"), strings.HasPrefix(strings.ToLower(href), "javascript:"):
+		return ""
+	case strings.HasPrefix(strings.ToLower(href), "mailto:"):
+		return href[len("mailto:"):]
+	}
+	return href
+}
+
+// wrapText wraps the lines of a plain-text body at width characters,
+// breaking between words only. Existing line breaks are kept, wrapped lines
+// keep their indentation and words longer than width, such as URLs, are
+// never split. A width of zero or less disables wrapping.
+func wrapText(text string, width int) string {
+	if width <= 0 {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if utf8.RuneCountInString(line) <= width {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		var wrapped strings.Builder
+		length := 0
+		for _, word := range strings.Fields(line) {
+			wordLength := utf8.RuneCountInString(word)
+			switch {
+			case length == 0:
+				wrapped.WriteString(indent)
+				length = utf8.RuneCountInString(indent)
+			case length+1+wordLength > width:
+				wrapped.WriteString("\n" + indent)
+				length = utf8.RuneCountInString(indent)
+			default:
+				wrapped.WriteByte(' ')
+				length++
+			}
+			wrapped.WriteString(word)
+			length += wordLength
+		}
+		lines[i] = wrapped.String()
+	}
+	return strings.Join(lines, "\n")
+}
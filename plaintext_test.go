@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs and headings",
+			html: "<h1>Spring sale</h1><p>Our   desk lamps\n are <b>25%</b> off.</p><p>Until Friday.</p>",
+			want: "Spring sale\n\nOur desk lamps are 25% off.\n\nUntil Friday.",
+		},
+		{
+			name: "links",
+			html: `<p><a href="https://shop.example.com/lamp">See the lamp</a>, <a href="https://shop.example.com">https://shop.example.com</a>, <a href="mailto:help@example.com">write us</a> or <a href="#top">go up</a></p>`,
+			want: "See the lamp (https://shop.example.com/lamp), https://shop.example.com, write us (help@example.com) or go up",
+		},
+		{
+			name: "lists and line breaks",
+			html: "<div>Includes:</div><ul><li>Linen shade</li><li>Oak base</li></ul>Ships free<br>in 2 days",
+			want: "Includes:\n\n- Linen shade\n- Oak base\n\nShips free\nin 2 days",
+		},
+		{
+			name: "table cells",
+			html: "<table><tr><td>Desk Lamp</td><td>$40.00</td></tr><tr><td>Bookshelf</td><td>$120.00</td></tr></table>",
+			want: "Desk Lamp $40.00\nBookshelf $120.00",
+		},
+		{
+			name: "hidden content dropped",
+			html: `<html><head><title>Offer</title><style>p { color: red }</style></head><body><script>track()</script><p>Hello <img src="logo.png" alt="Shop"></p></body></html>`,
+			want: "Hello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htmlToText(tt.html); got != tt.want {
+				t.Errorf("htmlToText() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoPlainText(t *testing.T) {
+	const document = `<html><body><h1>Spring sale</h1><p>See <a href="https://shop.example.com/sale">the sale</a></p></body></html>`
+	for _, auto := range []bool{true, false} {
+		service, sender := newTestService(t, map[string]string{"AUTO_PLAIN_TEXT": strconv.FormatBool(auto)})
+		service.htmlFetcher.client.Transport = serveDocument(200, "text/html", document)
+		if _, err := service.SendProductEmail(context.Background(), ProductEmail{
+			RecipientEmail: "alex@example.com",
+			ProductName:    "Desk Lamp",
+			HTMLURL:        "https://cms.example.com/spring.html",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		text := plain(t, sender.last(t)).Text()
+		if derived := strings.Contains(text, "Spring sale\n\nSee the sale (https://shop.example.com/sale)"); derived != auto {
+			t.Errorf("AUTO_PLAIN_TEXT=%v: text body derived = %v:\n%s", auto, derived, text)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	const url = "https://shop.example.com/products/desk-lamp?utm_source=newsletter&utm_campaign=spring"
+	tests := []struct {
+		name  string
+		text  string
+		width int
+		want  string
+	}{
+		{
+			name:  "short lines",
+			text:  "Name: Desk Lamp\nPrice: $40.00",
+			width: 20,
+			want:  "Name: Desk Lamp\nPrice: $40.00",
+		},
+		{
+			name:  "long paragraph",
+			text:  "A warm desk lamp with a linen shade and a solid oak base",
+			width: 20,
+			want:  "A warm desk lamp\nwith a linen shade\nand a solid oak base",
+		},
+		{
+			name:  "url kept whole",
+			text:  "See " + url + " for details",
+			width: 20,
+			want:  "See\n" + url + "\nfor details",
+		},
+		{
+			name:  "indentation kept",
+			text:  "  - a warm desk lamp with a linen shade",
+			width: 20,
+			want:  "  - a warm desk lamp\n  with a linen shade",
+		},
+		{
+			name:  "counts characters, not bytes",
+			text:  "Lámpara cálida con pantalla",
+			width: 15,
+			want:  "Lámpara cálida\ncon pantalla",
+		},
+		{
+			name:  "disabled",
+			text:  "A warm desk lamp with a linen shade",
+			width: 0,
+			want:  "A warm desk lamp with a linen shade",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapText(tt.text, tt.width); got != tt.want {
+				t.Errorf("wrapText() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextBodyIsWrapped(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"TEXT_WRAP_WIDTH": "40"})
+	url := "https://shop.example.com/products/desk-lamp?utm_source=newsletter&utm_campaign=spring"
+	if _, err := service.SendProductEmail(context.Background(), ProductEmail{
+		RecipientEmail: "alex@example.com",
+		ProductName:    "Desk Lamp",
+		Description:    "A warm desk lamp with a linen shade and a solid oak base, more at " + url,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	text := plain(t, sender.last(t)).Text()
+	if !strings.Contains(text, "\n"+url) {
+		t.Errorf("the URL was split or not moved to its own line:\n%s", text)
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if utf8.RuneCountInString(line) > 40 && line != url {
+			t.Errorf("line longer than 40 characters: %q", line)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "context"
+
+// defaultCategory is the email category used when a request doesn't set one
+const defaultCategory = "product"
+
+// skipOptedOut is reported when a recipient opted out of the email's category
+const skipOptedOut = "opted_out"
+
+// Preferences reports whether a recipient accepts emails of a category.
+// Recipients without stored preferences should be allowed.
+type Preferences interface {
+	Allows(ctx context.Context, recipient, category string) (bool, error)
+}
+
+// allowAllPreferences is the default Preferences that accepts everything
+type allowAllPreferences struct{}
+
+// Allows implements Preferences
+func (allowAllPreferences) Allows(context.Context, string, string) (bool, error) {
+	return true, nil
+}
+
+// SetPreferences replaces the recipient preferences consulted before sending
+func (s *EmailService) SetPreferences(preferences Preferences) {
+	s.preferences = preferences
+}
+
+// category returns the email's category, defaulting to product emails
+func (data ProductEmail) category() string {
+	if data.Category != "" {
+		return data.Category
+	}
+	return defaultCategory
+}
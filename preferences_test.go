@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakePreferences opts recipients out of categories, or fails every lookup
+type fakePreferences struct {
+	optedOut map[string]string
+	err      error
+}
+
+// Allows implements Preferences
+func (f fakePreferences) Allows(_ context.Context, recipient, category string) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	return f.optedOut[recipient] != category, nil
+}
+
+func TestSendHonoursRecipientPreferences(t *testing.T) {
+	tests := []struct {
+		name        string
+		preferences fakePreferences
+		body        string
+		status      int
+		skipped     bool
+		sent        bool
+	}{
+		{name: "allowed", preferences: fakePreferences{optedOut: map[string]string{"alex@example.com": "newsletter"}}, body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, status: 200, sent: true},
+		{name: "opted out of the default category", preferences: fakePreferences{optedOut: map[string]string{"alex@example.com": defaultCategory}}, body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, status: 200, skipped: true},
+		{name: "opted out of the category", preferences: fakePreferences{optedOut: map[string]string{"alex@example.com": "newsletter"}}, body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "category": "newsletter"}`, status: 200, skipped: true},
+		{name: "lookup failed", preferences: fakePreferences{err: errors.New("preferences unavailable")}, body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, status: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			service.SetPreferences(tt.preferences)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if skipped := w.Body.String() == `{"message":"Email skipped","skipped":"opted_out"}`; skipped != tt.skipped {
+				t.Errorf("body %s, want skipped %v", w.Body, tt.skipped)
+			}
+			if sent := len(sender.sent()) > 0; sent != tt.sent {
+				t.Errorf("sent = %v, want %v", sent, tt.sent)
+			}
+		})
+	}
+}
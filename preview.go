@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"mime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// supportedLocales returns the locales previews are rendered in
+func (s *EmailService) supportedLocales() []string {
+	cfg := s.cfg()
+	if len(cfg.SupportedLocales) == 0 {
+		return []string{s.resolveLocale(ProductEmail{})}
+	}
+	return cfg.SupportedLocales
+}
+
+// previewLocales renders the email body once per supported locale, keyed by
+// locale. html selects the HTML body instead of the plain-text one.
+func (s *EmailService) previewLocales(data ProductEmail, html bool) (map[string]string, error) {
+	render := s.formatProductEmail
+	if html {
+		render = s.formatProductHTML
+	}
+
+	previews := make(map[string]string)
+	for _, locale := range s.supportedLocales() {
+		data.Locale = locale
+		body, err := render(data)
+		if err != nil {
+			return nil, err
+		}
+		previews[locale] = body
+	}
+	return previews, nil
+}
+
+// PreviewLocalesHandler renders a product email in every supported locale
+// without sending it. Pass ?format=html for the HTML body.
+func (h *Handler) PreviewLocalesHandler(c *gin.Context) {
+	var productData ProductEmail
+	if !h.bindJSON(c, &productData) {
+		return
+	}
+	if productData.ProductName == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	format := c.DefaultQuery("format", "text")
+	if format != "text" && format != "html" {
+		c.JSON(400, gin.H{
+			"error": "format must be text or html",
+		})
+		return
+	}
+
+	previews, err := h.emailService.previewLocales(productData, format == "html")
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error":   "Template render failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"format":  format,
+		"locales": previews,
+	})
+}
+
+// EmailPreview is the email a send would produce, with the metadata the
+// send would set, so it can be confirmed before sending
+type EmailPreview struct {
+	From       string            `json:"from"`
+	Subject    string            `json:"subject"`
+	Text       string            `json:"text,omitempty"`
+	HTML       string            `json:"html,omitempty"`
+	Recipients PreviewRecipients `json:"recipients"`
+	Tags       []string          `json:"tags"`
+	Tracking   PreviewTracking   `json:"tracking"`
+	Domain     string            `json:"domain"`
+	TestMode   bool              `json:"test_mode"`
+	// EstimatedSizeBytes is the size of the email as MIME
+	EstimatedSizeBytes int      `json:"estimated_size_bytes"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+// PreviewRecipients are the addresses an email would be delivered to
+type PreviewRecipients struct {
+	To  []string `json:"to"`
+	CC  []string `json:"cc"`
+	BCC []string `json:"bcc"`
+}
+
+// PreviewTracking are the tracking options of an email; null leaves the
+// domain's setting in place
+type PreviewTracking struct {
+	Enabled *bool   `json:"enabled"`
+	Clicks  *string `json:"clicks"`
+	Opens   *bool   `json:"opens"`
+}
+
+// PreviewEmail builds the email SendProductEmail would send for data and
+// describes it without sending it. The preview is nil when the send would
+// be skipped; the result says why.
+func (s *EmailService) PreviewEmail(ctx context.Context, data ProductEmail) (*EmailPreview, SendResult, error) {
+	built, result, err := s.buildEmail(ctx, data)
+	if err != nil || built == nil {
+		return nil, result, err
+	}
+	message := built.message
+	plain := message.Specific.(*mailgun.PlainMessage)
+	// The subject is sent RFC 2047 encoded
+	subject, err := new(mime.WordDecoder).DecodeHeader(plain.Subject())
+	if err != nil {
+		subject = plain.Subject()
+	}
+	preview := &EmailPreview{
+		From:    plain.From(),
+		Subject: subject,
+		Text:    plain.Text(),
+		HTML:    plain.HTML(),
+		Recipients: PreviewRecipients{
+			To:  message.To(),
+			CC:  plain.CC(),
+			BCC: plain.BCC(),
+		},
+		Tags: message.Tags(),
+		Tracking: PreviewTracking{
+			Enabled: message.Tracking(),
+			Clicks:  message.TrackingClicks(),
+			Opens:   message.TrackingOpens(),
+		},
+		Domain:   result.Domain,
+		TestMode: result.TestMode,
+		Warnings: result.Warnings,
+	}
+
+	// Validated while building the message
+	charset, _ := resolveCharset(data.Charset)
+	raw, err := renderMIME(message, s.clock.Now(), charset, false)
+	if err != nil {
+		return nil, result, err
+	}
+	preview.EstimatedSizeBytes = len(raw)
+	return preview, result, nil
+}
+
+// PreviewHandler returns the email a ProductEmail would send along with
+// its sender, subject, recipients, tags, tracking and size, without
+// sending it
+func (h *Handler) PreviewHandler(c *gin.Context) {
+	var productData ProductEmail
+	if !h.bindJSON(c, &productData) {
+		return
+	}
+	if productData.RecipientEmail == "" || productData.ProductName == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	// X-Send-Mode previews the test mode of the overridden send
+	ctx, ok := h.withSendMode(c, c.Request.Context(), productData.RecipientEmail)
+	if !ok {
+		return
+	}
+	preview, result, err := h.emailService.PreviewEmail(ctx, productData)
+	if err != nil {
+		writeBuildError(c, err)
+		return
+	}
+	if preview == nil {
+		c.JSON(200, gin.H{
+			"message": "Email skipped",
+			"skipped": result.Skipped,
+		})
+		return
+	}
+
+	c.JSON(200, preview)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"mime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPreviewMatchesSend(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		body string
+	}{
+		{
+			name: "transactional",
+			body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "price": 40}`,
+		},
+		{
+			name: "marketing with tag and archive",
+			env:  map[string]string{"ARCHIVE_BCC": "archive@example.com", "PUBLIC_BASE_URL": "https://shop.example.com", "UNSUBSCRIBE_SIGNING_KEY": "secret"},
+			body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "profile": "marketing", "tag": "spring-sale", "subject": "Frühlingsangebot"}`,
+		},
+		{
+			name: "redirected in test mode",
+			env:  map[string]string{"REDIRECT_ALL_TO": "qa@example.com", "MAILGUN_TEST_MODE": "true"},
+			body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "tracking": false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/preview", NewHandler(service).PreviewHandler)
+
+			w := do(router, "POST", "/preview", tt.body, nil)
+			if w.Code != 200 {
+				t.Fatalf("preview status = %d, body %s", w.Code, w.Body)
+			}
+			var preview EmailPreview
+			if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil {
+				t.Fatal(err)
+			}
+			if len(sender.sent()) != 0 {
+				t.Fatal("the preview sent the email")
+			}
+
+			var data ProductEmail
+			if err := json.Unmarshal([]byte(tt.body), &data); err != nil {
+				t.Fatal(err)
+			}
+			result, err := service.SendProductEmail(context.Background(), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message := sender.last(t)
+			email := plain(t, message)
+			subject, err := new(mime.WordDecoder).DecodeHeader(email.Subject())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if preview.From != email.From() || preview.Subject != subject {
+				t.Errorf("preview from %q, subject %q; sent %q, %q", preview.From, preview.Subject, email.From(), subject)
+			}
+			if preview.Text != email.Text() || preview.HTML != email.HTML() {
+				t.Error("the previewed bodies differ from the sent ones")
+			}
+			if !slices.Equal(preview.Recipients.To, message.To()) || !slices.Equal(preview.Recipients.BCC, email.BCC()) {
+				t.Errorf("preview recipients %+v; sent to %v, bcc %v", preview.Recipients, message.To(), email.BCC())
+			}
+			if !slices.Equal(preview.Tags, message.Tags()) {
+				t.Errorf("preview tags %v; sent %v", preview.Tags, message.Tags())
+			}
+			if tracking := message.Tracking(); (preview.Tracking.Enabled == nil) != (tracking == nil) || (tracking != nil && *preview.Tracking.Enabled != *tracking) {
+				t.Errorf("preview tracking %v; sent %v", preview.Tracking.Enabled, tracking)
+			}
+			if preview.Domain != result.Domain || preview.TestMode != message.TestMode() {
+				t.Errorf("preview domain %q, test mode %v; sent %q, %v", preview.Domain, preview.TestMode, result.Domain, message.TestMode())
+			}
+			if preview.EstimatedSizeBytes <= len(preview.Text)+len(preview.HTML) {
+				t.Errorf("estimated size %d is smaller than the bodies", preview.EstimatedSizeBytes)
+			}
+		})
+	}
+}
+
+func TestPreviewReportsSendErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		body   string
+		status int
+	}{
+		{name: "missing fields", body: `{"product_name": "Desk Lamp"}`, status: 400},
+		{name: "invalid reply-to", body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "reply_to": "nope"}`, status: 400},
+		{name: "spam", env: map[string]string{"SPAM_CHECK": "block"}, body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "description": "Act now!"}`, status: 422},
+		{name: "too large", env: map[string]string{"MAX_MESSAGE_BYTES": "1024"}, body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, status: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/preview", NewHandler(service).PreviewHandler)
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			preview := do(router, "POST", "/preview", tt.body, nil)
+			send := do(router, "POST", "/send-product", tt.body, nil)
+			if preview.Code != tt.status || send.Code != tt.status {
+				t.Errorf("preview %d %s, send %d %s; want both %d", preview.Code, preview.Body, send.Code, send.Body, tt.status)
+			}
+		})
+	}
+}
+
+func TestPreviewAppliesSendMode(t *testing.T) {
+	const body = `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		status   int
+		testMode bool
+	}{
+		{name: "configured mode", status: 200},
+		{name: "test override", headers: map[string]string{"X-Send-Mode": "test", "X-API-Key": "secret"}, status: 200, testMode: true},
+		{name: "override without a key", headers: map[string]string{"X-Send-Mode": "test"}, status: 403},
+		{name: "invalid override", headers: map[string]string{"X-Send-Mode": "dry-run", "X-API-Key": "secret"}, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{"API_KEYS": "secret"})
+			// A preview sends nothing, so the kill-switch doesn't block it
+			service.SetSendingEnabled(false)
+			router := newTestRouter()
+			router.POST("/preview", NewHandler(service).PreviewHandler)
+
+			w := do(router, "POST", "/preview", body, tt.headers)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if len(sender.sent()) != 0 {
+				t.Error("the preview sent a message")
+			}
+			if tt.status != 200 {
+				return
+			}
+			var preview EmailPreview
+			if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil {
+				t.Fatal(err)
+			}
+			if preview.TestMode != tt.testMode {
+				t.Errorf("test mode = %v, want %v", preview.TestMode, tt.testMode)
+			}
+		})
+	}
+}
+
+func TestPreviewLocalesHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		status  int
+		locales map[string]string
+	}{
+		{
+			name:   "text",
+			target: "/preview-all-locales",
+			body:   `{"product_name": "Desk Lamp", "price": 1234.5}`,
+			status: 200,
+			locales: map[string]string{
+				"en-US": "Price: $1,234.50",
+				"de-DE": "Price: $1.234,50",
+			},
+		},
+		{
+			name:   "html",
+			target: "/preview-all-locales?format=html",
+			body:   `{"product_name": "Desk Lamp", "price": 1234.5}`,
+			status: 200,
+			locales: map[string]string{
+				"en-US": `<td style="padding: 4px 0">$1,234.50</td>`,
+				"de-DE": `<td style="padding: 4px 0">$1.234,50</td>`,
+			},
+		},
+		{name: "missing product", target: "/preview-all-locales", body: `{}`, status: 400},
+		{name: "unknown format", target: "/preview-all-locales?format=pdf", body: `{"product_name": "Desk Lamp"}`, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{"SUPPORTED_LOCALES": "en-US,de-DE"})
+			router := newTestRouter()
+			router.POST("/preview-all-locales", NewHandler(service).PreviewLocalesHandler)
+
+			w := do(router, "POST", tt.target, tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if len(sender.sent()) != 0 {
+				t.Error("the preview sent a message")
+			}
+			if tt.status != 200 {
+				return
+			}
+			var response struct {
+				Locales map[string]string `json:"locales"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Locales) != len(tt.locales) {
+				t.Errorf("previewed locales %v, want %d", slices.Collect(maps.Keys(response.Locales)), len(tt.locales))
+			}
+			for locale, want := range tt.locales {
+				if !strings.Contains(response.Locales[locale], want) {
+					t.Errorf("%s preview is missing %q:\n%s", locale, want, response.Locales[locale])
+				}
+			}
+		})
+	}
+}
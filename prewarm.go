@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// Prewarm opens a connection to the Mailgun API host through the client's
+// transport so that TLS and connection setup don't slow down the first send.
+// Any HTTP response counts as success; only transport errors are returned.
+func (s *EmailService) Prewarm(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.mg.APIBase(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.mg.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	slog.Debug("mailgun connection prewarmed", "status", resp.StatusCode)
+	return nil
+}
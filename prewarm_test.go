@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPrewarmOpensAReusedConnection(t *testing.T) {
+	var heads, connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+			// Any response means the connection is up
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprint(w, `{"id": "<1@mg.example.com>", "message": "Queued. Thank you."}`)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	service := NewEmailService(testConfig(t, nil))
+	service.mg.SetAPIBase(server.URL + "/v3")
+	if err := service.Prewarm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if heads.Load() != 1 {
+		t.Errorf("%d HEAD requests, want 1", heads.Load())
+	}
+	if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := connections.Load(); got != 1 {
+		t.Errorf("%d connections opened, want the prewarmed one reused", got)
+	}
+}
+
+func TestPrewarmReportsTransportErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	service := NewEmailService(testConfig(t, nil))
+	service.mg.SetAPIBase(server.URL + "/v3")
+	if err := service.Prewarm(context.Background()); err == nil {
+		t.Error("prewarming an unreachable host succeeded")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlpkg "html"
+	"strings"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// defaultProfile is used when a request doesn't name a profile
+const defaultProfile = "transactional"
+
+// ErrInvalidProfile is returned for unknown profiles and invalid overrides
+var ErrInvalidProfile = errors.New("invalid profile")
+
+// SendingProfile bundles the delivery defaults of a kind of email
+type SendingProfile struct {
+	// Tracking enables Mailgun open and click tracking
+	Tracking bool `json:"tracking"`
+	// UnsubscribeFooter appends an unsubscribe link to both bodies
+	UnsubscribeFooter bool `json:"unsubscribe_footer"`
+	// Priority is "high", "normal" or "low"
+	Priority string `json:"priority"`
+	// HonorUnsubscribes skips recipients found in the unsubscribe store
+	HonorUnsubscribes bool `json:"honor_unsubscribes"`
+}
+
+// defaultProfiles are the built-in profiles; SENDING_PROFILES can adjust
+// them or add new ones
+var defaultProfiles = map[string]SendingProfile{
+	"transactional": {Tracking: false, UnsubscribeFooter: false, Priority: "high", HonorUnsubscribes: false},
+	"marketing":     {Tracking: true, UnsubscribeFooter: true, Priority: "normal", HonorUnsubscribes: true},
+}
+
+// priorityHeaders maps a priority to its X-Priority value; normal emails
+// carry no priority headers
+var priorityHeaders = map[string]string{
+	"high":   "1",
+	"normal": "",
+	"low":    "5",
+}
+
+// parseSendingProfiles parses the SENDING_PROFILES JSON object on top of
+// the built-in profiles. Fields left out of a built-in profile keep their
+// defaults; new profiles default to normal priority and honor unsubscribes.
+func parseSendingProfiles(raw string) (map[string]SendingProfile, error) {
+	profiles := make(map[string]SendingProfile, len(defaultProfiles))
+	for name, profile := range defaultProfiles {
+		profiles[name] = profile
+	}
+	if raw == "" {
+		return profiles, nil
+	}
+
+	var overrides map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid SENDING_PROFILES: %w", err)
+	}
+	for name, override := range overrides {
+		profile, ok := profiles[name]
+		if !ok {
+			profile.Priority = "normal"
+			profile.HonorUnsubscribes = true
+		}
+		if err := json.Unmarshal(override, &profile); err != nil {
+			return nil, fmt.Errorf("invalid SENDING_PROFILES %q: %w", name, err)
+		}
+		if _, ok := priorityHeaders[profile.Priority]; !ok {
+			return nil, fmt.Errorf("SENDING_PROFILES %q: priority must be high, normal or low, got %q", name, profile.Priority)
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// resolveProfile returns the profile selected by an email with its
+// per-request overrides applied. Without configured profiles the built-in
+// ones are used.
+func resolveProfile(data ProductEmail, profiles map[string]SendingProfile) (SendingProfile, error) {
+	if profiles == nil {
+		profiles = defaultProfiles
+	}
+	name := data.Profile
+	if name == "" {
+		name = defaultProfile
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return profile, fmt.Errorf("%w: unknown profile %q", ErrInvalidProfile, name)
+	}
+
+	if data.Tracking != nil {
+		profile.Tracking = *data.Tracking
+	}
+	if data.UnsubscribeFooter != nil {
+		profile.UnsubscribeFooter = *data.UnsubscribeFooter
+	}
+	if data.Priority != "" {
+		if _, ok := priorityHeaders[data.Priority]; !ok {
+			return profile, fmt.Errorf("%w: priority must be high, normal or low, got %q", ErrInvalidProfile, data.Priority)
+		}
+		profile.Priority = data.Priority
+	}
+	// Mailgun may hold an optimized email for a day, which only suits
+	// tracked marketing emails; profiles honoring unsubscribes are those
+	if data.OptimizeDeliveryTime && !(profile.Tracking && profile.HonorUnsubscribes) {
+		return profile, fmt.Errorf("%w: optimize_delivery_time needs a marketing profile with tracking enabled", ErrInvalidProfile)
+	}
+	return profile, nil
+}
+
+// unsubscribeFooter returns the bodies with a link to unsubscribe appended
+func unsubscribeFooter(text, html, link string) (string, string) {
+	if text != "" {
+		text = strings.TrimRight(text, "\n") + "\n\nTo unsubscribe, visit " + link + "\n"
+	}
+	if html != "" {
+		footer := `<p style="font-size: 12px; color: #999999;"><a href="` + htmlpkg.EscapeString(link) + `">Unsubscribe</a></p>`
+		if i := strings.LastIndex(strings.ToLower(html), "</body>"); i >= 0 {
+			html = html[:i] + footer + "\n" + html[i:]
+		} else {
+			html += footer
+		}
+	}
+	return text, html
+}
+
+// applyProfile sets the tracking option and priority headers of a message
+func applyProfile(message *mailgun.Message, profile SendingProfile) {
+	message.SetTracking(profile.Tracking)
+	if priority := priorityHeaders[profile.Priority]; priority != "" {
+		message.AddHeader("X-Priority", priority)
+		message.AddHeader("Importance", profile.Priority)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOptimizeDeliveryTime(t *testing.T) {
+	untracked := false
+	tests := []struct {
+		name    string
+		data    ProductEmail
+		period  string
+		invalid bool
+	}{
+		{name: "marketing, requested", data: ProductEmail{Profile: "marketing", OptimizeDeliveryTime: true}, period: stoPeriod},
+		{name: "marketing, not requested", data: ProductEmail{Profile: "marketing"}},
+		{name: "transactional", data: ProductEmail{OptimizeDeliveryTime: true}, invalid: true},
+		{name: "marketing without tracking", data: ProductEmail{Profile: "marketing", Tracking: &untracked, OptimizeDeliveryTime: true}, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			tt.data.RecipientEmail = "alex@example.com"
+			tt.data.ProductName = "Desk Lamp"
+
+			_, err := service.SendProductEmail(context.Background(), tt.data)
+			if tt.invalid {
+				if !errors.Is(err, ErrInvalidProfile) {
+					t.Fatalf("err = %v, want ErrInvalidProfile", err)
+				}
+				if len(sender.sent()) != 0 {
+					t.Error("an invalid email was sent")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sender.last(t).STOPeriod(); got != tt.period {
+				t.Errorf("o:deliverytime-optimize-period = %q, want %q", got, tt.period)
+			}
+		})
+	}
+}
+
+func TestParseSendingProfiles(t *testing.T) {
+	profiles, err := parseSendingProfiles(`{"marketing": {"priority": "low"}, "digest": {"tracking": true}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fields left out keep the built-in values
+	if want := (SendingProfile{Tracking: true, UnsubscribeFooter: true, Priority: "low", HonorUnsubscribes: true}); profiles["marketing"] != want {
+		t.Errorf("marketing = %+v, want %+v", profiles["marketing"], want)
+	}
+	if want := (SendingProfile{Tracking: true, Priority: "normal", HonorUnsubscribes: true}); profiles["digest"] != want {
+		t.Errorf("digest = %+v, want %+v", profiles["digest"], want)
+	}
+	if profiles["transactional"] != defaultProfiles["transactional"] {
+		t.Errorf("transactional = %+v, want the built-in profile", profiles["transactional"])
+	}
+
+	for _, raw := range []string{`{"marketing": {"priority": "urgent"}}`, `{"marketing": []}`, `not json`} {
+		if _, err := parseSendingProfiles(raw); err == nil {
+			t.Errorf("parseSendingProfiles(%q) accepted", raw)
+		}
+	}
+}
+
+func TestSendingProfiles(t *testing.T) {
+	tracked, untracked, withoutFooter := true, false, false
+	tests := []struct {
+		name     string
+		data     ProductEmail
+		tracking bool
+		priority string
+		footer   bool
+	}{
+		{name: "transactional by default", priority: "1"},
+		{name: "marketing", data: ProductEmail{Profile: "marketing"}, tracking: true, footer: true},
+		{name: "marketing overridden", data: ProductEmail{Profile: "marketing", Tracking: &untracked, UnsubscribeFooter: &withoutFooter, Priority: "low"}, priority: "5"},
+		{name: "transactional with tracking", data: ProductEmail{Tracking: &tracked}, tracking: true, priority: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			tt.data.RecipientEmail = "alex@example.com"
+			tt.data.ProductName = "Desk Lamp"
+			if _, err := service.SendProductEmail(context.Background(), tt.data); err != nil {
+				t.Fatal(err)
+			}
+
+			message := sender.last(t)
+			if tracking := message.Tracking(); tracking == nil || *tracking != tt.tracking {
+				t.Errorf("tracking = %v, want %v", tracking, tt.tracking)
+			}
+			if got := message.Headers()["X-Priority"]; got != tt.priority {
+				t.Errorf("X-Priority = %q, want %q", got, tt.priority)
+			}
+			text := plain(t, message).Text()
+			if footer := strings.Contains(text, "To unsubscribe, visit"); footer != tt.footer {
+				t.Errorf("unsubscribe footer = %v, want %v:\n%s", footer, tt.footer, text)
+			}
+		})
+	}
+
+	service, _ := newTestService(t, nil)
+	if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Profile: "newsletter"}); !errors.Is(err, ErrInvalidProfile) {
+		t.Errorf("unknown profile err = %v, want ErrInvalidProfile", err)
+	}
+}
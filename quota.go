@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quotaWindow is the rolling window used for daily send limits
+const quotaWindow = 24 * time.Hour
+
+// globalQuotaKey counts every send against the global daily cap. It can't
+// collide with a recipient key, which always contains an @.
+const globalQuotaKey = "*"
+
+// QuotaStore tracks sends per key within a rolling window. It is an
+// interface so the in-memory implementation can be swapped for a shared
+// backend such as Redis.
+type QuotaStore interface {
+	// Reserve records a send for key at now when fewer than limit sends
+	// happened in the preceding window. When the limit is reached it
+	// returns false and the time at which the next send becomes possible.
+	Reserve(key string, limit int, window time.Duration, now time.Time) (bool, time.Time, error)
+	// Release removes a send previously recorded at the given time, e.g.
+	// when delivery to Mailgun failed.
+	Release(key string, at time.Time) error
+	// Usage returns how many sends were recorded for key in the window
+	// ending at now and when the oldest of them leaves the window.
+	Usage(key string, window time.Duration, now time.Time) (int, time.Time, error)
+}
+
+// QuotaExceededError is returned when a recipient reached their send limit,
+// with Tag set when a tag reached its cap, or with neither set when the
+// global daily cap was reached
+type QuotaExceededError struct {
+	Recipient string
+	Tag       string
+	RetryAt   time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Tag != "" {
+		return fmt.Sprintf("tag %s has reached its daily email limit; sending resumes after %s",
+			e.Tag, e.RetryAt.UTC().Format(time.RFC3339))
+	}
+	if e.Recipient == "" {
+		return fmt.Sprintf("the daily email limit has been reached; sending resumes after %s",
+			e.RetryAt.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("recipient %s has reached the daily email limit; they can be emailed again after %s",
+		e.Recipient, e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// MemoryQuotaStore is an in-process QuotaStore. Keys whose sends all left
+// the window are swept once per window, so recipients that are never
+// emailed again don't accumulate.
+type MemoryQuotaStore struct {
+	mu        sync.Mutex
+	sends     map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryQuotaStore creates an empty in-memory quota store
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{
+		sends: make(map[string][]time.Time),
+	}
+}
+
+// Reserve implements QuotaStore
+func (s *MemoryQuotaStore) Reserve(key string, limit int, window time.Duration, now time.Time) (bool, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(window, now)
+
+	// Drop sends that fell out of the window
+	cutoff := now.Add(-window)
+	recent := s.sends[key][:0]
+	for _, at := range s.sends[key] {
+		if at.After(cutoff) {
+			recent = append(recent, at)
+		}
+	}
+
+	if len(recent) >= limit {
+		s.sends[key] = recent
+		return false, recent[len(recent)-limit].Add(window), nil
+	}
+
+	s.sends[key] = append(recent, now)
+	return true, time.Time{}, nil
+}
+
+// sweep deletes the keys without a send in the window ending at now. It
+// runs at most once per window and must be called with s.mu held.
+func (s *MemoryQuotaStore) sweep(window time.Duration, now time.Time) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+	cutoff := now.Add(-window)
+	for key, sends := range s.sends {
+		if !slices.ContainsFunc(sends, func(at time.Time) bool { return at.After(cutoff) }) {
+			delete(s.sends, key)
+		}
+	}
+}
+
+// Usage implements QuotaStore
+func (s *MemoryQuotaStore) Usage(key string, window time.Duration, now time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := now.Add(-window)
+	used := 0
+	var oldest time.Time
+	for _, at := range s.sends[key] {
+		if at.After(cutoff) {
+			if used == 0 || at.Before(oldest) {
+				oldest = at
+			}
+			used++
+		}
+	}
+	if used == 0 {
+		return 0, time.Time{}, nil
+	}
+	return used, oldest.Add(window), nil
+}
+
+// Release implements QuotaStore
+func (s *MemoryQuotaStore) Release(key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sends := s.sends[key]
+	for i := len(sends) - 1; i >= 0; i-- {
+		if sends[i].Equal(at) {
+			s.sends[key] = append(sends[:i], sends[i+1:]...)
+			break
+		}
+	}
+	if len(s.sends[key]) == 0 {
+		delete(s.sends, key)
+	}
+	return nil
+}
+
+// recipientKey normalizes an email address for quota tracking
+func recipientKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// quotaUsage describes the remaining capacity of the cap counted under key
+func (h *Handler) quotaUsage(key string, limit int) (gin.H, error) {
+	used, resetAt, err := h.emailService.quotas.Usage(key, quotaWindow, h.emailService.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	usage := gin.H{
+		"limit":     limit,
+		"used":      used,
+		"remaining": max(limit-used, 0),
+		"resets_at": nil,
+	}
+	if !resetAt.IsZero() {
+		usage["resets_at"] = resetAt.UTC().Format(time.RFC3339)
+	}
+	return usage, nil
+}
+
+// QuotaHandler reports the remaining capacity of the global daily cap and
+// of each tag with a TAG_DAILY_LIMITS cap
+func (h *Handler) QuotaHandler(c *gin.Context) {
+	cfg := h.emailService.cfg()
+	response := gin.H{
+		"limit": "unlimited",
+	}
+	if cfg.MaxEmailsPerDay > 0 {
+		usage, err := h.quotaUsage(globalQuotaKey, cfg.MaxEmailsPerDay)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error":   "Failed to read quota",
+				"details": err.Error(),
+			})
+			return
+		}
+		response = usage
+	}
+
+	if len(cfg.TagDailyLimits) > 0 {
+		tags := make(gin.H, len(cfg.TagDailyLimits))
+		for tag, limit := range cfg.TagDailyLimits {
+			usage, err := h.quotaUsage(tagQuotaKey(tag), limit)
+			if err != nil {
+				c.JSON(500, gin.H{
+					"error":   "Failed to read quota",
+					"details": err.Error(),
+				})
+				return
+			}
+			tags[tag] = usage
+		}
+		response["tags"] = tags
+	}
+	c.JSON(200, response)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecipientQuotaResetsAfterWindow(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"MAX_EMAILS_PER_RECIPIENT_PER_DAY": "2"})
+	clock := newFakeClock()
+	service.SetClock(clock)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+	send := func() int {
+		return do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil).Code
+	}
+
+	first := clock.Now()
+	if code := send(); code != 200 {
+		t.Fatalf("first send status = %d", code)
+	}
+	clock.Advance(time.Hour)
+	if code := send(); code != 200 {
+		t.Fatalf("second send status = %d", code)
+	}
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "Alex@Example.com", "product_name": "Desk Lamp"}`, nil)
+	if w.Code != 429 {
+		t.Fatalf("third send status = %d, want 429 (body %s)", w.Code, w.Body)
+	}
+	var response struct {
+		RetryAt string `json:"retry_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	// The first send leaves the window first
+	if want := first.Add(quotaWindow).Format(time.RFC3339); response.RetryAt != want {
+		t.Errorf("retry_at = %q, want %q", response.RetryAt, want)
+	}
+	if got, want := w.Header().Get("Retry-After"), "82800"; got != want {
+		t.Errorf("Retry-After = %q, want %q", got, want)
+	}
+
+	clock.Advance(quotaWindow - time.Hour - time.Second)
+	if code := send(); code != 429 {
+		t.Errorf("status = %d a second before the window ends, want 429", code)
+	}
+	clock.Advance(time.Second)
+	if code := send(); code != 200 {
+		t.Errorf("status = %d after the window, want 200", code)
+	}
+	if got := len(sender.sent()); got != 3 {
+		t.Errorf("%d messages sent, want 3", got)
+	}
+}
+
+func TestMemoryQuotaStoreSweepsExpiredKeys(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	clock := newFakeClock()
+	for _, key := range []string{"alex@example.com", "sam@example.com"} {
+		if ok, _, _ := store.Reserve(key, 1, time.Hour, clock.Now()); !ok {
+			t.Fatalf("reserve %s failed", key)
+		}
+	}
+
+	clock.Advance(time.Hour)
+	if ok, _, _ := store.Reserve("kim@example.com", 1, time.Hour, clock.Now()); !ok {
+		t.Fatal("reserve failed")
+	}
+	if _, ok := store.sends["alex@example.com"]; ok {
+		t.Error("a key without sends in the window was kept")
+	}
+	if got := len(store.sends); got != 1 {
+		t.Errorf("store holds %d keys, want 1", got)
+	}
+}
+
+func TestGlobalDailyCap(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"MAX_EMAILS_PER_DAY": "2", "MAX_RETRIES": "0"})
+	clock := newFakeClock()
+	service.SetClock(clock)
+	handler := NewHandler(service)
+	router := newTestRouter()
+	router.POST("/send-product", handler.SendProductHandler)
+	router.GET("/quota", handler.QuotaHandler)
+	send := func(recipient string) *httptest.ResponseRecorder {
+		clock.Advance(time.Minute)
+		return do(router, "POST", "/send-product", `{"recipient_email": "`+recipient+`", "product_name": "Desk Lamp"}`, nil)
+	}
+	type usage struct {
+		Limit     int     `json:"limit"`
+		Used      int     `json:"used"`
+		Remaining int     `json:"remaining"`
+		ResetsAt  *string `json:"resets_at"`
+	}
+	quota := func() usage {
+		var got usage
+		if err := json.Unmarshal(do(router, "GET", "/quota", "", nil).Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := quota(); got.Limit != 2 || got.Remaining != 2 || got.ResetsAt != nil {
+		t.Errorf("unused quota = %+v", got)
+	}
+	// A failed send gives its slot back
+	sender.err = errors.New("connection reset")
+	if w := send("alex@example.com"); w.Code != 500 {
+		t.Fatalf("failing send status = %d", w.Code)
+	}
+	sender.err = nil
+	if got := quota(); got.Used != 0 {
+		t.Errorf("used = %d after a failed send, want 0", got.Used)
+	}
+
+	if w := send("alex@example.com"); w.Code != 200 {
+		t.Fatalf("first send status = %d", w.Code)
+	}
+	first := clock.Now()
+	if w := send("sam@example.com"); w.Code != 200 {
+		t.Fatalf("second send status = %d", w.Code)
+	}
+	// Every recipient counts against the same cap
+	w := send("kim@example.com")
+	if w.Code != 429 || !strings.Contains(w.Body.String(), "Daily email limit reached") {
+		t.Fatalf("third send status = %d, body %s", w.Code, w.Body)
+	}
+	resetsAt := first.Add(quotaWindow).UTC().Format(time.RFC3339)
+	if got := quota(); got.Used != 2 || got.Remaining != 0 || got.ResetsAt == nil || *got.ResetsAt != resetsAt {
+		t.Errorf("exhausted quota = %+v, want it to reset at %s", got, resetsAt)
+	}
+
+	clock.Advance(quotaWindow)
+	if w := send("kim@example.com"); w.Code != 200 {
+		t.Errorf("status = %d after the window, want 200", w.Code)
+	}
+}
+
+func TestQuotaHandlerUnlimited(t *testing.T) {
+	service, _ := newTestService(t, nil)
+	router := newTestRouter()
+	router.GET("/quota", NewHandler(service).QuotaHandler)
+	if w := do(router, "GET", "/quota", "", nil); w.Code != 200 || w.Body.String() != `{"limit":"unlimited"}` {
+		t.Errorf("status = %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// wantsRawResponse reports whether Mailgun's raw response should be echoed.
+// It takes the X-Return-Raw header, DEV_MODE and a valid API key.
+func (h *Handler) wantsRawResponse(c *gin.Context) bool {
+	enabled, _ := strconv.ParseBool(c.GetHeader("X-Return-Raw"))
+	cfg := h.emailService.cfg()
+	return enabled && cfg.DevMode && validAPIKey(cfg.APIKeys, requestCredential(c))
+}
+
+// rawMailgunResponse describes Mailgun's reply to a send, or nil when Mailgun
+// wasn't reached. mailgun-go decodes successful replies, so their body is
+// rebuilt from the decoded fields. Secrets are scrubbed from error bodies.
+func rawMailgunResponse(result SendResult, err error, secrets ...string) gin.H {
+	var respErr *mailgun.UnexpectedResponseError
+	if errors.As(err, &respErr) {
+		body := string(respErr.Data)
+		for _, secret := range secrets {
+			if secret != "" {
+				body = strings.ReplaceAll(body, secret, redactSecret(secret))
+			}
+		}
+		return gin.H{
+			"status": respErr.Actual,
+			"body":   body,
+		}
+	}
+	if err != nil || result.ID == "" {
+		return nil
+	}
+	return gin.H{
+		"status": 200,
+		"body": gin.H{
+			"id":      result.ID,
+			"message": result.Response,
+		},
+	}
+}
+
+// addRawResponse adds Mailgun's raw response to a handler response when the
+// caller asked for it and is allowed to see it
+func (h *Handler) addRawResponse(c *gin.Context, response gin.H, result SendResult, err error) {
+	if !h.wantsRawResponse(c) {
+		return
+	}
+	cfg := h.emailService.cfg()
+	if raw := rawMailgunResponse(result, err, cfg.ApiKey, cfg.WebhookSigningKey); raw != nil {
+		response["mailgun_raw"] = raw
+	}
+}
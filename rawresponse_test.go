@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+func TestReturnRawResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		devMode string
+		headers map[string]string
+		raw     bool
+	}{
+		{name: "requested in dev mode", devMode: "true", headers: map[string]string{"X-Return-Raw": "true", "X-API-Key": "caller-key"}, raw: true},
+		{name: "bearer key", devMode: "true", headers: map[string]string{"X-Return-Raw": "1", "Authorization": "Bearer caller-key"}, raw: true},
+		{name: "not requested", devMode: "true", headers: map[string]string{"X-API-Key": "caller-key"}},
+		{name: "outside dev mode", devMode: "false", headers: map[string]string{"X-Return-Raw": "true", "X-API-Key": "caller-key"}},
+		{name: "wrong key", devMode: "true", headers: map[string]string{"X-Return-Raw": "true", "X-API-Key": "other-key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, map[string]string{"DEV_MODE": tt.devMode, "API_KEYS": "caller-key"})
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, tt.headers)
+			if w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			var response struct {
+				Raw *struct {
+					Status int `json:"status"`
+					Body   struct {
+						ID      string `json:"id"`
+						Message string `json:"message"`
+					} `json:"body"`
+				} `json:"mailgun_raw"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if (response.Raw != nil) != tt.raw {
+				t.Fatalf("mailgun_raw present = %v, want %v (body %s)", response.Raw != nil, tt.raw, w.Body)
+			}
+			if tt.raw && (response.Raw.Status != 200 || response.Raw.Body.ID != "<1@mg.example.com>" || response.Raw.Body.Message != "Queued. Thank you.") {
+				t.Errorf("mailgun_raw = %+v", *response.Raw)
+			}
+		})
+	}
+}
+
+func TestReturnRawErrorResponseScrubsSecrets(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"DEV_MODE": "true", "API_KEYS": "caller-key", "MAX_RETRIES": "0"})
+	sender.err = &mailgun.UnexpectedResponseError{
+		Expected: []int{200},
+		Actual:   401,
+		Data:     []byte(`{"message": "Invalid private key key-test"}`),
+	}
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`,
+		map[string]string{"X-Return-Raw": "true", "X-API-Key": "caller-key"})
+	if w.Code != 500 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var response struct {
+		Raw struct {
+			Status int    `json:"status"`
+			Body   string `json:"body"`
+		} `json:"mailgun_raw"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Raw.Status != 401 || !strings.Contains(response.Raw.Body, "Invalid private key") {
+		t.Errorf("mailgun_raw = %+v", response.Raw)
+	}
+	if strings.Contains(response.Raw.Body, "key-test") {
+		t.Errorf("the Mailgun API key leaked: %s", response.Raw.Body)
+	}
+}
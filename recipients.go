@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// ErrTooManyRecipients is returned when a message would exceed MAX_RECIPIENTS
+var ErrTooManyRecipients = errors.New("too many recipients")
+
+// recipientLists returns the To, CC and BCC recipients of message
+func recipientLists(message *mailgun.Message) [][]string {
+	lists := [][]string{message.To()}
+	if plain, ok := message.Specific.(*mailgun.PlainMessage); ok {
+		lists = append(lists, plain.CC(), plain.BCC())
+	}
+	return lists
+}
+
+// checkRecipientCount enforces the cap on the combined To, CC and BCC
+// recipients of a message. addBCC skips duplicates, so each address counts
+// once. A zero limit disables the check.
+func checkRecipientCount(message *mailgun.Message, limit int) error {
+	count := 0
+	for _, list := range recipientLists(message) {
+		count += len(list)
+	}
+	if limit > 0 && count > limit {
+		return fmt.Errorf("%w: %d exceeds the limit of %d", ErrTooManyRecipients, count, limit)
+	}
+	return nil
+}
+
+// hasRecipient reports whether address is already a To, CC or BCC recipient
+func hasRecipient(message *mailgun.Message, address string) bool {
+	key := recipientKey(address)
+	for _, list := range recipientLists(message) {
+		for _, existing := range list {
+			if recipientKey(existing) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addBCC adds address as a blind copy unless it already receives the
+// message, reporting whether it was added
+func addBCC(message *mailgun.Message, address string) bool {
+	if address == "" || hasRecipient(message, address) {
+		return false
+	}
+	message.AddBCC(address)
+	return true
+}
+
+// parseArchiveBCCByCategory parses the ARCHIVE_BCC_BY_CATEGORY JSON object
+// mapping categories to their archive addresses
+func parseArchiveBCCByCategory(raw string) (map[string][]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var archives map[string][]string
+	if err := json.Unmarshal([]byte(raw), &archives); err != nil {
+		return nil, fmt.Errorf("invalid ARCHIVE_BCC_BY_CATEGORY: %w", err)
+	}
+	for category, addresses := range archives {
+		for _, address := range addresses {
+			if strings.LastIndexByte(address, '@') <= 0 {
+				return nil, fmt.Errorf("ARCHIVE_BCC_BY_CATEGORY %q: invalid email %q", category, address)
+			}
+		}
+	}
+	return archives, nil
+}
+
+// archiveBCC returns the archive addresses for a category, falling back to
+// the global archive when the category has no mapping
+func (c Config) archiveBCC(category string) []string {
+	if archives, ok := c.ArchiveBCCByCategory[category]; ok {
+		return archives
+	}
+	return c.ArchiveBCC
+}
+
+// ErrInvalidReplyTo is returned for a reply_to that isn't a valid address
+var ErrInvalidReplyTo = errors.New("invalid reply_to")
+
+// validAddress reports whether address parses as a single email address
+func validAddress(address string) bool {
+	_, err := mail.ParseAddress(address)
+	return err == nil
+}
+
+// parseReplyToByCategory parses the REPLY_TO_BY_CATEGORY JSON object
+// mapping categories to the address replies should go to
+func parseReplyToByCategory(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var replyTo map[string]string
+	if err := json.Unmarshal([]byte(raw), &replyTo); err != nil {
+		return nil, fmt.Errorf("invalid REPLY_TO_BY_CATEGORY: %w", err)
+	}
+	for category, address := range replyTo {
+		if !validAddress(address) {
+			return nil, fmt.Errorf("REPLY_TO_BY_CATEGORY %q: invalid email %q", category, address)
+		}
+	}
+	return replyTo, nil
+}
+
+// replyTo returns the Reply-To address of an email: its reply_to, else the
+// address for its category, else the default REPLY_TO. An empty result
+// leaves replies going to the sender. The address is formatted like the
+// sender, so a display name outside ASCII is RFC 2047 encoded.
+func (c Config) replyTo(data ProductEmail) (string, error) {
+	address := c.ReplyTo
+	if data.ReplyTo != "" {
+		address = data.ReplyTo
+	} else if categoryAddress, ok := c.ReplyToByCategory[data.category()]; ok {
+		address = categoryAddress
+	}
+	if address == "" {
+		return "", nil
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("%w %q", ErrInvalidReplyTo, address)
+	}
+	return formatAddress(parsed.Name, parsed.Address), nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRedirectAllTo(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		to         []string
+		bcc        []string
+		originalTo string
+		original   []string
+	}{
+		{
+			name: "unset",
+			env:  map[string]string{"ARCHIVE_BCC": "archive@example.com"},
+			to:   []string{"alex@example.com"},
+			bcc:  []string{"archive@example.com"},
+		},
+		{
+			name:       "set",
+			env:        map[string]string{"ARCHIVE_BCC": "archive@example.com", "REDIRECT_ALL_TO": "qa@example.com"},
+			to:         []string{"qa@example.com"},
+			originalTo: "alex@example.com, archive@example.com",
+			original:   []string{"alex@example.com", "archive@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil)
+			if w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			message := sender.last(t)
+			if got := message.To(); !slices.Equal(got, tt.to) {
+				t.Errorf("to = %v, want %v", got, tt.to)
+			}
+			if got := plain(t, message).BCC(); !slices.Equal(got, tt.bcc) {
+				t.Errorf("bcc = %v, want %v", got, tt.bcc)
+			}
+			if got := message.Headers()["X-Original-To"]; got != tt.originalTo {
+				t.Errorf("X-Original-To = %q, want %q", got, tt.originalTo)
+			}
+
+			var response struct {
+				OriginalRecipients []string `json:"original_recipients"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(response.OriginalRecipients, tt.original) {
+				t.Errorf("original_recipients = %v, want %v", response.OriginalRecipients, tt.original)
+			}
+		})
+	}
+}
+
+func TestRedirectAllToIsValidated(t *testing.T) {
+	t.Setenv("MAILGUN_DOMAIN", "mg.example.com")
+	t.Setenv("MAILGUN_API_KEY", "key-test")
+	t.Setenv("REDIRECT_ALL_TO", "not an address")
+	if _, _, err := ResolveConfig(nil); err == nil {
+		t.Error("invalid REDIRECT_ALL_TO accepted")
+	}
+}
+
+func TestReplyToRouting(t *testing.T) {
+	env := map[string]string{
+		"REPLY_TO":             "hello@example.com",
+		"REPLY_TO_BY_CATEGORY": `{"sales": "Sales <sales@example.com>", "support": "Süpport Team <support@example.com>"}`,
+	}
+	tests := []struct {
+		name string
+		data ProductEmail
+		want string
+	}{
+		{name: "category", data: ProductEmail{Category: "sales"}, want: `"Sales" <sales@example.com>`},
+		{name: "encoded name", data: ProductEmail{Category: "support"}, want: "=?utf-8?q?S=C3=BCpport_Team?= <support@example.com>"},
+		{name: "default", data: ProductEmail{Category: "product"}, want: "<hello@example.com>"},
+		{name: "request overrides category", data: ProductEmail{Category: "sales", ReplyTo: "Jo <jo@example.com>"}, want: `"Jo" <jo@example.com>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, env)
+			tt.data.RecipientEmail = "alex@example.com"
+			tt.data.ProductName = "Desk Lamp"
+			if _, err := service.SendProductEmail(context.Background(), tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if got := sender.last(t).Headers()["Reply-To"]; got != tt.want {
+				t.Errorf("Reply-To = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyToRejectsInvalidAddresses(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	_, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", ReplyTo: "not an address"})
+	if !errors.Is(err, ErrInvalidReplyTo) {
+		t.Errorf("err = %v, want ErrInvalidReplyTo", err)
+	}
+	if len(sender.sent()) != 0 {
+		t.Error("sent with an invalid Reply-To")
+	}
+
+	t.Setenv("REPLY_TO_BY_CATEGORY", `{"sales": "nope"}`)
+	if _, _, err := ResolveConfig(nil); err == nil {
+		t.Error("invalid REPLY_TO_BY_CATEGORY address accepted at startup")
+	}
+}
+
+func TestArchiveCopiesSkipDuplicateRecipients(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"ARCHIVE_BCC": "alex@example.com, archive@example.com, Archive@Example.com"})
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "Alex@Example.com", "product_name": "Desk Lamp"}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if got, want := plain(t, sender.last(t)).BCC(), []string{"archive@example.com"}; !slices.Equal(got, want) {
+		t.Errorf("bcc = %v, want %v", got, want)
+	}
+	var response struct {
+		DuplicateRecipients []string `json:"duplicate_recipients"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alex@example.com", "Archive@Example.com"}; !slices.Equal(response.DuplicateRecipients, want) {
+		t.Errorf("duplicate_recipients = %v, want %v", response.DuplicateRecipients, want)
+	}
+}
+
+func TestArchiveBCC(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		bcc  []string
+	}{
+		{name: "unset"},
+		{name: "one archive", env: map[string]string{"ARCHIVE_BCC": "archive@example.com"}, bcc: []string{"archive@example.com"}},
+		{name: "several archives", env: map[string]string{"ARCHIVE_BCC": "archive@example.com, legal@example.com"}, bcc: []string{"archive@example.com", "legal@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}); err != nil {
+				t.Fatal(err)
+			}
+			message := sender.last(t)
+			if got := plain(t, message).BCC(); !slices.Equal(got, tt.bcc) {
+				t.Errorf("bcc = %v, want %v", got, tt.bcc)
+			}
+			if got := message.To(); !slices.Equal(got, []string{"alex@example.com"}) {
+				t.Errorf("to = %v, the archive must not be a visible recipient", got)
+			}
+		})
+	}
+}
+
+func TestArchiveBCCByCategory(t *testing.T) {
+	env := map[string]string{
+		"ARCHIVE_BCC":             "archive@example.com",
+		"ARCHIVE_BCC_BY_CATEGORY": `{"invoice": ["finance@example.com", "legal@example.com"], "marketing": []}`,
+	}
+	tests := []struct {
+		category string
+		bcc      []string
+	}{
+		{category: "", bcc: []string{"archive@example.com"}},
+		{category: "invoice", bcc: []string{"finance@example.com", "legal@example.com"}},
+		// An empty list opts the category out of archiving
+		{category: "marketing"},
+		{category: "newsletter", bcc: []string{"archive@example.com"}},
+	}
+	service, sender := newTestService(t, env)
+	for _, tt := range tests {
+		if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Category: tt.category}); err != nil {
+			t.Fatal(err)
+		}
+		if got := plain(t, sender.last(t)).BCC(); !slices.Equal(got, tt.bcc) {
+			t.Errorf("category %q: bcc = %v, want %v", tt.category, got, tt.bcc)
+		}
+	}
+}
+
+func TestParseArchiveBCCByCategory(t *testing.T) {
+	for _, raw := range []string{`not json`, `{"invoice": ["finance"]}`, `{"invoice": "finance@example.com"}`} {
+		if _, err := parseArchiveBCCByCategory(raw); err == nil {
+			t.Errorf("parseArchiveBCCByCategory(%q) succeeded", raw)
+		}
+	}
+	if archives, err := parseArchiveBCCByCategory(""); err != nil || archives != nil {
+		t.Errorf("empty value = %v, %v", archives, err)
+	}
+}
+
+func TestMaxRecipients(t *testing.T) {
+	archives := "archive@example.com, legal@example.com"
+	tests := []struct {
+		name   string
+		env    map[string]string
+		status int
+	}{
+		{name: "within the limit", env: map[string]string{"ARCHIVE_BCC": archives, "MAX_RECIPIENTS": "3"}, status: 200},
+		{name: "archive copies count", env: map[string]string{"ARCHIVE_BCC": archives, "MAX_RECIPIENTS": "2"}, status: 400},
+		// The recipient's own archive copy is dropped, so it counts once
+		{name: "duplicates count once", env: map[string]string{"ARCHIVE_BCC": "alex@example.com, archive@example.com", "MAX_RECIPIENTS": "2"}, status: 200},
+		{name: "disabled", env: map[string]string{"ARCHIVE_BCC": archives, "MAX_RECIPIENTS": "0"}, status: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if sent := len(sender.sent()) == 1; sent != (tt.status == 200) {
+				t.Errorf("sent = %v with status %d", sent, w.Code)
+			}
+			if tt.status == 400 && !strings.Contains(w.Body.String(), "3 exceeds the limit of 2") {
+				t.Errorf("body = %s, want the count and limit", w.Body)
+			}
+		})
+	}
+}
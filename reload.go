@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	texttemplate "text/template"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+// cfg returns a snapshot of the current configuration
+func (s *EmailService) cfg() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// bodyTemplates returns the current text and HTML body templates
+func (s *EmailService) bodyTemplates() (*texttemplate.Template, *htmltemplate.Template) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.textTemplate, s.htmlTemplate
+}
+
+// bodyTemplatesFor returns the body templates of the named template set,
+// falling back to the default templates for an empty name or a template
+// the set doesn't have
+func (s *EmailService) bodyTemplatesFor(name string) (*texttemplate.Template, *htmltemplate.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	textTemplate, htmlTemplate := s.textTemplate, s.htmlTemplate
+	if name == "" {
+		return textTemplate, htmlTemplate, nil
+	}
+	set, ok := s.templateSets[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+	if set.text != nil {
+		textTemplate = set.text
+	}
+	if set.html != nil {
+		htmlTemplate = set.html
+	}
+	return textTemplate, htmlTemplate, nil
+}
+
+// subjectTmpl returns the current subject template, if any
+func (s *EmailService) subjectTmpl() *texttemplate.Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subjectTemplate
+}
+
+// applyReloadable copies the settings that may change at runtime from next.
+// Secrets (API keys, signing keys) and settings wired at startup such as the
+// port, region or audit log path are deliberately left untouched.
+func (c *Config) applyReloadable(next Config) {
+	c.FromName = next.FromName
+	c.FromEmail = next.FromEmail
+	c.FromIdentities = next.FromIdentities
+	c.MailgunDomains = next.MailgunDomains
+	c.SubjectTemplate = next.SubjectTemplate
+	c.EnvSubjectPrefix = next.EnvSubjectPrefix
+	c.DefaultLocale = next.DefaultLocale
+	c.SupportedLocales = next.SupportedLocales
+	c.InlineCSS = next.InlineCSS
+	c.AutoPlainText = next.AutoPlainText
+	c.TextWrapWidth = next.TextWrapWidth
+	c.PublicBaseURL = next.PublicBaseURL
+	c.ReviewServiceURL = next.ReviewServiceURL
+	c.ReviewTTL = next.ReviewTTL
+	c.SendingProfiles = next.SendingProfiles
+	c.ArchiveBCC = next.ArchiveBCC
+	c.ArchiveBCCByCategory = next.ArchiveBCCByCategory
+	c.ReplyTo = next.ReplyTo
+	c.ReplyToByCategory = next.ReplyToByCategory
+	c.RecipientNormalization = next.RecipientNormalization
+	c.SpamCheck = next.SpamCheck
+	c.StorageURLLookup = next.StorageURLLookup
+	c.StorageURLTimeout = next.StorageURLTimeout
+	c.SpamRules = next.SpamRules
+	c.MaxEmailsPerRecipientPerDay = next.MaxEmailsPerRecipientPerDay
+	c.MaxEmailsPerDay = next.MaxEmailsPerDay
+	c.TagDailyLimits = next.TagDailyLimits
+	c.JSONLimits = next.JSONLimits
+	c.MaxMessageBytes = next.MaxMessageBytes
+	c.MaxRecipients = next.MaxRecipients
+	c.StreamMaxRecipients = next.StreamMaxRecipients
+	c.CSVMaxBytes = next.CSVMaxBytes
+	c.CSVMaxRows = next.CSVMaxRows
+	c.BulkValidationMaxAddresses = next.BulkValidationMaxAddresses
+	c.MaxRetries = next.MaxRetries
+	c.RetryBackoff = next.RetryBackoff
+	c.DKIMSelectors = next.DKIMSelectors
+	c.DKIMVerifyDNS = next.DKIMVerifyDNS
+	c.AdminEmail = next.AdminEmail
+}
+
+// Reload applies the non-secret settings of next to the running service
+func (s *EmailService) Reload(next Config) error {
+	subjectTemplate, err := parseSubjectTemplate(next.SubjectTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid SUBJECT_TEMPLATE: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.applyReloadable(next)
+	s.subjectTemplate = subjectTemplate
+	return nil
+}
+
+// ReloadConfigHandler re-reads the .env file and environment and applies the
+// non-secret settings without a restart
+func (h *Handler) ReloadConfigHandler(c *gin.Context) {
+	// Values from .env take precedence so edits to the file are picked up
+	if err := godotenv.Overload(); err != nil {
+		slog.Debug("no .env file to reload", "error", err)
+	}
+
+	next, err := LoadConfig()
+	if err == nil {
+		err = h.emailService.Reload(next)
+	}
+	if err == nil {
+		// Picks up templates edited through another instance
+		err = h.emailService.LoadTemplates(c.Request.Context())
+	}
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error":   "Failed to reload configuration",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	slog.Info("configuration reloaded")
+	c.JSON(200, h.emailService.cfg().redact())
+}
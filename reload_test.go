@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReloadAppliesSubjectTemplateToNextSend(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{
+		"SUBJECT_TEMPLATE": "New: {{.ProductName}}",
+		"API_KEYS":         "old-key",
+	})
+	router := newTestRouter()
+	router.POST("/admin/config/reload", NewHandler(service).ReloadConfigHandler)
+	send := func() string {
+		t.Helper()
+		if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}); err != nil {
+			t.Fatal(err)
+		}
+		return plain(t, sender.last(t)).Subject()
+	}
+	if got, want := send(), "New: Desk Lamp"; got != want {
+		t.Fatalf("subject = %q, want %q", got, want)
+	}
+
+	t.Setenv("SUBJECT_TEMPLATE", "Just in: {{.ProductName}}")
+	t.Setenv("API_KEYS", "new-key")
+	if w := do(router, "POST", "/admin/config/reload", "", nil); w.Code != 200 {
+		t.Fatalf("reload status = %d, body %s", w.Code, w.Body)
+	}
+	if got, want := send(), "Just in: Desk Lamp"; got != want {
+		t.Errorf("subject after reload = %q, want %q", got, want)
+	}
+	// Secrets need a restart
+	if keys := service.cfg().APIKeys; len(keys) != 1 || keys[0] != "old-key" {
+		t.Errorf("API keys = %q after reload, want them unchanged", keys)
+	}
+
+	// A broken template is rejected and the running one kept
+	t.Setenv("SUBJECT_TEMPLATE", "{{.ProductName")
+	if w := do(router, "POST", "/admin/config/reload", "", nil); w.Code != 400 {
+		t.Errorf("reload of a broken template: status = %d, want 400", w.Code)
+	}
+	if got, want := send(), "Just in: Desk Lamp"; got != want {
+		t.Errorf("subject after a failed reload = %q, want %q", got, want)
+	}
+}
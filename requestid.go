@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDKey is the gin context key holding the request id
+const requestIDKey = "request_id"
+
+// maxRequestIDLength bounds caller supplied request ids
+const maxRequestIDLength = 128
+
+// RequestID assigns every request an id, reusing a valid X-Request-ID header
+// from the caller, and echoes it back in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !validRequestID(id) {
+			id = randomID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
+// requestID returns the id assigned by the RequestID middleware
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// validRequestID accepts short ids made of printable ASCII characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// randomID generates a random hex identifier
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Recovery turns handler panics into a structured JSON 500. The stack trace
+// is logged but never sent to the client.
+func Recovery(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				logger.Error("panic recovered",
+					"request_id", requestID(c),
+					"panic", rec,
+					"stack", string(debug.Stack()),
+				)
+				if c.Writer.Written() {
+					c.Abort()
+					return
+				}
+				c.AbortWithStatusJSON(500, gin.H{
+					"error":      "internal server error",
+					"request_id": requestID(c),
+				})
+			}
+		}()
+		c.Next()
+	}
+}
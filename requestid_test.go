@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecoveryReturnsStructuredError(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	router := newTestRouter()
+	router.Use(RequestID(), Recovery(logger))
+	router.GET("/panic", func(c *gin.Context) { panic("secret internal state") })
+	router.GET("/panic-after-write", func(c *gin.Context) {
+		c.String(200, "partial")
+		panic("late failure")
+	})
+
+	w := do(router, "GET", "/panic", "", map[string]string{"X-Request-ID": "req-123"})
+	if w.Code != 500 {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	var response struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("body %s isn't JSON: %v", w.Body, err)
+	}
+	if response.Error != "internal server error" || response.RequestID != "req-123" {
+		t.Errorf("response = %+v", response)
+	}
+	if strings.Contains(w.Body.String(), "secret internal state") || strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("the panic leaked to the client: %s", w.Body)
+	}
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Panic     string `json:"panic"`
+		Stack     string `json:"stack"`
+	}
+	if err := json.Unmarshal([]byte(strings.SplitN(logs.String(), "\n", 2)[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Msg != "panic recovered" || entry.RequestID != "req-123" || entry.Panic != "secret internal state" || !strings.Contains(entry.Stack, "goroutine") {
+		t.Errorf("log entry = %+v", entry)
+	}
+
+	// A response already started is left as is
+	w = do(router, "GET", "/panic-after-write", "", nil)
+	if w.Code != 200 || w.Body.String() != "partial" {
+		t.Errorf("status = %d, body %q after a late panic", w.Code, w.Body)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router := newTestRouter()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) { c.String(200, requestID(c)) })
+
+	tests := []struct {
+		name   string
+		header string
+		reused bool
+	}{
+		{name: "generated"},
+		{name: "caller id reused", header: "req-123", reused: true},
+		{name: "spaces rejected", header: "req 123"},
+		{name: "too long rejected", header: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(router, "GET", "/", "", map[string]string{"X-Request-ID": tt.header})
+			id := w.Header().Get("X-Request-ID")
+			if id == "" || id != w.Body.String() {
+				t.Fatalf("header %q, handler saw %q", id, w.Body)
+			}
+			if reused := id == tt.header; reused != tt.reused {
+				t.Errorf("id = %q, reused %v, want %v", id, reused, tt.reused)
+			}
+		})
+	}
+}
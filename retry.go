@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// maxRetriesLimit bounds the per-request X-Max-Retries override
+const maxRetriesLimit = 5
+
+// maxRetriesKey is the context key carrying a per-request retry budget
+type maxRetriesKey struct{}
+
+// withMaxRetries overrides the retry budget for sends made with ctx
+func withMaxRetries(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, maxRetriesKey{}, retries)
+}
+
+// maxRetriesFrom returns the retry budget carried by ctx, or the fallback
+func maxRetriesFrom(ctx context.Context, fallback int) int {
+	if retries, ok := ctx.Value(maxRetriesKey{}).(int); ok {
+		return retries
+	}
+	return fallback
+}
+
+// parseMaxRetries validates an X-Max-Retries header value
+func parseMaxRetries(value string) (int, error) {
+	retries, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || retries < 0 || retries > maxRetriesLimit {
+		return 0, fmt.Errorf("X-Max-Retries must be an integer between 0 and %d", maxRetriesLimit)
+	}
+	return retries, nil
+}
+
+// RetryClassifier decides whether a failed send is worth retrying
+type RetryClassifier func(error) bool
+
+// DefaultIsRetryable treats network failures, 429s and 5xx responses from
+// Mailgun as transient. Cancellation and our own deadlines are never retried.
+func DefaultIsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var respErr *mailgun.UnexpectedResponseError
+	if errors.As(err, &respErr) {
+		return respErr.Actual == 429 || respErr.Actual >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// SetRetryClassifier overrides which send errors are retried. A nil
+// classifier restores DefaultIsRetryable.
+func (s *EmailService) SetRetryClassifier(classifier RetryClassifier) {
+	if classifier == nil {
+		classifier = DefaultIsRetryable
+	}
+	s.isRetryable = classifier
+}
+
+// replayableBody is an in-memory message part that rewinds when closed.
+// mailgun-go reads and closes reader parts on every send, so a retry of
+// the same message uploads the whole part again instead of nothing.
+type replayableBody struct {
+	*bytes.Reader
+}
+
+// newReplayableBody returns a reader part of data that survives retries
+func newReplayableBody(data []byte) io.ReadCloser {
+	return replayableBody{bytes.NewReader(data)}
+}
+
+// Close implements io.Closer by rewinding to the start
+func (b replayableBody) Close() error {
+	_, err := b.Seek(0, io.SeekStart)
+	return err
+}
+
+// sendWithRetry sends the message through sender, retrying retryable
+// failures up to maxRetries times with exponential backoff
+func (s *EmailService) sendWithRetry(ctx context.Context, sender Sender, message *mailgun.Message, maxRetries int) (string, string, error) {
+	delay := s.cfg().RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if s.limiter != nil {
+			if err := s.limiter.Wait(ctx); err != nil {
+				return "", "", err
+			}
+		}
+		resp, id, err := sender.Send(ctx, message)
+		if s.limiter != nil && isRateLimited(err) {
+			s.limiter.Throttle()
+		}
+		if err == nil || attempt >= maxRetries || !s.isRetryable(err) {
+			return resp, id, err
+		}
+
+		slog.Warn("send failed, retrying", "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		case <-s.clock.After(delay):
+		}
+		delay *= 2
+	}
+}
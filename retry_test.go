@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+func TestDefaultIsRetryable(t *testing.T) {
+	status := func(code int) error {
+		return &mailgun.UnexpectedResponseError{Expected: []int{200}, Actual: code}
+	}
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "success", err: nil},
+		{name: "rate limited", err: status(429), retryable: true},
+		{name: "server error", err: status(500), retryable: true},
+		{name: "bad gateway, wrapped", err: fmt.Errorf("send: %w", status(502)), retryable: true},
+		{name: "bad request", err: status(400)},
+		{name: "unauthorized", err: status(401)},
+		{name: "not found", err: status(404)},
+		{name: "dns timeout", err: &net.DNSError{Err: "timeout", Name: "api.mailgun.net", IsTimeout: true}, retryable: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, retryable: true},
+		{name: "canceled", err: context.Canceled},
+		{name: "deadline", err: fmt.Errorf("send: %w", context.DeadlineExceeded)},
+		{name: "other", err: errors.New("invalid message")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultIsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("DefaultIsRetryable(%v) = %v, want %v", tt.err, got, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestSendRetriesOnlyRetryableErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		classifier RetryClassifier
+		attempts   int
+	}{
+		{name: "transient", err: &mailgun.UnexpectedResponseError{Actual: 503}, attempts: 3},
+		{name: "permanent", err: &mailgun.UnexpectedResponseError{Actual: 400}, attempts: 1},
+		{
+			name:       "custom classifier",
+			err:        errors.New("mailgun: domain is temporarily suspended"),
+			classifier: func(err error) bool { return strings.Contains(err.Error(), "temporarily") },
+			attempts:   3,
+		},
+		{
+			name:       "custom classifier refuses",
+			err:        &mailgun.UnexpectedResponseError{Actual: 503},
+			classifier: func(error) bool { return false },
+			attempts:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{"MAX_RETRIES": "2", "RETRY_BACKOFF": "1ms"})
+			sender.err = tt.err
+			service.SetRetryClassifier(tt.classifier)
+			if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}); err == nil {
+				t.Fatal("send succeeded")
+			}
+			if got := len(sender.sent()); got != tt.attempts {
+				t.Errorf("%d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestMaxRetriesHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		status   int
+		attempts int
+	}{
+		{name: "configured budget", status: 500, attempts: 3},
+		{name: "no retries", header: "0", status: 500, attempts: 1},
+		{name: "raised budget", header: " 4 ", status: 500, attempts: 5},
+		{name: "above the limit", header: "6", status: 400},
+		{name: "negative", header: "-1", status: 400},
+		{name: "not a number", header: "many", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{"MAX_RETRIES": "2", "RETRY_BACKOFF": "1ms"})
+			sender.err = &mailgun.UnexpectedResponseError{Actual: 503}
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["X-Max-Retries"] = tt.header
+			}
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, headers)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if got := len(sender.sent()); got != tt.attempts {
+				t.Errorf("%d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
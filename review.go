@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// ErrReviewUnavailable is returned for emails requiring approval when no
+// review service is configured
+var ErrReviewUnavailable = errors.New("require_approval needs REVIEW_SERVICE_URL to be configured")
+
+// PendingReview is an email held until the review service decides on it
+type PendingReview struct {
+	ID    string
+	Email ProductEmail
+	// Rendered is the content that was reviewed; an approval sends exactly
+	// this rather than rendering the email again
+	Rendered renderedEmail
+	// Overrides and Actor carry the submitting request's context over to
+	// the send made on approval
+	Overrides   sendOverrides
+	Actor       string
+	SubmittedAt time.Time
+	// ExpiresAt is when an undecided review is discarded; zero never
+	ExpiresAt time.Time
+}
+
+// expired reports whether the review can no longer be decided at now
+func (r PendingReview) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// ReviewStore holds the emails pending approval. It is an interface so the
+// in-memory implementation can be swapped for a shared backend.
+type ReviewStore interface {
+	Put(ctx context.Context, review PendingReview) error
+	// Take removes and returns the pending review with the given ID,
+	// reporting false when there is none, so each review is decided once
+	Take(ctx context.Context, id string) (PendingReview, bool, error)
+}
+
+// MemoryReviewStore is a process-local ReviewStore
+type MemoryReviewStore struct {
+	mu      sync.Mutex
+	reviews map[string]PendingReview
+}
+
+// NewMemoryReviewStore creates an empty in-memory review store
+func NewMemoryReviewStore() *MemoryReviewStore {
+	return &MemoryReviewStore{
+		reviews: make(map[string]PendingReview),
+	}
+}
+
+// Put implements ReviewStore. Reviews that expired by the time the new one
+// was submitted are dropped so undecided emails don't pile up.
+func (s *MemoryReviewStore) Put(_ context.Context, review PendingReview) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, pending := range s.reviews {
+		if pending.expired(review.SubmittedAt) {
+			delete(s.reviews, id)
+		}
+	}
+	s.reviews[review.ID] = review
+	return nil
+}
+
+// Take implements ReviewStore
+func (s *MemoryReviewStore) Take(_ context.Context, id string) (PendingReview, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	review, ok := s.reviews[id]
+	delete(s.reviews, id)
+	return review, ok, nil
+}
+
+// SetReviewStore replaces the store holding emails pending approval
+func (s *EmailService) SetReviewStore(reviews ReviewStore) {
+	s.reviews = reviews
+}
+
+// ReviewRequest is the payload posted to the review service
+type ReviewRequest struct {
+	ID          string    `json:"id"`
+	Recipient   string    `json:"recipient"`
+	From        string    `json:"from"`
+	Subject     string    `json:"subject"`
+	Text        string    `json:"text,omitempty"`
+	HTML        string    `json:"html,omitempty"`
+	Category    string    `json:"category"`
+	Tag         string    `json:"tag,omitempty"`
+	Actor       string    `json:"actor,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// SubmitForReview renders data as it would be sent, holds it and posts it
+// to the review service. Emails that would be skipped aren't held; their
+// result is returned instead. An approval sends the reviewed content.
+func (s *EmailService) SubmitForReview(ctx context.Context, data ProductEmail) (PendingReview, SendResult, error) {
+	cfg := s.cfg()
+	if cfg.ReviewServiceURL == "" {
+		return PendingReview{}, SendResult{}, ErrReviewUnavailable
+	}
+	built, result, err := s.buildEmail(ctx, data)
+	if err != nil || built == nil {
+		return PendingReview{}, result, err
+	}
+
+	// The approval request's Accept-Language must not change the email
+	if data.Locale == "" {
+		data.Locale = localeFrom(ctx)
+	}
+	data.RequireApproval = false
+	// The effective mode, so a later MAILGUN_TEST_MODE change doesn't apply
+	overrides := sendOverridesFrom(ctx)
+	overrides.TestMode = &result.TestMode
+	review := PendingReview{
+		ID:          randomID(),
+		Email:       data,
+		Rendered:    built.rendered,
+		Overrides:   overrides,
+		Actor:       actorFrom(ctx),
+		SubmittedAt: s.clock.Now(),
+	}
+	if cfg.ReviewTTL > 0 {
+		review.ExpiresAt = review.SubmittedAt.Add(cfg.ReviewTTL)
+	}
+	plain := built.message.Specific.(*mailgun.PlainMessage)
+	request := ReviewRequest{
+		ID:          review.ID,
+		Recipient:   data.RecipientEmail,
+		From:        plain.From(),
+		Subject:     built.rendered.Subject,
+		Text:        built.rendered.Text,
+		HTML:        built.rendered.HTML,
+		Category:    data.category(),
+		Tag:         data.Tag,
+		Actor:       review.Actor,
+		SubmittedAt: review.SubmittedAt,
+	}
+
+	// Stored first so an immediate approval finds the email
+	if err := s.reviews.Put(ctx, review); err != nil {
+		return PendingReview{}, result, fmt.Errorf("hold email for review: %w", err)
+	}
+	if err := s.postReview(ctx, cfg.ReviewServiceURL, request); err != nil {
+		s.reviews.Take(context.WithoutCancel(ctx), review.ID)
+		return PendingReview{}, result, fmt.Errorf("submit email for review: %w", err)
+	}
+	return review, result, nil
+}
+
+// postReview posts a review request to the review service
+func (s *EmailService) postReview(ctx context.Context, url string, request ReviewRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.reviewClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// submitForReview holds the email of a send request for approval
+func (h *Handler) submitForReview(c *gin.Context, ctx context.Context, productData ProductEmail) {
+	review, result, err := h.emailService.SubmitForReview(ctx, productData)
+	if err != nil {
+		if errors.Is(err, ErrReviewUnavailable) {
+			c.JSON(400, gin.H{
+				"error":   "Invalid request",
+				"details": err.Error(),
+			})
+			return
+		}
+		if writeEmailError(c, err) {
+			return
+		}
+		c.JSON(502, gin.H{
+			"error":   "Failed to submit email for review",
+			"details": err.Error(),
+		})
+		return
+	}
+	if result.Skipped != "" {
+		c.JSON(200, gin.H{
+			"message": "Email skipped",
+			"skipped": result.Skipped,
+		})
+		return
+	}
+
+	slog.Info("email held for review", "review_id", review.ID, "actor", review.Actor, "recipient", maskEmail(productData.RecipientEmail))
+	c.JSON(202, gin.H{
+		"message":   "Email pending approval",
+		"review_id": review.ID,
+	})
+}
+
+// ApproveHandler receives the review service's decision on a pending
+// email, sending it when approved and discarding it when rejected. An
+// approved email whose send fails stays pending.
+func (h *Handler) ApproveHandler(c *gin.Context) {
+	var req struct {
+		Approved *bool  `json:"approved"`
+		Reason   string `json:"reason"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if req.Approved == nil {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	id := c.Param("id")
+	review, ok, err := h.emailService.reviews.Take(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error":   "Failed to read pending review",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !ok {
+		c.JSON(404, gin.H{
+			"error": "No email is pending review with this ID",
+		})
+		return
+	}
+	if review.expired(h.emailService.clock.Now()) {
+		c.JSON(410, gin.H{
+			"error": "The review of this email has expired",
+		})
+		return
+	}
+
+	reviewer := requestActor(c, h.emailService.cfg().APIKeys)
+	if !*req.Approved {
+		slog.Info("email rejected in review", "review_id", id, "reviewer", reviewer, "reason", req.Reason, "recipient", maskEmail(review.Email.RecipientEmail))
+		c.JSON(200, gin.H{
+			"message":   "Email rejected",
+			"review_id": id,
+		})
+		return
+	}
+
+	slog.Info("email approved in review", "review_id", id, "reviewer", reviewer, "recipient", maskEmail(review.Email.RecipientEmail))
+	ctx := review.Overrides.apply(withActor(c.Request.Context(), review.Actor))
+	result, err := h.emailService.SendProductEmail(withStorageURLLookup(withRenderedEmail(ctx, review.Rendered)), review.Email)
+	if err != nil {
+		// The email stays pending so the approval can be retried
+		if putErr := h.emailService.reviews.Put(context.WithoutCancel(ctx), review); putErr != nil {
+			slog.Error("restoring review after a failed send failed", "review_id", id, "error", putErr)
+		}
+	}
+	h.writeSendResult(c, result, err)
+}
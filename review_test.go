@@ -0,0 +1,139 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReviewService records the review requests posted to it
+type fakeReviewService struct {
+	mu       sync.Mutex
+	requests []ReviewRequest
+}
+
+func (f *fakeReviewService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, request)
+	w.WriteHeader(202)
+}
+
+// last returns the most recent review request
+func (f *fakeReviewService) last(t *testing.T) ReviewRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		t.Fatal("nothing was submitted for review")
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func TestReviewDecisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      string
+		decision string
+		advance  time.Duration
+		// sendFails fails the first send of the approved email
+		sendFails bool
+		status    int
+		sent      bool
+	}{
+		{name: "approved", decision: `{"approved": true}`, status: 200, sent: true},
+		{name: "rejected", decision: `{"approved": false, "reason": "pricing is wrong"}`, status: 200},
+		{name: "expired", decision: `{"approved": true}`, advance: time.Hour, status: 410},
+		{name: "no expiry", ttl: "0", decision: `{"approved": true}`, advance: 30 * 24 * time.Hour, status: 200, sent: true},
+		{name: "send failed", decision: `{"approved": true}`, sendFails: true, status: 200, sent: true},
+		{name: "no decision", decision: `{}`, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reviewer := &fakeReviewService{}
+			server := httptest.NewServer(reviewer)
+			defer server.Close()
+			service, sender := newTestService(t, map[string]string{
+				"API_KEYS":           "secret",
+				"REVIEW_SERVICE_URL": server.URL,
+				"REVIEW_TTL":         cmp.Or(tt.ttl, "1h"),
+				"MAX_RETRIES":        "0",
+			})
+			clock := newFakeClock()
+			service.SetClock(clock)
+			handler := NewHandler(service)
+			router := newTestRouter()
+			router.POST("/send-product", handler.SendProductHandler)
+			router.POST("/approve/:id", RequireAPIKey([]string{"secret"}), handler.ApproveHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "subject": "New lamp", "require_approval": true}`, nil)
+			if w.Code != 202 {
+				t.Fatalf("submit status = %d, want 202 (body %s)", w.Code, w.Body)
+			}
+			var pending struct {
+				ReviewID string `json:"review_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &pending); err != nil {
+				t.Fatal(err)
+			}
+			request := reviewer.last(t)
+			if request.ID != pending.ReviewID || request.Recipient != "alex@example.com" || request.Subject != "New lamp" {
+				t.Errorf("review request = %+v, want the pending email", request)
+			}
+			if len(sender.sent()) != 0 {
+				t.Fatal("sent before the review was decided")
+			}
+
+			clock.Advance(tt.advance)
+			if tt.sendFails {
+				sender.err = errors.New("connection reset")
+				if w := do(router, "POST", "/approve/"+pending.ReviewID, tt.decision, map[string]string{"X-API-Key": "secret"}); w.Code != 500 {
+					t.Fatalf("failed send status = %d, want 500 (body %s)", w.Code, w.Body)
+				}
+				sender.err = nil
+				// The reviewer approves again once the send pacing allows
+				clock.Advance(time.Minute)
+			}
+			w = do(router, "POST", "/approve/"+pending.ReviewID, tt.decision, map[string]string{"X-API-Key": "secret"})
+			if w.Code != tt.status {
+				t.Fatalf("decision status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if !tt.sent {
+				if len(sender.sent()) != 0 {
+					t.Error("sent without an approval")
+				}
+				return
+			}
+			email := plain(t, sender.last(t))
+			if email.Subject() != request.Subject || email.Text() != request.Text || email.HTML() != request.HTML {
+				t.Error("the approved email differs from the reviewed content")
+			}
+			if w := do(router, "POST", "/approve/"+pending.ReviewID, tt.decision, map[string]string{"X-API-Key": "secret"}); w.Code != 404 {
+				t.Errorf("second decision status = %d, want 404", w.Code)
+			}
+		})
+	}
+}
+
+func TestReviewNeedsAReviewService(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "require_approval": true}`, nil)
+	if w.Code != 400 {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	if len(sender.sent()) != 0 {
+		t.Error("sent without review")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Values of the X-Send-Mode header
+const (
+	sendModeLive = "live"
+	sendModeTest = "test"
+)
+
+// testModeKey is the context key carrying a per-request test mode override
+type testModeKey struct{}
+
+// withTestMode overrides MAILGUN_TEST_MODE for sends made with ctx
+func withTestMode(ctx context.Context, test bool) context.Context {
+	return context.WithValue(ctx, testModeKey{}, test)
+}
+
+// testModeFrom returns the test mode carried by ctx, or the fallback
+func testModeFrom(ctx context.Context, fallback bool) bool {
+	if test, ok := ctx.Value(testModeKey{}).(bool); ok {
+		return test
+	}
+	return fallback
+}
+
+// sendOverrides are the per-request send settings carried by a context,
+// kept with emails that are sent after their request has finished
+type sendOverrides struct {
+	TestMode   *bool `json:"test_mode,omitempty"`
+	MaxRetries *int  `json:"max_retries,omitempty"`
+}
+
+// sendOverridesFrom returns the X-Send-Mode and X-Max-Retries overrides
+// carried by ctx
+func sendOverridesFrom(ctx context.Context) sendOverrides {
+	var overrides sendOverrides
+	if test, ok := ctx.Value(testModeKey{}).(bool); ok {
+		overrides.TestMode = &test
+	}
+	if retries, ok := ctx.Value(maxRetriesKey{}).(int); ok {
+		overrides.MaxRetries = &retries
+	}
+	return overrides
+}
+
+// apply carries the overrides on ctx again
+func (o sendOverrides) apply(ctx context.Context) context.Context {
+	if o.TestMode != nil {
+		ctx = withTestMode(ctx, *o.TestMode)
+	}
+	if o.MaxRetries != nil {
+		ctx = withMaxRetries(ctx, *o.MaxRetries)
+	}
+	return ctx
+}
+
+// key distinguishes emails with different overrides, e.g. so a test send
+// is never batched with a live one
+func (o sendOverrides) key() string {
+	key := ""
+	if o.TestMode != nil {
+		key += "|mode=" + sendModeName(*o.TestMode)
+	}
+	if o.MaxRetries != nil {
+		key += fmt.Sprintf("|retries=%d", *o.MaxRetries)
+	}
+	return key
+}
+
+// parseSendMode validates an X-Send-Mode header value, reporting whether
+// it selects test mode
+func parseSendMode(header string) (bool, error) {
+	switch header {
+	case sendModeLive:
+		return false, nil
+	case sendModeTest:
+		return true, nil
+	default:
+		return false, fmt.Errorf("must be %s or %s, got %q", sendModeLive, sendModeTest, header)
+	}
+}
+
+// sendModeName names the effective mode of a send in responses
+func sendModeName(test bool) string {
+	if test {
+		return sendModeTest
+	}
+	return sendModeLive
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestSendModeOverride(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		header     string
+		apiKey     string
+		status     int
+		testMode   bool
+	}{
+		{name: "configured live", configured: "false", status: 200},
+		{name: "configured test", configured: "true", status: 200, testMode: true},
+		{name: "live to test", configured: "false", header: "test", apiKey: "secret", status: 200, testMode: true},
+		{name: "test to live", configured: "true", header: "live", apiKey: "secret", status: 200},
+		{name: "without an API key", configured: "true", header: "live", status: 403},
+		{name: "with a wrong API key", configured: "true", header: "live", apiKey: "guess", status: 403},
+		{name: "unknown mode", configured: "true", header: "dry-run", apiKey: "secret", status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{"API_KEYS": "secret", "MAILGUN_TEST_MODE": tt.configured})
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["X-Send-Mode"] = tt.header
+			}
+			if tt.apiKey != "" {
+				headers["X-API-Key"] = tt.apiKey
+			}
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, headers)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				if len(sender.sent()) != 0 {
+					t.Error("sent despite the rejected override")
+				}
+				return
+			}
+
+			if got := sender.last(t).TestMode(); got != tt.testMode {
+				t.Errorf("message test mode = %v, want %v", got, tt.testMode)
+			}
+			var response struct {
+				SendMode string `json:"send_mode"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if want := sendModeName(tt.testMode); response.SendMode != want {
+				t.Errorf("send_mode = %q, want %q", response.SendMode, want)
+			}
+		})
+	}
+}
+
+func TestSendModeKeepsDigestsApart(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"DIGEST_WINDOW": "1h"})
+	ctx := context.Background()
+	queueDigest(t, service, ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"})
+	if queued := queueDigest(t, service, withTestMode(ctx, true), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Bookshelf"}); queued != 1 {
+		t.Errorf("queued = %d, want a test send kept out of the live digest", queued)
+	}
+	if err := service.FlushDigests(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	modes := map[bool]int{}
+	for _, message := range sender.sent() {
+		modes[message.TestMode()]++
+	}
+	if modes[false] != 1 || modes[true] != 1 {
+		t.Errorf("sent %d live and %d test messages, want one of each", modes[false], modes[true])
+	}
+}
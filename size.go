@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// mailgunMaxMessageBytes is the largest message Mailgun accepts
+const mailgunMaxMessageBytes = 25 << 20
+
+// Rough allowances for the headers of the message and of each MIME part
+const (
+	messageHeaderOverhead = 2048
+	mimePartOverhead      = 256
+)
+
+// MessageTooLargeError is returned when a message would exceed the size limit
+type MessageTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message too large (%.2f MB, limit %.2f MB)",
+		float64(e.Size)/(1<<20), float64(e.Limit)/(1<<20))
+}
+
+// messageSize estimates the serialized size of a message as it is built
+type messageSize struct {
+	bytes int
+}
+
+// newMessageSize starts an estimate for a message with the given subject
+func newMessageSize(subject string) *messageSize {
+	return &messageSize{bytes: messageHeaderOverhead + len(subject)}
+}
+
+// addBody counts a text or HTML body part
+func (m *messageSize) addBody(body string) {
+	m.bytes += mimePartOverhead + len(body)
+}
+
+// addAttachment counts an attachment, which is base64 encoded in 76
+// character lines
+func (m *messageSize) addAttachment(data []byte) {
+	encoded := base64.StdEncoding.EncodedLen(len(data))
+	m.bytes += mimePartOverhead + encoded + encoded/76*2
+}
+
+// check fails when the estimate exceeds limit bytes, or Mailgun's limit
+// when limit is zero
+func (m *messageSize) check(limit int) error {
+	if limit <= 0 || limit > mailgunMaxMessageBytes {
+		limit = mailgunMaxMessageBytes
+	}
+	if m.bytes > limit {
+		return &MessageTooLargeError{Size: m.bytes, Limit: limit}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMessageSize(t *testing.T) {
+	size := newMessageSize("Desk Lamp")
+	size.addBody("Name: Desk Lamp")
+	// 57 bytes encode to one 76 character line
+	size.addAttachment(make([]byte, 57*10))
+	want := messageHeaderOverhead + len("Desk Lamp") + mimePartOverhead + len("Name: Desk Lamp") + mimePartOverhead + 760 + 20
+	if size.bytes != want {
+		t.Fatalf("estimate = %d, want %d", size.bytes, want)
+	}
+
+	if err := size.check(want); err != nil {
+		t.Errorf("check at the limit = %v", err)
+	}
+	var sizeErr *MessageTooLargeError
+	if err := size.check(want - 1); !errors.As(err, &sizeErr) || sizeErr.Size != want || sizeErr.Limit != want-1 {
+		t.Errorf("check over the limit = %v", err)
+	}
+	// No limit, or one above Mailgun's, falls back to Mailgun's limit
+	size.bytes = mailgunMaxMessageBytes + 1
+	for _, limit := range []int{0, mailgunMaxMessageBytes * 2} {
+		if err := size.check(limit); !errors.As(err, &sizeErr) || sizeErr.Limit != mailgunMaxMessageBytes {
+			t.Errorf("check(%d) = %v, want Mailgun's limit", limit, err)
+		}
+	}
+}
+
+func TestSendRejectsOversizedMessages(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"MAX_MESSAGE_BYTES": "8192"})
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+	send := func(description string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(map[string]string{
+			"recipient_email": "alex@example.com",
+			"product_name":    "Desk Lamp",
+			"description":     description,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return do(router, "POST", "/send-product", string(body), nil)
+	}
+
+	if w := send("A warm desk lamp"); w.Code != 200 {
+		t.Fatalf("small message status = %d, body %s", w.Code, w.Body)
+	}
+	w := send(strings.Repeat("A warm desk lamp. ", 500))
+	if w.Code != 413 {
+		t.Fatalf("large message status = %d, want 413 (body %s)", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "limit 0.01 MB") {
+		t.Errorf("body = %s, want the limit in the details", w.Body)
+	}
+	if got := len(sender.sent()); got != 1 {
+		t.Errorf("%d messages sent, want only the small one", got)
+	}
+}
+
+func TestMaxMessageBytesIsCappedAtMailgunsLimit(t *testing.T) {
+	t.Setenv("MAILGUN_DOMAIN", "mg.example.com")
+	t.Setenv("MAILGUN_API_KEY", "key-test")
+	t.Setenv("MAX_MESSAGE_BYTES", strconv.Itoa(mailgunMaxMessageBytes+1))
+	if _, _, err := ResolveConfig(nil); err == nil {
+		t.Error("MAX_MESSAGE_BYTES above Mailgun's limit was accepted")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Spam check modes selected by SPAM_CHECK
+const (
+	spamCheckOff   = "off"
+	spamCheckWarn  = "warn"
+	spamCheckBlock = "block"
+)
+
+// warnSpamSuspected is reported when SPAM_CHECK=warn flagged the content
+const warnSpamSuspected = "spam_suspected"
+
+// Below these sizes the caps and link ratios say little about a body
+const (
+	spamMinLetters = 20
+	spamMinLinks   = 3
+)
+
+// SpamRules are the checks run on email bodies to catch obviously spammy
+// user-generated content
+type SpamRules struct {
+	// Patterns are case-insensitive regular expressions of trigger phrases
+	Patterns []*regexp.Regexp `json:"patterns"`
+	// MaxCapsRatio is the largest share of uppercase letters allowed
+	MaxCapsRatio float64 `json:"max_caps_ratio"`
+	// MaxLinkRatio is the largest share of words that may be links
+	MaxLinkRatio float64 `json:"max_link_ratio"`
+}
+
+// defaultSpamRules are used unless SPAM_RULES replaces them
+var defaultSpamRules = SpamRules{
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b100% free\b`),
+		regexp.MustCompile(`(?i)\bact now\b`),
+		regexp.MustCompile(`(?i)\bcongratulations,? you(?:'ve| have)? won\b`),
+		regexp.MustCompile(`(?i)\bno credit check\b`),
+		regexp.MustCompile(`(?i)\brisk[- ]free\b`),
+		regexp.MustCompile(`(?i)\$\$\$`),
+	},
+	MaxCapsRatio: 0.5,
+	MaxLinkRatio: 0.2,
+}
+
+// SpamContentError is returned when SPAM_CHECK=block flagged the content
+type SpamContentError struct {
+	Reasons []string
+}
+
+func (e *SpamContentError) Error() string {
+	return "content looks like spam: " + strings.Join(e.Reasons, "; ")
+}
+
+// parseSpamRules parses the SPAM_RULES JSON object, e.g.
+// {"patterns": ["free money"], "max_caps_ratio": 0.4}. Patterns always
+// match case-insensitively. Fields left out keep their defaults; a zero
+// ratio disables that check.
+func parseSpamRules(raw string) (SpamRules, error) {
+	if raw == "" {
+		return defaultSpamRules, nil
+	}
+	var parsed struct {
+		Patterns     *[]string `json:"patterns"`
+		MaxCapsRatio *float64  `json:"max_caps_ratio"`
+		MaxLinkRatio *float64  `json:"max_link_ratio"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return SpamRules{}, fmt.Errorf("invalid SPAM_RULES: %w", err)
+	}
+	rules := defaultSpamRules
+	if parsed.Patterns != nil {
+		rules.Patterns = nil
+		for _, pattern := range *parsed.Patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return SpamRules{}, fmt.Errorf("invalid SPAM_RULES pattern %q: %w", pattern, err)
+			}
+			rules.Patterns = append(rules.Patterns, re)
+		}
+	}
+	if parsed.MaxCapsRatio != nil {
+		if *parsed.MaxCapsRatio < 0 || *parsed.MaxCapsRatio > 1 {
+			return SpamRules{}, fmt.Errorf("invalid SPAM_RULES max_caps_ratio %v (expected 0 to 1)", *parsed.MaxCapsRatio)
+		}
+		rules.MaxCapsRatio = *parsed.MaxCapsRatio
+	}
+	if parsed.MaxLinkRatio != nil {
+		if *parsed.MaxLinkRatio < 0 || *parsed.MaxLinkRatio > 1 {
+			return SpamRules{}, fmt.Errorf("invalid SPAM_RULES max_link_ratio %v (expected 0 to 1)", *parsed.MaxLinkRatio)
+		}
+		rules.MaxLinkRatio = *parsed.MaxLinkRatio
+	}
+	return rules, nil
+}
+
+// parseSpamCheckMode validates a SPAM_CHECK value
+func parseSpamCheckMode(mode string) (string, error) {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "":
+		return spamCheckOff, nil
+	case spamCheckOff, spamCheckWarn, spamCheckBlock:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid SPAM_CHECK %q (expected off, warn or block)", mode)
+	}
+}
+
+// check returns the reasons content looks like spam, if any
+func (r SpamRules) check(content string) []string {
+	var reasons []string
+	for _, pattern := range r.Patterns {
+		if match := pattern.FindString(content); match != "" {
+			reasons = append(reasons, fmt.Sprintf("contains %q", match))
+		}
+	}
+
+	letters, upper := 0, 0
+	for _, c := range content {
+		if unicode.IsLetter(c) {
+			letters++
+			if unicode.IsUpper(c) {
+				upper++
+			}
+		}
+	}
+	if r.MaxCapsRatio > 0 && letters >= spamMinLetters {
+		if ratio := float64(upper) / float64(letters); ratio > r.MaxCapsRatio {
+			reasons = append(reasons, fmt.Sprintf("%.0f%% of letters are uppercase", ratio*100))
+		}
+	}
+
+	words := strings.Fields(content)
+	links := 0
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if strings.Contains(word, "http://") || strings.Contains(word, "https://") || strings.HasPrefix(word, "www.") {
+			links++
+		}
+	}
+	if r.MaxLinkRatio > 0 && links >= spamMinLinks {
+		if ratio := float64(links) / float64(len(words)); ratio > r.MaxLinkRatio {
+			reasons = append(reasons, fmt.Sprintf("%d of %d words are links", links, len(words)))
+		}
+	}
+	return reasons
+}
+
+// checkSpam runs the spam rules on the bodies of an email. Flagged content
+// fails the send in block mode; in warn mode the reasons are returned.
+func checkSpam(mode string, rules SpamRules, text, htmlBody string) ([]string, error) {
+	if mode == spamCheckOff || mode == "" {
+		return nil, nil
+	}
+	// Each body is checked on its own: the HTML usually repeats the text,
+	// which would double the counts of a combined check
+	var reasons []string
+	for _, content := range []string{text, htmlToText(htmlBody)} {
+		if content == "" {
+			continue
+		}
+		for _, reason := range rules.check(content) {
+			if !slices.Contains(reasons, reason) {
+				reasons = append(reasons, reason)
+			}
+		}
+	}
+	if len(reasons) > 0 && mode == spamCheckBlock {
+		return nil, &SpamContentError{Reasons: reasons}
+	}
+	return reasons, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSpamCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		description string
+		status      int
+		reasons     []string
+		warned      bool
+	}{
+		{name: "clean", mode: "block", description: "A warm desk lamp with a linen shade, see https://shop.example.com/lamp", status: 200},
+		{name: "trigger phrase", mode: "block", description: "Act now, this lamp is 100% free!", status: 422, reasons: []string{`contains "100% free"`, `contains "Act now"`}},
+		{name: "shouting", mode: "block", description: "THE BEST LAMP YOU WILL EVER OWN, BUY IT TODAY", status: 422, reasons: []string{"of letters are uppercase"}},
+		{name: "link farm", mode: "block", description: "Lamp https://a.example.com https://b.example.com www.c.example.com", status: 422},
+		{name: "warn mode", mode: "warn", description: "Act now!", status: 200, warned: true},
+		{name: "off", mode: "off", description: "Act now!", status: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{"SPAM_CHECK": tt.mode})
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			body, err := json.Marshal(map[string]string{
+				"recipient_email": "alex@example.com",
+				"product_name":    "Desk Lamp",
+				"description":     tt.description,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := do(router, "POST", "/send-product", string(body), nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			var response struct {
+				Reasons  []string `json:"reasons"`
+				Warnings []string `json:"warnings"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.status == 422 {
+				if len(sender.sent()) != 0 {
+					t.Error("sent flagged content")
+				}
+				for _, reason := range tt.reasons {
+					if !slices.ContainsFunc(response.Reasons, func(got string) bool { return strings.Contains(got, reason) }) {
+						t.Errorf("reasons = %q, want %q", response.Reasons, reason)
+					}
+				}
+				if len(response.Reasons) == 0 {
+					t.Error("no reasons given")
+				}
+				return
+			}
+			sender.last(t)
+			if warned := slices.Contains(response.Warnings, warnSpamSuspected); warned != tt.warned {
+				t.Errorf("warnings = %q, want spam suspected %v", response.Warnings, tt.warned)
+			}
+		})
+	}
+}
+
+func TestCheckSpamCountsEachBodyOnce(t *testing.T) {
+	text := "Lamp https://a.example.com https://b.example.com https://c.example.com and so on for a while"
+	html := `<p>Lamp <a href="https://a.example.com">https://a.example.com</a> https://b.example.com https://c.example.com and so on for a while</p>`
+	reasons, err := checkSpam("warn", defaultSpamRules, text, html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) != 1 || !strings.HasPrefix(reasons[0], "3 of ") {
+		t.Errorf("reasons = %q, want the link ratio reported once", reasons)
+	}
+}
+
+func TestParseSpamRules(t *testing.T) {
+	tests := []struct {
+		raw   string
+		valid bool
+	}{
+		{raw: "", valid: true},
+		{raw: `{"patterns": ["free money"], "max_caps_ratio": 0.4}`, valid: true},
+		{raw: `{"max_link_ratio": 0}`, valid: true},
+		{raw: `{"max_caps_ratio": 1.5}`},
+		{raw: `{"max_link_ratio": -0.1}`},
+		{raw: `{"patterns": ["("]}`},
+		{raw: `not json`},
+	}
+	for _, tt := range tests {
+		if _, err := parseSpamRules(tt.raw); (err == nil) != tt.valid {
+			t.Errorf("parseSpamRules(%q) err = %v, want valid %v", tt.raw, err, tt.valid)
+		}
+	}
+
+	rules, err := parseSpamRules(`{"patterns": ["free money"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reasons := rules.check("Get FREE MONEY today"); len(reasons) != 1 {
+		t.Errorf("reasons = %q, want the case-insensitive pattern to match", reasons)
+	}
+}
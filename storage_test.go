@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// fakeMailgunAPI accepts every message and, when indexed is set, lists an
+// accepted event with a storage URL for it
+func fakeMailgunAPI(t *testing.T, indexed bool) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v3/mg.example.com/messages", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": "<1@mg.example.com>", "message": "Queued. Thank you."}`)
+	})
+	mux.HandleFunc("GET /v3/mg.example.com/events", func(w http.ResponseWriter, r *http.Request) {
+		items := "[]"
+		if indexed && r.URL.Query().Get("message-id") == "1@mg.example.com" {
+			items = `[{"event": "accepted", "id": "ev1", "timestamp": 1709294400, "storage": {"key": "k1", "url": "https://storage.example.com/messages/k1"}}]`
+		}
+		fmt.Fprintf(w, `{"items": %s, "paging": {}}`, items)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStorageURLLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		lookup  string
+		indexed bool
+		url     string
+		warned  bool
+	}{
+		{name: "indexed", lookup: "true", indexed: true, url: "https://storage.example.com/messages/k1"},
+		{name: "not indexed in time", lookup: "true", warned: true},
+		{name: "lookup off", lookup: "false", indexed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakeMailgunAPI(t, tt.indexed)
+			service := NewEmailService(testConfig(t, map[string]string{
+				"STORAGE_URL_LOOKUP":  tt.lookup,
+				"STORAGE_URL_TIMEOUT": "50ms",
+			}))
+			service.mg.SetAPIBase(server.URL + "/v3")
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, nil)
+			if w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			var response struct {
+				StorageURL string   `json:"storage_url"`
+				Warnings   []string `json:"warnings"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.StorageURL != tt.url {
+				t.Errorf("storage_url = %q, want %q", response.StorageURL, tt.url)
+			}
+			if warned := slices.Contains(response.Warnings, warnStorageURLUnavailable); warned != tt.warned {
+				t.Errorf("warnings = %q, want storage url unavailable %v", response.Warnings, tt.warned)
+			}
+		})
+	}
+}
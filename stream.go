@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamRecipient is a recipient of a streamed send
+type StreamRecipient struct {
+	Email     string            `json:"email"`
+	Name      string            `json:"name"`
+	Variables map[string]string `json:"variables"`
+	// Template selects the recipient's template set, e.g. "wholesale";
+	// the product's template when empty
+	Template string `json:"template"`
+}
+
+// StreamResult is the "result" event sent for every recipient
+type StreamResult struct {
+	Index   int    `json:"index"`
+	Email   string `json:"email"`
+	Status  string `json:"status"`
+	ID      string `json:"id,omitempty"`
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RecipientError reports an invalid recipient of a multi-recipient request
+type RecipientError struct {
+	Index int    `json:"index"`
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+// Multi-send modes: strict rejects the whole request when any recipient is
+// invalid, best_effort sends to the valid ones and reports the others
+const (
+	sendModeStrict     = "strict"
+	sendModeBestEffort = "best_effort"
+)
+
+// invalidRecipients checks every recipient address up front
+func invalidRecipients(recipients []StreamRecipient) []RecipientError {
+	var invalid []RecipientError
+	for i, recipient := range recipients {
+		switch {
+		case recipient.Email == "":
+			invalid = append(invalid, RecipientError{Index: i, Error: "missing email"})
+		case !validAddress(recipient.Email):
+			invalid = append(invalid, RecipientError{Index: i, Email: recipient.Email, Error: "invalid email address"})
+		}
+	}
+	return invalid
+}
+
+// unknownTemplates returns the template sets named by the product or its
+// recipients that aren't loaded
+func (s *EmailService) unknownTemplates(product ProductEmail, recipients []StreamRecipient) []string {
+	var unknown []string
+	check := func(name string) {
+		if !s.hasTemplateSet(name) && !slices.Contains(unknown, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	check(product.Template)
+	for _, recipient := range recipients {
+		check(recipient.Template)
+	}
+	return unknown
+}
+
+// StreamTotals is the final "done" event of a streamed send
+type StreamTotals struct {
+	Total   int `json:"total"`
+	Sent    int `json:"sent"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// SendStreamHandler sends the product email to each recipient in turn and
+// streams the results as Server-Sent Events: a "result" event per recipient
+// followed by a "done" event with the totals. Remaining sends are abandoned
+// when the client disconnects.
+//
+// In the default strict mode a request with any invalid recipient is
+// rejected with a 422 listing all of them before anything is sent; with
+// "mode": "best_effort" they are reported as failed results instead.
+// Each recipient may pick its own template set; a request naming an
+// unknown one is rejected with a 422 in either mode.
+func (h *Handler) SendStreamHandler(c *gin.Context) {
+	var req struct {
+		Product    ProductEmail      `json:"product"`
+		Recipients []StreamRecipient `json:"recipients"`
+		Mode       string            `json:"mode"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if req.Product.ProductName == "" || len(req.Recipients) == 0 {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+	if limit := h.emailService.cfg().StreamMaxRecipients; limit > 0 && len(req.Recipients) > limit {
+		c.JSON(400, gin.H{
+			"error":          "Too many recipients",
+			"max_recipients": limit,
+		})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = sendModeStrict
+	}
+	if req.Mode != sendModeStrict && req.Mode != sendModeBestEffort {
+		c.JSON(400, gin.H{
+			"error":   "Invalid request",
+			"details": fmt.Sprintf("mode must be %s or %s, got %q", sendModeStrict, sendModeBestEffort, req.Mode),
+		})
+		return
+	}
+
+	if unknown := h.emailService.unknownTemplates(req.Product, req.Recipients); len(unknown) > 0 {
+		c.JSON(422, gin.H{
+			"error":     "Unknown templates",
+			"unknown":   unknown,
+			"templates": h.emailService.templateSetNames(),
+		})
+		return
+	}
+
+	invalid := invalidRecipients(req.Recipients)
+	if len(invalid) > 0 && req.Mode == sendModeStrict {
+		c.JSON(422, gin.H{
+			"error":              "Invalid recipients",
+			"invalid_recipients": invalid,
+		})
+		return
+	}
+	invalidAt := make(map[int]string, len(invalid))
+	for _, recipient := range invalid {
+		invalidAt[recipient.Index] = recipient.Error
+	}
+
+	ctx := withActor(c.Request.Context(), requestActor(c, h.emailService.cfg().APIKeys))
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	totals := StreamTotals{Total: len(req.Recipients)}
+	for i, recipient := range req.Recipients {
+		if ctx.Err() != nil {
+			// The client went away; nobody is left to read the results
+			return
+		}
+
+		data := req.Product
+		data.RecipientEmail = recipient.Email
+		data.RecipientName = recipient.Name
+		data.Variables = recipient.Variables
+		if recipient.Template != "" {
+			data.Template = recipient.Template
+		}
+
+		event := StreamResult{Index: i, Email: recipient.Email}
+		var result SendResult
+		var err error
+		if reason, ok := invalidAt[i]; ok {
+			err = errors.New(reason)
+		} else {
+			result, err = h.emailService.SendProductEmail(ctx, data)
+		}
+		switch {
+		case err != nil:
+			event.Status, event.Error = "failed", err.Error()
+			totals.Failed++
+		case result.Skipped != "":
+			event.Status, event.Skipped = "skipped", result.Skipped
+			totals.Skipped++
+		default:
+			event.Status, event.ID = "sent", result.ID
+			totals.Sent++
+		}
+
+		c.SSEvent("result", event)
+		c.Writer.Flush()
+	}
+
+	c.SSEvent("done", totals)
+	c.Writer.Flush()
+}
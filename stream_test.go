@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// streamEvent is a Server-Sent Event read back from a response
+type streamEvent struct {
+	name string
+	data string
+}
+
+// readEvents parses the Server-Sent Events of a response body
+func readEvents(t *testing.T, body string) []streamEvent {
+	t.Helper()
+	var events []streamEvent
+	var event streamEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.name != "" {
+				events = append(events, event)
+			}
+			event = streamEvent{}
+		case strings.HasPrefix(line, "event:"):
+			event.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			event.data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	if event.name != "" {
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestSendStreamHandler(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	service.SetPreferences(fakePreferences{optedOut: map[string]string{"sam@example.com": defaultCategory}})
+	router := newTestRouter()
+	router.POST("/admin/send-stream", NewHandler(service).SendStreamHandler)
+
+	w := do(router, "POST", "/admin/send-stream", `{
+		"product": {"product_name": "Desk Lamp"},
+		"recipients": [
+			{"email": "alex@example.com", "name": "Alex"},
+			{"email": "sam@example.com"},
+			{"email": "kim@example.com"}
+		]
+	}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("content type = %q", got)
+	}
+
+	events := readEvents(t, w.Body.String())
+	if len(events) != 4 {
+		t.Fatalf("%d events, want 3 results and done:\n%s", len(events), w.Body)
+	}
+	want := []StreamResult{
+		{Index: 0, Email: "alex@example.com", Status: "sent", ID: "<1@mg.example.com>"},
+		{Index: 1, Email: "sam@example.com", Status: "skipped"},
+		{Index: 2, Email: "kim@example.com", Status: "sent", ID: "<2@mg.example.com>"},
+	}
+	for i, event := range events[:3] {
+		var result StreamResult
+		if err := json.Unmarshal([]byte(event.data), &result); err != nil {
+			t.Fatal(err)
+		}
+		// The skip reason comes from the preferences check
+		result.Skipped = ""
+		if event.name != "result" || result != want[i] {
+			t.Errorf("event %d = %s %+v, want result %+v", i, event.name, result, want[i])
+		}
+	}
+	var totals StreamTotals
+	if err := json.Unmarshal([]byte(events[3].data), &totals); err != nil {
+		t.Fatal(err)
+	}
+	if events[3].name != "done" || totals != (StreamTotals{Total: 3, Sent: 2, Skipped: 1}) {
+		t.Errorf("last event = %s %+v", events[3].name, totals)
+	}
+	if got := len(sender.sent()); got != 2 {
+		t.Errorf("%d messages sent, want 2", got)
+	}
+}
+
+func TestSendStreamStopsWhenTheClientDisconnects(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/admin/send-stream", NewHandler(service).SendStreamHandler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/admin/send-stream", strings.NewReader(`{
+		"product": {"product_name": "Desk Lamp"},
+		"recipients": [{"email": "alex@example.com"}, {"email": "kim@example.com"}]
+	}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if len(sender.sent()) != 0 {
+		t.Error("sent after the client disconnected")
+	}
+	if events := readEvents(t, w.Body.String()); len(events) != 0 {
+		t.Errorf("events written after the client disconnected: %v", events)
+	}
+}
+
+func TestSendStreamRecipientValidation(t *testing.T) {
+	const recipients = `[
+		{"email": "alex@example.com"},
+		{"email": "not an address"},
+		{"name": "Sam"},
+		{"email": "kim@example.com"}
+	]`
+	tests := []struct {
+		name   string
+		mode   string
+		env    map[string]string
+		status int
+		sent   int
+	}{
+		{name: "strict by default", status: 422},
+		{name: "strict", mode: "strict", status: 422},
+		{name: "best effort", mode: "best_effort", status: 200, sent: 2},
+		{name: "unknown mode", mode: "some", status: 400},
+		{name: "too many recipients", mode: "best_effort", env: map[string]string{"STREAM_MAX_RECIPIENTS": "3"}, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, tt.env)
+			router := newTestRouter()
+			router.POST("/admin/send-stream", NewHandler(service).SendStreamHandler)
+
+			w := do(router, "POST", "/admin/send-stream", `{"product": {"product_name": "Desk Lamp"}, "recipients": `+recipients+`, "mode": "`+tt.mode+`"}`, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if got := len(sender.sent()); got != tt.sent {
+				t.Errorf("%d messages sent, want %d", got, tt.sent)
+			}
+
+			invalid := []RecipientError{
+				{Index: 1, Email: "not an address", Error: "invalid email address"},
+				{Index: 2, Error: "missing email"},
+			}
+			switch tt.status {
+			case 422:
+				// Every invalid recipient is listed, not just the first
+				var response struct {
+					Invalid []RecipientError `json:"invalid_recipients"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(response.Invalid, invalid) {
+					t.Errorf("invalid_recipients = %+v, want %+v", response.Invalid, invalid)
+				}
+			case 200:
+				// Invalid recipients are streamed as failed results in place
+				events := readEvents(t, w.Body.String())
+				for _, want := range invalid {
+					var result StreamResult
+					if err := json.Unmarshal([]byte(events[want.Index].data), &result); err != nil {
+						t.Fatal(err)
+					}
+					if result.Status != "failed" || result.Error != want.Error {
+						t.Errorf("result %d = %+v, want failed with %q", want.Index, result, want.Error)
+					}
+				}
+				var totals StreamTotals
+				if err := json.Unmarshal([]byte(events[len(events)-1].data), &totals); err != nil {
+					t.Fatal(err)
+				}
+				if totals != (StreamTotals{Total: 4, Sent: 2, Failed: 2}) {
+					t.Errorf("totals = %+v", totals)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	texttemplate "text/template"
+)
+
+// defaultSubject is used when no subject template is configured
+const defaultSubject = "Product Information"
+
+// maxSubjectLength keeps the encoded subject within the RFC 5322 line
+// length limit
+const maxSubjectLength = 998
+
+// ErrSubjectTooLong is returned when an encoded subject exceeds
+// maxSubjectLength bytes
+var ErrSubjectTooLong = fmt.Errorf("subject exceeds %d bytes once encoded", maxSubjectLength)
+
+// parseSubjectTemplate parses a SUBJECT_TEMPLATE value. An empty source
+// yields a nil template.
+func parseSubjectTemplate(src string) (*texttemplate.Template, error) {
+	if src == "" {
+		return nil, nil
+	}
+	return texttemplate.New("subject").Funcs(templateFuncs).Option("missingkey=error").Parse(src)
+}
+
+// resolveSubject returns the subject for an email: the request's own
+// subject, else the rendered subject template, else the static default.
+// ENV_SUBJECT_PREFIX is prepended before the length limit is checked.
+func (s *EmailService) resolveSubject(data ProductEmail) (string, error) {
+	subject := data.Subject
+	if tmpl := s.subjectTmpl(); subject == "" && tmpl != nil {
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, s.personalize(data)); err != nil {
+			return "", fmt.Errorf("render subject: %w", err)
+		}
+		subject = rendered.String()
+	}
+	if subject == "" {
+		subject = defaultSubject
+	}
+	return sanitizeSubject(prefixSubject(s.cfg().EnvSubjectPrefix, subject))
+}
+
+// prefixSubject prepends prefix to subject, separated by a space, unless
+// the subject already starts with it
+func prefixSubject(prefix, subject string) string {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" || strings.HasPrefix(strings.TrimSpace(subject), prefix) {
+		return subject
+	}
+	return prefix + " " + subject
+}
+
+// sanitizeSubject collapses line breaks, which are not allowed in a header,
+// replaces invalid UTF-8 and enforces the length limit on the encoded form
+func sanitizeSubject(subject string) (string, error) {
+	subject = strings.ToValidUTF8(subject, "\uFFFD")
+	subject = strings.Join(strings.Fields(subject), " ")
+	if len(encodeSubject(subject)) > maxSubjectLength {
+		return "", ErrSubjectTooLong
+	}
+	return subject, nil
+}
+
+// encodeSubject RFC 2047 encodes a subject containing non-ASCII characters
+// such as emoji, choosing whichever of the Q and B encodings is shorter.
+// ASCII subjects are returned unchanged.
+func encodeSubject(subject string) string {
+	q := mime.QEncoding.Encode("utf-8", subject)
+	if b := mime.BEncoding.Encode("utf-8", subject); len(b) < len(q) {
+		return b
+	}
+	return q
+}
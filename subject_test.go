@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestResolveSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		data    ProductEmail
+		want    string
+		wantErr bool
+	}{
+		{name: "default", data: ProductEmail{ProductName: "Desk Lamp"}, want: defaultSubject},
+		{name: "request subject", env: map[string]string{"SUBJECT_TEMPLATE": "New: {{.ProductName}}"}, data: ProductEmail{ProductName: "Desk Lamp", Subject: "Hello"}, want: "Hello"},
+		{name: "template", env: map[string]string{"SUBJECT_TEMPLATE": "New: {{.ProductName}} for {{price .Price .Locale}}"}, data: ProductEmail{ProductName: "Desk Lamp", Price: 40}, want: "New: Desk Lamp for $40.00"},
+		{name: "template sees variables", env: map[string]string{"SUBJECT_TEMPLATE": "{{.RecipientName}}, {{.Description}}"}, data: ProductEmail{RecipientName: "Alex", Description: "use {{code}}", Variables: map[string]string{"code": "SPRING10"}}, want: "Alex, use SPRING10"},
+		{name: "template failing at send", env: map[string]string{"SUBJECT_TEMPLATE": "{{.Variables.code}}"}, data: ProductEmail{Variables: map[string]string{"other": "x"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.env)
+			got, err := service.resolveSubject(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSubject() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSubject() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubjectTemplateIsValidatedAtStartup(t *testing.T) {
+	t.Setenv("MAILGUN_DOMAIN", "mg.example.com")
+	t.Setenv("MAILGUN_API_KEY", "key-test")
+	t.Setenv("SUBJECT_TEMPLATE", "New: {{.ProductName")
+	if _, _, err := ResolveConfig(nil); err == nil {
+		t.Error("a broken SUBJECT_TEMPLATE was accepted")
+	}
+	if _, err := parseSubjectTemplate(""); err != nil {
+		t.Errorf("an empty template failed: %v", err)
+	}
+}
+
+func TestEncodeSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{subject: "New: Desk Lamp", want: "New: Desk Lamp"},
+		{subject: "Neu: Schreibtischlampe für Café", want: "=?utf-8?q?Neu:_Schreibtischlampe_f=C3=BCr_Caf=C3=A9?="},
+		{subject: "🔥🔥🔥 Sale", want: "=?utf-8?b?8J+UpfCflKXwn5SlIFNhbGU=?="},
+	}
+	var decoder mime.WordDecoder
+	for _, tt := range tests {
+		got := encodeSubject(tt.subject)
+		if got != tt.want {
+			t.Errorf("encodeSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+		if decoded, err := decoder.DecodeHeader(got); err != nil || decoded != tt.subject {
+			t.Errorf("%q decodes to %q, %v", got, decoded, err)
+		}
+	}
+}
+
+func TestSanitizeSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+		err     error
+	}{
+		{name: "line breaks", subject: "New:\r\n  Desk Lamp\n", want: "New: Desk Lamp"},
+		{name: "invalid utf-8", subject: "Desk \xffLamp", want: "Desk �Lamp"},
+		{name: "long ascii", subject: strings.Repeat("a", maxSubjectLength), want: strings.Repeat("a", maxSubjectLength)},
+		{name: "too long", subject: strings.Repeat("a", maxSubjectLength+1), err: ErrSubjectTooLong},
+		// Short enough as UTF-8, too long once encoded
+		{name: "too long once encoded", subject: strings.Repeat("🔥", 200), err: ErrSubjectTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeSubject(tt.subject)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeSubject() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendEncodesSubject(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "subject": "🔥 Sale"}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if got, want := plain(t, sender.last(t)).Subject(), "=?utf-8?b?8J+UpSBTYWxl?="; got != want {
+		t.Errorf("subject = %q, want %q", got, want)
+	}
+
+	w = do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "subject": "`+strings.Repeat("🔥", 200)+`"}`, nil)
+	if w.Code != 400 {
+		t.Errorf("oversized subject status = %d, want 400 (body %s)", w.Code, w.Body)
+	}
+	if got := len(sender.sent()); got != 1 {
+		t.Errorf("%d messages sent, want 1", got)
+	}
+}
+
+func TestEnvSubjectPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		subject string
+		want    string
+	}{
+		{name: "prefixed", prefix: "[STAGING]", subject: "New: Desk Lamp", want: "[STAGING] New: Desk Lamp"},
+		{name: "prefix trimmed", prefix: " [STAGING] ", subject: "New: Desk Lamp", want: "[STAGING] New: Desk Lamp"},
+		{name: "already prefixed", prefix: "[STAGING]", subject: "[STAGING] New: Desk Lamp", want: "[STAGING] New: Desk Lamp"},
+		{name: "default subject", prefix: "[STAGING]", want: "[STAGING] " + defaultSubject},
+		{name: "no prefix", subject: "New: Desk Lamp", want: "New: Desk Lamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, map[string]string{"ENV_SUBJECT_PREFIX": tt.prefix})
+			if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Subject: tt.subject}); err != nil {
+				t.Fatal(err)
+			}
+			if got := plain(t, sender.last(t)).Subject(); got != tt.want {
+				t.Errorf("subject = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The prefix counts towards the length limit
+	service, _ := newTestService(t, map[string]string{"ENV_SUBJECT_PREFIX": "[STAGING]"})
+	if _, err := service.resolveSubject(ProductEmail{Subject: strings.Repeat("a", maxSubjectLength-5)}); !errors.Is(err, ErrSubjectTooLong) {
+		t.Errorf("err = %v, want ErrSubjectTooLong", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxTagLength is the longest tag Mailgun accepts
+const maxTagLength = 128
+
+// ErrInvalidTag is returned for a tag Mailgun wouldn't accept
+var ErrInvalidTag = errors.New("invalid tag")
+
+// validateTag checks that tag is printable ASCII within Mailgun's length
+// limit. An @ is rejected too so tag quota keys never look like recipients.
+func validateTag(tag string) error {
+	if len(tag) > maxTagLength {
+		return fmt.Errorf("%w: longer than %d characters", ErrInvalidTag, maxTagLength)
+	}
+	for _, r := range tag {
+		if r < ' ' || r > '~' || r == '@' {
+			return fmt.Errorf("%w %q: only printable ASCII characters other than @ are allowed", ErrInvalidTag, tag)
+		}
+	}
+	return nil
+}
+
+// tagQuotaKey counts the sends of a tag against its daily cap. It can't
+// collide with a recipient key since tags contain no @.
+func tagQuotaKey(tag string) string {
+	return "tag:" + tag
+}
+
+// parseTagDailyLimits parses "tag=limit" pairs separated by commas, e.g.
+// "spring-sale=5000,newsletter=20000"
+func parseTagDailyLimits(raw string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, pair := range splitList(raw) {
+		tag, value, found := strings.Cut(pair, "=")
+		tag = strings.TrimSpace(tag)
+		if !found || tag == "" {
+			return nil, fmt.Errorf("invalid TAG_DAILY_LIMITS entry %q (expected tag=limit)", pair)
+		}
+		if err := validateTag(tag); err != nil {
+			return nil, fmt.Errorf("invalid TAG_DAILY_LIMITS entry %q: %w", pair, err)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid TAG_DAILY_LIMITS limit for %s: %q", tag, value)
+		}
+		limits[tag] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestTagDailyLimits(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"TAG_DAILY_LIMITS": "spring-sale=2,newsletter=5"})
+	handler := NewHandler(service)
+	router := newTestRouter()
+	router.POST("/send-product", handler.SendProductHandler)
+	router.GET("/quota", handler.QuotaHandler)
+
+	tests := []struct {
+		name   string
+		tag    string
+		status int
+	}{
+		{name: "first spring-sale", tag: "spring-sale", status: 200},
+		{name: "second spring-sale", tag: "spring-sale", status: 200},
+		{name: "spring-sale over its limit", tag: "spring-sale", status: 429},
+		{name: "newsletter still sends", tag: "newsletter", status: 200},
+		{name: "untagged still sends", status: 200},
+		{name: "uncapped tag still sends", tag: "restock", status: 200},
+	}
+	sent := 0
+	for i, tt := range tests {
+		body := fmt.Sprintf(`{"recipient_email": "user%d@example.com", "product_name": "Desk Lamp", "tag": %q}`, i, tt.tag)
+		w := do(router, "POST", "/send-product", body, nil)
+		if w.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d (body %s)", tt.name, w.Code, tt.status, w.Body)
+		}
+		if tt.status == 429 {
+			if w.Header().Get("Retry-After") == "" {
+				t.Errorf("%s: no Retry-After", tt.name)
+			}
+			var response struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Error != "Tag email limit reached" {
+				t.Errorf("%s: error = %q", tt.name, response.Error)
+			}
+			continue
+		}
+		sent++
+		if tags := sender.last(t).Tags(); tt.tag != "" && !slices.Contains(tags, tt.tag) {
+			t.Errorf("%s: tags = %v, want %s", tt.name, tags, tt.tag)
+		}
+	}
+	if got := len(sender.sent()); got != sent {
+		t.Errorf("%d messages sent, want %d", got, sent)
+	}
+
+	w := do(router, "GET", "/quota", "", nil)
+	var quota struct {
+		Tags map[string]struct {
+			Used      int `json:"used"`
+			Remaining int `json:"remaining"`
+		} `json:"tags"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &quota); err != nil {
+		t.Fatal(err)
+	}
+	if got := quota.Tags["spring-sale"]; got.Used != 2 || got.Remaining != 0 {
+		t.Errorf("spring-sale usage = %+v, want 2 used and none remaining", got)
+	}
+	if got := quota.Tags["newsletter"]; got.Used != 1 || got.Remaining != 4 {
+		t.Errorf("newsletter usage = %+v, want 1 used and 4 remaining", got)
+	}
+}
+
+func TestParseTagDailyLimits(t *testing.T) {
+	tests := []struct {
+		raw   string
+		valid bool
+	}{
+		{raw: "", valid: true},
+		{raw: "spring-sale=5000, newsletter=20000", valid: true},
+		{raw: "spring-sale"},
+		{raw: "=5"},
+		{raw: "spring-sale=0"},
+		{raw: "spring-sale=lots"},
+		{raw: "ops@example.com=5"},
+	}
+	for _, tt := range tests {
+		if _, err := parseTagDailyLimits(tt.raw); (err == nil) != tt.valid {
+			t.Errorf("parseTagDailyLimits(%q) err = %v, want valid %v", tt.raw, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// templateFuncs are the helpers available to every email template
+var templateFuncs = map[string]any{
+	"price":   formatPrice,
+	"percent": formatPercent,
+}
+
+// productTextTemplate renders the plain-text body of a product email, or
+// of a digest when DigestItems is set
+var productTextTemplate = texttemplate.Must(texttemplate.New("product_text").Funcs(templateFuncs).Parse(`
+{{if .RecipientName}}Hi {{.RecipientName}},{{else}}Hello,{{end}}
+{{if .DigestItems}}
+Here are {{len .DigestItems}} new products:
+{{range .DigestItems}}{{template "details" .}}{{end}}{{else}}
+Product Details:
+---------------{{template "details" .}}{{end}}
+{{- define "details"}}
+Name: {{.ProductName}}
+Price: {{price .Price .Locale}}
+{{- if gt .OriginalPrice .Price}}
+Was: {{price .OriginalPrice .Locale}}
+{{- end}}
+{{- with .DiscountPercent}}
+Discount: {{percent . $.Locale}} off
+{{- end}}
+Description: {{.Description}}
+{{end}}`))
+
+// productHTMLTemplate renders the HTML body of a product email or digest.
+// Values are escaped by html/template.
+var productHTMLTemplate = htmltemplate.Must(htmltemplate.New("product_html").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+body { font-family: Arial, Helvetica, sans-serif; color: #333333; }
+h2 { color: #1a1a1a; }
+table { border-collapse: collapse; }
+th { text-align: left; padding: 4px 12px 4px 0; color: #666666; }
+td { padding: 4px 0; }
+</style>
+</head>
+<body>
+{{if .LogoSrc}}<p><img src="{{.LogoSrc}}" alt="Logo"></p>
+{{end}}<p>{{if .RecipientName}}Hi {{.RecipientName}},{{else}}Hello,{{end}}</p>
+{{if .DigestItems -}}
+<h2>{{len .DigestItems}} New Products</h2>
+{{range .DigestItems}}{{template "details" .}}
+{{end}}
+{{- else -}}
+<h2>Product Details</h2>
+{{template "details" .}}
+{{- end}}
+</body>
+</html>
+{{define "details"}}<table>
+<tr><th>Name</th><td>{{.ProductName}}</td></tr>
+<tr><th>Price</th><td>{{price .Price .Locale}}</td></tr>
+{{if gt .OriginalPrice .Price}}<tr><th>Was</th><td><s>{{price .OriginalPrice .Locale}}</s></td></tr>
+{{end}}{{with .DiscountPercent}}<tr><th>Discount</th><td>{{percent . $.Locale}} off</td></tr>
+{{end}}<tr><th>Description</th><td>{{if .DescriptionHTML}}{{.DescriptionHTML}}{{else}}{{.Description}}{{end}}</td></tr>
+</table>{{end}}`))
+
+// substituteVariables replaces {{key}} placeholders in text with the
+// matching variable values. Unknown placeholders are left untouched.
+func substituteVariables(text string, variables map[string]string) string {
+	if len(variables) == 0 {
+		return text
+	}
+
+	pairs := make([]string, 0, len(variables)*2)
+	for key, value := range variables {
+		pairs = append(pairs, "{{"+key+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// ErrUnknownTemplate is returned when a template name isn't configured
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// templateExecutor is satisfied by both text and html templates
+type templateExecutor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// templates returns the service's templates by name. Only these can be
+// rendered by name, so callers can never point at arbitrary files.
+func (s *EmailService) templates() map[string]templateExecutor {
+	textTemplate, htmlTemplate := s.bodyTemplates()
+	templates := map[string]templateExecutor{
+		"product_text": textTemplate,
+		"product_html": htmlTemplate,
+	}
+	if subject := s.subjectTmpl(); subject != nil {
+		templates["subject"] = subject
+	}
+	for _, set := range s.templateSetNames() {
+		text, html, err := s.bodyTemplatesFor(set)
+		if err != nil {
+			continue
+		}
+		templates[set+".product_text"] = text
+		templates[set+".product_html"] = html
+	}
+	return templates
+}
+
+// templateNames lists the names accepted by renderTemplate
+func (s *EmailService) templateNames() []string {
+	var names []string
+	for name := range s.templates() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderTemplate renders a named template against arbitrary data, applying
+// the same post-processing as the send path
+func (s *EmailService) renderTemplate(name string, data any) (string, error) {
+	tmpl, ok := s.templates()[name]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	if _, base, _ := splitTemplateName(name); base == "product_html" && s.cfg().InlineCSS {
+		return inlineCSS(out.String())
+	}
+	return out.String(), nil
+}
+
+// validateTemplate parses source as a text or html template with the
+// production helpers and executes it against sample data. It returns
+// warnings for templates that work but are likely mistakes.
+func (s *EmailService) validateTemplate(kind, source string) ([]string, error) {
+	var tmpl templateExecutor
+	var err error
+	switch kind {
+	case "text":
+		tmpl, err = texttemplate.New("validate").Funcs(templateFuncs).Option("missingkey=error").Parse(source)
+	case "html":
+		tmpl, err = htmltemplate.New("validate").Funcs(templateFuncs).Option("missingkey=error").Parse(source)
+	default:
+		return nil, fmt.Errorf("kind must be text or html, got %q", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	sample := sampleProductEmail("customer@example.com")
+	sample.RecipientName = "Alex"
+	var out strings.Builder
+	if err := tmpl.Execute(&out, s.personalize(sample)); err != nil {
+		return nil, fmt.Errorf("execute: %w", err)
+	}
+
+	warnings := []string{}
+	if strings.TrimSpace(out.String()) == "" {
+		warnings = append(warnings, "template renders no output")
+	}
+	if kind == "html" && s.cfg().InlineCSS {
+		if _, err := inlineCSS(out.String()); err != nil {
+			warnings = append(warnings, "css inlining failed: "+err.Error())
+		}
+	}
+	return warnings, nil
+}
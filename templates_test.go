@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubstituteVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		variables map[string]string
+		want      string
+	}{
+		{name: "no variables", text: "Use {{code}} today", want: "Use {{code}} today"},
+		{name: "replaced", text: "Use {{code}} by {{date}}", variables: map[string]string{"code": "SPRING10", "date": "May 1"}, want: "Use SPRING10 by May 1"},
+		{name: "repeated", text: "{{code}} or {{code}}", variables: map[string]string{"code": "SPRING10"}, want: "SPRING10 or SPRING10"},
+		{name: "unknown kept", text: "Use {{code}} at {{store}}", variables: map[string]string{"code": "SPRING10"}, want: "Use SPRING10 at {{store}}"},
+		{name: "not recursive", text: "{{a}}", variables: map[string]string{"a": "{{b}}", "b": "x"}, want: "{{b}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := substituteVariables(tt.text, tt.variables); got != tt.want {
+				t.Errorf("substituteVariables() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendPersonalizesBodies(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		text  []string
+		html  []string
+		notIn []string
+	}{
+		{
+			name: "name and variables",
+			body: `{"recipient_email": "alex@example.com", "recipient_name": "Alex", "product_name": "Desk Lamp", "description": "Use {{code}} at checkout", "variables": {"code": "SPRING10"}}`,
+			text: []string{"Hi Alex,", "Description: Use SPRING10 at checkout"},
+			html: []string{"Hi Alex,", "Use SPRING10 at checkout"},
+		},
+		{
+			name: "no name",
+			body: `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`,
+			text: []string{"Hello,"},
+			html: []string{"Hello,"},
+		},
+		{
+			name:  "values are escaped in html",
+			body:  `{"recipient_email": "alex@example.com", "recipient_name": "<b>Alex</b>", "product_name": "Desk Lamp", "description": "Code {{code}}", "variables": {"code": "<script>x</script>"}}`,
+			text:  []string{"Hi <b>Alex</b>,", "Code <script>x</script>"},
+			html:  []string{"Hi &lt;b&gt;Alex&lt;/b&gt;,", "Code &lt;script&gt;x&lt;/script&gt;"},
+			notIn: []string{"<script>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			if w := do(router, "POST", "/send-product", tt.body, nil); w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			email := plain(t, sender.last(t))
+			for _, want := range tt.text {
+				if !strings.Contains(email.Text(), want) {
+					t.Errorf("text body is missing %q:\n%s", want, email.Text())
+				}
+			}
+			for _, want := range tt.html {
+				if !strings.Contains(email.HTML(), want) {
+					t.Errorf("html body is missing %q:\n%s", want, email.HTML())
+				}
+			}
+			for _, unwanted := range tt.notIn {
+				if strings.Contains(email.HTML(), unwanted) {
+					t.Errorf("html body contains %q:\n%s", unwanted, email.HTML())
+				}
+			}
+		})
+	}
+}
+
+func TestSendFallsBackToTextWhenHTMLFails(t *testing.T) {
+	service, sender, _ := newTemplateSetService(t)
+	// Fails at send time only, for emails carrying variables
+	if _, err := service.SaveTemplate(context.Background(), "wholesale.product_html", `<p>{{.ProductName}}{{with .Variables}}{{index $.DigestItems 3}}{{end}}</p>`); err != nil {
+		t.Fatal(err)
+	}
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "template": "wholesale", "variables": {"boom": "yes"}}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var response struct {
+		Warnings []string `json:"warnings"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(response.Warnings, []string{warnHTMLFallback}) {
+		t.Errorf("warnings = %v, want %v", response.Warnings, []string{warnHTMLFallback})
+	}
+	email := plain(t, sender.last(t))
+	if email.HTML() != "" {
+		t.Errorf("html body = %q, want none", email.HTML())
+	}
+	if !strings.Contains(email.Text(), "Wholesale offer: Desk Lamp") {
+		t.Errorf("text body = %q", email.Text())
+	}
+
+	// The same template renders when it doesn't fail
+	w = do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp", "template": "wholesale"}`, nil)
+	if w.Code != 200 || strings.Contains(w.Body.String(), warnHTMLFallback) {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if html := plain(t, sender.last(t)).HTML(); !strings.Contains(html, "<p>Desk Lamp</p>") {
+		t.Errorf("html body = %q", html)
+	}
+}
+
+func TestDiscountPercent(t *testing.T) {
+	tests := []struct {
+		name string
+		data ProductEmail
+		want float64
+	}{
+		{name: "no discount", data: ProductEmail{Price: 40}},
+		{name: "derived", data: ProductEmail{Price: 30, OriginalPrice: 40}, want: 25},
+		{name: "explicit", data: ProductEmail{Price: 30, OriginalPrice: 40, Discount: 20}, want: 20},
+		{name: "original below price", data: ProductEmail{Price: 40, OriginalPrice: 30}},
+		{name: "out of range", data: ProductEmail{Price: 40, Discount: 150}},
+	}
+	for _, tt := range tests {
+		if got := tt.data.DiscountPercent(); got != tt.want {
+			t.Errorf("%s: DiscountPercent() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendShowsDiscount(t *testing.T) {
+	tests := []struct {
+		name string
+		data ProductEmail
+		text []string
+		html []string
+	}{
+		{
+			name: "discounted",
+			data: ProductEmail{Price: 30, OriginalPrice: 40},
+			text: []string{"Price: $30.00\nWas: $40.00\nDiscount: 25% off\nDescription:"},
+			html: []string{"<s>$40.00</s>", "25% off"},
+		},
+		{
+			name: "discount without an original price",
+			data: ProductEmail{Price: 30, Discount: 10},
+			text: []string{"Price: $30.00\nDiscount: 10% off\nDescription:"},
+			html: []string{"10% off"},
+		},
+		{
+			name: "full price",
+			data: ProductEmail{Price: 40},
+			text: []string{"Price: $40.00\nDescription:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			tt.data.RecipientEmail = "alex@example.com"
+			tt.data.ProductName = "Desk Lamp"
+			if _, err := service.SendProductEmail(context.Background(), tt.data); err != nil {
+				t.Fatal(err)
+			}
+			message := plain(t, sender.last(t))
+			for _, want := range tt.text {
+				if !strings.Contains(message.Text(), want) {
+					t.Errorf("text body is missing %q:\n%s", want, message.Text())
+				}
+			}
+			for _, want := range tt.html {
+				if !strings.Contains(message.HTML(), want) {
+					t.Errorf("HTML body is missing %q:\n%s", want, message.HTML())
+				}
+			}
+			if tt.html == nil && (strings.Contains(message.HTML(), "<s>") || strings.Contains(message.HTML(), "Discount")) {
+				t.Errorf("full-price HTML shows a discount:\n%s", message.HTML())
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// storedTemplateKinds are the templates a TemplateStore can replace, with
+// the kind their source is parsed as
+var storedTemplateKinds = map[string]string{
+	"product_text": "text",
+	"product_html": "html",
+}
+
+// templateSetPattern matches the names of template sets. A set's templates
+// are stored as "<set>.product_text" and "<set>.product_html".
+var templateSetPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// bodyTemplateSet is a named pair of body templates, e.g. "wholesale". A
+// nil template falls back to the default one.
+type bodyTemplateSet struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// with returns the set with tmpl in place of the template of its kind
+func (t bodyTemplateSet) with(tmpl templateExecutor) bodyTemplateSet {
+	switch tmpl := tmpl.(type) {
+	case *texttemplate.Template:
+		t.text = tmpl
+	case *htmltemplate.Template:
+		t.html = tmpl
+	}
+	return t
+}
+
+// splitTemplateName splits the name of a stored template into its template
+// set, empty for the default templates, and the template it replaces. It
+// reports false for names a TemplateStore can't hold.
+func splitTemplateName(name string) (set, base string, ok bool) {
+	set, base, found := strings.Cut(name, ".")
+	if !found {
+		set, base = "", name
+	} else if !templateSetPattern.MatchString(set) {
+		return "", "", false
+	}
+	_, ok = storedTemplateKinds[base]
+	return set, base, ok
+}
+
+// ErrInvalidTemplate is returned when a template to save doesn't parse or
+// execute against sample data
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// TemplateStore holds edited template sources so templates can change
+// without a deploy. Templates missing from the store use the built-in ones.
+type TemplateStore interface {
+	// Get returns the source of the named template, reporting false when
+	// the store has none
+	Get(ctx context.Context, name string) (string, bool, error)
+	// Put creates or replaces the source of the named template
+	Put(ctx context.Context, name, source string) error
+	// List returns the names of the stored templates
+	List(ctx context.Context) ([]string, error)
+}
+
+// FileTemplateStore keeps each template in <dir>/<name>.tmpl
+type FileTemplateStore struct {
+	dir string
+}
+
+// NewFileTemplateStore creates a store reading and writing templates in dir
+func NewFileTemplateStore(dir string) *FileTemplateStore {
+	return &FileTemplateStore{dir: dir}
+}
+
+// Get implements TemplateStore
+func (s *FileTemplateStore) Get(_ context.Context, name string) (string, bool, error) {
+	source, err := os.ReadFile(filepath.Join(s.dir, name+".tmpl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(source), true, nil
+}
+
+// Put implements TemplateStore. The file is replaced atomically so a
+// concurrent Get never sees a partial template.
+func (s *FileTemplateStore) Put(_ context.Context, name, source string) error {
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(source); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name+".tmpl"))
+}
+
+// List implements TemplateStore
+func (s *FileTemplateStore) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".tmpl"); ok && entry.Type().IsRegular() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// SQLTemplateStore keeps templates in a table created with:
+//
+//	CREATE TABLE email_templates (name VARCHAR(64) PRIMARY KEY, source TEXT NOT NULL)
+//
+// The caller opens db with a driver of their choice. Queries use ?
+// placeholders unless dollarPlaceholders selects PostgreSQL's $1 style.
+type SQLTemplateStore struct {
+	db                 *sql.DB
+	dollarPlaceholders bool
+}
+
+// NewSQLTemplateStore creates a store on the email_templates table of db
+func NewSQLTemplateStore(db *sql.DB, dollarPlaceholders bool) *SQLTemplateStore {
+	return &SQLTemplateStore{db: db, dollarPlaceholders: dollarPlaceholders}
+}
+
+// query rewrites the ? placeholders of q for the database's style
+func (s *SQLTemplateStore) query(q string, args int) string {
+	if !s.dollarPlaceholders {
+		return q
+	}
+	for i := 1; i <= args; i++ {
+		q = strings.Replace(q, "?", fmt.Sprintf("$%d", i), 1)
+	}
+	return q
+}
+
+// Get implements TemplateStore
+func (s *SQLTemplateStore) Get(ctx context.Context, name string) (string, bool, error) {
+	var source string
+	err := s.db.QueryRowContext(ctx, s.query("SELECT source FROM email_templates WHERE name = ?", 1), name).Scan(&source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return source, true, nil
+}
+
+// List implements TemplateStore
+func (s *SQLTemplateStore) List(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM email_templates")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Ping checks the database connection
+func (s *SQLTemplateStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Put implements TemplateStore. It avoids upsert syntax, which differs
+// between databases, by inserting when the update matched no row.
+func (s *SQLTemplateStore) Put(ctx context.Context, name, source string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, s.query("UPDATE email_templates SET source = ? WHERE name = ?", 2), source, name)
+	if err != nil {
+		return err
+	}
+	if updated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		if _, err := tx.ExecContext(ctx, s.query("INSERT INTO email_templates (name, source) VALUES (?, ?)", 2), name, source); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetTemplateStore sets the store templates are loaded from and saved to
+func (s *EmailService) SetTemplateStore(store TemplateStore) {
+	s.templateStore = store
+}
+
+// parseStoredTemplate parses the source of a stored template
+func parseStoredTemplate(name, source string) (templateExecutor, error) {
+	_, base, _ := splitTemplateName(name)
+	switch storedTemplateKinds[base] {
+	case "text":
+		return texttemplate.New(name).Funcs(templateFuncs).Parse(source)
+	case "html":
+		return htmltemplate.New(name).Funcs(templateFuncs).Parse(source)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+}
+
+// LoadTemplates replaces the body templates with those in the template
+// store, keeping the built-in template for any the store doesn't have, and
+// loads the template sets it holds
+func (s *EmailService) LoadTemplates(ctx context.Context) error {
+	if s.templateStore == nil {
+		return nil
+	}
+	names, err := s.templateStore.List(ctx)
+	if err != nil {
+		return fmt.Errorf("list templates: %w", err)
+	}
+	loaded := map[string]bodyTemplateSet{
+		"": {text: productTextTemplate, html: productHTMLTemplate},
+	}
+	for _, name := range names {
+		set, _, ok := splitTemplateName(name)
+		if !ok {
+			continue
+		}
+		source, found, err := s.templateStore.Get(ctx, name)
+		if err != nil {
+			return fmt.Errorf("load template %s: %w", name, err)
+		}
+		if !found {
+			continue
+		}
+		tmpl, err := parseStoredTemplate(name, source)
+		if err != nil {
+			return fmt.Errorf("parse stored template %s: %w", name, err)
+		}
+		loaded[set] = loaded[set].with(tmpl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.textTemplate, s.htmlTemplate = loaded[""].text, loaded[""].html
+	delete(loaded, "")
+	s.templateSets = loaded
+	return nil
+}
+
+// SaveTemplate validates source, stores it and swaps it in for the named
+// template, creating the template set a "<set>.<template>" name refers to
+// if needed. It returns the validation warnings.
+func (s *EmailService) SaveTemplate(ctx context.Context, name, source string) ([]string, error) {
+	set, base, ok := splitTemplateName(name)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+	warnings, err := s.validateTemplate(storedTemplateKinds[base], source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	tmpl, err := parseStoredTemplate(name, source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if err := s.templateStore.Put(ctx, name, source); err != nil {
+		return nil, fmt.Errorf("store template %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if set == "" {
+		defaults := bodyTemplateSet{text: s.textTemplate, html: s.htmlTemplate}.with(tmpl)
+		s.textTemplate, s.htmlTemplate = defaults.text, defaults.html
+		return warnings, nil
+	}
+	if s.templateSets == nil {
+		s.templateSets = make(map[string]bodyTemplateSet)
+	}
+	s.templateSets[set] = s.templateSets[set].with(tmpl)
+	return warnings, nil
+}
+
+// hasTemplateSet reports whether name is a loaded template set; the empty
+// name is the default templates
+func (s *EmailService) hasTemplateSet(name string) bool {
+	if name == "" {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.templateSets[name]
+	return ok
+}
+
+// templateSetNames lists the loaded template sets
+func (s *EmailService) templateSetNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.templateSets))
+	for name := range s.templateSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PutTemplateHandler creates or updates a body template in the template
+// store: product_text or product_html, or "<set>.product_text" and
+// "<set>.product_html" for a template set. The template is validated
+// before it is saved and used right away.
+func (h *Handler) PutTemplateHandler(c *gin.Context) {
+	if h.emailService.templateStore == nil {
+		c.JSON(404, gin.H{
+			"error": "No template store is configured",
+		})
+		return
+	}
+	var req struct {
+		Source string `json:"source"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if req.Source == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	name := c.Param("name")
+	warnings, err := h.emailService.SaveTemplate(c.Request.Context(), name, req.Source)
+	if errors.Is(err, ErrUnknownTemplate) {
+		c.JSON(404, gin.H{
+			"error":   "Unknown template",
+			"details": err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, ErrInvalidTemplate) {
+		c.JSON(400, gin.H{
+			"error":   "Invalid template",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error":   "Failed to save template",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	slog.Info("template updated", "template", name, "actor", requestActor(c, h.emailService.cfg().APIKeys))
+	c.JSON(200, gin.H{
+		"message":  "Template saved",
+		"template": name,
+		"warnings": warnings,
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// wholesaleText is the text body of the "wholesale" set used by the tests
+const wholesaleText = `Wholesale offer: {{.ProductName}}`
+
+// newTemplateSetService creates a service whose template store holds the
+// "wholesale" set
+func newTemplateSetService(t *testing.T) (*EmailService, *fakeSender, string) {
+	t.Helper()
+	service, sender := newTestService(t, nil)
+	dir := t.TempDir()
+	service.SetTemplateStore(NewFileTemplateStore(dir))
+	if _, err := service.SaveTemplate(context.Background(), "wholesale.product_text", wholesaleText); err != nil {
+		t.Fatal(err)
+	}
+	return service, sender, dir
+}
+
+func TestStreamMixesTemplateSets(t *testing.T) {
+	service, sender, _ := newTemplateSetService(t)
+	router := newTestRouter()
+	router.POST("/admin/send-stream", NewHandler(service).SendStreamHandler)
+
+	w := do(router, "POST", "/admin/send-stream", `{
+		"product": {"product_name": "Desk Lamp"},
+		"recipients": [
+			{"email": "alex@example.com"},
+			{"email": "sam@example.com", "template": "wholesale"}
+		]
+	}`, nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	texts := map[string]string{}
+	for _, message := range sender.sent() {
+		texts[message.To()[0]] = plain(t, message).Text()
+	}
+	if text := texts["alex@example.com"]; !strings.Contains(text, "Product Details:") {
+		t.Errorf("default recipient got:\n%s", text)
+	}
+	if text := texts["sam@example.com"]; !strings.Contains(text, "Wholesale offer: Desk Lamp") {
+		t.Errorf("wholesale recipient got:\n%s", text)
+	}
+}
+
+func TestStreamRejectsUnknownTemplateSets(t *testing.T) {
+	service, sender, _ := newTemplateSetService(t)
+	router := newTestRouter()
+	router.POST("/admin/send-stream", NewHandler(service).SendStreamHandler)
+
+	w := do(router, "POST", "/admin/send-stream", `{
+		"product": {"product_name": "Desk Lamp"},
+		"recipients": [
+			{"email": "alex@example.com", "template": "wholesale"},
+			{"email": "sam@example.com", "template": "retail"}
+		],
+		"mode": "best_effort"
+	}`, nil)
+	if w.Code != 422 {
+		t.Fatalf("status = %d, want 422 (body %s)", w.Code, w.Body)
+	}
+	var response struct {
+		Unknown   []string `json:"unknown"`
+		Templates []string `json:"templates"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(response.Unknown, []string{"retail"}) || !slices.Contains(response.Templates, "wholesale") {
+		t.Errorf("response = %+v, want retail unknown and wholesale listed", response)
+	}
+	if len(sender.sent()) != 0 {
+		t.Error("sent despite an unknown template")
+	}
+}
+
+func TestTemplateSetsLoadFromTheStore(t *testing.T) {
+	_, _, dir := newTemplateSetService(t)
+	service, sender := newTestService(t, nil)
+	service.SetTemplateStore(NewFileTemplateStore(dir))
+	if err := service.LoadTemplates(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := service.SendProductEmail(ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Template: "wholesale"}); err != nil {
+		t.Fatal(err)
+	}
+	email := plain(t, sender.last(t))
+	if !strings.Contains(email.Text(), "Wholesale offer: Desk Lamp") {
+		t.Errorf("text body:\n%s", email.Text())
+	}
+	if !strings.Contains(email.HTML(), "Desk Lamp") {
+		t.Errorf("the set's missing html body didn't fall back to the default:\n%s", email.HTML())
+	}
+
+	if _, err := service.SendProductEmail(ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Template: "retail"}); err == nil {
+		t.Error("unknown template set accepted")
+	}
+	if _, err := service.SaveTemplate(ctx, "Not A Set.product_text", wholesaleText); err == nil {
+		t.Error("invalid set name accepted")
+	}
+}
+
+func TestPutTemplateHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		body     string
+		status   int
+		text     string
+	}{
+		{name: "default text", template: "product_text", body: `{"source": "Just in: {{.ProductName}}"}`, status: 200, text: "Just in: Desk Lamp"},
+		{name: "template set", template: "wholesale.product_text", body: `{"source": "Wholesale: {{.ProductName}}"}`, status: 200, text: "Product Details:"},
+		{name: "syntax error", template: "product_text", body: `{"source": "{{.ProductName"}`, status: 400, text: "Product Details:"},
+		{name: "unknown field", template: "product_text", body: `{"source": "{{.Nope}}"}`, status: 400, text: "Product Details:"},
+		{name: "no source", template: "product_text", body: `{}`, status: 400, text: "Product Details:"},
+		{name: "unknown template", template: "subject", body: `{"source": "x"}`, status: 404, text: "Product Details:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			dir := t.TempDir()
+			store := NewFileTemplateStore(dir)
+			service.SetTemplateStore(store)
+			router := newTestRouter()
+			router.PUT("/admin/templates/:name", NewHandler(service).PutTemplateHandler)
+
+			w := do(router, "PUT", "/admin/templates/"+tt.template, tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			_, stored, err := store.Get(context.Background(), tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != (tt.status == 200) {
+				t.Errorf("stored = %v, want %v", stored, tt.status == 200)
+			}
+
+			// The saved template is used without a reload
+			if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}); err != nil {
+				t.Fatal(err)
+			}
+			if text := plain(t, sender.last(t)).Text(); !strings.Contains(text, tt.text) {
+				t.Errorf("text body is missing %q:\n%s", tt.text, text)
+			}
+		})
+	}
+}
+
+func TestPutTemplateHandlerWithoutStore(t *testing.T) {
+	service, _ := newTestService(t, nil)
+	router := newTestRouter()
+	router.PUT("/admin/templates/:name", NewHandler(service).PutTemplateHandler)
+	if w := do(router, "PUT", "/admin/templates/product_text", `{"source": "x"}`, nil); w.Code != 404 {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestReloadPicksUpStoredTemplates(t *testing.T) {
+	dir := t.TempDir()
+	service, sender := newTestService(t, nil)
+	service.SetTemplateStore(NewFileTemplateStore(dir))
+	router := newTestRouter()
+	router.POST("/admin/config/reload", NewHandler(service).ReloadConfigHandler)
+
+	// Another instance sharing the store edits a template
+	other, _ := newTestService(t, nil)
+	other.SetTemplateStore(NewFileTemplateStore(dir))
+	if _, err := other.SaveTemplate(context.Background(), "product_text", "Edited: {{.ProductName}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func() string {
+		t.Helper()
+		if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}); err != nil {
+			t.Fatal(err)
+		}
+		return plain(t, sender.last(t)).Text()
+	}
+	if text := send(); strings.Contains(text, "Edited:") {
+		t.Fatalf("edit used before the reload:\n%s", text)
+	}
+	if w := do(router, "POST", "/admin/config/reload", "", nil); w.Code != 200 {
+		t.Fatalf("reload status = %d, body %s", w.Code, w.Body)
+	}
+	if text := send(); !strings.Contains(text, "Edited: Desk Lamp") {
+		t.Errorf("text body after the reload:\n%s", text)
+	}
+}
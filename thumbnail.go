@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxThumbnailBytes bounds the image read back from the renderer
+const maxThumbnailBytes = 10 << 20
+
+// ThumbnailRenderer converts a rendered HTML email into a PNG image
+type ThumbnailRenderer interface {
+	RenderPNG(ctx context.Context, html string) ([]byte, error)
+}
+
+// HTTPThumbnailRenderer renders thumbnails through an external service that
+// accepts the HTML as the request body and responds with a PNG
+type HTTPThumbnailRenderer struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPThumbnailRenderer creates a renderer for the given service URL
+func NewHTTPThumbnailRenderer(url string) *HTTPThumbnailRenderer {
+	return &HTTPThumbnailRenderer{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// RenderPNG implements ThumbnailRenderer
+func (r *HTTPThumbnailRenderer) RenderPNG(ctx context.Context, html string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, strings.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/html; charset=utf-8")
+	req.Header.Set("Accept", "image/png")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxThumbnailBytes))
+}
+
+// SetThumbnailRenderer sets the renderer used for email thumbnails. A nil
+// renderer makes the thumbnail endpoint return the HTML instead.
+func (s *EmailService) SetThumbnailRenderer(renderer ThumbnailRenderer) {
+	s.thumbnails = renderer
+}
+
+// ThumbnailHandler renders the HTML email for the posted data as a PNG.
+// Without a renderer, or when rendering fails, the HTML itself is returned.
+func (h *Handler) ThumbnailHandler(c *gin.Context) {
+	var productData ProductEmail
+	if !h.bindJSON(c, &productData) {
+		return
+	}
+	if productData.ProductName == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	htmlBody, err := h.emailService.formatProductHTML(productData)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error":   "Template render failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if renderer := h.emailService.thumbnails; renderer != nil {
+		image, err := renderer.RenderPNG(c.Request.Context(), htmlBody)
+		if err == nil {
+			c.Data(200, "image/png", image)
+			return
+		}
+		slog.Warn("thumbnail rendering failed, returning html", "error", err)
+	}
+
+	c.Header("X-Thumbnail-Fallback", "html")
+	c.Data(200, "text/html; charset=utf-8", []byte(htmlBody))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThumbnailHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		renderer    http.HandlerFunc
+		body        string
+		status      int
+		contentType string
+		fallback    bool
+	}{
+		{
+			name: "rendered",
+			renderer: func(w http.ResponseWriter, r *http.Request) {
+				html, _ := io.ReadAll(r.Body)
+				if !strings.Contains(string(html), "Desk Lamp") || r.Header.Get("Accept") != "image/png" {
+					http.Error(w, "unexpected request", 400)
+					return
+				}
+				w.Header().Set("Content-Type", "image/png")
+				io.WriteString(w, pngSignature)
+			},
+			body:        `{"product_name": "Desk Lamp"}`,
+			status:      200,
+			contentType: "image/png",
+		},
+		{
+			name:        "renderer failed",
+			renderer:    func(w http.ResponseWriter, r *http.Request) { http.Error(w, "unavailable", 503) },
+			body:        `{"product_name": "Desk Lamp"}`,
+			status:      200,
+			contentType: "text/html; charset=utf-8",
+			fallback:    true,
+		},
+		{
+			name:        "no renderer",
+			body:        `{"product_name": "Desk Lamp"}`,
+			status:      200,
+			contentType: "text/html; charset=utf-8",
+			fallback:    true,
+		},
+		{name: "missing product", body: `{}`, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, sender := newTestService(t, nil)
+			if tt.renderer != nil {
+				server := httptest.NewServer(tt.renderer)
+				defer server.Close()
+				service.SetThumbnailRenderer(NewHTTPThumbnailRenderer(server.URL))
+			}
+			router := newTestRouter()
+			router.POST("/admin/thumbnail", NewHandler(service).ThumbnailHandler)
+
+			w := do(router, "POST", "/admin/thumbnail", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if len(sender.sent()) != 0 {
+				t.Error("rendering a thumbnail sent a message")
+			}
+			if tt.status != 200 {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("content type = %q, want %q", got, tt.contentType)
+			}
+			if fallback := w.Header().Get("X-Thumbnail-Fallback") == "html"; fallback != tt.fallback {
+				t.Errorf("fallback = %v, want %v", fallback, tt.fallback)
+			}
+			want := pngSignature
+			if tt.fallback {
+				want = "Desk Lamp"
+			}
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("body = %q, want it to contain %q", w.Body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRouteTimeouts are the per-route deadlines used unless overridden by
+// ROUTE_TIMEOUTS. Mailgun expects webhook responses quickly, while CSV
+// uploads and streamed sends send one email per recipient and bulk
+// validation may check each address with Mailgun.
+var defaultRouteTimeouts = map[string]time.Duration{
+	"/send-product":      10 * time.Second,
+	"/send-csv":          5 * time.Minute,
+	"/validate-bulk":     5 * time.Minute,
+	"/admin/send-stream": 30 * time.Minute,
+	"/webhooks/mailgun":  2 * time.Second,
+}
+
+// parseRouteTimeouts parses "path=duration" pairs separated by commas, e.g.
+// "/send-product=10s,/webhooks/mailgun=2s", on top of the defaults
+func parseRouteTimeouts(raw string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(defaultRouteTimeouts))
+	for path, timeout := range defaultRouteTimeouts {
+		timeouts[path] = timeout
+	}
+
+	for _, pair := range splitList(raw) {
+		path, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid ROUTE_TIMEOUTS entry %q (expected path=duration)", pair)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid ROUTE_TIMEOUTS duration for %s: %q", path, value)
+		}
+		timeouts[strings.TrimSpace(path)] = timeout
+	}
+	return timeouts, nil
+}
+
+// RouteTimeouts puts a deadline on each request's context based on its route,
+// falling back to the given default. Handlers must honour the context; if the
+// deadline passes before anything was written the request fails with a 504.
+func RouteTimeouts(timeouts map[string]time.Duration, fallback time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout, ok := timeouts[c.FullPath()]
+		if !ok {
+			timeout = fallback
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if !c.Writer.Written() && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.AbortWithStatusJSON(504, gin.H{
+				"error": "Request timed out",
+			})
+		}
+	}
+}
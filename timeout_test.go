@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	timeouts, err := parseRouteTimeouts("/send-product=3s, /admin/stats=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts["/send-product"] != 3*time.Second || timeouts["/admin/stats"] != time.Minute {
+		t.Errorf("timeouts = %v", timeouts)
+	}
+	// Routes left out keep their defaults
+	if timeouts["/webhooks/mailgun"] != defaultRouteTimeouts["/webhooks/mailgun"] {
+		t.Errorf("/webhooks/mailgun = %v, want the default", timeouts["/webhooks/mailgun"])
+	}
+	for _, raw := range []string{"/send-product", "/send-product=fast", "/send-product=0s", "/send-product=-1s"} {
+		if _, err := parseRouteTimeouts(raw); err == nil {
+			t.Errorf("parseRouteTimeouts(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	router := newTestRouter()
+	router.Use(RouteTimeouts(map[string]time.Duration{"/slow": 10 * time.Millisecond}, time.Minute))
+	// Waits for its deadline without writing, like a handler stuck on Mailgun
+	router.GET("/slow", func(c *gin.Context) { <-c.Request.Context().Done() })
+	router.GET("/fast", func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		if !ok || time.Until(deadline) < 50*time.Second {
+			c.Status(500)
+			return
+		}
+		c.Status(204)
+	})
+
+	if w := do(router, "GET", "/slow", "", nil); w.Code != 504 {
+		t.Errorf("/slow status = %d, want 504", w.Code)
+	}
+	// Falls back to the default timeout, which is long enough here
+	if w := do(router, "GET", "/fast", "", nil); w.Code != 204 {
+		t.Errorf("/fast status = %d, want 204 with the default deadline", w.Code)
+	}
+
+	// A handler that answered after its deadline keeps its own response
+	wrote := newTestRouter()
+	wrote.Use(RouteTimeouts(nil, 10*time.Millisecond))
+	wrote.GET("/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		c.JSON(502, gin.H{"error": "Mailgun timed out"})
+	})
+	if w := do(wrote, "GET", "/slow", "", nil); w.Code != 502 {
+		t.Errorf("status = %d, want the handler's own 502", w.Code)
+	}
+}
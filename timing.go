@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SendTiming is how long the phases of a send took. Phases that weren't
+// reached are zero.
+type SendTiming struct {
+	// Validation covers request checks, preferences and profile resolution
+	Validation time.Duration
+	// Rendering covers the bodies, subject and message assembly
+	Rendering time.Duration
+	// Send is the Mailgun call including retries
+	Send time.Duration
+}
+
+// milliseconds reports d in fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// addTiming adds the timing breakdown of a send to a handler response when
+// the caller set X-Timing
+func addTiming(c *gin.Context, response gin.H, result SendResult) {
+	if enabled, _ := strconv.ParseBool(c.GetHeader("X-Timing")); !enabled {
+		return
+	}
+	response["timing"] = gin.H{
+		"validation_ms": milliseconds(result.Timing.Validation),
+		"rendering_ms":  milliseconds(result.Timing.Rendering),
+		"send_ms":       milliseconds(result.Timing.Send),
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// slowSender is a fakeSender whose sends take d on the fake clock
+type slowSender struct {
+	fakeSender
+	clock *fakeClock
+	d     time.Duration
+}
+
+// Send implements Sender
+func (s *slowSender) Send(ctx context.Context, message *mailgun.Message) (string, string, error) {
+	s.clock.Advance(s.d)
+	return s.fakeSender.Send(ctx, message)
+}
+
+func TestSendTiming(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		timing bool
+	}{
+		{name: "requested", header: "true", timing: true},
+		{name: "not requested"},
+		{name: "disabled", header: "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			sender := &slowSender{clock: clock, d: 250 * time.Millisecond}
+			service := NewEmailServiceWithSender(testConfig(t, nil), sender)
+			service.SetClock(clock)
+			router := newTestRouter()
+			router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["X-Timing"] = tt.header
+			}
+			w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, headers)
+			if w.Code != 200 {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			var response struct {
+				Timing *struct {
+					Validation *float64 `json:"validation_ms"`
+					Rendering  *float64 `json:"rendering_ms"`
+					Send       float64  `json:"send_ms"`
+				} `json:"timing"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if (response.Timing != nil) != tt.timing {
+				t.Fatalf("timing present = %v, want %v (body %s)", response.Timing != nil, tt.timing, w.Body)
+			}
+			if !tt.timing {
+				return
+			}
+			if response.Timing.Validation == nil || response.Timing.Rendering == nil {
+				t.Errorf("timing is missing phases: %s", w.Body)
+			}
+			if response.Timing.Send != 250 {
+				t.Errorf("send_ms = %v, want 250", response.Timing.Send)
+			}
+		})
+	}
+}
+
+func TestSendTimingOnFailure(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{"MAX_RETRIES": "0"})
+	sender.err = &mailgun.UnexpectedResponseError{Expected: []int{200}, Actual: 400, Data: []byte("bad request")}
+	router := newTestRouter()
+	router.POST("/send-product", NewHandler(service).SendProductHandler)
+
+	w := do(router, "POST", "/send-product", `{"recipient_email": "alex@example.com", "product_name": "Desk Lamp"}`, map[string]string{"X-Timing": "1"})
+	if w.Code != 500 {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := response["timing"]; !ok {
+		t.Errorf("failed send has no timing: %s", w.Body)
+	}
+}
+
+func TestMilliseconds(t *testing.T) {
+	if got := milliseconds(1500 * time.Microsecond); got != 1.5 {
+		t.Errorf("milliseconds(1.5ms) = %v", got)
+	}
+}
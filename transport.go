@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// tlsVersions maps MAILGUN_MIN_TLS_VERSION values to crypto/tls versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a minimum TLS version such as "1.2". An empty
+// value keeps the crypto/tls default.
+func parseTLSVersion(value string) (uint16, error) {
+	if value == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("MAILGUN_MIN_TLS_VERSION must be 1.0, 1.1, 1.2 or 1.3, got %q", value)
+	}
+	return version, nil
+}
+
+// parseProxyURL parses the proxy used for Mailgun API calls
+func parseProxyURL(value string) (*url.URL, error) {
+	if value == "" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(value)
+	if err != nil || proxy.Host == "" || (proxy.Scheme != "http" && proxy.Scheme != "https") {
+		// The value isn't echoed since it may carry proxy credentials
+		return nil, fmt.Errorf("MAILGUN_HTTPS_PROXY must be an http(s) URL")
+	}
+	return proxy, nil
+}
+
+// mailgunTransport builds the transport for Mailgun API calls from the
+// proxy and minimum TLS settings, or returns nil when neither is set
+func mailgunTransport(config Config) *http.Transport {
+	if config.MailgunProxy == nil && config.MailgunMinTLSVersion == 0 {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.MailgunProxy != nil {
+		transport.Proxy = http.ProxyURL(config.MailgunProxy)
+	}
+	if config.MailgunMinTLSVersion != 0 {
+		transport.TLSClientConfig = &tls.Config{MinVersion: config.MailgunMinTLSVersion}
+	}
+	return transport
+}
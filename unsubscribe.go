@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	htmltemplate "html/template"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// skipUnsubscribed is reported when a recipient unsubscribed and the
+// email's profile honors unsubscribes
+const skipUnsubscribed = "unsubscribed"
+
+// mailgunUnsubscribeURL is replaced by Mailgun with the recipient's link to
+// its own unsubscribe page
+const mailgunUnsubscribeURL = "%unsubscribe_url%"
+
+// UnsubscribeStore records recipients who unsubscribed. It is an interface
+// so the in-memory implementation can be swapped for a shared backend.
+type UnsubscribeStore interface {
+	Unsubscribed(ctx context.Context, recipient string) (bool, error)
+	Unsubscribe(ctx context.Context, recipient string) error
+}
+
+// MemoryUnsubscribeStore is a process-local UnsubscribeStore. It starts
+// empty, so everyone is allowed until they unsubscribe.
+type MemoryUnsubscribeStore struct {
+	mu         sync.Mutex
+	recipients map[string]struct{}
+}
+
+// NewMemoryUnsubscribeStore creates an empty in-memory unsubscribe store
+func NewMemoryUnsubscribeStore() *MemoryUnsubscribeStore {
+	return &MemoryUnsubscribeStore{
+		recipients: make(map[string]struct{}),
+	}
+}
+
+// Unsubscribed implements UnsubscribeStore
+func (s *MemoryUnsubscribeStore) Unsubscribed(_ context.Context, recipient string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.recipients[recipientKey(recipient)]
+	return ok, nil
+}
+
+// Unsubscribe implements UnsubscribeStore
+func (s *MemoryUnsubscribeStore) Unsubscribe(_ context.Context, recipient string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recipients[recipientKey(recipient)] = struct{}{}
+	return nil
+}
+
+// SetUnsubscribeStore replaces the store consulted before sending emails
+// whose profile honors unsubscribes
+func (s *EmailService) SetUnsubscribeStore(unsubscribes UnsubscribeStore) {
+	s.unsubscribes = unsubscribes
+}
+
+// unsubscribeToken signs a recipient so unsubscribe links can't be forged
+// for other addresses
+func unsubscribeToken(key, recipient string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(recipientKey(recipient)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// unsubscribeURL returns the recipient's link to /unsubscribe, or
+// Mailgun's own unsubscribe link when PUBLIC_BASE_URL or
+// UNSUBSCRIBE_SIGNING_KEY isn't set
+func (c Config) unsubscribeURL(recipient string) string {
+	if c.PublicBaseURL == "" || c.UnsubscribeSigningKey == "" {
+		return mailgunUnsubscribeURL
+	}
+	query := url.Values{
+		"email": {recipient},
+		"token": {unsubscribeToken(c.UnsubscribeSigningKey, recipient)},
+	}
+	return strings.TrimRight(c.PublicBaseURL, "/") + "/unsubscribe?" + query.Encode()
+}
+
+// addListUnsubscribe adds the List-Unsubscribe headers for our own
+// unsubscribe link, including RFC 8058 one-click unsubscribe. Mailgun adds
+// its own headers for %unsubscribe_url%.
+func addListUnsubscribe(message *mailgun.Message, link string) {
+	if link == mailgunUnsubscribeURL {
+		return
+	}
+	message.AddHeader("List-Unsubscribe", "<"+link+">")
+	message.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+}
+
+// unsubscribePageTemplate renders the pages of an unsubscribe link. The
+// confirmation form has no action, so it posts back to the signed link.
+var unsubscribePageTemplate = htmltemplate.Must(htmltemplate.New("unsubscribe_page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Unsubscribe</title>
+</head>
+<body>
+{{if .Unsubscribed -}}
+<p>{{.Email}} has been unsubscribed.</p>
+{{- else -}}
+<p>Unsubscribe {{.Email}} from these emails?</p>
+<form method="post"><button type="submit">Unsubscribe</button></form>
+{{- end}}
+</body>
+</html>
+`))
+
+// writeUnsubscribePage renders an unsubscribe page for email
+func writeUnsubscribePage(c *gin.Context, email string, unsubscribed bool) {
+	var page strings.Builder
+	if err := unsubscribePageTemplate.Execute(&page, struct {
+		Email        string
+		Unsubscribed bool
+	}{email, unsubscribed}); err != nil {
+		c.JSON(500, gin.H{
+			"error":   "Failed to render page",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.Data(200, "text/html; charset=utf-8", []byte(page.String()))
+}
+
+// unsubscribeRecipient returns the recipient of the signed unsubscribe
+// link of the request. It responds itself and reports false when the link
+// is invalid or unsubscribe links aren't enabled.
+func (h *Handler) unsubscribeRecipient(c *gin.Context) (string, bool) {
+	key := h.emailService.cfg().UnsubscribeSigningKey
+	if key == "" {
+		c.JSON(404, gin.H{
+			"error": "Unsubscribe links are not enabled",
+		})
+		return "", false
+	}
+
+	email, token := c.Query("email"), c.Query("token")
+	if email == "" || !hmac.Equal([]byte(token), []byte(unsubscribeToken(key, email))) {
+		c.JSON(400, gin.H{
+			"error": "Invalid unsubscribe link",
+		})
+		return "", false
+	}
+	return email, true
+}
+
+// UnsubscribeConfirmHandler serves the page a signed unsubscribe link
+// opens. It changes nothing, since link scanners and prefetchers follow
+// links in emails; the page's form posts to UnsubscribeHandler.
+func (h *Handler) UnsubscribeConfirmHandler(c *gin.Context) {
+	email, ok := h.unsubscribeRecipient(c)
+	if !ok {
+		return
+	}
+	writeUnsubscribePage(c, email, false)
+}
+
+// UnsubscribeHandler records the opt-out of the recipient of a signed
+// unsubscribe link. It serves both the confirmation form and the RFC 8058
+// one-click POST of mail clients; browsers get a page, others JSON.
+func (h *Handler) UnsubscribeHandler(c *gin.Context) {
+	email, ok := h.unsubscribeRecipient(c)
+	if !ok {
+		return
+	}
+
+	if err := h.emailService.unsubscribes.Unsubscribe(c.Request.Context(), email); err != nil {
+		c.JSON(500, gin.H{
+			"error":   "Failed to unsubscribe",
+			"details": err.Error(),
+		})
+		return
+	}
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		writeUnsubscribePage(c, email, true)
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "You have been unsubscribed",
+		"email":   email,
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscribeLink(t *testing.T) {
+	service, sender := newTestService(t, map[string]string{
+		"PUBLIC_BASE_URL":         "https://shop.example.com/",
+		"UNSUBSCRIBE_SIGNING_KEY": "secret",
+	})
+	handler := NewHandler(service)
+	router := newTestRouter()
+	router.GET("/unsubscribe", handler.UnsubscribeConfirmHandler)
+	router.POST("/unsubscribe", handler.UnsubscribeHandler)
+	ctx := context.Background()
+	marketing := ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Profile: "marketing"}
+
+	if _, err := service.SendProductEmail(ctx, marketing); err != nil {
+		t.Fatal(err)
+	}
+	headers := sender.last(t).Headers()
+	listUnsubscribe := headers["List-Unsubscribe"]
+	if !strings.HasPrefix(listUnsubscribe, "<https://shop.example.com/unsubscribe?") || !strings.HasSuffix(listUnsubscribe, ">") {
+		t.Fatalf("List-Unsubscribe = %q, want our signed link", listUnsubscribe)
+	}
+	if got := headers["List-Unsubscribe-Post"]; got != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q, want one-click", got)
+	}
+	link, err := url.Parse(strings.Trim(listUnsubscribe, "<>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := link.RequestURI()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{name: "forged token", method: "GET", target: "/unsubscribe?email=alex%40example.com&token=0000", status: 400},
+		{name: "other recipient", method: "POST", target: strings.Replace(target, "alex", "sam", 1), status: 400},
+		{name: "missing email", method: "POST", target: "/unsubscribe", status: 400},
+	}
+	for _, tt := range tests {
+		if w := do(router, tt.method, tt.target, "", nil); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+
+	w := do(router, "GET", target, "", map[string]string{"Accept": "text/html"})
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `<form method="post">`) {
+		t.Fatalf("GET = %d %s, want the confirmation form", w.Code, w.Body)
+	}
+	if unsubscribed, _ := service.unsubscribes.Unsubscribed(ctx, "alex@example.com"); unsubscribed {
+		t.Fatal("GET unsubscribed the recipient")
+	}
+
+	w = do(router, "POST", target, "", nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "You have been unsubscribed") {
+		t.Fatalf("POST = %d %s, want unsubscribed", w.Code, w.Body)
+	}
+	result, err := service.SendProductEmail(ctx, marketing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Skipped != skipUnsubscribed {
+		t.Errorf("skipped = %q, want %q", result.Skipped, skipUnsubscribed)
+	}
+	if _, err := service.SendProductEmail(ctx, ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(sender.sent()); got != 2 {
+		t.Errorf("%d messages sent, want the first marketing email and the transactional one", got)
+	}
+}
+
+func TestUnsubscribeLinksDisabled(t *testing.T) {
+	service, sender := newTestService(t, nil)
+	router := newTestRouter()
+	router.POST("/unsubscribe", NewHandler(service).UnsubscribeHandler)
+	if w := do(router, "POST", "/unsubscribe?email=alex%40example.com&token=0000", "", nil); w.Code != 404 {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+
+	if _, err := service.SendProductEmail(context.Background(), ProductEmail{RecipientEmail: "alex@example.com", ProductName: "Desk Lamp", Profile: "marketing"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := sender.last(t).Headers()["List-Unsubscribe"]; ok {
+		t.Errorf("List-Unsubscribe = %q, want Mailgun to add its own", got)
+	}
+}
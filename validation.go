@@ -0,0 +1,36 @@
+package main
+
+import "errors"
+
+// validationErrors are the errors caused by invalid request data; the
+// handler maps them to a 400
+var validationErrors = []error{
+	ErrSubjectTooLong,
+	ErrUnknownFromKey,
+	ErrFromDomainMismatch,
+	ErrInvalidEvent,
+	ErrInvalidDKIMSelector,
+	ErrInvalidCustomVariables,
+	ErrInlineImageTooLarge,
+	ErrUnknownDomainKey,
+	ErrInvalidCallbackURL,
+	ErrInvalidHTMLURL,
+	ErrInvalidProfile,
+	ErrInvalidReplyTo,
+	ErrInvalidBodyFormat,
+	ErrTooManyRecipients,
+	ErrInvalidTag,
+	ErrUnsupportedCharset,
+	ErrUnrepresentableCharacter,
+	ErrUnknownTemplate,
+}
+
+// isValidationError reports whether err was caused by invalid request data
+func isValidationError(err error) bool {
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
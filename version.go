@@ -0,0 +1,34 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VersionHandler reports the build of the running binary: the module
+// version, the VCS revision it was built from and the Go version
+func (h *Handler) VersionHandler(c *gin.Context) {
+	response := gin.H{
+		"version":    "unknown",
+		"go_version": runtime.Version(),
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		c.JSON(200, response)
+		return
+	}
+	response["version"] = info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			response["revision"] = setting.Value
+		case "vcs.time":
+			response["built_at"] = setting.Value
+		case "vcs.modified":
+			response["modified"] = setting.Value == "true"
+		}
+	}
+	c.JSON(200, response)
+}
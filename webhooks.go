@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// maxStoredEvents bounds how many webhook events are kept in memory
+const maxStoredEvents = 1000
+
+// maxDeadLetters bounds how many dead-lettered events are kept in memory
+const maxDeadLetters = 1000
+
+// StoredEvent is a verified Mailgun webhook event
+type StoredEvent struct {
+	ReceivedAt time.Time       `json:"received_at"`
+	Event      string          `json:"event"`
+	Recipient  string          `json:"recipient"`
+	MessageID  string          `json:"message_id"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// eventSummary holds the event-data fields we index events by
+type eventSummary struct {
+	Event     string `json:"event"`
+	Recipient string `json:"recipient"`
+	Message   struct {
+		Headers struct {
+			MessageID string `json:"message-id"`
+		} `json:"headers"`
+	} `json:"message"`
+}
+
+// MemoryEventStore keeps the most recent webhook events in memory
+type MemoryEventStore struct {
+	mu     sync.Mutex
+	events []StoredEvent
+}
+
+// NewMemoryEventStore creates an empty event store
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{}
+}
+
+// Add stores an event, evicting the oldest once the store is full
+func (s *MemoryEventStore) Add(event StoredEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+	if len(s.events) > maxStoredEvents {
+		s.events = s.events[len(s.events)-maxStoredEvents:]
+	}
+}
+
+// EventForwarder pushes webhook events to an external URL in the background,
+// retrying failures and dead-lettering events that never get through
+type EventForwarder struct {
+	clock       Clock
+	url         string
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	queue       chan StoredEvent
+
+	mu          sync.Mutex
+	deadLetters []StoredEvent
+}
+
+// NewEventForwarder creates a forwarder for the given URL
+func NewEventForwarder(clock Clock, url string, maxAttempts int) *EventForwarder {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &EventForwarder{
+		clock:       clock,
+		url:         url,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		maxAttempts: maxAttempts,
+		backoff:     time.Second,
+		queue:       make(chan StoredEvent, 1000),
+	}
+}
+
+// Run forwards queued events until ctx is cancelled
+func (f *EventForwarder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-f.queue:
+			if err := f.forwardWithRetry(ctx, event); err != nil {
+				f.deadLetter(event, err)
+			}
+		}
+	}
+}
+
+// Enqueue schedules an event for forwarding without blocking. Events that
+// don't fit in the queue are dead-lettered straight away.
+func (f *EventForwarder) Enqueue(event StoredEvent) {
+	select {
+	case f.queue <- event:
+	default:
+		f.deadLetter(event, fmt.Errorf("forward queue is full"))
+	}
+}
+
+// Backlog returns the number of queued events and the queue's capacity
+func (f *EventForwarder) Backlog() (int, int) {
+	return len(f.queue), cap(f.queue)
+}
+
+// DeadLetters returns the events that could not be forwarded
+func (f *EventForwarder) DeadLetters() []StoredEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]StoredEvent(nil), f.deadLetters...)
+}
+
+// forwardWithRetry attempts delivery with exponential backoff between attempts
+func (f *EventForwarder) forwardWithRetry(ctx context.Context, event StoredEvent) error {
+	delay := f.backoff
+	var err error
+	for attempt := 1; attempt <= f.maxAttempts; attempt++ {
+		if err = f.forward(ctx, event); err == nil {
+			return nil
+		}
+		slog.Warn("event forward failed", "attempt", attempt, "event", event.Event, "error", err)
+
+		if attempt == f.maxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.clock.After(delay):
+		}
+		delay *= 2
+	}
+	return err
+}
+
+// forward posts a single event to the configured URL
+func (f *EventForwarder) forward(ctx context.Context, event StoredEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deadLetter records an event that could not be forwarded, evicting the
+// oldest once maxDeadLetters are kept
+func (f *EventForwarder) deadLetter(event StoredEvent, err error) {
+	slog.Error("event forward dead-lettered", "event", event.Event, "message_id", event.MessageID, "error", err)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deadLetters = append(f.deadLetters, event)
+	if len(f.deadLetters) > maxDeadLetters {
+		f.deadLetters = f.deadLetters[len(f.deadLetters)-maxDeadLetters:]
+	}
+}
+
+// WebhookHandler receives Mailgun webhook events
+type WebhookHandler struct {
+	emailService *EmailService
+	events       *MemoryEventStore
+	forwarder    *EventForwarder
+}
+
+// NewWebhookHandler creates a new webhook handler. The forwarder is optional.
+func NewWebhookHandler(emailService *EmailService, events *MemoryEventStore, forwarder *EventForwarder) *WebhookHandler {
+	return &WebhookHandler{
+		emailService: emailService,
+		events:       events,
+		forwarder:    forwarder,
+	}
+}
+
+// bindJSON decodes the request body like Handler.bindJSON
+func (h *WebhookHandler) bindJSON(c *gin.Context, v any) bool {
+	return bindJSONWithLimits(c, h.emailService.cfg().JSONLimits, v)
+}
+
+// MailgunWebhookHandler validates and stores a Mailgun event, then queues it
+// for forwarding. It responds quickly so Mailgun doesn't retry.
+func (h *WebhookHandler) MailgunWebhookHandler(c *gin.Context) {
+	if h.emailService.cfg().WebhookSigningKey == "" {
+		c.JSON(503, gin.H{
+			"error": "Webhook signing key not configured",
+		})
+		return
+	}
+
+	var payload mailgun.WebhookPayload
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+
+	verified, err := h.emailService.mg.VerifyWebhookSignature(payload.Signature)
+	if err != nil || !verified {
+		// Mailgun does not retry requests rejected with a 406
+		c.JSON(406, gin.H{
+			"error": "Invalid webhook signature",
+		})
+		return
+	}
+
+	var summary eventSummary
+	if err := json.Unmarshal(payload.EventData, &summary); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "Invalid event data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	event := StoredEvent{
+		ReceivedAt: h.emailService.clock.Now(),
+		Event:      summary.Event,
+		Recipient:  summary.Recipient,
+		MessageID:  summary.Message.Headers.MessageID,
+		Data:       json.RawMessage(payload.EventData),
+	}
+	h.events.Add(event)
+	if h.forwarder != nil {
+		h.forwarder.Enqueue(event)
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Event received",
+	})
+}
+
+// DeadLettersHandler lists events that could not be forwarded
+func (h *WebhookHandler) DeadLettersHandler(c *gin.Context) {
+	deadLetters := []StoredEvent{}
+	if h.forwarder != nil {
+		deadLetters = h.forwarder.DeadLetters()
+	}
+	c.JSON(200, gin.H{
+		"dead_letters": deadLetters,
+	})
+}
+
+// signaturePrefixLength is how much of a signature the verification
+// endpoint reveals; enough to compare, not enough to reuse
+const signaturePrefixLength = 8
+
+// webhookSignature computes the hex HMAC Mailgun sends for a timestamp and
+// token
+func webhookSignature(key, timestamp, token string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signaturePrefix shortens a signature for display
+func signaturePrefix(signature string) string {
+	if len(signature) <= signaturePrefixLength {
+		return signature
+	}
+	return signature[:signaturePrefixLength] + "..."
+}
+
+// VerifyWebhookHandler checks a sample timestamp, token and signature from
+// Mailgun against the configured signing key. On a mismatch only prefixes
+// of the computed and provided signatures are returned.
+func (h *WebhookHandler) VerifyWebhookHandler(c *gin.Context) {
+	key := h.emailService.cfg().WebhookSigningKey
+	if key == "" {
+		c.JSON(503, gin.H{
+			"error": "Webhook signing key not configured",
+		})
+		return
+	}
+
+	var sig mailgun.Signature
+	if !h.bindJSON(c, &sig) {
+		return
+	}
+	if sig.TimeStamp == "" || sig.Token == "" || sig.Signature == "" {
+		c.JSON(400, gin.H{
+			"error": "Missing required fields",
+		})
+		return
+	}
+
+	computed := webhookSignature(key, sig.TimeStamp, sig.Token)
+	provided := strings.ToLower(strings.TrimSpace(sig.Signature))
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(provided)) == 1 {
+		c.JSON(200, gin.H{
+			"valid": true,
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"valid":              false,
+		"computed_signature": signaturePrefix(computed),
+		"provided_signature": signaturePrefix(provided),
+	})
+}
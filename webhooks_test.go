@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestForwarder creates a forwarder to a server failing the first
+// failures requests, with a backoff short enough for tests
+func newTestForwarder(t *testing.T, failures int32, maxAttempts int) (*EventForwarder, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	t.Cleanup(server.Close)
+
+	forwarder := NewEventForwarder(realClock{}, server.URL, maxAttempts)
+	forwarder.backoff = time.Millisecond
+	return forwarder, &requests
+}
+
+func TestEventForwarderRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int32
+		requests    int32
+		deadLetters int
+	}{
+		{name: "first attempt", failures: 0, requests: 1},
+		{name: "after a failure", failures: 2, requests: 3},
+		{name: "dead-lettered", failures: 3, requests: 3, deadLetters: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarder, requests := newTestForwarder(t, tt.failures, 3)
+			event := StoredEvent{Event: "delivered", MessageID: "<1@example.com>"}
+			if err := forwarder.forwardWithRetry(context.Background(), event); err != nil {
+				forwarder.deadLetter(event, err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+			if got := len(forwarder.DeadLetters()); got != tt.deadLetters {
+				t.Errorf("dead letters = %d, want %d", got, tt.deadLetters)
+			}
+		})
+	}
+}
+
+func TestEventForwarderCapsDeadLetters(t *testing.T) {
+	forwarder := NewEventForwarder(realClock{}, "http://127.0.0.1:0", 1)
+	for i := range maxDeadLetters + 10 {
+		forwarder.deadLetter(StoredEvent{MessageID: fmt.Sprint(i)}, fmt.Errorf("down"))
+	}
+	deadLetters := forwarder.DeadLetters()
+	if len(deadLetters) != maxDeadLetters {
+		t.Fatalf("kept %d dead letters, want %d", len(deadLetters), maxDeadLetters)
+	}
+	if deadLetters[0].MessageID != "10" {
+		t.Errorf("oldest kept is %s, want the oldest ten evicted", deadLetters[0].MessageID)
+	}
+}
+
+func TestMailgunWebhookHandlerForwardsVerifiedEvents(t *testing.T) {
+	service, _ := newTestService(t, map[string]string{"MAILGUN_WEBHOOK_SIGNING_KEY": "signing-key"})
+	forwarder, requests := newTestForwarder(t, 0, 1)
+	webhooks := NewWebhookHandler(service, NewMemoryEventStore(), forwarder)
+	router := newTestRouter()
+	router.POST("/webhooks/mailgun", webhooks.MailgunWebhookHandler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go forwarder.Run(ctx)
+
+	payload := func(signature string) string {
+		body, _ := json.Marshal(map[string]any{
+			"signature": map[string]string{
+				"timestamp": "1700000000",
+				"token":     "token",
+				"signature": signature,
+			},
+			"event-data": map[string]any{
+				"event":     "delivered",
+				"recipient": "alex@example.com",
+			},
+		})
+		return string(body)
+	}
+
+	if w := do(router, "POST", "/webhooks/mailgun", payload(webhookSignature("wrong-key", "1700000000", "token")), nil); w.Code != 406 {
+		t.Errorf("forged signature: status = %d, want 406", w.Code)
+	}
+	if w := do(router, "POST", "/webhooks/mailgun", payload(webhookSignature("signing-key", "1700000000", "token")), nil); w.Code != 200 {
+		t.Fatalf("signed event: status = %d, want 200 (body %s)", w.Code, w.Body)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for requests.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("forwarded %d events, want 1", got)
+	}
+}
+
+func TestMailgunWebhookHandlerRejectsBadBodies(t *testing.T) {
+	service, _ := newTestService(t, map[string]string{
+		"MAILGUN_WEBHOOK_SIGNING_KEY": "signing-key",
+		"JSON_MAX_BODY_BYTES":         "1024",
+		"JSON_MAX_DEPTH":              "4",
+	})
+	webhooks := NewWebhookHandler(service, NewMemoryEventStore(), nil)
+	router := newTestRouter()
+	router.POST("/webhooks/mailgun", webhooks.MailgunWebhookHandler)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "malformed", body: `{"signature":`, status: 400},
+		{name: "too large", body: `{"event-data": {"event": "` + strings.Repeat("x", 2048) + `"}}`, status: 413},
+		{name: "too deep", body: `{"event-data": {"a": {"b": {"c": {}}}}}`, status: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(router, "POST", "/webhooks/mailgun", tt.body, nil); w.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	if events := webhooks.events.events; len(events) != 0 {
+		t.Errorf("%d events stored from rejected bodies", len(events))
+	}
+}
+
+func TestVerifyWebhookHandler(t *testing.T) {
+	service, _ := newTestService(t, map[string]string{
+		"MAILGUN_WEBHOOK_SIGNING_KEY": "signing-key",
+		"JSON_MAX_BODY_BYTES":         "1024",
+	})
+	webhooks := NewWebhookHandler(service, NewMemoryEventStore(), nil)
+	router := newTestRouter()
+	router.POST("/admin/verify-webhook", webhooks.VerifyWebhookHandler)
+
+	valid := webhookSignature("signing-key", "1700000000", "token")
+	forged := webhookSignature("wrong-key", "1700000000", "token")
+	sample := func(signature string) string {
+		return fmt.Sprintf(`{"timestamp": "1700000000", "token": "token", "signature": %q}`, signature)
+	}
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		valid    bool
+		computed string
+	}{
+		{name: "valid", body: sample(valid), status: 200, valid: true},
+		{name: "upper case", body: sample(strings.ToUpper(valid)), status: 200, valid: true},
+		{name: "mismatch", body: sample(forged), status: 200, computed: valid[:signaturePrefixLength] + "..."},
+		{name: "missing token", body: `{"timestamp": "1700000000", "signature": "abc"}`, status: 400},
+		{name: "malformed", body: `{"timestamp":`, status: 400},
+		{name: "too large", body: sample(strings.Repeat("a", 2048)), status: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(router, "POST", "/admin/verify-webhook", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				return
+			}
+			var response struct {
+				Valid    bool   `json:"valid"`
+				Computed string `json:"computed_signature"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Valid != tt.valid || response.Computed != tt.computed {
+				t.Errorf("response = %+v, want valid %v and computed %q", response, tt.valid, tt.computed)
+			}
+			// The full computed signature would be a valid forgery
+			if strings.Contains(w.Body.String(), valid) {
+				t.Errorf("response reveals the full signature: %s", w.Body)
+			}
+		})
+	}
+}